@@ -0,0 +1,73 @@
+package audio
+
+var aLawToPcmTable [256]int16
+
+func init() {
+	for i := 0; i < 256; i++ {
+		aLawToPcmTable[i] = decodeALawByte(byte(i))
+	}
+}
+
+// ALawToPCM decodes one A-law byte into a 16-bit PCM sample
+func ALawToPCM(b byte) int16 {
+	return aLawToPcmTable[b]
+}
+
+// ALaw8kToPCM16k converts A-law 8kHz audio to PCM 16kHz (16-bit LE) for Gemini
+func ALaw8kToPCM16k(aLawData []byte) []byte {
+	return g711ToPCM16k(aLawData, &aLawToPcmTable)
+}
+
+// PCM24kToALaw8k converts Gemini's PCM 24kHz audio (16-bit LE) to A-law 8kHz
+func PCM24kToALaw8k(pcmData []byte) []byte {
+	return pcm24kToG711(pcmData, PCMToALaw)
+}
+
+// decodeALawByte follows the G.711 reference implementation
+func decodeALawByte(aVal byte) int16 {
+	// Even bits are inverted on the wire
+	aVal ^= 0x55
+
+	sign := aVal & 0x80
+	exponent := (aVal >> 4) & 0x07
+	mantissa := int16(aVal&0x0F)<<4 + 8
+
+	sample := mantissa
+	if exponent > 0 {
+		sample = (mantissa + 0x100) << (exponent - 1)
+	}
+
+	// A-law's sign bit is set for positive samples
+	if sign == 0 {
+		return -sample
+	}
+	return sample
+}
+
+// PCMToALaw encodes a 16-bit PCM sample as one A-law byte
+func PCMToALaw(pcm int16) byte {
+	sign := byte(0x80)
+	magnitude := int32(pcm)
+	if magnitude < 0 {
+		sign = 0
+		magnitude = -magnitude - 1
+	}
+	if magnitude > 32767 {
+		magnitude = 32767
+	}
+
+	var aVal byte
+	if magnitude < 256 {
+		aVal = byte(magnitude >> 4)
+	} else {
+		// Find the segment: the position of the highest set bit above bit 7
+		exponent := byte(1)
+		for m := magnitude >> 8; m > 1; m >>= 1 {
+			exponent++
+		}
+		mantissa := byte((magnitude >> (exponent + 3)) & 0x0F)
+		aVal = exponent<<4 | mantissa
+	}
+
+	return (aVal | sign) ^ 0x55
+}
@@ -0,0 +1,127 @@
+// Package audio converts between the audio formats of telephony providers
+// (G.711 mu-law and A-law at 8kHz, 16-bit PCM at 16kHz) and Gemini, which
+// takes 16-bit PCM at 16kHz and responds with 16-bit PCM at 24kHz
+package audio
+
+import "encoding/binary"
+
+var muLawToPcmTable [256]int16
+
+func init() {
+	for i := 0; i < 256; i++ {
+		muLawToPcmTable[i] = decodeMuLawByte(byte(i))
+	}
+}
+
+// MuLawToPCM decodes one mu-law byte into a 16-bit PCM sample
+func MuLawToPCM(b byte) int16 {
+	return muLawToPcmTable[b]
+}
+
+// MuLaw8kToPCM16k converts mu-law 8kHz audio to PCM 16kHz (16-bit LE) for Gemini
+func MuLaw8kToPCM16k(muLawData []byte) []byte {
+	return g711ToPCM16k(muLawData, &muLawToPcmTable)
+}
+
+// PCM24kToMuLaw8k converts Gemini's PCM 24kHz audio (16-bit LE) to mu-law 8kHz
+func PCM24kToMuLaw8k(pcmData []byte) []byte {
+	return pcm24kToG711(pcmData, PCMToMuLaw)
+}
+
+// g711ToPCM16k decodes 8kHz G.711 audio with table and upsamples it to PCM 16kHz
+func g711ToPCM16k(data []byte, table *[256]int16) []byte {
+	// Each G.711 byte -> 1 PCM sample (8kHz)
+	// Upsample 8kHz -> 16kHz by duplicating each sample
+	// Output: 2 bytes per sample * 2 samples per input byte = 4 bytes per G.711 byte
+	pcmData := make([]byte, len(data)*4)
+	for i, b := range data {
+		sample := uint16(table[b])
+		// Write sample twice (duplicate for 8kHz -> 16kHz upsampling)
+		binary.LittleEndian.PutUint16(pcmData[i*4:i*4+2], sample)
+		binary.LittleEndian.PutUint16(pcmData[i*4+2:i*4+4], sample)
+	}
+	return pcmData
+}
+
+// pcm24kToG711 downsamples PCM 24kHz audio to 8kHz and encodes it with encode
+func pcm24kToG711(pcmData []byte, encode func(int16) byte) []byte {
+	// Downsample 24kHz -> 8kHz (take every 3rd sample)
+	sampleCount := len(pcmData) / 2
+	out := make([]byte, 0, sampleCount/3+1)
+	for i := 0; i < sampleCount; i += 3 {
+		offset := i * 2
+		sample := int16(binary.LittleEndian.Uint16(pcmData[offset : offset+2]))
+		out = append(out, encode(sample))
+	}
+	return out
+}
+
+// The Core Algorithm
+// This logic is based on the Sun Microsystems G.711 reference implementation.
+// ========================================================================
+func decodeMuLawByte(uVal byte) int16 {
+	// 1. Toggle bits (Mu-law definition requires inverting bits before processing)
+	uVal = ^uVal
+
+	// 2. Extract components
+	// Sign bit (Mask 0x80)
+	// Exponent (Mask 0x70)
+	// Mantissa (Mask 0x0F)
+	sign := uVal & 0x80
+	exponent := (uVal >> 4) & 0x07
+	mantissa := uVal & 0x0F
+
+	// 3. Calculate sample location
+	// The geometric bias for mu-law is 33 (0x21).
+	// We shift the mantissa to align it, add the bias (132 or 0x84 due to alignment),
+	// and then shift by the exponent.
+	sample := int16((int32(mantissa)<<3 + 0x84) << exponent)
+
+	// 4. Subtract the bias back out
+	sample -= 0x84
+
+	// 5. Apply the sign
+	if sign != 0 {
+		return -sample
+	}
+	return sample
+}
+
+// PCMToMuLaw encodes a 16-bit PCM sample as one mu-law byte
+func PCMToMuLaw(pcm int16) byte {
+	const (
+		bias = 0x84 // 132
+		clip = 32635
+	)
+
+	// 1. Get the sign bit
+	sign := (pcm >> 8) & 0x80
+
+	// 2. Magnitude (absolute value)
+	if pcm < 0 {
+		pcm = -pcm
+	}
+
+	// 3. Clip the magnitude
+	if pcm > clip {
+		pcm = clip
+	}
+
+	// 4. Add bias
+	pcm += bias
+
+	// 5. Calculate the exponent and mantissa
+	exponent := 7
+	// Move the exponent down until we find the highest bit
+	for mask := 0x4000; (pcm&int16(mask)) == 0 && exponent > 0; mask >>= 1 {
+		exponent--
+	}
+
+	mantissa := (pcm >> (exponent + 3)) & 0x0F
+
+	// 6. Assemble the byte
+	ulawByte := byte(sign | (int16(exponent) << 4) | mantissa)
+
+	// 7. Invert bits (compressed format requirement)
+	return ^ulawByte
+}
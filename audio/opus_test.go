@@ -0,0 +1,142 @@
+package audio
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// opusFrameTime is the audio in one Opus packet
+const opusFrameTime = 20 * time.Millisecond
+
+// speechFrame returns a frame of 24kHz PCM shaped like voiced speech, so the
+// encoder does real work
+func speechFrame(n int) []byte {
+	frame := make([]byte, OpusFrameBytes)
+	for i := range OpusFrameBytes / 2 {
+		t := float64(n*OpusFrameBytes/2+i) / 24000
+		sample := 6000*math.Sin(2*math.Pi*180*t) + 3000*math.Sin(2*math.Pi*720*t) + 1500*math.Sin(2*math.Pi*2500*t)
+		binary.LittleEndian.PutUint16(frame[i*2:], uint16(int16(sample)))
+	}
+	return frame
+}
+
+// TestOpusWasmChecksum guards the embedded codec against being replaced
+// without going through go generate
+func TestOpusWasmChecksum(t *testing.T) {
+	recorded, err := os.ReadFile("opus.wasm.sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _, _ := strings.Cut(string(recorded), " ")
+	sum := sha256.Sum256(opusWasm)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		t.Fatalf("opus.wasm has SHA-256 %s, opus.wasm.sha256 records %s", got, want)
+	}
+}
+
+func TestOpusRoundTrip(t *testing.T) {
+	codec, err := NewOpusCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for n := range 5 {
+		packet, err := codec.Encode(speechFrame(n))
+		if err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		if len(packet) == 0 || len(packet) > opusMaxPacket {
+			t.Fatalf("packet of %d bytes", len(packet))
+		}
+		pcm, err := codec.Decode(packet)
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		// 20ms at 16kHz, 16-bit
+		if len(pcm) != 640 {
+			t.Fatalf("decoded %d bytes, want 640", len(pcm))
+		}
+	}
+
+	if _, err := codec.Decode(make([]byte, opusMaxPacket+1)); err == nil {
+		t.Error("Decode of an oversized packet succeeded")
+	}
+	if err := codec.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := codec.Encode(speechFrame(0)); !errors.Is(err, errOpusClosed) {
+		t.Errorf("Encode after Close = %v, want errOpusClosed", err)
+	}
+}
+
+// runSessions has n streams encode and decode 20ms frames concurrently, the
+// worst case of both parties talking at once, and returns the wall time taken
+func runSessions(tb testing.TB, n, frames int) time.Duration {
+	tb.Helper()
+	streams := make([]*OpusCodec, n)
+	for i := range streams {
+		codec, err := NewOpusCodec()
+		if err != nil {
+			tb.Fatal(err)
+		}
+		defer codec.Close()
+		streams[i] = codec
+	}
+	input := make([][]byte, frames)
+	for i := range input {
+		input[i] = speechFrame(i)
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for _, s := range streams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, frame := range input {
+				packet, err := s.Encode(frame)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if _, err := s.Decode(packet); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		tb.Fatal(err)
+	}
+	return time.Since(start)
+}
+
+// BenchmarkOpusSessions reports the wall time n concurrent sessions spend in
+// the codec as a share of the audio's duration; at 100% they fall behind.
+// Sessions run in parallel, so the share falls with GOMAXPROCS.
+func BenchmarkOpusSessions(b *testing.B) {
+	for _, n := range []int{1, 10, 50, 100, 200} {
+		b.Run(fmt.Sprintf("sessions=%d", n), func(b *testing.B) {
+			const frames = 50 // One second of audio
+			var elapsed time.Duration
+			for b.Loop() {
+				elapsed += runSessions(b, n, frames)
+			}
+			audio := time.Duration(b.N) * frames * opusFrameTime
+			b.ReportMetric(100*float64(elapsed)/float64(audio), "%realtime")
+		})
+	}
+}
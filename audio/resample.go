@@ -0,0 +1,30 @@
+package audio
+
+import "encoding/binary"
+
+// PCM24kToPCM16k resamples Gemini's PCM 24kHz audio (16-bit LE) to 16kHz by
+// linear interpolation: every 3 input samples become 2
+func PCM24kToPCM16k(pcmData []byte) []byte {
+	inSamples := len(pcmData) / 2
+	outSamples := inSamples * 2 / 3
+	out := make([]byte, outSamples*2)
+
+	sampleAt := func(i int) int32 {
+		if i >= inSamples {
+			i = inSamples - 1
+		}
+		return int32(int16(binary.LittleEndian.Uint16(pcmData[i*2:])))
+	}
+
+	for j := 0; j < outSamples; j++ {
+		// Output sample j sits 1.5*j input samples in
+		pos := j * 3
+		i, frac := pos/2, pos%2
+		sample := sampleAt(i)
+		if frac == 1 {
+			sample = (sample + sampleAt(i+1)) / 2
+		}
+		binary.LittleEndian.PutUint16(out[j*2:], uint16(int16(sample)))
+	}
+	return out
+}
@@ -0,0 +1,28 @@
+package auth
+
+import (
+	"github.com/room4-2/OpenConverse/tenant"
+)
+
+// TenantKeys authenticates static API keys declared in the tenants file
+type TenantKeys struct {
+	tenants *tenant.Registry
+}
+
+// NewTenantKeys creates an authenticator backed by the tenants' API keys
+func NewTenantKeys(tenants *tenant.Registry) *TenantKeys {
+	return &TenantKeys{tenants: tenants}
+}
+
+// Authenticate implements Authenticator
+func (k *TenantKeys) Authenticate(credential string) (*Claims, error) {
+	t, ok := k.tenants.Lookup(credential)
+	if !ok {
+		return nil, ErrInvalidCredential
+	}
+	return &Claims{
+		Subject: t.ID,
+		Tenant:  t.ID,
+		Profile: t.Profile,
+	}, nil
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Subprotocol is the WebSocket subprotocol the server selects when a client
+// passes its credential through Sec-WebSocket-Protocol
+const Subprotocol = "openconverse"
+
+// bearerProtocolPrefix marks the Sec-WebSocket-Protocol entry carrying the credential
+const bearerProtocolPrefix = "bearer."
+
+var (
+	// ErrMissingCredential is returned when the request carries no credential
+	ErrMissingCredential = errors.New("missing credential")
+	// ErrInvalidCredential is returned when no authenticator accepts the credential
+	ErrInvalidCredential = errors.New("invalid credential")
+)
+
+// Claims describes an authenticated client
+type Claims struct {
+	Subject   string    // Who the credential was issued to
+	Tenant    string    // Tenant billed for the client's sessions ("" for none)
+	Profile   string    // Agent profile the client may use ("" for the default)
+	ExpiresAt time.Time // Zero for credentials that don't expire
+}
+
+// Authenticator verifies a client credential and returns its claims
+type Authenticator interface {
+	Authenticate(credential string) (*Claims, error)
+}
+
+// Chain tries each authenticator in turn and returns the first success
+type Chain []Authenticator
+
+// Authenticate implements Authenticator
+func (c Chain) Authenticate(credential string) (*Claims, error) {
+	err := ErrInvalidCredential
+	for _, a := range c {
+		claims, aerr := a.Authenticate(credential)
+		if aerr == nil {
+			return claims, nil
+		}
+		// Keep the most specific failure (e.g. an expired token) for logging
+		if aerr != ErrInvalidCredential {
+			err = aerr
+		}
+	}
+	return nil, err
+}
+
+// Credential extracts the client credential from a WebSocket upgrade request.
+// It is looked up, in order, in Sec-WebSocket-Protocol ("bearer.<token>"),
+// the Authorization bearer header, the X-API-Key header and the "token" or
+// "api_key" query parameters. Browsers can only use the first and the last.
+func Credential(r *http.Request) string {
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(header, ",") {
+			protocol = strings.TrimSpace(protocol)
+			if strings.HasPrefix(protocol, bearerProtocolPrefix) {
+				return strings.TrimPrefix(protocol, bearerProtocolPrefix)
+			}
+		}
+	}
+
+	if authz := r.Header.Get("Authorization"); len(authz) > 7 && strings.EqualFold(authz[:7], "Bearer ") {
+		return strings.TrimSpace(authz[7:])
+	}
+
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+
+	query := r.URL.Query()
+	if token := query.Get("token"); token != "" {
+		return token
+	}
+	return query.Get("api_key")
+}
+
+// Request authenticates an HTTP request with the given authenticator
+func Request(a Authenticator, r *http.Request) (*Claims, error) {
+	credential := Credential(r)
+	if credential == "" {
+		return nil, ErrMissingCredential
+	}
+	return a.Authenticate(credential)
+}
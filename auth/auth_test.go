@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/room4-2/OpenConverse/tenant"
+)
+
+func TestCredential(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		headers map[string]string
+		want    string
+	}{
+		{"subprotocol", "/ws", map[string]string{"Sec-WebSocket-Protocol": "openconverse, bearer.tok1"}, "tok1"},
+		{"subprotocol first", "/ws?token=tok2", map[string]string{
+			"Sec-WebSocket-Protocol": "bearer.tok1", "Authorization": "Bearer tok3", "X-API-Key": "key1"}, "tok1"},
+		{"authorization", "/ws", map[string]string{"Authorization": "Bearer tok3"}, "tok3"},
+		{"authorization lower case", "/ws", map[string]string{"Authorization": "bearer  tok3 "}, "tok3"},
+		{"authorization before api key", "/ws", map[string]string{"Authorization": "Bearer tok3", "X-API-Key": "key1"}, "tok3"},
+		{"basic authorization ignored", "/ws", map[string]string{"Authorization": "Basic dXNlcjpwYXNz"}, ""},
+		{"api key header", "/ws?api_key=key2", map[string]string{"X-API-Key": "key1"}, "key1"},
+		{"token query", "/ws?token=tok2&api_key=key2", nil, "tok2"},
+		{"api key query", "/ws?api_key=key2", nil, "key2"},
+		{"subprotocol without bearer", "/ws", map[string]string{"Sec-WebSocket-Protocol": "openconverse"}, ""},
+		{"none", "/ws", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.target, nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			if got := Credential(r); got != tt.want {
+				t.Errorf("Credential = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequest(t *testing.T) {
+	tenants, err := tenant.NewRegistry([]*tenant.Tenant{
+		{ID: "acme", APIKeys: []string{"key-acme"}, Profile: "support"},
+		{ID: "globex", APIKeys: []string{"key-globex-1", "key-globex-2"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := Chain{NewTenantKeys(tenants), NewJWTVerifier(testSecret, nil, "", "", time.Hour)}
+	jwtToken := sign(t, jwt.SigningMethodHS256, testSecret, "", jwt.MapClaims{
+		"sub": "browser", "tenant": "acme", "exp": time.Now().Add(time.Minute).Unix()})
+	expired := sign(t, jwt.SigningMethodHS256, testSecret, "", jwt.MapClaims{
+		"sub": "browser", "exp": time.Now().Add(-time.Hour).Unix()})
+
+	tests := []struct {
+		name       string
+		target     string
+		header     string
+		value      string
+		wantErr    error
+		wantTenant string
+	}{
+		{"api key header", "/ws", "X-API-Key", "key-acme", nil, "acme"},
+		{"api key query", "/ws?api_key=key-globex-2", "", "", nil, "globex"},
+		{"jwt subprotocol", "/ws", "Sec-WebSocket-Protocol", Subprotocol + ", bearer." + jwtToken, nil, "acme"},
+		{"jwt authorization", "/ws", "Authorization", "Bearer " + jwtToken, nil, "acme"},
+		{"jwt query", "/ws?token=" + jwtToken, "", "", nil, "acme"},
+		{"unknown api key", "/ws", "X-API-Key", "key-unknown", ErrInvalidCredential, ""},
+		{"expired jwt", "/ws", "Authorization", "Bearer " + expired, ErrInvalidCredential, ""},
+		{"missing", "/ws", "", "", ErrMissingCredential, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.target, nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			claims, err := Request(a, r)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Request = %+v, %v, want %v", claims, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Request: %v", err)
+			}
+			if claims.Tenant != tt.wantTenant {
+				t.Errorf("tenant = %q, want %q", claims.Tenant, tt.wantTenant)
+			}
+		})
+	}
+}
+
+func TestTenantKeys(t *testing.T) {
+	tenants, err := tenant.NewRegistry([]*tenant.Tenant{{ID: "acme", APIKeys: []string{"key-acme"}, Profile: "support"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := NewTenantKeys(tenants)
+
+	claims, err := keys.Authenticate("key-acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "acme" || claims.Tenant != "acme" || claims.Profile != "support" || !claims.ExpiresAt.IsZero() {
+		t.Errorf("claims = %+v", claims)
+	}
+	for _, key := range []string{"", "key-acm", "KEY-ACME", "acme"} {
+		if _, err := keys.Authenticate(key); err != ErrInvalidCredential {
+			t.Errorf("Authenticate(%q) = %v, want ErrInvalidCredential", key, err)
+		}
+	}
+}
+
+func TestChainKeepsSpecificError(t *testing.T) {
+	expired := sign(t, jwt.SigningMethodHS256, testSecret, "", jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()})
+	_, err := Chain{NewJWTVerifier(testSecret, nil, "", "", 0)}.Authenticate(expired)
+	if !errors.Is(err, jwt.ErrTokenExpired) {
+		t.Errorf("Authenticate = %v, want the verifier's expiry error", err)
+	}
+	if _, err := (Chain{}).Authenticate("anything"); err != ErrInvalidCredential {
+		t.Errorf("empty Chain = %v, want ErrInvalidCredential", err)
+	}
+}
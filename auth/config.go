@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"crypto"
+
+	"github.com/room4-2/OpenConverse/config"
+	"github.com/room4-2/OpenConverse/tenant"
+)
+
+// FromConfig builds an authenticator for every configured method: tenant API
+// keys, HMAC-signed JWTs and JWKS-verified JWTs. It returns nil when none is
+// configured, in which case the endpoint is left open.
+func FromConfig(cfg *config.Config, tenants *tenant.Registry) (Authenticator, error) {
+	var chain Chain
+
+	if tenants != nil {
+		chain = append(chain, NewTenantKeys(tenants))
+	}
+
+	var keys map[string]crypto.PublicKey
+	if cfg.AuthJWKSFile != "" {
+		var err error
+		keys, err = LoadJWKS(cfg.AuthJWKSFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.AuthJWTSecret != "" || len(keys) > 0 {
+		var secret []byte
+		if cfg.AuthJWTSecret != "" {
+			secret = []byte(cfg.AuthJWTSecret)
+		}
+		chain = append(chain, NewJWTVerifier(secret, keys, cfg.AuthJWTIssuer, cfg.AuthJWTAudience, cfg.AuthTokenMaxTTL))
+	}
+
+	if len(chain) == 0 {
+		return nil, nil
+	}
+	return chain, nil
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwtClaims are the JWT claims understood by the server
+type jwtClaims struct {
+	jwt.RegisteredClaims
+	Tenant  string `json:"tenant,omitempty"`
+	Profile string `json:"profile,omitempty"`
+}
+
+// JWTVerifier authenticates short-lived JWTs signed either with a shared
+// HMAC secret (HS256/384/512) or with a key from a JWKS (RS*, PS*, ES*)
+type JWTVerifier struct {
+	secret   []byte                      // HMAC secret (nil disables HS*)
+	keys     map[string]crypto.PublicKey // JWKS public keys by key ID
+	issuer   string                      // Required "iss" (optional)
+	audience string                      // Required "aud" (optional)
+	maxTTL   time.Duration               // Longest accepted remaining lifetime (0 = any)
+}
+
+// NewJWTVerifier creates a JWT authenticator. Either secret or keys must be set.
+func NewJWTVerifier(secret []byte, keys map[string]crypto.PublicKey, issuer, audience string, maxTTL time.Duration) *JWTVerifier {
+	return &JWTVerifier{
+		secret:   secret,
+		keys:     keys,
+		issuer:   issuer,
+		audience: audience,
+		maxTTL:   maxTTL,
+	}
+}
+
+// Authenticate implements Authenticator
+func (v *JWTVerifier) Authenticate(credential string) (*Claims, error) {
+	// Not a JWT, leave it to other authenticators
+	if strings.Count(credential, ".") != 2 {
+		return nil, ErrInvalidCredential
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(v.methods()),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30 * time.Second),
+	}
+	if v.issuer != "" {
+		opts = append(opts, jwt.WithIssuer(v.issuer))
+	}
+	if v.audience != "" {
+		opts = append(opts, jwt.WithAudience(v.audience))
+	}
+
+	var claims jwtClaims
+	if _, err := jwt.ParseWithClaims(credential, &claims, v.keyFunc, opts...); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCredential, err)
+	}
+
+	expiresAt := claims.ExpiresAt.Time
+	if v.maxTTL > 0 && time.Until(expiresAt) > v.maxTTL {
+		return nil, fmt.Errorf("%w: token lifetime exceeds %s", ErrInvalidCredential, v.maxTTL)
+	}
+
+	return &Claims{
+		Subject:   claims.Subject,
+		Tenant:    claims.Tenant,
+		Profile:   claims.Profile,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// methods lists the signing algorithms accepted with the configured keys
+func (v *JWTVerifier) methods() []string {
+	var methods []string
+	if len(v.secret) > 0 {
+		methods = append(methods, "HS256", "HS384", "HS512")
+	}
+	if len(v.keys) > 0 {
+		methods = append(methods, "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512")
+	}
+	return methods
+}
+
+// keyFunc returns the verification key for a parsed token
+func (v *JWTVerifier) keyFunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		return v.secret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, ok := v.keys[kid]
+	if !ok && kid == "" && len(v.keys) == 1 {
+		// Single-key sets may be used without a "kid"
+		for _, k := range v.keys {
+			key, ok = k, true
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	switch token.Method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		if _, isRSA := key.(*rsa.PublicKey); !isRSA {
+			return nil, fmt.Errorf("key %q is not an RSA key", kid)
+		}
+	case *jwt.SigningMethodECDSA:
+		if _, isEC := key.(*ecdsa.PublicKey); !isEC {
+			return nil, fmt.Errorf("key %q is not an EC key", kid)
+		}
+	}
+	return key, nil
+}
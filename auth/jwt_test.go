@@ -0,0 +1,233 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var testSecret = []byte("test-secret-at-least-32-bytes-long")
+
+// sign returns a token with the given claims, signed by method with key and
+// carrying kid when it isn't empty
+func sign(t *testing.T, method jwt.SigningMethod, key any, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+	return signed
+}
+
+// validClaims returns claims every verifier below accepts
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":     "client-1",
+		"tenant":  "acme",
+		"profile": "support",
+		"iss":     "https://issuer.example",
+		"aud":     "openconverse",
+		"exp":     time.Now().Add(5 * time.Minute).Unix(),
+	}
+}
+
+func with(claims jwt.MapClaims, key string, value any) jwt.MapClaims {
+	if value == nil {
+		delete(claims, key)
+	} else {
+		claims[key] = value
+	}
+	return claims
+}
+
+func TestJWTVerifier(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherRSAKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: must(x509.MarshalPKIXPublicKey(&rsaKey.PublicKey))})
+
+	keys := map[string]crypto.PublicKey{"rsa": &rsaKey.PublicKey, "ec": &ecKey.PublicKey}
+	hmacOnly := NewJWTVerifier(testSecret, nil, "https://issuer.example", "openconverse", time.Hour)
+	jwksOnly := NewJWTVerifier(nil, keys, "https://issuer.example", "openconverse", time.Hour)
+	both := NewJWTVerifier(testSecret, keys, "", "", 0)
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		verifier *JWTVerifier
+		token    string
+		wantErr  bool
+	}{
+		{"HS256", hmacOnly, sign(t, jwt.SigningMethodHS256, testSecret, "", validClaims()), false},
+		{"HS512", hmacOnly, sign(t, jwt.SigningMethodHS512, testSecret, "", validClaims()), false},
+		{"RS256", jwksOnly, sign(t, jwt.SigningMethodRS256, rsaKey, "rsa", validClaims()), false},
+		{"PS256", jwksOnly, sign(t, jwt.SigningMethodPS256, rsaKey, "rsa", validClaims()), false},
+		{"ES256", jwksOnly, sign(t, jwt.SigningMethodES256, ecKey, "ec", validClaims()), false},
+		{"HS and RS on one verifier", both, sign(t, jwt.SigningMethodHS256, testSecret, "", validClaims()), false},
+		{"expired within leeway", hmacOnly, sign(t, jwt.SigningMethodHS256, testSecret, "",
+			with(validClaims(), "exp", now.Add(-10*time.Second).Unix())), false},
+
+		// Algorithm confusion: the RSA public key used as an HMAC secret
+		{"HS256 signed with the RSA public key", jwksOnly, sign(t, jwt.SigningMethodHS256, rsaPEM, "rsa", validClaims()), true},
+		{"RS256 without JWKS", hmacOnly, sign(t, jwt.SigningMethodRS256, rsaKey, "rsa", validClaims()), true},
+		{"none", both, sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", validClaims()), true},
+		{"ES256 with an RSA kid", jwksOnly, sign(t, jwt.SigningMethodES256, ecKey, "rsa", validClaims()), true},
+		{"RS256 with an EC kid", jwksOnly, sign(t, jwt.SigningMethodRS256, rsaKey, "ec", validClaims()), true},
+
+		{"wrong secret", hmacOnly, sign(t, jwt.SigningMethodHS256, []byte("another-secret-another-secret-xx"), "", validClaims()), true},
+		{"wrong RSA key", jwksOnly, sign(t, jwt.SigningMethodRS256, otherRSAKey, "rsa", validClaims()), true},
+		{"unknown kid", jwksOnly, sign(t, jwt.SigningMethodRS256, rsaKey, "rotated", validClaims()), true},
+		{"no kid with several keys", jwksOnly, sign(t, jwt.SigningMethodRS256, rsaKey, "", validClaims()), true},
+		{"missing exp", hmacOnly, sign(t, jwt.SigningMethodHS256, testSecret, "", with(validClaims(), "exp", nil)), true},
+		{"expired beyond leeway", hmacOnly, sign(t, jwt.SigningMethodHS256, testSecret, "",
+			with(validClaims(), "exp", now.Add(-time.Minute).Unix())), true},
+		{"not yet valid", hmacOnly, sign(t, jwt.SigningMethodHS256, testSecret, "",
+			with(validClaims(), "nbf", now.Add(time.Minute).Unix())), true},
+		{"lifetime over max TTL", hmacOnly, sign(t, jwt.SigningMethodHS256, testSecret, "",
+			with(validClaims(), "exp", now.Add(2*time.Hour).Unix())), true},
+		{"wrong issuer", hmacOnly, sign(t, jwt.SigningMethodHS256, testSecret, "",
+			with(validClaims(), "iss", "https://evil.example")), true},
+		{"missing issuer", hmacOnly, sign(t, jwt.SigningMethodHS256, testSecret, "", with(validClaims(), "iss", nil)), true},
+		{"wrong audience", hmacOnly, sign(t, jwt.SigningMethodHS256, testSecret, "",
+			with(validClaims(), "aud", "someone-else")), true},
+		{"missing audience", hmacOnly, sign(t, jwt.SigningMethodHS256, testSecret, "", with(validClaims(), "aud", nil)), true},
+		{"tampered payload", hmacOnly, tamper(sign(t, jwt.SigningMethodHS256, testSecret, "", validClaims())), true},
+		{"not a JWT", hmacOnly, "sk_live_123", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := tt.verifier.Authenticate(tt.token)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidCredential) {
+					t.Fatalf("Authenticate = %+v, %v, want ErrInvalidCredential", claims, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate: %v", err)
+			}
+			if claims.Subject != "client-1" || claims.Tenant != "acme" || claims.Profile != "support" || claims.ExpiresAt.IsZero() {
+				t.Errorf("claims = %+v", claims)
+			}
+		})
+	}
+}
+
+func TestJWTVerifierSingleKeyWithoutKid(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := NewJWTVerifier(nil, map[string]crypto.PublicKey{"only": &ecKey.PublicKey}, "", "", 0)
+	if _, err := v.Authenticate(sign(t, jwt.SigningMethodES384, ecKey, "", validClaims())); err != nil {
+		t.Errorf("Authenticate of a kid-less token against a single-key set: %v", err)
+	}
+}
+
+// tamper replaces the token's payload with one granting another tenant
+func tamper(token string) string {
+	parts := strings.Split(token, ".")
+	parts[1] = base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"client-1","tenant":"other","exp":9999999999}`))
+	return strings.Join(parts, ".")
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func TestLoadJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	rsaJWK := `{"kty":"RSA","kid":"rsa","use":"sig","n":"` + b64(rsaKey.N.Bytes()) + `","e":"` + b64(big.NewInt(int64(rsaKey.E)).Bytes()) + `"}`
+	ecJWK := `{"kty":"EC","kid":"ec","crv":"P-256","x":"` + b64(ecKey.X.FillBytes(make([]byte, 32))) + `","y":"` + b64(ecKey.Y.FillBytes(make([]byte, 32))) + `"}`
+	offCurve := `{"kty":"EC","kid":"bad","crv":"P-256","x":"` + b64(ecKey.X.FillBytes(make([]byte, 32))) + `","y":"` + b64(make([]byte, 32)) + `"}`
+
+	write := func(t *testing.T, content string) string {
+		path := filepath.Join(t.TempDir(), "jwks.json")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		keys, err := LoadJWKS(write(t, `{"keys":[`+rsaJWK+`,`+ecJWK+`,{"kty":"RSA","kid":"enc","use":"enc"}]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != 2 {
+			t.Fatalf("got %d keys, want 2 (encryption keys skipped)", len(keys))
+		}
+		if pub, ok := keys["rsa"].(*rsa.PublicKey); !ok || !pub.Equal(&rsaKey.PublicKey) {
+			t.Errorf("rsa key = %v", keys["rsa"])
+		}
+		if pub, ok := keys["ec"].(*ecdsa.PublicKey); !ok || !pub.Equal(&ecKey.PublicKey) {
+			t.Errorf("ec key = %v", keys["ec"])
+		}
+
+		v := NewJWTVerifier(nil, keys, "", "", 0)
+		if _, err := v.Authenticate(sign(t, jwt.SigningMethodES256, ecKey, "ec", validClaims())); err != nil {
+			t.Errorf("Authenticate with a loaded EC key: %v", err)
+		}
+	})
+
+	for _, tt := range []struct {
+		name, content string
+	}{
+		{"not JSON", `keys`},
+		{"no signing keys", `{"keys":[]}`},
+		{"unsupported key type", `{"keys":[{"kty":"oct","kid":"k"}]}`},
+		{"unsupported curve", `{"keys":[{"kty":"EC","kid":"k","crv":"secp256k1","x":"AA","y":"AA"}]}`},
+		{"point off the curve", `{"keys":[` + offCurve + `]}`},
+		{"short coordinates", `{"keys":[{"kty":"EC","kid":"k","crv":"P-256","x":"AA","y":"AA"}]}`},
+		{"bad modulus", `{"keys":[{"kty":"RSA","kid":"k","n":"!!","e":"AQAB"}]}`},
+		{"huge exponent", `{"keys":[{"kty":"RSA","kid":"k","n":"AQAB","e":"` + b64(append([]byte{1}, make([]byte, 8)...)) + `"}]}`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if keys, err := LoadJWKS(write(t, tt.content)); err == nil {
+				t.Errorf("LoadJWKS = %v, want an error", keys)
+			}
+		})
+	}
+
+	if _, err := LoadJWKS(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("LoadJWKS of a missing file succeeded")
+	}
+}
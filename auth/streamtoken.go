@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stream token errors
+var (
+	ErrMalformedToken = errors.New("malformed stream token")
+	ErrInvalidToken   = errors.New("invalid stream token signature")
+	ErrExpiredToken   = errors.New("stream token expired")
+	ErrReusedToken    = errors.New("stream token already used")
+)
+
+// A token is the nonce, the expiry, the encoded StreamCall and their MAC
+const (
+	nonceSize   = 16
+	expirySize  = 8
+	macSize     = sha256.Size
+	payloadSize = nonceSize + expirySize // Without the call
+)
+
+// StreamCall is what the signed webhook of a call, or the dialer of an
+// outbound call, tells its stream
+type StreamCall struct {
+	Caller    string            // The caller reported by the provider (the callee of an outbound call), "" when unknown
+	Profile   string            // Agent profile answering the call, "" for the default
+	Outbound  bool              // The assistant placed the call and speaks first
+	Variables map[string]string // Template variables of the profile's outbound prompt
+}
+
+// variablePrefix prefixes the query keys of StreamCall.Variables
+const variablePrefix = "var."
+
+// NonceStore records the nonces of consumed tokens where every instance
+// sees them; store.SessionStore implements it
+type NonceStore interface {
+	Claim(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+// StreamTokens issues and verifies one-time tokens embedded in the media
+// stream URL a telephony provider's call webhook is answered with, so only
+// calls set up through the webhook can open the stream. A token also carries
+// the caller and profile chosen by the signed webhook, so the stream can't be
+// opened in someone else's name or with another agent, and for outbound calls
+// the variables they were placed with, so any instance can run the call.
+type StreamTokens struct {
+	secret []byte
+	ttl    time.Duration
+	nonces NonceStore // nil when tokens are one-time per instance only
+
+	mu   sync.Mutex
+	used map[string]time.Time // nonce -> expiry, kept until the token would have expired anyway
+}
+
+// NewStreamTokens creates a token issuer signing with secret; tokens are
+// valid for ttl. Consumed tokens are remembered in process and, unless nonces
+// is nil, in nonces, so a token can't be replayed against another instance.
+func NewStreamTokens(secret []byte, ttl time.Duration, nonces NonceStore) *StreamTokens {
+	return &StreamTokens{
+		secret: secret,
+		ttl:    ttl,
+		nonces: nonces,
+		used:   make(map[string]time.Time),
+	}
+}
+
+// Issue returns a new URL-safe token for call
+func (t *StreamTokens) Issue(call StreamCall) (string, error) {
+	values := url.Values{}
+	if call.Caller != "" {
+		values.Set("caller", call.Caller)
+	}
+	if call.Profile != "" {
+		values.Set("profile", call.Profile)
+	}
+	if call.Outbound {
+		values.Set("outbound", "1")
+	}
+	for name, value := range call.Variables {
+		values.Set(variablePrefix+name, value)
+	}
+	encoded := values.Encode()
+
+	payload := make([]byte, payloadSize, payloadSize+len(encoded)+macSize)
+	if _, err := rand.Read(payload[:nonceSize]); err != nil {
+		return "", err
+	}
+	binary.BigEndian.PutUint64(payload[nonceSize:], uint64(time.Now().Add(t.ttl).Unix()))
+	payload = append(payload, encoded...)
+
+	return base64.RawURLEncoding.EncodeToString(append(payload, t.sign(payload)...)), nil
+}
+
+// Verify checks a token's signature and expiry, consumes it and returns the
+// call it was issued for. When the shared nonce store fails, the token is
+// still checked against the ones consumed by this instance.
+func (t *StreamTokens) Verify(ctx context.Context, token string) (StreamCall, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(raw) < payloadSize+macSize {
+		return StreamCall{}, ErrMalformedToken
+	}
+
+	payload, mac := raw[:len(raw)-macSize], raw[len(raw)-macSize:]
+	if !hmac.Equal(mac, t.sign(payload)) {
+		return StreamCall{}, ErrInvalidToken
+	}
+
+	expiry := time.Unix(int64(binary.BigEndian.Uint64(payload[nonceSize:])), 0)
+	now := time.Now()
+	if now.After(expiry) {
+		return StreamCall{}, ErrExpiredToken
+	}
+
+	nonce := payload[:nonceSize]
+	if err := t.consume(string(nonce), expiry, now); err != nil {
+		return StreamCall{}, err
+	}
+	if t.nonces != nil {
+		claimed, err := t.nonces.Claim(ctx, "stream-token:"+base64.RawURLEncoding.EncodeToString(nonce), expiry.Sub(now)+time.Second)
+		if err == nil && !claimed {
+			return StreamCall{}, ErrReusedToken
+		}
+	}
+	values, err := url.ParseQuery(string(payload[payloadSize:]))
+	if err != nil {
+		return StreamCall{}, ErrMalformedToken
+	}
+	call := StreamCall{Caller: values.Get("caller"), Profile: values.Get("profile"), Outbound: values.Get("outbound") == "1"}
+	for key := range values {
+		if name, ok := strings.CutPrefix(key, variablePrefix); ok {
+			if call.Variables == nil {
+				call.Variables = make(map[string]string)
+			}
+			call.Variables[name] = values.Get(key)
+		}
+	}
+	return call, nil
+}
+
+// consume records a nonce as used by this instance
+func (t *StreamTokens) consume(nonce string, expiry, now time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Forget consumed tokens that have expired anyway
+	for n, exp := range t.used {
+		if now.After(exp) {
+			delete(t.used, n)
+		}
+	}
+
+	if _, used := t.used[nonce]; used {
+		return ErrReusedToken
+	}
+	t.used[nonce] = expiry
+	return nil
+}
+
+func (t *StreamTokens) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte("openconverse-stream-token"))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
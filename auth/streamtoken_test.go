@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/room4-2/OpenConverse/store"
+)
+
+func TestStreamTokens(t *testing.T) {
+	ctx := context.Background()
+	tokens := NewStreamTokens([]byte("secret"), time.Minute, nil)
+
+	token, err := tokens.Issue(StreamCall{Caller: "+13122010094", Profile: "support"})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	call, err := tokens.Verify(ctx, token)
+	if err != nil {
+		t.Fatalf("Verify of a fresh token: %v", err)
+	}
+	if want := (StreamCall{Caller: "+13122010094", Profile: "support"}); !reflect.DeepEqual(call, want) {
+		t.Errorf("call = %+v, want %+v", call, want)
+	}
+	if _, err := tokens.Verify(ctx, token); !errors.Is(err, ErrReusedToken) {
+		t.Errorf("second Verify = %v, want ErrReusedToken", err)
+	}
+
+	anonymous, _ := tokens.Issue(StreamCall{})
+	if call, err := tokens.Verify(ctx, anonymous); err != nil || !reflect.DeepEqual(call, StreamCall{}) {
+		t.Errorf("Verify of a token without a call = %+v, %v", call, err)
+	}
+
+	other, _ := NewStreamTokens([]byte("other secret"), time.Minute, nil).Issue(StreamCall{})
+	if _, err := tokens.Verify(ctx, other); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify of another issuer's token = %v, want ErrInvalidToken", err)
+	}
+
+	expired, _ := NewStreamTokens([]byte("secret"), -time.Second, nil).Issue(StreamCall{})
+	if _, err := tokens.Verify(ctx, expired); !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("Verify of an expired token = %v, want ErrExpiredToken", err)
+	}
+
+	fresh, _ := tokens.Issue(StreamCall{})
+	tampered := strings.Map(func(r rune) rune {
+		if r == 'A' {
+			return 'B'
+		}
+		return r
+	}, fresh)
+	if tampered != fresh {
+		if _, err := tokens.Verify(ctx, tampered); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Verify of a tampered token = %v, want ErrInvalidToken", err)
+		}
+	}
+
+	for _, malformed := range []string{"", "not base64!", "c2hvcnQ"} {
+		if _, err := tokens.Verify(ctx, malformed); !errors.Is(err, ErrMalformedToken) {
+			t.Errorf("Verify(%q) = %v, want ErrMalformedToken", malformed, err)
+		}
+	}
+}
+
+// Outbound calls carry their variables to whichever instance the stream reaches
+func TestStreamTokensOutboundCall(t *testing.T) {
+	tokens := NewStreamTokens([]byte("secret"), time.Minute, nil)
+	want := StreamCall{
+		Caller:    "+13122010094",
+		Profile:   "sales",
+		Outbound:  true,
+		Variables: map[string]string{"name": "Ada Lovelace", "order": "#42&co"},
+	}
+	token, err := tokens.Issue(want)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	call, err := tokens.Verify(context.Background(), token)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if !reflect.DeepEqual(call, want) {
+		t.Errorf("call = %+v, want %+v", call, want)
+	}
+}
+
+// The call can't be changed without invalidating the token
+func TestStreamTokensSignCall(t *testing.T) {
+	tokens := NewStreamTokens([]byte("secret"), time.Minute, nil)
+	token, err := tokens.Issue(StreamCall{Caller: "+13122010094", Profile: "support"})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	raw, _ := base64.RawURLEncoding.DecodeString(token)
+	mac := raw[len(raw)-macSize:]
+	spoofed := append(append(raw[:payloadSize:payloadSize], "caller=%2B15550100000&profile=support"...), mac...)
+	if _, err := tokens.Verify(context.Background(), base64.RawURLEncoding.EncodeToString(spoofed)); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify of a token with another caller = %v, want ErrInvalidToken", err)
+	}
+}
+
+// A token consumed by one instance is rejected by the others sharing its store
+func TestStreamTokensShareNonces(t *testing.T) {
+	ctx := context.Background()
+	nonces := store.NewMemory()
+	first := NewStreamTokens([]byte("secret"), time.Minute, nonces)
+	second := NewStreamTokens([]byte("secret"), time.Minute, nonces)
+
+	token, err := first.Issue(StreamCall{})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if _, err := first.Verify(ctx, token); err != nil {
+		t.Fatalf("Verify on the issuing instance: %v", err)
+	}
+	if _, err := second.Verify(ctx, token); !errors.Is(err, ErrReusedToken) {
+		t.Errorf("Verify on another instance = %v, want ErrReusedToken", err)
+	}
+
+	// Without the shared store, each instance only knows its own tokens
+	alone := NewStreamTokens([]byte("secret"), time.Minute, nil)
+	if _, err := alone.Verify(ctx, token); err != nil {
+		t.Errorf("Verify on an instance without a nonce store: %v", err)
+	}
+}
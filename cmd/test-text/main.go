@@ -36,7 +36,9 @@ func main() {
 	}
 
 	// Setup session (no tools for this test)
-	err = proxy.Setup(context.Background(), "You are a helpful assistant. Keep responses brief.", nil)
+	err = proxy.Setup(context.Background(), gemini.SetupOptions{
+		SystemPrompt: "You are a helpful assistant. Keep responses brief.",
+	})
 	if err != nil {
 		log.Fatalf("Failed to setup: %v", err)
 	}
@@ -1,12 +1,18 @@
 package config
 
 import (
+	"crypto/ed25519"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/room4-2/OpenConverse/telnyx"
+	"github.com/room4-2/OpenConverse/twilio"
+
 	"github.com/joho/godotenv"
 )
 
@@ -14,15 +20,88 @@ import (
 type Config struct {
 	Port            int
 	TwilioPort      int    // Port for Twilio server (used when ServerType is "both")
-	ServerType      string // "websocket", "twilio", or "both"
+	ServerType      string // "websocket", "twilio", "both" or "sip"
 	RedisURL        string
 	RedisPassword   string
+	SessionStore    string // "redis" or "memory"
 	MaxSessions     int
 	SessionTimeout  time.Duration
 	GeminiAPIKey    string
 	AllowedOrigins  []string
 	KeepAlivePeriod time.Duration
 	MaxBufferSize   int // Maximum audio buffer size in bytes per session
+
+	// Gemini context window compression (disabled when trigger is 0)
+	CompressionTriggerTokens int64
+	CompressionTargetTokens  int64 // 0 lets Gemini default to half the trigger
+
+	GeminiPoolSize   int           // Pre-warmed Gemini connections per agent profile (0 disables the pool)
+	GeminiPoolMaxAge time.Duration // Pooled connections are replaced once this old
+
+	MaxSessionDuration time.Duration // Hard cap on session length (0 disables)
+	SessionEndWarning  time.Duration // How long before the cap the caller is warned
+
+	// Keys pressed by callers are grouped into entries
+	DTMFInterDigitTimeout time.Duration // An entry ends after this long without a key
+	DTMFTerminator        string        // Key ending an entry ("" disables it)
+	DTMFMaxDigits         int           // An entry ends at this many keys (0 = no limit)
+
+	TenantsFile  string // JSON file with tenants, their API keys and quotas (optional)
+	TwilioTenant string // Tenant billed for Twilio calls (optional)
+
+	TwilioAuthToken         string // Validates Twilio webhook signatures and signs stream tokens (optional)
+	PublicBaseURL           string // Externally visible base URL, e.g. https://voice.example.com (optional)
+	TwilioStatusCallbackURL string // Twilio posts media stream status events here (optional)
+
+	// Outbound calls through the Twilio REST API (disabled without TwilioAccountSID)
+	TwilioAccountSID string // Account placing the calls, authenticated with TwilioAuthToken
+	TwilioFromNumber string // Default caller ID (optional)
+	TwilioAPIURL     string // Base URL of the REST API, e.g. a local mock
+
+	TelnyxTenant    string            // Tenant billed for Telnyx calls (optional)
+	TelnyxPublicKey ed25519.PublicKey // Validates Telnyx webhook signatures (optional, with TelnyxAPIKey)
+	TelnyxAPIKey    string            // Signs Telnyx stream tokens (optional, with TelnyxPublicKey)
+
+	VonageTenant          string // Tenant billed for Vonage calls (optional)
+	VonageSignatureSecret string // Validates Vonage signed webhooks and signs stream tokens (optional)
+
+	InsecurePhoneStreams bool // Accept media streams without a stream token from providers whose secret isn't set
+
+	AgentProfilesFile string // JSON file with agent profiles (optional)
+
+	// Client authentication for /ws (open when no method is configured)
+	AuthJWTSecret   string        // Shared secret for HS256/384/512 JWTs
+	AuthJWKSFile    string        // JWKS file with public keys for RS/PS/ES JWTs
+	AuthJWTIssuer   string        // Required "iss" claim (optional)
+	AuthJWTAudience string        // Required "aud" claim (optional)
+	AuthTokenMaxTTL time.Duration // Longest accepted token lifetime
+
+	LogLevel  string // "debug", "info", "warn" or "error"
+	LogFormat string // "text" or "json"
+	LogRedact bool   // Mask phone numbers, emails and transcript text in logs
+
+	TracingExporter string // "otlp", "stdout" or "" (tracing disabled)
+
+	DrainTimeout time.Duration // How long shutdown waits for active sessions to finish
+	DrainWarning time.Duration // Callers still connected this long before the drain deadline are told goodbye (0 disables)
+
+	AdminPort  int    // Port of the admin API (0 disables it)
+	AdminToken string // Bearer token required by the admin API
+
+	NodeID             string // Identifies this instance in the Redis session registry
+	ClusterMaxSessions int    // Concurrent sessions across all instances sharing Redis (0 = unlimited)
+
+	// SIP gateway (ServerType "sip")
+	SIPPort            int          // SIP signalling port, UDP and TCP
+	SIPPublicIP        string       // Address advertised in SDP and Contact (optional, else the local address facing the caller)
+	SIPTenant          string       // Tenant billed for SIP calls (optional)
+	SIPAllowedNetworks []*net.IPNet // Sources allowed to send INVITEs (empty allows all)
+	RTPPortMin         int          // First port of the RTP media range
+	RTPPortMax         int          // Last port of the RTP media range
+
+	// WebRTC endpoint of the WebSocket server
+	WebRTCPublicIP string // Address advertised in ICE candidates (optional, else the local addresses)
+	WebRTCUDPPort  int    // One UDP port for all WebRTC media (0 uses a port per connection)
 }
 
 // LoadConfig loads configuration from environment variables with defaults
@@ -36,11 +115,32 @@ func LoadConfig() (*Config, error) {
 		ServerType:      "websocket",
 		RedisURL:        "localhost:6379",
 		RedisPassword:   "",
+		SessionStore:    "redis",
 		MaxSessions:     100,
 		SessionTimeout:  30 * time.Minute,
 		AllowedOrigins:  []string{"*"},
 		KeepAlivePeriod: 30 * time.Second,
 		MaxBufferSize:   5 * 1024 * 1024, // 5MB default
+
+		GeminiPoolMaxAge: 5 * time.Minute,
+
+		SessionEndWarning: 1 * time.Minute, // Used once MAX_SESSION_DURATION enables the limit
+
+		DTMFInterDigitTimeout: 3 * time.Second,
+		DTMFTerminator:        "#",
+
+		AuthTokenMaxTTL: 60 * time.Minute,
+
+		DrainTimeout: 30 * time.Second,
+
+		TwilioAPIURL: twilio.DefaultAPIURL,
+
+		SIPPort:    5060,
+		RTPPortMin: 10000,
+		RTPPortMax: 20000,
+
+		LogLevel:  "info",
+		LogFormat: "text",
 	}
 
 	// Required: GEMINI_API_KEY
@@ -68,6 +168,14 @@ func LoadConfig() (*Config, error) {
 		config.RedisPassword = redisPassword
 	}
 
+	// Optional: SESSION_STORE
+	if sessionStore := os.Getenv("SESSION_STORE"); sessionStore != "" {
+		if sessionStore != "redis" && sessionStore != "memory" {
+			return nil, fmt.Errorf("invalid SESSION_STORE: must be redis or memory")
+		}
+		config.SessionStore = sessionStore
+	}
+
 	// Optional: MAX_SESSIONS
 	if maxSessions := os.Getenv("MAX_SESSIONS"); maxSessions != "" {
 		m, err := strconv.Atoi(maxSessions)
@@ -109,14 +217,71 @@ func LoadConfig() (*Config, error) {
 		config.MaxBufferSize = b
 	}
 
-	// Optional: SERVER_TYPE ("websocket", "twilio", or "both")
+	// Optional: SERVER_TYPE ("websocket", "twilio", "both" or "sip")
 	if serverType := os.Getenv("SERVER_TYPE"); serverType != "" {
 		switch serverType {
-		case "websocket", "twilio", "both":
+		case "websocket", "twilio", "both", "sip":
 			config.ServerType = serverType
 		default:
-			return nil, fmt.Errorf("invalid SERVER_TYPE: must be 'websocket', 'twilio', or 'both'")
+			return nil, fmt.Errorf("invalid SERVER_TYPE: must be 'websocket', 'twilio', 'both' or 'sip'")
+		}
+	}
+
+	// Optional: SIP_PORT
+	if sipPort := os.Getenv("SIP_PORT"); sipPort != "" {
+		p, err := strconv.Atoi(sipPort)
+		if err != nil || p <= 0 || p > 65535 {
+			return nil, fmt.Errorf("invalid SIP_PORT: must be a port number")
+		}
+		config.SIPPort = p
+	}
+
+	// Optional: SIP_PUBLIC_IP
+	if publicIP := os.Getenv("SIP_PUBLIC_IP"); publicIP != "" {
+		if ip := net.ParseIP(publicIP); ip == nil || ip.To4() == nil {
+			return nil, fmt.Errorf("invalid SIP_PUBLIC_IP: must be an IPv4 address")
+		}
+		config.SIPPublicIP = publicIP
+	}
+
+	// Optional: SIP_TENANT
+	config.SIPTenant = os.Getenv("SIP_TENANT")
+
+	// Optional: SIP_ALLOWED_NETWORKS (comma-separated CIDRs or addresses)
+	if networks := os.Getenv("SIP_ALLOWED_NETWORKS"); networks != "" {
+		for _, network := range strings.Split(networks, ",") {
+			network = strings.TrimSpace(network)
+			if !strings.Contains(network, "/") {
+				network += "/32"
+				if strings.Contains(network, ":") {
+					network = strings.TrimSuffix(network, "/32") + "/128"
+				}
+			}
+			_, ipNet, err := net.ParseCIDR(network)
+			if err != nil {
+				return nil, fmt.Errorf("invalid SIP_ALLOWED_NETWORKS: %w", err)
+			}
+			config.SIPAllowedNetworks = append(config.SIPAllowedNetworks, ipNet)
+		}
+	}
+
+	// Optional: RTP_PORT_MIN, RTP_PORT_MAX
+	if portMin := os.Getenv("RTP_PORT_MIN"); portMin != "" {
+		p, err := strconv.Atoi(portMin)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RTP_PORT_MIN: %w", err)
 		}
+		config.RTPPortMin = p
+	}
+	if portMax := os.Getenv("RTP_PORT_MAX"); portMax != "" {
+		p, err := strconv.Atoi(portMax)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RTP_PORT_MAX: %w", err)
+		}
+		config.RTPPortMax = p
+	}
+	if config.RTPPortMin <= 0 || config.RTPPortMax > 65535 || config.RTPPortMax-config.RTPPortMin < 1 {
+		return nil, fmt.Errorf("invalid RTP port range: need 0 < RTP_PORT_MIN < RTP_PORT_MAX <= 65535")
 	}
 
 	// Optional: TWILIO_PORT (used when SERVER_TYPE is "both")
@@ -128,5 +293,299 @@ func LoadConfig() (*Config, error) {
 		config.TwilioPort = tp
 	}
 
+	// Optional: CONTEXT_COMPRESSION_TRIGGER_TOKENS
+	if trigger := os.Getenv("CONTEXT_COMPRESSION_TRIGGER_TOKENS"); trigger != "" {
+		t, err := strconv.ParseInt(trigger, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CONTEXT_COMPRESSION_TRIGGER_TOKENS: %w", err)
+		}
+		config.CompressionTriggerTokens = t
+	}
+
+	// Optional: CONTEXT_COMPRESSION_TARGET_TOKENS
+	if target := os.Getenv("CONTEXT_COMPRESSION_TARGET_TOKENS"); target != "" {
+		t, err := strconv.ParseInt(target, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CONTEXT_COMPRESSION_TARGET_TOKENS: %w", err)
+		}
+		config.CompressionTargetTokens = t
+	}
+
+	if config.CompressionTargetTokens > 0 && config.CompressionTargetTokens >= config.CompressionTriggerTokens {
+		return nil, fmt.Errorf("CONTEXT_COMPRESSION_TARGET_TOKENS must be lower than CONTEXT_COMPRESSION_TRIGGER_TOKENS")
+	}
+
+	// Optional: GEMINI_POOL_SIZE (per agent profile)
+	if poolSize := os.Getenv("GEMINI_POOL_SIZE"); poolSize != "" {
+		p, err := strconv.Atoi(poolSize)
+		if err != nil || p < 0 {
+			return nil, fmt.Errorf("invalid GEMINI_POOL_SIZE: must be a non-negative integer")
+		}
+		config.GeminiPoolSize = p
+	}
+
+	// Optional: GEMINI_POOL_MAX_AGE (in seconds)
+	if maxAge := os.Getenv("GEMINI_POOL_MAX_AGE"); maxAge != "" {
+		a, err := strconv.Atoi(maxAge)
+		if err != nil || a <= 0 {
+			return nil, fmt.Errorf("invalid GEMINI_POOL_MAX_AGE: must be a positive integer")
+		}
+		config.GeminiPoolMaxAge = time.Duration(a) * time.Second
+	}
+
+	// Optional: MAX_SESSION_DURATION (in minutes, 0 disables the limit)
+	if maxDuration := os.Getenv("MAX_SESSION_DURATION"); maxDuration != "" {
+		d, err := strconv.Atoi(maxDuration)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MAX_SESSION_DURATION: %w", err)
+		}
+		config.MaxSessionDuration = time.Duration(d) * time.Minute
+	}
+
+	// Optional: SESSION_END_WARNING (in seconds before MAX_SESSION_DURATION)
+	if warning := os.Getenv("SESSION_END_WARNING"); warning != "" {
+		w, err := strconv.Atoi(warning)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SESSION_END_WARNING: %w", err)
+		}
+		config.SessionEndWarning = time.Duration(w) * time.Second
+	}
+	if config.MaxSessionDuration < 0 || config.SessionEndWarning < 0 {
+		return nil, fmt.Errorf("MAX_SESSION_DURATION and SESSION_END_WARNING must not be negative")
+	}
+	if config.MaxSessionDuration > 0 && config.SessionEndWarning >= config.MaxSessionDuration {
+		return nil, fmt.Errorf("SESSION_END_WARNING must be shorter than MAX_SESSION_DURATION")
+	}
+
+	// Optional: DTMF_INTER_DIGIT_TIMEOUT (in seconds)
+	if timeout := os.Getenv("DTMF_INTER_DIGIT_TIMEOUT"); timeout != "" {
+		t, err := strconv.Atoi(timeout)
+		if err != nil || t <= 0 {
+			return nil, fmt.Errorf("invalid DTMF_INTER_DIGIT_TIMEOUT: must be a positive number of seconds")
+		}
+		config.DTMFInterDigitTimeout = time.Duration(t) * time.Second
+	}
+
+	// Optional: DTMF_TERMINATOR ("#", "*" or "none")
+	if terminator := os.Getenv("DTMF_TERMINATOR"); terminator != "" {
+		switch terminator {
+		case "#", "*":
+			config.DTMFTerminator = terminator
+		case "none":
+			config.DTMFTerminator = ""
+		default:
+			return nil, fmt.Errorf("invalid DTMF_TERMINATOR: must be #, * or none")
+		}
+	}
+
+	// Optional: DTMF_MAX_DIGITS (0 = no limit)
+	if maxDigits := os.Getenv("DTMF_MAX_DIGITS"); maxDigits != "" {
+		n, err := strconv.Atoi(maxDigits)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid DTMF_MAX_DIGITS: must be a non-negative number")
+		}
+		config.DTMFMaxDigits = n
+	}
+
+	// Optional: TENANTS_FILE
+	config.TenantsFile = os.Getenv("TENANTS_FILE")
+
+	// Optional: TWILIO_TENANT
+	config.TwilioTenant = os.Getenv("TWILIO_TENANT")
+
+	// Optional: TWILIO_AUTH_TOKEN
+	config.TwilioAuthToken = os.Getenv("TWILIO_AUTH_TOKEN")
+
+	// Optional: TELNYX_TENANT
+	config.TelnyxTenant = os.Getenv("TELNYX_TENANT")
+
+	// Optional: TELNYX_PUBLIC_KEY and TELNYX_API_KEY (both or neither)
+	config.TelnyxAPIKey = os.Getenv("TELNYX_API_KEY")
+	if publicKey := os.Getenv("TELNYX_PUBLIC_KEY"); publicKey != "" {
+		key, err := telnyx.ParsePublicKey(publicKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TELNYX_PUBLIC_KEY: %w", err)
+		}
+		config.TelnyxPublicKey = key
+	}
+	if (config.TelnyxPublicKey == nil) != (config.TelnyxAPIKey == "") {
+		return nil, fmt.Errorf("invalid Telnyx config: TELNYX_PUBLIC_KEY and TELNYX_API_KEY must be set together")
+	}
+
+	// Optional: VONAGE_TENANT
+	config.VonageTenant = os.Getenv("VONAGE_TENANT")
+
+	// Optional: VONAGE_SIGNATURE_SECRET
+	config.VonageSignatureSecret = os.Getenv("VONAGE_SIGNATURE_SECRET")
+
+	// Optional: INSECURE_PHONE_STREAMS
+	if insecure := os.Getenv("INSECURE_PHONE_STREAMS"); insecure != "" {
+		i, err := strconv.ParseBool(insecure)
+		if err != nil {
+			return nil, fmt.Errorf("invalid INSECURE_PHONE_STREAMS: %w", err)
+		}
+		config.InsecurePhoneStreams = i
+	}
+
+	// Optional: WEBRTC_PUBLIC_IP
+	if publicIP := os.Getenv("WEBRTC_PUBLIC_IP"); publicIP != "" {
+		if net.ParseIP(publicIP) == nil {
+			return nil, fmt.Errorf("invalid WEBRTC_PUBLIC_IP: must be an IP address")
+		}
+		config.WebRTCPublicIP = publicIP
+	}
+
+	// Optional: WEBRTC_UDP_PORT
+	if udpPort := os.Getenv("WEBRTC_UDP_PORT"); udpPort != "" {
+		p, err := strconv.Atoi(udpPort)
+		if err != nil || p < 0 || p > 65535 {
+			return nil, fmt.Errorf("invalid WEBRTC_UDP_PORT: must be a port number")
+		}
+		config.WebRTCUDPPort = p
+	}
+
+	// Optional: PUBLIC_BASE_URL (used behind reverse proxies)
+	if baseURL := os.Getenv("PUBLIC_BASE_URL"); baseURL != "" {
+		u, err := url.Parse(baseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid PUBLIC_BASE_URL: must be an http(s) URL")
+		}
+		config.PublicBaseURL = strings.TrimRight(baseURL, "/")
+	}
+
+	// Optional: TWILIO_STATUS_CALLBACK_URL
+	if callbackURL := os.Getenv("TWILIO_STATUS_CALLBACK_URL"); callbackURL != "" {
+		u, err := url.Parse(callbackURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid TWILIO_STATUS_CALLBACK_URL: must be an http(s) URL")
+		}
+		config.TwilioStatusCallbackURL = callbackURL
+	}
+
+	// Optional: TWILIO_ACCOUNT_SID (enables outbound calls), TWILIO_FROM_NUMBER, TWILIO_API_URL
+	config.TwilioAccountSID = os.Getenv("TWILIO_ACCOUNT_SID")
+	config.TwilioFromNumber = os.Getenv("TWILIO_FROM_NUMBER")
+	if apiURL := os.Getenv("TWILIO_API_URL"); apiURL != "" {
+		u, err := url.Parse(apiURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid TWILIO_API_URL: must be an http(s) URL")
+		}
+		config.TwilioAPIURL = strings.TrimRight(apiURL, "/")
+	}
+	if config.TwilioAccountSID != "" && (config.TwilioAuthToken == "" || config.PublicBaseURL == "") {
+		return nil, fmt.Errorf("invalid Twilio config: TWILIO_ACCOUNT_SID requires TWILIO_AUTH_TOKEN and PUBLIC_BASE_URL")
+	}
+
+	// Optional: AGENT_PROFILES_FILE
+	config.AgentProfilesFile = os.Getenv("AGENT_PROFILES_FILE")
+
+	// Optional: AUTH_JWT_SECRET, AUTH_JWKS_FILE, AUTH_JWT_ISSUER, AUTH_JWT_AUDIENCE
+	config.AuthJWTSecret = os.Getenv("AUTH_JWT_SECRET")
+	config.AuthJWKSFile = os.Getenv("AUTH_JWKS_FILE")
+	config.AuthJWTIssuer = os.Getenv("AUTH_JWT_ISSUER")
+	config.AuthJWTAudience = os.Getenv("AUTH_JWT_AUDIENCE")
+
+	// Optional: AUTH_TOKEN_MAX_TTL (in minutes)
+	if maxTTL := os.Getenv("AUTH_TOKEN_MAX_TTL"); maxTTL != "" {
+		t, err := strconv.Atoi(maxTTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AUTH_TOKEN_MAX_TTL: %w", err)
+		}
+		config.AuthTokenMaxTTL = time.Duration(t) * time.Minute
+	}
+
+	// Optional: LOG_LEVEL ("debug", "info", "warn" or "error")
+	if level := os.Getenv("LOG_LEVEL"); level != "" {
+		switch strings.ToLower(level) {
+		case "debug", "info", "warn", "error":
+			config.LogLevel = strings.ToLower(level)
+		default:
+			return nil, fmt.Errorf("invalid LOG_LEVEL: must be 'debug', 'info', 'warn', or 'error'")
+		}
+	}
+
+	// Optional: LOG_FORMAT ("text" or "json")
+	if format := os.Getenv("LOG_FORMAT"); format != "" {
+		switch strings.ToLower(format) {
+		case "text", "json":
+			config.LogFormat = strings.ToLower(format)
+		default:
+			return nil, fmt.Errorf("invalid LOG_FORMAT: must be 'text' or 'json'")
+		}
+	}
+
+	// Optional: LOG_REDACT
+	if redact := os.Getenv("LOG_REDACT"); redact != "" {
+		r, err := strconv.ParseBool(redact)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LOG_REDACT: %w", err)
+		}
+		config.LogRedact = r
+	}
+
+	// Optional: TRACING_EXPORTER ("otlp" or "stdout")
+	if exporter := os.Getenv("TRACING_EXPORTER"); exporter != "" {
+		switch strings.ToLower(exporter) {
+		case "otlp", "stdout":
+			config.TracingExporter = strings.ToLower(exporter)
+		case "none":
+		default:
+			return nil, fmt.Errorf("invalid TRACING_EXPORTER: must be 'otlp', 'stdout', or 'none'")
+		}
+	}
+
+	// Optional: DRAIN_TIMEOUT (in seconds)
+	if drainTimeout := os.Getenv("DRAIN_TIMEOUT"); drainTimeout != "" {
+		d, err := strconv.Atoi(drainTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DRAIN_TIMEOUT: %w", err)
+		}
+		config.DrainTimeout = time.Duration(d) * time.Second
+	}
+
+	// Optional: DRAIN_WARNING (in seconds before DRAIN_TIMEOUT)
+	if drainWarning := os.Getenv("DRAIN_WARNING"); drainWarning != "" {
+		w, err := strconv.Atoi(drainWarning)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DRAIN_WARNING: %w", err)
+		}
+		config.DrainWarning = time.Duration(w) * time.Second
+	}
+	if config.DrainWarning > 0 && config.DrainWarning >= config.DrainTimeout {
+		return nil, fmt.Errorf("DRAIN_WARNING (%s) must be less than DRAIN_TIMEOUT (%s)", config.DrainWarning, config.DrainTimeout)
+	}
+
+	// Optional: ADMIN_PORT (requires ADMIN_TOKEN)
+	if adminPort := os.Getenv("ADMIN_PORT"); adminPort != "" {
+		p, err := strconv.Atoi(adminPort)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ADMIN_PORT: %w", err)
+		}
+		config.AdminPort = p
+	}
+	config.AdminToken = os.Getenv("ADMIN_TOKEN")
+	if config.AdminPort != 0 && config.AdminToken == "" {
+		return nil, fmt.Errorf("ADMIN_TOKEN is required when ADMIN_PORT is set")
+	}
+
+	// Optional: NODE_ID (defaults to the hostname)
+	config.NodeID = os.Getenv("NODE_ID")
+	if config.NodeID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("NODE_ID is required when the hostname is unavailable: %w", err)
+		}
+		config.NodeID = hostname
+	}
+
+	// Optional: CLUSTER_MAX_SESSIONS
+	if clusterMax := os.Getenv("CLUSTER_MAX_SESSIONS"); clusterMax != "" {
+		m, err := strconv.Atoi(clusterMax)
+		if err != nil || m < 0 {
+			return nil, fmt.Errorf("invalid CLUSTER_MAX_SESSIONS: must be a non-negative integer")
+		}
+		config.ClusterMaxSessions = m
+	}
+
 	return config, nil
 }
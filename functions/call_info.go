@@ -0,0 +1,17 @@
+package functions
+
+import "google.golang.org/genai"
+
+// CallInfoName is the tool describing the current call
+const CallInfoName = "get_call_info"
+
+// CallInfoFunctionDeclaration returns the function declaration for Gemini
+func CallInfoFunctionDeclaration() *genai.FunctionDeclaration {
+	return &genai.FunctionDeclaration{
+		Name: CallInfoName,
+		Description: "Get what is known about the current call: the caller's number, the number they dialed, " +
+			"the call's identifier and the parameters it was set up with (e.g. a customer ID), " +
+			"the agent profile answering it, and for calls you placed, the variables they were placed with. " +
+			"Use it to recognize the caller or look up their records.",
+	}
+}
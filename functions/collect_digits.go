@@ -0,0 +1,55 @@
+package functions
+
+import (
+	"context"
+
+	"google.golang.org/genai"
+)
+
+// CollectDigitsName is the tool reading a secure entry from the caller's keypad
+const CollectDigitsName = "collect_digits"
+
+// CollectDigitsFunctionDeclaration returns the function declaration for Gemini
+func CollectDigitsFunctionDeclaration() *genai.FunctionDeclaration {
+	return &genai.FunctionDeclaration{
+		Name: CollectDigitsName,
+		Description: "Collect a number the caller types on their phone keypad, for private input such as a PIN, " +
+			"an account or a card number. Ask the caller to type it first. The digits are kept by the system and " +
+			"never shared with you: the result only says whether the caller typed them and how many.",
+		Parameters: &genai.Schema{
+			Type: genai.TypeObject,
+			Properties: map[string]*genai.Schema{
+				"name": {
+					Type:        genai.TypeString,
+					Description: "What is collected, e.g. \"pin\" or \"account_number\"",
+				},
+				"max_digits": {
+					Type:        genai.TypeInteger,
+					Description: "Length of the number, when known; the entry ends once this many digits are typed",
+				},
+			},
+			Required: []string{"name"},
+		},
+	}
+}
+
+// digitsKey is the context key of the collect_digits entries of a tool call
+type digitsKey struct{}
+
+// WithCollectedDigits returns the context of a tool call, from which tools
+// read the caller's collect_digits entries with CollectedDigits. lookup
+// returns the entry typed for a collect_digits call by its name.
+func WithCollectedDigits(ctx context.Context, lookup func(name string) (string, bool)) context.Context {
+	return context.WithValue(ctx, digitsKey{}, lookup)
+}
+
+// CollectedDigits returns what the caller typed for the collect_digits call
+// named name. Entries never reach Gemini; tools needing one, such as a PIN
+// check, read it from the context of their call instead.
+func CollectedDigits(ctx context.Context, name string) (string, bool) {
+	lookup, ok := ctx.Value(digitsKey{}).(func(string) (string, bool))
+	if !ok {
+		return "", false
+	}
+	return lookup(name)
+}
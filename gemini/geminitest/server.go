@@ -0,0 +1,241 @@
+// Package geminitest provides a fake Gemini Live API for tests: proxies
+// connected to it send their messages to the test, which answers as the
+// model would
+package geminitest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/room4-2/OpenConverse/gemini"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/genai"
+)
+
+// Message is a client message received by the fake
+type Message struct {
+	Audio          []byte // Realtime audio input
+	AudioStreamEnd bool   // The client ended its audio stream, completing the turn
+	Text           string // Text of a client content turn
+	ToolResponses  []*genai.FunctionResponse
+}
+
+// Server is a fake Live API. Every connection's setup message is consumed;
+// the messages that follow are delivered by Next.
+type Server struct {
+	t        testing.TB
+	srv      *httptest.Server
+	received chan Message
+	ready    chan struct{} // Closed once a client connected
+
+	mu        sync.Mutex
+	conns     []*websocket.Conn
+	connected int // Clients that connected since the server started
+}
+
+// NewServer starts a fake Live API that proxies created in the test
+// connect to, until the test ends
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	s := &Server{t: t, received: make(chan Message, 256), ready: make(chan struct{})}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.close)
+
+	// genai keeps ws:// base URLs as they are
+	t.Setenv("GOOGLE_GEMINI_BASE_URL", "ws://"+strings.TrimPrefix(s.srv.URL, "http://"))
+	return s
+}
+
+// Connect is a session Connector opening a Live session on the fake
+func (s *Server) Connect(ctx context.Context) (*gemini.Proxy, error) {
+	proxy, err := gemini.NewProxy(ctx, "test-key")
+	if err != nil {
+		return nil, err
+	}
+	if err := proxy.Setup(ctx, gemini.SetupOptions{}); err != nil {
+		return nil, err
+	}
+	return proxy, nil
+}
+
+// Next returns the next message a client sent, failing the test if none
+// arrives within a second
+func (s *Server) Next() Message {
+	s.t.Helper()
+	select {
+	case msg := <-s.received:
+		return msg
+	case <-time.After(time.Second):
+		s.t.Fatal("timed out waiting for a message to Gemini")
+		return Message{}
+	}
+}
+
+// Idle fails the test if a client sends a message within d
+func (s *Server) Idle(d time.Duration) {
+	s.t.Helper()
+	select {
+	case msg := <-s.received:
+		s.t.Fatalf("unexpected message to Gemini: %+v", msg)
+	case <-time.After(d):
+	}
+}
+
+// Send writes msg to every connected client, once one connected
+func (s *Server) Send(msg *genai.LiveServerMessage) {
+	s.t.Helper()
+	select {
+	case <-s.ready:
+	case <-time.After(time.Second):
+		s.t.Fatal("no client connected to Gemini")
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		s.t.Fatalf("marshal server message: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		_ = conn.WriteMessage(websocket.TextMessage, data)
+	}
+}
+
+// SendAudio has the model speak pcm, 24kHz 16-bit mono
+func (s *Server) SendAudio(pcm []byte) {
+	s.Send(&genai.LiveServerMessage{ServerContent: &genai.LiveServerContent{
+		ModelTurn: &genai.Content{Role: "model", Parts: []*genai.Part{
+			{InlineData: &genai.Blob{MIMEType: "audio/pcm;rate=24000", Data: pcm}},
+		}},
+	}})
+}
+
+// SendTurnComplete ends the model's turn
+func (s *Server) SendTurnComplete() {
+	s.Send(&genai.LiveServerMessage{ServerContent: &genai.LiveServerContent{TurnComplete: true}})
+}
+
+// SendGoAway tells every connected client that Gemini will soon close its session
+func (s *Server) SendGoAway() {
+	s.Send(&genai.LiveServerMessage{GoAway: &genai.LiveServerGoAway{TimeLeft: time.Second}})
+}
+
+// Drop closes every client connection, as Gemini does when it ends a session
+func (s *Server) Drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.conns = nil
+}
+
+// Connected returns how many clients have connected so far
+func (s *Server) Connected() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connected
+}
+
+// clientMessage is the wire form of the client messages the proxy sends
+type clientMessage struct {
+	RealtimeInput *struct {
+		Audio          *genai.Blob   `json:"audio"`
+		Media          *genai.Blob   `json:"media"`
+		MediaChunks    []*genai.Blob `json:"mediaChunks"`
+		AudioStreamEnd bool          `json:"audioStreamEnd"`
+	} `json:"realtimeInput"`
+	ClientContent *struct {
+		Turns []*genai.Content `json:"turns"`
+	} `json:"clientContent"`
+	ToolResponse *struct {
+		FunctionResponses []*genai.FunctionResponse `json:"functionResponses"`
+	} `json:"toolResponse"`
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// The setup message comes first
+	if _, _, err := conn.ReadMessage(); err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.conns = append(s.conns, conn)
+	s.connected++
+	if s.connected == 1 {
+		close(s.ready)
+	}
+	s.mu.Unlock()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var raw clientMessage
+		if err := json.Unmarshal(data, &raw); err != nil {
+			continue
+		}
+		s.received <- decode(raw)
+	}
+}
+
+// decode flattens a client message
+func decode(raw clientMessage) Message {
+	var msg Message
+	if in := raw.RealtimeInput; in != nil {
+		msg.AudioStreamEnd = in.AudioStreamEnd
+		for _, blob := range append([]*genai.Blob{in.Audio, in.Media}, in.MediaChunks...) {
+			if blob != nil {
+				msg.Audio = append(msg.Audio, blob.Data...)
+			}
+		}
+	}
+	if content := raw.ClientContent; content != nil {
+		for _, turn := range content.Turns {
+			for _, part := range turn.Parts {
+				msg.Text += part.Text
+			}
+		}
+	}
+	if tools := raw.ToolResponse; tools != nil {
+		msg.ToolResponses = tools.FunctionResponses
+	}
+	return msg
+}
+
+func (s *Server) close() {
+	s.mu.Lock()
+	for _, conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+	s.srv.Close()
+}
+
+// PCM returns n bytes of 16-bit PCM loud enough to count as speech
+func PCM(n int) []byte {
+	pcm := make([]byte, n)
+	for i := 0; i+1 < n; i += 2 {
+		sample := int16(8000)
+		if (i/2)%20 >= 10 {
+			sample = -8000
+		}
+		pcm[i] = byte(sample)
+		pcm[i+1] = byte(sample >> 8)
+	}
+	return pcm
+}
@@ -0,0 +1,171 @@
+package gemini
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// poolCheckInterval is how often a pool replaces expired and missing connections
+const poolCheckInterval = 5 * time.Second
+
+// Pool keeps Live sessions set up ahead of time so new calls skip the connect latency
+type Pool struct {
+	name    string
+	size    int
+	maxAge  time.Duration
+	connect func(ctx context.Context) (*Proxy, error)
+
+	mu    sync.Mutex
+	ready []pooledProxy // Oldest first
+
+	wake   chan struct{} // Signals the maintainer to refill after a checkout
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+type pooledProxy struct {
+	proxy       *Proxy
+	connectedAt time.Time
+}
+
+// NewPool creates a pool of `size` connections opened with connect and
+// replaced once older than maxAge, before Gemini would expire them. Call
+// Start to begin filling it.
+func NewPool(name string, size int, maxAge time.Duration, connect func(ctx context.Context) (*Proxy, error)) *Pool {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Pool{
+		name:    name,
+		size:    size,
+		maxAge:  maxAge,
+		connect: connect,
+		wake:    make(chan struct{}, 1),
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+}
+
+// Start fills the pool and keeps it full in the background
+func (p *Pool) Start() {
+	go p.maintain()
+}
+
+// Get checks out a ready connection, or returns nil when none is available.
+// Connections that expired or that Gemini closed while idle are discarded.
+// The caller owns the returned proxy and must start receiving on it.
+func (p *Pool) Get() *Proxy {
+	defer p.refill()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for len(p.ready) > 0 {
+		entry := p.ready[0]
+		p.ready = p.ready[1:]
+		if time.Since(entry.connectedAt) < p.maxAge && entry.proxy.Alive() {
+			return entry.proxy
+		}
+		entry.proxy.Close()
+	}
+	return nil
+}
+
+// Close stops refilling and closes the idle connections
+func (p *Pool) Close() {
+	p.cancel()
+	<-p.done
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, entry := range p.ready {
+		entry.proxy.Close()
+	}
+	p.ready = nil
+}
+
+// refill wakes the maintainer without blocking
+func (p *Pool) refill() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *Pool) maintain() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(poolCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		p.prune()
+		p.fill()
+
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-p.wake:
+		}
+	}
+}
+
+// prune closes connections that would expire before the next check, or
+// that Gemini closed while idle
+func (p *Pool) prune() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	fresh := p.ready[:0]
+	for _, entry := range p.ready {
+		if time.Since(entry.connectedAt)+poolCheckInterval >= p.maxAge {
+			entry.proxy.Close()
+			continue
+		}
+		if !entry.proxy.Alive() {
+			slog.Warn("Dropped pre-warmed Gemini connection closed while idle", "agent", p.name)
+			entry.proxy.Close()
+			continue
+		}
+		fresh = append(fresh, entry)
+	}
+	p.ready = fresh
+}
+
+// fill opens the missing connections concurrently
+func (p *Pool) fill() {
+	p.mu.Lock()
+	missing := p.size - len(p.ready)
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < missing; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			proxy, err := p.connect(p.ctx)
+			if err != nil {
+				if p.ctx.Err() == nil {
+					slog.Warn("Failed to pre-warm Gemini connection", "agent", p.name, "error", err)
+				}
+				return
+			}
+
+			// Read while idle, so a connection Gemini drops isn't handed out
+			proxy.Watch()
+
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			if p.ctx.Err() != nil {
+				proxy.Close()
+				return
+			}
+			p.ready = append(p.ready, pooledProxy{proxy: proxy, connectedAt: time.Now()})
+		}()
+	}
+	wg.Wait()
+}
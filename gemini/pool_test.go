@@ -0,0 +1,101 @@
+package gemini_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/room4-2/OpenConverse/gemini"
+	"github.com/room4-2/OpenConverse/gemini/geminitest"
+)
+
+// eventually fails the test unless cond holds within a second
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWatchNoticesDrop(t *testing.T) {
+	gm := geminitest.NewServer(t)
+	proxy, err := gm.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	t.Cleanup(func() { _ = proxy.Close() })
+
+	proxy.Watch()
+	if !proxy.Alive() {
+		t.Fatal("Alive = false for a fresh connection")
+	}
+	eventually(t, "the connection to reach Gemini", func() bool { return gm.Connected() == 1 })
+	gm.Drop()
+	eventually(t, "a dropped connection to be noticed", func() bool { return !proxy.Alive() })
+
+	// The error read while idle reaches the session once it starts receiving
+	errs := make(chan error, 2)
+	proxy.OnError = func(err error) { errs <- err }
+	proxy.StartReceiving(context.Background())
+	select {
+	case <-errs:
+	case <-time.After(time.Second):
+		t.Fatal("OnError not called for a connection dropped while idle")
+	}
+}
+
+func TestWatchNoticesGoAway(t *testing.T) {
+	gm := geminitest.NewServer(t)
+	proxy, err := gm.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	t.Cleanup(func() { _ = proxy.Close() })
+
+	proxy.Watch()
+	gm.SendGoAway()
+	eventually(t, "a GoAway to be noticed", func() bool { return !proxy.Alive() })
+}
+
+func TestClosedProxyNotAlive(t *testing.T) {
+	gm := geminitest.NewServer(t)
+	proxy, err := gm.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	proxy.Watch()
+	_ = proxy.Close()
+	if proxy.Alive() {
+		t.Error("Alive = true after Close")
+	}
+}
+
+// A pooled connection Gemini closed while idle is replaced, not handed out
+func TestPoolSkipsDeadConnections(t *testing.T) {
+	gm := geminitest.NewServer(t)
+	pool := gemini.NewPool("test", 1, time.Minute, gm.Connect)
+	pool.Start()
+	t.Cleanup(pool.Close)
+
+	eventually(t, "the pool to connect", func() bool { return gm.Connected() == 1 })
+	gm.Drop()
+	// Give the idle connection's reader time to see the close
+	time.Sleep(50 * time.Millisecond)
+
+	var proxy *gemini.Proxy
+	eventually(t, "a live pooled connection", func() bool {
+		proxy = pool.Get()
+		return proxy != nil
+	})
+	t.Cleanup(func() { _ = proxy.Close() })
+	if !proxy.Alive() {
+		t.Error("Get returned a dead connection")
+	}
+	if n := gm.Connected(); n != 2 {
+		t.Errorf("%d connections to Gemini, want the dropped one replaced", n)
+	}
+}
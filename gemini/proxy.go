@@ -4,15 +4,26 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
-	"log"
+	"log/slog"
 	"sync"
 
+	"github.com/room4-2/OpenConverse/logging"
+	"github.com/room4-2/OpenConverse/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"google.golang.org/genai"
 )
 
 const (
 	modelName = "models/gemini-2.5-flash-native-audio-preview-12-2025"
 	//modelName = "models/gemini-3-flash-preview"
+
+	// Available voices: Puck, Charon, Kore, Fenrir, Aoede, Leda, Orus, Zephyr
+	defaultVoice = "Zephyr"
+
+	// receiveBuffer is how many messages are held until StartReceiving delivers them
+	receiveBuffer = 64
 )
 
 // Proxy manages the connection to Gemini Live API using the official SDK
@@ -26,10 +37,35 @@ type Proxy struct {
 	OnText     func(text string)
 	OnComplete func()
 	OnToolCall func(functionCalls []*genai.FunctionCall) // Tool/function calls from model
+	OnUsage    func(usage *genai.UsageMetadata)          // Token usage reported by the model
 	OnError    func(err error)
 
-	mu     sync.RWMutex
-	closed bool
+	OnToolCallCancellation func(ids []string) // Tool calls the model no longer waits for, e.g. after an interruption
+
+	OnInputTranscription  func(text string) // Transcription fragment of the user's audio
+	OnOutputTranscription func(text string) // Transcription fragment of the model's audio
+
+	Logger *slog.Logger // Carries the owning session's attributes (nil uses slog.Default)
+
+	mu       sync.RWMutex
+	closed   bool
+	lost     bool          // Gemini closed the session or sent a GoAway
+	incoming chan received // Read from the session, nil until Watch or StartReceiving
+	stop     chan struct{} // Closed by Close to stop the reader
+}
+
+// SetupOptions configures a Live session at connect time
+type SetupOptions struct {
+	SystemPrompt string
+	Tools        []*genai.Tool
+	Voice        string              // Prebuilt voice name ("" for defaultVoice)
+	Compression  *ContextCompression // nil disables context window compression
+}
+
+// ContextCompression configures sliding-window compression of the session context
+type ContextCompression struct {
+	TriggerTokens int64 // Context size that triggers compression
+	TargetTokens  int64 // Context size kept after compression (0 = TriggerTokens/2)
 }
 
 // NewProxy creates and connects to Gemini Live API
@@ -48,8 +84,31 @@ func NewProxy(ctx context.Context, apiKey string) (*Proxy, error) {
 	}, nil
 }
 
+// logger returns the proxy's logger
+func (gp *Proxy) logger() *slog.Logger {
+	if gp.Logger != nil {
+		return gp.Logger
+	}
+	return slog.Default()
+}
+
 // Setup establishes the Live session
-func (gp *Proxy) Setup(ctx context.Context, systemPrompt string, tools []*genai.Tool) error {
+func (gp *Proxy) Setup(ctx context.Context, opts SetupOptions) (err error) {
+	voice := opts.Voice
+	if voice == "" {
+		voice = defaultVoice
+	}
+
+	ctx, span := tracing.Tracer().Start(ctx, "gemini.setup")
+	span.SetAttributes(attribute.String("gemini.model", modelName), attribute.String("gemini.voice", voice))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "gemini setup failed")
+		}
+		span.End()
+	}()
+
 	gp.mu.Lock()
 	defer gp.mu.Unlock()
 
@@ -62,20 +121,35 @@ func (gp *Proxy) Setup(ctx context.Context, systemPrompt string, tools []*genai.
 		ResponseModalities: []genai.Modality{"AUDIO"},
 		SystemInstruction: &genai.Content{
 			Parts: []*genai.Part{
-				{Text: systemPrompt},
+				{Text: opts.SystemPrompt},
 			},
 		},
-		Tools: tools,
+		Tools: opts.Tools,
+		// Transcribe both sides of the conversation for the live transcript
+		InputAudioTranscription:  &genai.AudioTranscriptionConfig{},
+		OutputAudioTranscription: &genai.AudioTranscriptionConfig{},
 		// Configure voice for TTS
 		SpeechConfig: &genai.SpeechConfig{
 			VoiceConfig: &genai.VoiceConfig{
 				PrebuiltVoiceConfig: &genai.PrebuiltVoiceConfig{
-					VoiceName: "Zephyr", // Available voices: Puck, Charon, Kore, Fenrir, Aoede, Leda, Orus, Zephyr
+					VoiceName: voice,
 				},
 			},
 		},
 	}
 
+	// Long calls would otherwise hit the context limit and be cut off
+	if opts.Compression != nil && opts.Compression.TriggerTokens > 0 {
+		compression := &genai.ContextWindowCompressionConfig{
+			TriggerTokens: &opts.Compression.TriggerTokens,
+			SlidingWindow: &genai.SlidingWindow{},
+		}
+		if opts.Compression.TargetTokens > 0 {
+			compression.SlidingWindow.TargetTokens = &opts.Compression.TargetTokens
+		}
+		config.ContextWindowCompression = compression
+	}
+
 	// Connect to the Live API
 	session, err := gp.client.Live.Connect(ctx, modelName, config)
 	if err != nil {
@@ -83,12 +157,90 @@ func (gp *Proxy) Setup(ctx context.Context, systemPrompt string, tools []*genai.
 	}
 
 	gp.session = session
-	log.Printf("✅ Connected to Gemini Live via SDK (%s)", modelName)
+	gp.logger().Info("Connected to Gemini Live", "model", modelName)
 	return nil
 }
 
-// StartReceiving begins listening for Gemini responses
+// received is a message or error read from the Live session
+type received struct {
+	resp *genai.LiveServerMessage
+	err  error
+}
+
+// Watch starts reading the Live session ahead of StartReceiving, so an idle
+// connection that Gemini closes or asks to leave is noticed by Alive.
+// Messages read before StartReceiving are delivered once it's called.
+func (gp *Proxy) Watch() {
+	gp.startReading()
+}
+
+// Alive reports whether the Live session is connected and Gemini hasn't
+// closed it or sent a GoAway
+func (gp *Proxy) Alive() bool {
+	gp.mu.RLock()
+	defer gp.mu.RUnlock()
+	return !gp.closed && !gp.lost && gp.session != nil
+}
+
+// startReading starts the goroutine reading the Live session, once
+func (gp *Proxy) startReading() <-chan received {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+
+	if gp.incoming == nil {
+		gp.incoming = make(chan received, receiveBuffer)
+		gp.stop = make(chan struct{})
+		go gp.read(gp.incoming, gp.stop)
+	}
+	return gp.incoming
+}
+
+// read reads the Live session into incoming until it fails or the proxy is closed
+func (gp *Proxy) read(incoming chan<- received, stop <-chan struct{}) {
+	defer close(incoming)
+
+	for {
+		gp.mu.RLock()
+		if gp.closed || gp.session == nil {
+			gp.mu.RUnlock()
+			return
+		}
+		session := gp.session
+		gp.mu.RUnlock()
+
+		// Receive blocks until a message arrives or error occurs
+		resp, err := session.Receive()
+		if err != nil {
+			gp.mu.Lock()
+			gp.lost = true
+			closed := gp.closed
+			gp.mu.Unlock()
+
+			if !closed {
+				select {
+				case incoming <- received{err: err}:
+				case <-stop:
+				}
+			}
+			return
+		}
+		if resp.GoAway != nil {
+			gp.mu.Lock()
+			gp.lost = true
+			gp.mu.Unlock()
+		}
+
+		select {
+		case incoming <- received{resp: resp}:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// StartReceiving begins delivering Gemini responses to the callbacks
 func (gp *Proxy) StartReceiving(ctx context.Context) {
+	incoming := gp.startReading()
 	go func() {
 		defer func() {
 			if gp.OnError != nil {
@@ -96,56 +248,47 @@ func (gp *Proxy) StartReceiving(ctx context.Context) {
 			}
 		}()
 
-		for {
-			gp.mu.RLock()
-			if gp.closed || gp.session == nil {
-				gp.mu.RUnlock()
-				return
-			}
-			session := gp.session
-			gp.mu.RUnlock()
-
-			// Receive blocks until a message arrives or error occurs
-			resp, err := session.Receive()
-			if err != nil {
-				gp.mu.RLock()
-				closed := gp.closed
-				gp.mu.RUnlock()
-
-				if !closed {
-					log.Printf("❌ Gemini receive error: %v", err)
-					if gp.OnError != nil {
-						gp.OnError(err)
-					}
+		for msg := range incoming {
+			if msg.err != nil {
+				gp.logger().Error("Gemini receive error", "error", msg.err)
+				if gp.OnError != nil {
+					gp.OnError(msg.err)
 				}
 				return
 			}
-
-			gp.handleResponse(resp)
+			gp.handleResponse(msg.resp)
 		}
 	}()
 }
 
 func (gp *Proxy) handleResponse(resp *genai.LiveServerMessage) {
+	if resp.UsageMetadata != nil && gp.OnUsage != nil {
+		gp.OnUsage(resp.UsageMetadata)
+	}
+
 	// Handle Tool Calls
 	if resp.ToolCall != nil && len(resp.ToolCall.FunctionCalls) > 0 {
-		log.Printf("📥 Received from Gemini: %d function call(s)", len(resp.ToolCall.FunctionCalls))
+		gp.logger().Debug("Received function calls from Gemini", "count", len(resp.ToolCall.FunctionCalls))
 		if gp.OnToolCall != nil {
 			gp.OnToolCall(resp.ToolCall.FunctionCalls)
 		}
 	}
 
+	if resp.ToolCallCancellation != nil && len(resp.ToolCallCancellation.IDs) > 0 && gp.OnToolCallCancellation != nil {
+		gp.OnToolCallCancellation(resp.ToolCallCancellation.IDs)
+	}
+
 	// Handle Server Content
 	if resp.ServerContent != nil {
 		if resp.ServerContent.ModelTurn != nil {
 			for _, part := range resp.ServerContent.ModelTurn.Parts {
 				if part.Text != "" && gp.OnText != nil {
-					log.Printf("📥 Received from Gemini: text '%s'", part.Text)
+					gp.logger().Debug("Received text from Gemini", logging.KeyTranscript, part.Text)
 					gp.OnText(part.Text)
 				}
 				if part.InlineData != nil {
 					// SDK provides raw bytes in InlineData.Data
-					log.Printf("📥 Received from Gemini: %d bytes audio", len(part.InlineData.Data))
+					gp.logger().Debug("Received audio from Gemini", "bytes", len(part.InlineData.Data))
 
 					if gp.OnAudioRaw != nil {
 						encoded := base64.StdEncoding.EncodeToString(part.InlineData.Data)
@@ -157,8 +300,15 @@ func (gp *Proxy) handleResponse(resp *genai.LiveServerMessage) {
 			}
 		}
 
+		if t := resp.ServerContent.InputTranscription; t != nil && t.Text != "" && gp.OnInputTranscription != nil {
+			gp.OnInputTranscription(t.Text)
+		}
+		if t := resp.ServerContent.OutputTranscription; t != nil && t.Text != "" && gp.OnOutputTranscription != nil {
+			gp.OnOutputTranscription(t.Text)
+		}
+
 		if resp.ServerContent.TurnComplete && gp.OnComplete != nil {
-			log.Println("📥 Received from Gemini: turn complete")
+			gp.logger().Debug("Received turn complete from Gemini")
 			gp.OnComplete()
 		}
 	}
@@ -219,7 +369,7 @@ func (gp *Proxy) SendText(text string) error {
 		return fmt.Errorf("failed to send text: %w", err)
 	}
 
-	log.Printf("📤 Sent text to Gemini: %s", text)
+	gp.logger().Debug("Sent text to Gemini", logging.KeyTranscript, text)
 	return nil
 }
 
@@ -245,7 +395,7 @@ func (gp *Proxy) sendRealtimeInput(data []byte) error {
 		return fmt.Errorf("failed to send audio: %w", err)
 	}
 
-	log.Printf("📤 Sent %d bytes audio to Gemini", len(data))
+	gp.logger().Debug("Sent audio to Gemini", "bytes", len(data))
 	return nil
 }
 
@@ -268,7 +418,7 @@ func (gp *Proxy) sendTurnComplete() error {
 		return fmt.Errorf("failed to send audio stream end: %w", err)
 	}
 
-	log.Println("📤 Sent audio stream end to Gemini")
+	gp.logger().Debug("Sent audio stream end to Gemini")
 	return nil
 }
 
@@ -290,7 +440,7 @@ func (gp *Proxy) SendToolResponse(responses []*genai.FunctionResponse) error {
 		return fmt.Errorf("failed to send tool response: %w", err)
 	}
 
-	log.Printf("📤 Sent %d tool response(s) to Gemini", len(responses))
+	gp.logger().Debug("Sent tool responses to Gemini", "count", len(responses))
 	return nil
 }
 
@@ -303,6 +453,9 @@ func (gp *Proxy) Close() error {
 		return nil
 	}
 	gp.closed = true
+	if gp.stop != nil {
+		close(gp.stop)
+	}
 
 	if gp.session != nil {
 		return gp.session.Close()
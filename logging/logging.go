@@ -0,0 +1,107 @@
+// Package logging configures the server's structured logger
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/room4-2/OpenConverse/config"
+)
+
+// Attribute keys shared across the server
+const (
+	KeySessionID = "session_id"
+	KeyTenant    = "tenant"
+	KeyTransport = "transport"
+	KeyAgent     = "agent"
+
+	// KeyTranscript holds caller or model speech and text, masked entirely when redacting
+	KeyTranscript = "transcript"
+)
+
+var (
+	emailPattern  = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	phonePattern  = regexp.MustCompile(`\+\d{7,15}\b|\(?\b\d{3}\)?[\s.-]?\d{3}[\s.-]\d{4}\b`)
+	bearerPattern = regexp.MustCompile(`(?i)\bBearer\s+[A-Za-z0-9._~+/=-]+`)
+	jwtPattern    = regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	apiKeyPattern = regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`) // Google API keys
+)
+
+// Setup installs the logger described by cfg as the process default, which
+// also routes the standard library's log package through it
+func Setup(cfg *config.Config) error {
+	logger, err := New(os.Stderr, cfg.LogLevel, cfg.LogFormat, cfg.LogRedact)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+// New creates a logger writing to w at the given level ("debug", "info",
+// "warn" or "error") and format ("text" or "json")
+func New(w io.Writer, level, format string, redact bool) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	if redact {
+		opts.ReplaceAttr = redactAttr
+	}
+
+	switch format {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text", "":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+}
+
+// Redact masks credentials, phone numbers and email addresses in s
+func Redact(s string) string {
+	s = bearerPattern.ReplaceAllString(s, "Bearer [token]")
+	s = jwtPattern.ReplaceAllString(s, "[token]")
+	s = apiKeyPattern.ReplaceAllString(s, "[api key]")
+	s = emailPattern.ReplaceAllString(s, "[email]")
+	return phonePattern.ReplaceAllString(s, "[phone]")
+}
+
+// secretKey reports whether attributes named key hold credentials
+func secretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, suffix := range []string{"token", "secret", "password", "api_key", "apikey", "authorization"} {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactAttr masks transcripts and credentials, and phone numbers and emails
+// in strings and errors
+func redactAttr(_ []string, a slog.Attr) slog.Attr {
+	if a.Key == KeyTranscript {
+		return slog.String(a.Key, fmt.Sprintf("[redacted %d chars]", len(a.Value.String())))
+	}
+	if a.Value.Kind() == slog.KindString && secretKey(a.Key) {
+		return slog.String(a.Key, "[redacted]")
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, Redact(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, Redact(err.Error()))
+		}
+	}
+	return a
+}
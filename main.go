@@ -2,109 +2,161 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/room4-2/OpenConverse/auth"
 	"github.com/room4-2/OpenConverse/config"
+	"github.com/room4-2/OpenConverse/logging"
 	"github.com/room4-2/OpenConverse/server"
 	"github.com/room4-2/OpenConverse/session"
+	"github.com/room4-2/OpenConverse/store"
+	"github.com/room4-2/OpenConverse/tracing"
 )
 
 func main() {
 	// Load configuration
 	cfg, err := config.LoadConfig()
 	if err != nil {
-		log.Fatalf("Failed to load config: %v", err)
+		fatal("Failed to load config", err)
+	}
+
+	if err := logging.Setup(cfg); err != nil {
+		fatal("Failed to configure logging", err)
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg)
+	if err != nil {
+		fatal("Failed to configure tracing", err)
+	}
+
+	sessionStore, err := store.FromConfig(cfg)
+	if err != nil {
+		fatal("Failed to create session store", err)
 	}
 
 	// Create session manager
-	sessionManager, err := session.NewManager(cfg)
+	sessionManager, err := session.NewManager(cfg, sessionStore)
+	if err != nil {
+		fatal("Failed to create session manager", err)
+	}
+
+	// Build client authentication for /ws
+	authenticator, err := auth.FromConfig(cfg, sessionManager.Tenants())
 	if err != nil {
-		log.Fatalf("Failed to create session manager: %v", err)
+		fatal("Failed to configure authentication", err)
+	}
+	if authenticator == nil && (cfg.ServerType == "websocket" || cfg.ServerType == "both") {
+		slog.Warn("No authentication configured, /ws and /webrtc are open to anyone")
 	}
 
 	// Start cleanup routine
 	ctx, cancel := context.WithCancel(context.Background())
 	go sessionManager.StartCleanupRoutine(ctx)
 
-	// Handle graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-
+	// Servers for the configured mode; the admin API runs alongside on its own port
+	var servers []namedServer
+	var dialer *server.Dialer // Outbound calls stream back to the Twilio server
 	switch cfg.ServerType {
 	case "websocket":
-		srv := server.NewServerWebsocket(cfg, sessionManager)
-
-		go func() {
-			<-sigChan
-			log.Println("\nReceived shutdown signal...")
-			cancel()
-			shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
-			defer shutdownCancel()
-			if err := srv.Shutdown(shutdownCtx); err != nil {
-				log.Printf("Server shutdown error: %v", err)
-			}
-		}()
-
-		if err := srv.Start(); err != nil && err.Error() != "http: Server closed" {
-			log.Fatalf("Server error: %v", err)
-		}
-
+		servers = append(servers, namedServer{"WebSocket", server.NewServerWebsocket(cfg, sessionManager, authenticator)})
 	case "twilio":
-		twilioSrv := server.NewWebsocketTwilio(cfg, sessionManager)
+		twilioServer := server.NewWebsocketTwilio(cfg, sessionManager)
+		dialer = twilioServer.Dialer()
+		servers = append(servers, namedServer{"Twilio", twilioServer})
+	case "both":
+		twilioServer := server.NewWebsocketTwilio(cfg, sessionManager)
+		dialer = twilioServer.Dialer()
+		servers = append(servers,
+			namedServer{"WebSocket", server.NewServerWebsocket(cfg, sessionManager, authenticator)},
+			namedServer{"Twilio", twilioServer},
+		)
+	case "sip":
+		servers = append(servers, namedServer{"SIP", server.NewSIPServer(cfg, sessionManager)})
+	default:
+		fatal("Unknown SERVER_TYPE", fmt.Errorf("%q", cfg.ServerType))
+	}
+	if cfg.AdminPort != 0 {
+		servers = append(servers, namedServer{"Admin", server.NewAdminServer(cfg, sessionManager, dialer)})
+	} else if dialer != nil {
+		slog.Warn("TWILIO_ACCOUNT_SID is set without ADMIN_PORT, outbound calls can't be requested")
+	}
 
-		go func() {
-			<-sigChan
-			log.Println("\nReceived shutdown signal...")
-			cancel()
-			sessionManager.Shutdown()
-			shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
-			defer shutdownCancel()
-			if err := twilioSrv.Shutdown(shutdownCtx); err != nil {
-				log.Printf("Twilio server shutdown error: %v", err)
+	serverErrors := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv namedServer) {
+			if err := srv.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				serverErrors <- fmt.Errorf("%s server: %w", srv.name, err)
 			}
-		}()
-
-		if err := twilioSrv.Start(); err != nil && err.Error() != "http: Server closed" {
-			log.Fatalf("Twilio server error: %v", err)
-		}
+		}(srv)
+	}
 
-	case "both":
-		srv := server.NewServerWebsocket(cfg, sessionManager)
-		twilioSrv := server.NewWebsocketTwilio(cfg, sessionManager)
+	// Handle graceful shutdown: drain sessions, then stop servers and close what's left
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
+	shutdownDone := make(chan struct{})
+	go func() {
+		<-sigChan
+		slog.Info("Received shutdown signal, draining sessions",
+			"sessions", sessionManager.GetActiveSessionCount(), "timeout", cfg.DrainTimeout)
+		// A second signal skips the wait
+		drainCtx, skipDrain := context.WithCancel(context.Background())
 		go func() {
 			<-sigChan
-			log.Println("\nReceived shutdown signal...")
-			cancel()
-			shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
-			defer shutdownCancel()
-			if err := srv.Shutdown(shutdownCtx); err != nil {
-				log.Printf("WebSocket server shutdown error: %v", err)
-			}
-			if err := twilioSrv.Shutdown(shutdownCtx); err != nil {
-				log.Printf("Twilio server shutdown error: %v", err)
-			}
+			slog.Warn("Received second shutdown signal, closing sessions now")
+			skipDrain()
 		}()
+		sessionManager.Drain(drainCtx, cfg.DrainTimeout, cfg.DrainWarning)
+		skipDrain()
+		cancel()
 
-		// Start Twilio server in background
-		go func() {
-			if err := twilioSrv.Start(); err != nil && err.Error() != "http: Server closed" {
-				log.Fatalf("Twilio server error: %v", err)
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer shutdownCancel()
+		for _, srv := range servers {
+			if err := srv.Shutdown(shutdownCtx); err != nil {
+				slog.Error("Server shutdown error", "server", srv.name, "error", err)
 			}
-		}()
-
-		// Start WebSocket server (blocks)
-		if err := srv.Start(); err != nil && err.Error() != "http: Server closed" {
-			log.Fatalf("WebSocket server error: %v", err)
 		}
+		sessionManager.Shutdown()
+		close(shutdownDone)
+	}()
+
+	select {
+	case err := <-serverErrors:
+		fatal("Server error", err)
+	case <-shutdownDone:
+	}
 
-	default:
-		log.Fatalf("Unknown SERVER_TYPE: %s", cfg.ServerType)
+	// Flush buffered spans before exiting
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer flushCancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Error("Tracing shutdown error", "error", err)
 	}
 
-	log.Println("Server stopped")
+	slog.Info("Server stopped")
+}
+
+// namedServer is an HTTP server run by main, named for logs
+type namedServer struct {
+	name string
+	httpServer
+}
+
+type httpServer interface {
+	Start() error
+	Shutdown(ctx context.Context) error
+}
+
+// fatal logs err and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
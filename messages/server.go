@@ -19,23 +19,12 @@ const (
 )
 
 // ServerMessage represents a message sent to frontend client
-
-type Media struct {
-	Payload string `json:"payload"` //  Base64-encoded mi-law audio data
-}
-
 type ServerMessage struct {
 	Type      string      `json:"type"` // "audio", "text", "status", "error"
 	SessionID string      `json:"sessionId,omitempty"`
 	Payload   interface{} `json:"payload"`
 }
 
-type TwilioMessageBack struct {
-	Event     string `json:"event"`
-	StreamSid string `json:"streamSid"`
-	Media     Media  `json:"media"`
-}
-
 // AudioResponsePayload contains audio data for client
 type AudioResponsePayload struct {
 	Data     string `json:"data"`     // Base64-encoded PCM audio
@@ -49,8 +38,34 @@ type TextResponsePayload struct {
 
 // StatusPayload contains status updates
 type StatusPayload struct {
-	Status  string `json:"status"` // "connected", "turn_complete", "disconnected"
-	Message string `json:"message,omitempty"`
+	Status  string              `json:"status"` // "connected", "turn_complete", "usage", "disconnected"
+	Message string              `json:"message,omitempty"`
+	Usage   *UsagePayload       `json:"usage,omitempty"`   // Set on "usage" status
+	Latency *TurnLatencyPayload `json:"latency,omitempty"` // Set on "turn_complete" status
+}
+
+// TurnLatencyPayload reports when each milestone of a turn was reached, in
+// milliseconds after the end of the user's speech (omitted if not reached)
+type TurnLatencyPayload struct {
+	Turn            int    `json:"turn"`
+	AudioSentMs     *int64 `json:"audioSentMs,omitempty"`     // User audio sent to Gemini
+	FirstResponseMs *int64 `json:"firstResponseMs,omitempty"` // First response chunk from Gemini
+	FirstAudioMs    *int64 `json:"firstAudioMs,omitempty"`    // First response audio written to the client
+	TurnCompleteMs  *int64 `json:"turnCompleteMs,omitempty"`  // Gemini finished the turn
+}
+
+// UsagePayload reports the Gemini tokens consumed by a session
+type UsagePayload struct {
+	PromptTokens        int64 `json:"promptTokens"`
+	ResponseTokens      int64 `json:"responseTokens"`
+	ToolUsePromptTokens int64 `json:"toolUsePromptTokens"`
+	ThoughtsTokens      int64 `json:"thoughtsTokens"`
+	TotalTokens         int64 `json:"totalTokens"`
+
+	// Per-modality breakdowns, keyed by modality ("AUDIO", "TEXT", ...)
+	PromptTokensByModality        map[string]int64 `json:"promptTokensByModality,omitempty"`
+	ResponseTokensByModality      map[string]int64 `json:"responseTokensByModality,omitempty"`
+	ToolUsePromptTokensByModality map[string]int64 `json:"toolUsePromptTokensByModality,omitempty"`
 }
 
 // ErrorPayload contains error information
@@ -59,14 +74,6 @@ type ErrorPayload struct {
 	Message string `json:"message"`
 }
 
-func NewTwilioMessageBack(streamSid string, data string) *TwilioMessageBack {
-	return &TwilioMessageBack{
-		Event:     "media",
-		StreamSid: streamSid,
-		Media:     Media{Payload: data},
-	}
-}
-
 // NewAudioMessage creates an audio response message
 func NewAudioMessage(sessionID, data string) *ServerMessage {
 	return &ServerMessage{
@@ -102,6 +109,31 @@ func NewStatusMessage(sessionID, status, message string) *ServerMessage {
 	}
 }
 
+// NewTurnCompleteMessage creates a "turn_complete" status message, with the
+// turn's latencies when it answered user speech
+func NewTurnCompleteMessage(sessionID string, latency *TurnLatencyPayload) *ServerMessage {
+	return &ServerMessage{
+		Type:      TypeStatus,
+		SessionID: sessionID,
+		Payload: StatusPayload{
+			Status:  "turn_complete",
+			Latency: latency,
+		},
+	}
+}
+
+// NewUsageMessage creates a "usage" status message with the session's token counts
+func NewUsageMessage(sessionID string, usage UsagePayload) *ServerMessage {
+	return &ServerMessage{
+		Type:      TypeStatus,
+		SessionID: sessionID,
+		Payload: StatusPayload{
+			Status: "usage",
+			Usage:  &usage,
+		},
+	}
+}
+
 // NewErrorMessage creates an error message
 func NewErrorMessage(sessionID, code, message string) *ServerMessage {
 	return &ServerMessage{
@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "openconverse"
+
+// Session lifecycle
+var (
+	ActiveSessions = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_sessions",
+		Help:      "Number of active sessions.",
+	}, []string{"transport", "agent"})
+
+	SessionCreateFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "session_create_failures_total",
+		Help:      "Sessions that could not be created, by reason.",
+	}, []string{"reason"})
+
+	SessionStoreErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "session_store_errors_total",
+		Help:      "Failed session store operations. A failed \"reserve session\" admits the session without cluster-wide limits.",
+	}, []string{"store", "operation"})
+
+	GeminiConnectSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "gemini_connect_seconds",
+		Help:      "Time to establish a Gemini Live session.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 0.75, 1, 1.5, 2, 3, 5, 10},
+	}, []string{"result"})
+
+	GeminiPoolCheckouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "gemini_pool_checkouts_total",
+		Help:      "Sessions served from the pre-warmed Gemini pool (hit) or connected on demand (miss).",
+	}, []string{"agent", "result"})
+)
+
+// Audio and message flow
+var (
+	AudioBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "audio_bytes_total",
+		Help:      "Audio bytes received from (in) and sent to (out) clients.",
+	}, []string{"transport", "direction"})
+
+	DroppedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dropped_messages_total",
+		Help:      "Messages dropped because a session's write queue was full.",
+	}, []string{"transport"})
+
+	TimeToFirstAudioSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "time_to_first_audio_seconds",
+		Help:      "Time from the end of the user's speech to the first response audio written to the client.",
+		Buckets:   latencyBuckets,
+	}, []string{"transport"})
+
+	TurnLatencySeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "turn_latency_seconds",
+		Help:      "Time from the end of the user's speech to each turn milestone (audio_sent, first_response, first_audio, turn_complete).",
+		Buckets:   latencyBuckets,
+	}, []string{"transport", "stage"})
+)
+
+var latencyBuckets = []float64{0.05, 0.1, 0.2, 0.4, 0.6, 0.8, 1, 1.25, 1.5, 2, 3, 5, 10}
+
+// Tool calls
+var (
+	ToolCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tool_calls_total",
+		Help:      "Tool calls made by the model.",
+	}, []string{"tool"})
+
+	ToolCallErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tool_call_errors_total",
+		Help:      "Tool calls that returned an error.",
+	}, []string{"tool"})
+
+	ToolCallSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "tool_call_seconds",
+		Help:      "Time spent executing tool calls.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"tool"})
+)
+
+// Token usage
+var (
+	Tokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tokens_total",
+		Help:      "Gemini tokens used, by kind (prompt, response, tool_use_prompt, thoughts). Tenant is empty for sessions without one.",
+	}, []string{"agent", "tenant", "kind"})
+)
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,302 @@
+package profile
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/bytedance/sonic"
+)
+
+// Default is the name of the profile used when none is requested
+const Default = "default"
+
+// DefaultOutboundPrompt opens the outbound calls of profiles without an outbound_prompt
+const DefaultOutboundPrompt = `[System notice] You called {{.To}} and they just answered. ` +
+	`Speak first: greet them and say why you are calling.
+{{- range $name, $value := .Variables}}
+{{$name}}: {{$value}}
+{{- end}}`
+
+var defaultOutboundPrompt = template.Must(newTemplate("outbound_prompt", DefaultOutboundPrompt))
+
+// greetingNotice asks Gemini to say a profile's greeting
+const greetingNotice = `[System notice] The caller is connected. Start the conversation by saying: "%s"`
+
+// DefaultSay is what the provider says before connecting an inbound call,
+// unless the profile opens the conversation itself
+const DefaultSay = "Connecting to the assistant now."
+
+// DefaultTwiML answers the Twilio calls of profiles without a twiml template:
+// the provider's Say, then the media stream, passing the call's numbers and SID
+const DefaultTwiML = `<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+{{- if .Say}}
+	<Say>{{.Say}}</Say>
+{{- end}}
+	<Connect>
+		<Stream url="{{.StreamURL}}"{{if .StatusCallback}} statusCallback="{{.StatusCallback}}"{{end}}>
+			<Parameter name="From" value="{{.From}}" />
+			<Parameter name="To" value="{{.To}}" />
+			<Parameter name="CallSid" value="{{.CallSid}}" />
+		</Stream>
+	</Connect>
+</Response>`
+
+var defaultTwiML = template.Must(newTemplate("twiml", DefaultTwiML))
+
+// TwiMLData is what TwiML templates know about a call. Values are XML-escaped
+// when rendered.
+type TwiMLData struct {
+	Profile        string // Profile serving the call
+	From           string // Calling number
+	To             string // Called number
+	CallSid        string // Twilio's call identifier ("" on outbound calls, which aren't placed yet)
+	Say            string // What the provider says first, "" for nothing (see ProviderSay)
+	StreamURL      string // Media stream of the call, with its one-time token
+	StatusCallback string // URL Twilio posts the stream's status events to (TWILIO_STATUS_CALLBACK_URL)
+	PublicBaseURL  string // URL providers reach this server at
+}
+
+// escaped returns the data with every value XML-escaped
+func (d TwiMLData) escaped() TwiMLData {
+	escape := func(s string) string {
+		var b strings.Builder
+		_ = xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+	return TwiMLData{
+		Profile:        escape(d.Profile),
+		From:           escape(d.From),
+		To:             escape(d.To),
+		CallSid:        escape(d.CallSid),
+		Say:            escape(d.Say),
+		StreamURL:      escape(d.StreamURL),
+		StatusCallback: escape(d.StatusCallback),
+		PublicBaseURL:  escape(d.PublicBaseURL),
+	}
+}
+
+// CallData is what prompt templates know about a session
+type CallData struct {
+	Caller    string            // The other party: the caller, or the number called on outbound calls
+	To        string            // Number called
+	Outbound  bool              // The server placed the call
+	Variables map[string]string // Variables the call was placed with, e.g. {{.Variables.name}}
+
+	CallSID    string            // The provider's call identifier, when known
+	Parameters map[string]string // Custom parameters the call passed to its stream, e.g. {{.Parameters.CustomerId}}
+}
+
+// Profile configures an agent persona served by the server
+type Profile struct {
+	Name             string `json:"name"`
+	SystemPrompt     string `json:"system_prompt,omitempty"`
+	SystemPromptFile string `json:"system_prompt_file,omitempty"` // Read into SystemPrompt, relative to the profiles file
+	Voice            string `json:"voice,omitempty"`              // Gemini prebuilt voice (e.g. "Zephyr")
+	PoolSize         *int   `json:"pool_size,omitempty"`          // Pre-warmed Gemini connections (nil uses GEMINI_POOL_SIZE)
+	OutboundPrompt   string `json:"outbound_prompt,omitempty"`    // Template of the turn opening outbound calls (see CallData)
+
+	// How the assistant opens other sessions; without either it waits for the caller
+	Greeting      string  `json:"greeting,omitempty"`       // Words the assistant says first
+	OpeningPrompt string  `json:"opening_prompt,omitempty"` // Template of the turn sent to Gemini first (see CallData)
+	Say           *string `json:"say,omitempty"`            // Said by the provider before connecting inbound calls (see ProviderSay)
+
+	// Twilio call instructions (see TwiMLData)
+	TwiML     string `json:"twiml,omitempty"`      // Template answering the profile's calls (DefaultTwiML when empty)
+	BusyTwiML string `json:"busy_twiml,omitempty"` // Template answering calls while the server is full or draining
+
+	outboundPrompt *template.Template
+	openingPrompt  *template.Template
+	twiml          *template.Template
+	busyTwiML      *template.Template
+}
+
+// Opening returns the turn sent to Gemini once the session's stream started,
+// "" when the assistant waits for the caller to speak
+func (p *Profile) Opening(data CallData) (string, error) {
+	var tmpl *template.Template
+	switch {
+	case data.Outbound && p.outboundPrompt != nil:
+		tmpl = p.outboundPrompt
+	case data.Outbound:
+		tmpl = defaultOutboundPrompt
+	case p.Greeting != "":
+		return fmt.Sprintf(greetingNotice, p.Greeting), nil
+	case p.openingPrompt != nil:
+		tmpl = p.openingPrompt
+	default:
+		return "", nil
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// ProviderSay returns what the provider says before connecting an inbound
+// call, "" for nothing
+func (p *Profile) ProviderSay() string {
+	switch {
+	case p.Say != nil:
+		return *p.Say
+	case p.Greeting != "" || p.OpeningPrompt != "":
+		return ""
+	default:
+		return DefaultSay
+	}
+}
+
+// RenderTwiML returns the TwiML connecting a call to the assistant
+func (p *Profile) RenderTwiML(data TwiMLData) (string, error) {
+	tmpl := p.twiml
+	if tmpl == nil {
+		tmpl = defaultTwiML
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data.escaped()); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// RenderBusyTwiML returns the TwiML answering a call the server has no room
+// for, false when the profile has no busy_twiml
+func (p *Profile) RenderBusyTwiML(data TwiMLData) (string, bool, error) {
+	if p.busyTwiML == nil {
+		return "", false, nil
+	}
+	var b strings.Builder
+	if err := p.busyTwiML.Execute(&b, data.escaped()); err != nil {
+		return "", true, err
+	}
+	return b.String(), true, nil
+}
+
+// newTemplate parses a prompt template; missing variables render empty
+func newTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=zero").Parse(text)
+}
+
+// Registry holds the configured agent profiles
+type Registry struct {
+	profiles map[string]*Profile
+}
+
+// LoadRegistry reads profiles from a JSON file containing an array of profiles.
+// fallback is used as the default profile unless the file defines one.
+func LoadRegistry(path string, fallback *Profile) (*Registry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read agent profiles file: %w", err)
+	}
+
+	var profiles []*Profile
+	if err := sonic.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("failed to parse agent profiles file: %w", err)
+	}
+
+	for _, p := range profiles {
+		if p.SystemPromptFile == "" {
+			continue
+		}
+		promptPath := p.SystemPromptFile
+		if !filepath.IsAbs(promptPath) {
+			promptPath = filepath.Join(filepath.Dir(path), promptPath)
+		}
+		prompt, err := os.ReadFile(promptPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read system prompt of profile %q: %w", p.Name, err)
+		}
+		p.SystemPrompt = string(prompt)
+	}
+
+	return NewRegistry(profiles, fallback)
+}
+
+// NewRegistry indexes profiles by name. fallback is used as the default
+// profile unless profiles contains one named Default.
+func NewRegistry(profiles []*Profile, fallback *Profile) (*Registry, error) {
+	r := &Registry{
+		profiles: make(map[string]*Profile, len(profiles)+1),
+	}
+
+	for _, p := range profiles {
+		if p.Name == "" {
+			return nil, fmt.Errorf("agent profile is missing a name")
+		}
+		if _, exists := r.profiles[p.Name]; exists {
+			return nil, fmt.Errorf("duplicate agent profile %q", p.Name)
+		}
+		if p.SystemPrompt == "" {
+			return nil, fmt.Errorf("agent profile %q has no system prompt", p.Name)
+		}
+		if p.PoolSize != nil && *p.PoolSize < 0 {
+			return nil, fmt.Errorf("agent profile %q has a negative pool size", p.Name)
+		}
+		if p.OutboundPrompt != "" {
+			tmpl, err := newTemplate("outbound_prompt", p.OutboundPrompt)
+			if err != nil {
+				return nil, fmt.Errorf("invalid outbound prompt of profile %q: %w", p.Name, err)
+			}
+			p.outboundPrompt = tmpl
+		}
+		if p.Greeting != "" && p.OpeningPrompt != "" {
+			return nil, fmt.Errorf("agent profile %q has both a greeting and an opening prompt", p.Name)
+		}
+		if p.OpeningPrompt != "" {
+			tmpl, err := newTemplate("opening_prompt", p.OpeningPrompt)
+			if err != nil {
+				return nil, fmt.Errorf("invalid opening prompt of profile %q: %w", p.Name, err)
+			}
+			p.openingPrompt = tmpl
+		}
+		if p.TwiML != "" {
+			tmpl, err := newTemplate("twiml", p.TwiML)
+			if err != nil {
+				return nil, fmt.Errorf("invalid TwiML of profile %q: %w", p.Name, err)
+			}
+			p.twiml = tmpl
+		}
+		if p.BusyTwiML != "" {
+			tmpl, err := newTemplate("busy_twiml", p.BusyTwiML)
+			if err != nil {
+				return nil, fmt.Errorf("invalid busy TwiML of profile %q: %w", p.Name, err)
+			}
+			p.busyTwiML = tmpl
+		}
+		r.profiles[p.Name] = p
+	}
+
+	if _, exists := r.profiles[Default]; !exists {
+		if fallback == nil {
+			return nil, fmt.Errorf("no %q agent profile configured", Default)
+		}
+		r.profiles[Default] = fallback
+	}
+
+	return r, nil
+}
+
+// All returns every profile, including the default
+func (r *Registry) All() []*Profile {
+	profiles := make([]*Profile, 0, len(r.profiles))
+	for _, p := range r.profiles {
+		profiles = append(profiles, p)
+	}
+	return profiles
+}
+
+// Get returns the named profile; an empty name selects the default profile
+func (r *Registry) Get(name string) (*Profile, bool) {
+	if name == "" {
+		name = Default
+	}
+	p, ok := r.profiles[name]
+	return p, ok
+}
@@ -0,0 +1,186 @@
+package profile
+
+import (
+	"strings"
+	"testing"
+)
+
+// compile returns p with its templates parsed, as NewRegistry does
+func compile(t *testing.T, p Profile) *Profile {
+	t.Helper()
+	p.Name = Default
+	p.SystemPrompt = "You are a helpful assistant."
+	if _, err := NewRegistry([]*Profile{&p}, nil); err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+	return &p
+}
+
+func TestOpening(t *testing.T) {
+	inbound := CallData{Caller: "+13122010094", CallSID: "CA123", Parameters: map[string]string{"CustomerId": "42"}}
+	outbound := CallData{Caller: "+13122010094", To: "+13122010094", Outbound: true, Variables: map[string]string{"name": "Ada", "order": "1234"}}
+
+	tests := []struct {
+		name    string
+		profile Profile
+		data    CallData
+		want    string
+		wantErr bool
+	}{
+		{"inbound waits for the caller", Profile{}, inbound, "", false},
+		{"inbound greeting", Profile{Greeting: "Hi, this is Acme."}, inbound,
+			`[System notice] The caller is connected. Start the conversation by saying: "Hi, this is Acme."`, false},
+		{"inbound opening prompt", Profile{OpeningPrompt: "Greet customer {{.Parameters.CustomerId}} calling from {{.Caller}} ({{.CallSID}})."}, inbound,
+			"Greet customer 42 calling from +13122010094 (CA123).", false},
+		{"outbound default prompt", Profile{}, outbound,
+			"[System notice] You called +13122010094 and they just answered. Speak first: greet them and say why you are calling.\nname: Ada\norder: 1234", false},
+		{"outbound prompt", Profile{OutboundPrompt: "Remind {{.Variables.name}} of order {{.Variables.order}}."}, outbound,
+			"Remind Ada of order 1234.", false},
+		{"outbound ignores the greeting", Profile{Greeting: "Hi, this is Acme.", OutboundPrompt: "Call {{.Caller}}."}, outbound,
+			"Call +13122010094.", false},
+		{"missing variables render empty", Profile{OutboundPrompt: "Remind {{.Variables.name}} of [{{.Variables.missing}}]."},
+			CallData{Outbound: true}, "Remind  of [].", false},
+		{"missing parameters render empty", Profile{OpeningPrompt: "Customer [{{.Parameters.CustomerId}}]"}, CallData{},
+			"Customer []", false},
+		{"template error", Profile{OpeningPrompt: "{{.Caller.Name}}"}, inbound, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := compile(t, tt.profile).Opening(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Opening error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Opening = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInvalidTemplates(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile Profile
+		want    string
+	}{
+		{"outbound prompt", Profile{OutboundPrompt: "Call {{.Caller"}, "invalid outbound prompt"},
+		{"opening prompt", Profile{OpeningPrompt: "{{if .Caller}}"}, "invalid opening prompt"},
+		{"twiml", Profile{TwiML: "<Response>{{end}}</Response>"}, "invalid TwiML"},
+		{"busy twiml", Profile{BusyTwiML: "{{.Say"}, "invalid busy TwiML"},
+		{"greeting and opening prompt", Profile{Greeting: "Hi", OpeningPrompt: "Say hi"}, "both a greeting and an opening prompt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.profile
+			p.Name = "support"
+			p.SystemPrompt = "You are a helpful assistant."
+			_, err := NewRegistry([]*Profile{&p}, &Profile{Name: Default})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("NewRegistry error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestProviderSay(t *testing.T) {
+	empty, custom := "", "Please hold."
+
+	tests := []struct {
+		name    string
+		profile Profile
+		want    string
+	}{
+		{"default", Profile{}, DefaultSay},
+		{"greeting", Profile{Greeting: "Hi, this is Acme."}, ""},
+		{"opening prompt", Profile{OpeningPrompt: "Greet the caller."}, ""},
+		{"say nothing", Profile{Say: &empty}, ""},
+		{"say", Profile{Say: &custom}, custom},
+		{"say with a greeting", Profile{Say: &custom, Greeting: "Hi, this is Acme."}, custom},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.profile.ProviderSay(); got != tt.want {
+				t.Errorf("ProviderSay = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderTwiML(t *testing.T) {
+	data := TwiMLData{
+		Profile:        "support",
+		From:           "+13122010094",
+		To:             "+13122123456",
+		CallSid:        "CA123",
+		Say:            "Connecting you to Acme & Co.",
+		StreamURL:      "wss://voice.example.com/stream?token=a&profile=support",
+		StatusCallback: "https://voice.example.com/status",
+	}
+
+	got, err := compile(t, Profile{}).RenderTwiML(data)
+	if err != nil {
+		t.Fatalf("RenderTwiML: %v", err)
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Say>Connecting you to Acme &amp; Co.</Say>
+	<Connect>
+		<Stream url="wss://voice.example.com/stream?token=a&amp;profile=support" statusCallback="https://voice.example.com/status">
+			<Parameter name="From" value="+13122010094" />
+			<Parameter name="To" value="+13122123456" />
+			<Parameter name="CallSid" value="CA123" />
+		</Stream>
+	</Connect>
+</Response>`
+	if got != want {
+		t.Errorf("default TwiML =\n%s\nwant\n%s", got, want)
+	}
+
+	// Without a Say or a status callback, neither is rendered
+	data.Say, data.StatusCallback = "", ""
+	got, err = compile(t, Profile{}).RenderTwiML(data)
+	if err != nil {
+		t.Fatalf("RenderTwiML: %v", err)
+	}
+	if strings.Contains(got, "<Say>") || strings.Contains(got, "statusCallback") {
+		t.Errorf("TwiML without Say or status callback =\n%s", got)
+	}
+
+	custom := compile(t, Profile{TwiML: `<Response><Play>{{.PublicBaseURL}}/hold.mp3</Play><Connect><Stream url="{{.StreamURL}}" /></Connect></Response>`})
+	data.PublicBaseURL = "https://voice.example.com"
+	got, err = custom.RenderTwiML(data)
+	if err != nil {
+		t.Fatalf("RenderTwiML: %v", err)
+	}
+	if want := `<Response><Play>https://voice.example.com/hold.mp3</Play><Connect><Stream url="wss://voice.example.com/stream?token=a&amp;profile=support" /></Connect></Response>`; got != want {
+		t.Errorf("custom TwiML = %s, want %s", got, want)
+	}
+
+	if _, err := compile(t, Profile{TwiML: "{{.Say.Text}}"}).RenderTwiML(data); err == nil {
+		t.Error("RenderTwiML of a failing template succeeded")
+	}
+}
+
+func TestRenderBusyTwiML(t *testing.T) {
+	data := TwiMLData{Profile: "support", From: "+13122010094", Say: "Busy <now>"}
+
+	if twiml, ok, err := compile(t, Profile{}).RenderBusyTwiML(data); ok || err != nil || twiml != "" {
+		t.Errorf("RenderBusyTwiML without busy_twiml = %q, %v, %v, want nothing", twiml, ok, err)
+	}
+
+	busy := compile(t, Profile{BusyTwiML: `<Response><Say>All agents are busy, {{.From}}. {{.Say}}</Say><Hangup /></Response>`})
+	twiml, ok, err := busy.RenderBusyTwiML(data)
+	if !ok || err != nil {
+		t.Fatalf("RenderBusyTwiML = %v, %v", ok, err)
+	}
+	if want := `<Response><Say>All agents are busy, +13122010094. Busy &lt;now&gt;</Say><Hangup /></Response>`; twiml != want {
+		t.Errorf("busy TwiML = %s, want %s", twiml, want)
+	}
+
+	if _, ok, err := compile(t, Profile{BusyTwiML: "{{.From.Number}}"}).RenderBusyTwiML(data); !ok || err == nil {
+		t.Errorf("RenderBusyTwiML of a failing template = %v, %v, want true and an error", ok, err)
+	}
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bytedance/sonic"
+
+	"github.com/room4-2/OpenConverse/config"
+	"github.com/room4-2/OpenConverse/session"
+	"github.com/room4-2/OpenConverse/twilio"
+)
+
+// AdminServer exposes live session management to operators on its own port
+type AdminServer struct {
+	httpServer     *http.Server
+	sessionManager *session.Manager
+	dialer         *Dialer // nil when outbound calls aren't configured
+	token          string
+}
+
+// drainRequest is the body of PUT /drain
+type drainRequest struct {
+	Draining bool `json:"draining"`
+}
+
+// callStatus is returned by POST /calls
+type callStatus struct {
+	CallSID string `json:"callSid"`
+	Status  string `json:"status"`
+	To      string `json:"to"`
+	Profile string `json:"profile,omitempty"`
+}
+
+// drainStatus is returned by /drain
+type drainStatus struct {
+	Draining bool `json:"draining"`
+	Sessions int  `json:"sessions"`
+}
+
+func NewAdminServer(cfg *config.Config, sessionManager *session.Manager, dialer *Dialer) *AdminServer {
+	s := &AdminServer{
+		sessionManager: sessionManager,
+		dialer:         dialer,
+		token:          cfg.AdminToken,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sessions", s.handleListSessions)
+	mux.HandleFunc("GET /sessions/{id}", s.handleGetSession)
+	mux.HandleFunc("DELETE /sessions/{id}", s.handleTerminateSession)
+	mux.HandleFunc("GET /nodes", s.handleListNodes)
+	mux.HandleFunc("GET /usage", s.handleUsage)
+	mux.HandleFunc("GET /drain", s.handleGetDrain)
+	mux.HandleFunc("PUT /drain", s.handleSetDrain)
+	mux.HandleFunc("POST /calls", s.handleCreateCall)
+
+	s.httpServer = &http.Server{
+		Addr:         fmt.Sprintf(":%d", cfg.AdminPort),
+		Handler:      s.requireToken(mux),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: twilio.RequestTimeout + 5*time.Second, // POST /calls outlives a Twilio API timeout
+	}
+
+	return s
+}
+
+// Start begins listening for admin requests
+func (s *AdminServer) Start() error {
+	slog.Info("Admin server starting", "addr", s.httpServer.Addr)
+	return s.httpServer.ListenAndServe()
+}
+
+// Shutdown gracefully stops the admin server
+func (s *AdminServer) Shutdown(ctx context.Context) error {
+	slog.Info("Shutting down admin server")
+	return s.httpServer.Shutdown(ctx)
+}
+
+// requireToken rejects requests without the admin bearer token, and every
+// request when no token is configured
+func (s *AdminServer) requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || s.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			slog.Warn("Rejected admin request", "remote_addr", r.RemoteAddr, "path", r.URL.Path)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *AdminServer) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	infos, err := s.sessionManager.ClusterSessions(r.Context())
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].CreatedAt.Before(infos[j].CreatedAt) })
+
+	writeJSON(w, http.StatusOK, infos)
+}
+
+func (s *AdminServer) handleGetSession(w http.ResponseWriter, r *http.Request) {
+	details, err := s.sessionManager.SessionDetails(r.Context(), r.PathValue("id"))
+	if errors.Is(err, session.ErrSessionNotFound) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, details)
+}
+
+func (s *AdminServer) handleTerminateSession(w http.ResponseWriter, r *http.Request) {
+	err := s.sessionManager.TerminateSession(r.Context(), r.PathValue("id"))
+	if errors.Is(err, session.ErrSessionNotFound) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *AdminServer) handleListNodes(w http.ResponseWriter, r *http.Request) {
+	nodes, err := s.sessionManager.Nodes(r.Context())
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	if nodes == nil {
+		nodes = []session.NodeInfo{}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+
+	writeJSON(w, http.StatusOK, nodes)
+}
+
+func (s *AdminServer) handleUsage(w http.ResponseWriter, r *http.Request) {
+	totals, err := s.sessionManager.UsageTotals(r.Context())
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, totals)
+}
+
+// storeError reports a failed query of the session store
+func storeError(w http.ResponseWriter, err error) {
+	slog.Error("Session store query failed", "error", err)
+	http.Error(w, "Session store unavailable", http.StatusServiceUnavailable)
+}
+
+func (s *AdminServer) handleGetDrain(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.drainStatus())
+}
+
+func (s *AdminServer) handleSetDrain(w http.ResponseWriter, r *http.Request) {
+	var req drainRequest
+	if err := sonic.ConfigDefault.NewDecoder(http.MaxBytesReader(w, r.Body, 1024)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	s.sessionManager.SetDraining(req.Draining)
+	slog.Info("Draining mode changed by operator", "draining", req.Draining)
+	writeJSON(w, http.StatusOK, s.drainStatus())
+}
+
+func (s *AdminServer) drainStatus() drainStatus {
+	return drainStatus{
+		Draining: s.sessionManager.Draining(),
+		Sessions: s.sessionManager.GetActiveSessionCount(),
+	}
+}
+
+func (s *AdminServer) handleCreateCall(w http.ResponseWriter, r *http.Request) {
+	if s.dialer == nil {
+		http.Error(w, "Outbound calls are not configured", http.StatusNotImplemented)
+		return
+	}
+
+	var req OutboundCall
+	if err := sonic.ConfigDefault.NewDecoder(http.MaxBytesReader(w, r.Body, 16*1024)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	call, err := s.dialer.Dial(r.Context(), req)
+	var apiErr *twilio.APIError
+	switch {
+	case errors.Is(err, ErrInvalidCall):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, session.ErrDraining), errors.Is(err, session.ErrMaxSessions):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case errors.As(err, &apiErr):
+		slog.Warn("Twilio refused outbound call", "to", req.To, "error", err)
+		http.Error(w, apiErr.Message, http.StatusBadGateway)
+		return
+	case err != nil:
+		slog.Error("Failed to place outbound call", "to", req.To, "error", err)
+		http.Error(w, "Failed to place call", http.StatusBadGateway)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, callStatus{CallSID: call.SID, Status: call.Status, To: req.To, Profile: req.Profile})
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	data, err := sonic.Marshal(v)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
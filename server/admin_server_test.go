@@ -0,0 +1,296 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/room4-2/OpenConverse/config"
+	"github.com/room4-2/OpenConverse/gemini/geminitest"
+	"github.com/room4-2/OpenConverse/messages"
+	"github.com/room4-2/OpenConverse/session"
+	"github.com/room4-2/OpenConverse/store"
+	"github.com/room4-2/OpenConverse/transport"
+
+	"google.golang.org/genai"
+)
+
+const adminToken = "admin-token"
+
+// idleTransport is a client that never sends anything and keeps what it is sent
+type idleTransport struct {
+	mu        sync.Mutex
+	sent      []*messages.ServerMessage
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newIdleTransport() *idleTransport {
+	return &idleTransport{closed: make(chan struct{})}
+}
+
+func (c *idleTransport) Name() string    { return "test" }
+func (c *idleTransport) Streaming() bool { return true }
+
+func (c *idleTransport) Receive() (transport.Event, error) {
+	<-c.closed
+	return transport.Event{}, io.EOF
+}
+
+func (c *idleTransport) Send(msg *messages.ServerMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent = append(c.sent, msg)
+	return nil
+}
+
+func (c *idleTransport) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+// statuses returns the statuses the client was sent
+func (c *idleTransport) statuses() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var statuses []string
+	for _, msg := range c.sent {
+		if payload, ok := msg.Payload.(messages.StatusPayload); ok {
+			statuses = append(statuses, payload.Status)
+		}
+	}
+	return statuses
+}
+
+// newTestAdmin returns the admin server of instance nodeID sharing
+// sessionStore, with its session manager running
+func newTestAdmin(t *testing.T, nodeID string, sessionStore store.SessionStore) (*AdminServer, *session.Manager) {
+	t.Helper()
+	cfg := &config.Config{MaxSessions: 4, NodeID: nodeID, AdminToken: adminToken, GeminiAPIKey: "test-key"}
+	sm, err := session.NewManager(cfg, sessionStore)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go sm.StartCleanupRoutine(ctx)
+	t.Cleanup(func() {
+		cancel()
+		sm.Shutdown()
+	})
+	return NewAdminServer(cfg, sm, nil), sm
+}
+
+// startTestSession starts a session on sm for caller
+func startTestSession(t *testing.T, sm *session.Manager, caller string) (*session.ClientSession, *idleTransport) {
+	t.Helper()
+	client := newIdleTransport()
+	cs, err := sm.CreateSession(context.Background(), client, session.Options{Caller: caller})
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	cs.Start()
+	return cs, client
+}
+
+// adminRequest serves one admin request with token
+func adminRequest(admin *AdminServer, method, path, body, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	admin.httpServer.Handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// decodeResponse decodes a JSON response into v, failing the test unless
+// its status is want
+func decodeResponse(t *testing.T, rec *httptest.ResponseRecorder, want int, v any) {
+	t.Helper()
+	if rec.Code != want {
+		t.Fatalf("status %d, want %d: %s", rec.Code, want, rec.Body)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %s: %v", rec.Body, err)
+	}
+}
+
+func TestAdminRequiresToken(t *testing.T) {
+	admin, _ := newTestAdmin(t, "node-a", store.NewMemory())
+	disabled := NewAdminServer(&config.Config{}, admin.sessionManager, nil)
+
+	tests := []struct {
+		name   string
+		admin  *AdminServer
+		header string
+		status int
+	}{
+		{"no token", admin, "", http.StatusUnauthorized},
+		{"wrong token", admin, "Bearer not-the-token", http.StatusUnauthorized},
+		{"not a bearer token", admin, "Basic " + adminToken, http.StatusUnauthorized},
+		{"token", admin, "Bearer " + adminToken, http.StatusOK},
+		{"empty ADMIN_TOKEN", disabled, "Bearer ", http.StatusUnauthorized},
+		{"empty ADMIN_TOKEN without a token", disabled, "", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, path := range []string{"/sessions", "/nodes", "/drain"} {
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				if tt.header != "" {
+					req.Header.Set("Authorization", tt.header)
+				}
+				rec := httptest.NewRecorder()
+				tt.admin.httpServer.Handler.ServeHTTP(rec, req)
+				if rec.Code != tt.status {
+					t.Errorf("GET %s: status %d, want %d", path, rec.Code, tt.status)
+				}
+			}
+		})
+	}
+}
+
+// Operators see and end the sessions of every instance sharing the store
+func TestAdminSessions(t *testing.T) {
+	gm := geminitest.NewServer(t)
+	shared := store.NewMemory()
+	admin, local := newTestAdmin(t, "node-a", shared)
+	_, remote := newTestAdmin(t, "node-b", shared)
+
+	here, _ := startTestSession(t, local, "+13122010094")
+	there, remoteClient := startTestSession(t, remote, "+13122010095")
+
+	// Wait for both sessions to reach Gemini before it speaks to them
+	deadline := time.Now().Add(time.Second)
+	for gm.Connected() != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d sessions connected to Gemini, want 2", gm.Connected())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	gm.Send(&genai.LiveServerMessage{ServerContent: &genai.LiveServerContent{InputTranscription: &genai.Transcription{Text: "What are your hours?"}}})
+	gm.Send(&genai.LiveServerMessage{ServerContent: &genai.LiveServerContent{OutputTranscription: &genai.Transcription{Text: "We open at nine."}}})
+
+	var infos []session.Info
+	decodeResponse(t, adminRequest(admin, http.MethodGet, "/sessions", "", adminToken), http.StatusOK, &infos)
+	nodes := make(map[string]string)
+	for _, info := range infos {
+		nodes[info.ID] = info.Node
+	}
+	if len(infos) != 2 || nodes[here.ID] != "node-a" || nodes[there.ID] != "node-b" {
+		t.Errorf("GET /sessions = %+v, want the sessions of both nodes", infos)
+	}
+
+	// A local session has its live transcript
+	var details session.Details
+	deadline = time.Now().Add(time.Second)
+	for len(details.Transcript) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("transcript = %+v, want both sides of the conversation", details.Transcript)
+		}
+		time.Sleep(10 * time.Millisecond)
+		decodeResponse(t, adminRequest(admin, http.MethodGet, "/sessions/"+here.ID, "", adminToken), http.StatusOK, &details)
+	}
+	if details.Caller != "+13122010094" || details.Node != "node-a" {
+		t.Errorf("GET /sessions/{id} = %+v", details.Info)
+	}
+	if user, model := details.Transcript[0], details.Transcript[1]; user.Role != session.RoleUser || user.Text != "What are your hours?" ||
+		model.Role != session.RoleModel || model.Text != "We open at nine." {
+		t.Errorf("transcript = %+v", details.Transcript)
+	}
+
+	// A remote one is known from its record
+	var remoteDetails session.Details
+	decodeResponse(t, adminRequest(admin, http.MethodGet, "/sessions/"+there.ID, "", adminToken), http.StatusOK, &remoteDetails)
+	if remoteDetails.Caller != "+13122010095" || remoteDetails.Node != "node-b" || remoteDetails.Transcript != nil {
+		t.Errorf("GET /sessions/{id} of another node = %+v", remoteDetails)
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		if rec := adminRequest(admin, method, "/sessions/unknown", "", adminToken); rec.Code != http.StatusNotFound {
+			t.Errorf("%s of an unknown session: status %d, want 404", method, rec.Code)
+		}
+	}
+
+	// Ending a remote session goes through the store to the node running it
+	if rec := adminRequest(admin, http.MethodDelete, "/sessions/"+there.ID, "", adminToken); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE /sessions/{id}: status %d, want 204: %s", rec.Code, rec.Body)
+	}
+	select {
+	case <-there.CloseChan:
+	case <-time.After(time.Second):
+		t.Fatal("session on the other node not terminated")
+	}
+	if statuses := remoteClient.statuses(); !strings.Contains(strings.Join(statuses, ","), "terminated") {
+		t.Errorf("client was sent %v, want a terminated status", statuses)
+	}
+	if here.IsClosed() {
+		t.Error("local session closed by the termination of another")
+	}
+}
+
+func TestAdminNodes(t *testing.T) {
+	shared := store.NewMemory()
+	admin, _ := newTestAdmin(t, "node-a", shared)
+	newTestAdmin(t, "node-b", shared)
+
+	var nodes []session.NodeInfo
+	deadline := time.Now().Add(time.Second)
+	for len(nodes) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("GET /nodes = %+v, want both nodes", nodes)
+		}
+		time.Sleep(10 * time.Millisecond)
+		decodeResponse(t, adminRequest(admin, http.MethodGet, "/nodes", "", adminToken), http.StatusOK, &nodes)
+	}
+	if nodes[0].ID != "node-a" || nodes[1].ID != "node-b" || nodes[0].Heartbeat.IsZero() {
+		t.Errorf("GET /nodes = %+v, want node-a and node-b with their heartbeats", nodes)
+	}
+}
+
+func TestAdminDrain(t *testing.T) {
+	admin, sm := newTestAdmin(t, "node-a", store.NewMemory())
+
+	var status drainStatus
+	decodeResponse(t, adminRequest(admin, http.MethodGet, "/drain", "", adminToken), http.StatusOK, &status)
+	if status.Draining {
+		t.Fatal("draining before an operator asked")
+	}
+
+	decodeResponse(t, adminRequest(admin, http.MethodPut, "/drain", `{"draining": true}`, adminToken), http.StatusOK, &status)
+	if !status.Draining || !sm.Draining() {
+		t.Errorf("after PUT draining: status %+v, manager draining %v", status, sm.Draining())
+	}
+	decodeResponse(t, adminRequest(admin, http.MethodGet, "/drain", "", adminToken), http.StatusOK, &status)
+	if !status.Draining {
+		t.Error("GET /drain = not draining after PUT")
+	}
+
+	if rec := adminRequest(admin, http.MethodPut, "/drain", `{"draining": `, adminToken); rec.Code != http.StatusBadRequest {
+		t.Errorf("PUT of an invalid body: status %d, want 400", rec.Code)
+	}
+
+	decodeResponse(t, adminRequest(admin, http.MethodPut, "/drain", `{"draining": false}`, adminToken), http.StatusOK, &status)
+	if status.Draining || sm.Draining() {
+		t.Errorf("after PUT not draining: status %+v, manager draining %v", status, sm.Draining())
+	}
+}
+
+func TestAdminUsage(t *testing.T) {
+	admin, sm := newTestAdmin(t, "node-a", store.NewMemory())
+	if err := sm.Store().AddCounters(context.Background(), "usage:agent:default", map[string]int64{"total_tokens": 1200}); err != nil {
+		t.Fatalf("AddCounters: %v", err)
+	}
+
+	var totals session.UsageTotals
+	decodeResponse(t, adminRequest(admin, http.MethodGet, "/usage", "", adminToken), http.StatusOK, &totals)
+	if got := totals.Agents["default"]["total_tokens"]; got != 1200 {
+		t.Errorf("GET /usage = %+v, want 1200 tokens for the default agent", totals)
+	}
+}
@@ -0,0 +1,28 @@
+package server
+
+import "github.com/room4-2/OpenConverse/session"
+
+// healthStatus is the body of /health
+type healthStatus struct {
+	Status   string      `json:"status"`
+	Server   string      `json:"server,omitempty"`
+	Sessions int         `json:"sessions"`
+	Store    storeStatus `json:"store"`
+}
+
+// storeStatus reports the session store in use; an unreachable store
+// doesn't fail the health check since sessions are still admitted
+type storeStatus struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+}
+
+func newHealthStatus(status, server string, sessionManager *session.Manager) healthStatus {
+	name, healthy := sessionManager.StoreStatus()
+	return healthStatus{
+		Status:   status,
+		Server:   server,
+		Sessions: sessionManager.GetActiveSessionCount(),
+		Store:    storeStatus{Name: name, Healthy: healthy},
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/room4-2/OpenConverse/auth"
+	"github.com/room4-2/OpenConverse/config"
+	"github.com/room4-2/OpenConverse/profile"
+	"github.com/room4-2/OpenConverse/session"
+	"github.com/room4-2/OpenConverse/twilio"
+)
+
+const (
+	// outboundRingTimeout is how long an outbound call rings, well inside the stream token's validity
+	outboundRingTimeout = 60 * time.Second
+	// maxCallTwiMLSize is Twilio's limit on the TwiML a call is created with
+	maxCallTwiMLSize = 4000
+)
+
+// e164 matches a phone number in E.164 format
+var e164 = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+// ErrInvalidCall is returned for an outbound call request that can't be placed
+var ErrInvalidCall = errors.New("invalid call")
+
+// CallCreator places calls: a *twilio.Client, or a fake when developing locally
+type CallCreator interface {
+	CreateCall(ctx context.Context, params twilio.CallParams) (*twilio.Call, error)
+}
+
+// OutboundCall asks the assistant to call someone
+type OutboundCall struct {
+	To          string            `json:"to"`                    // Number to call, E.164
+	From        string            `json:"from,omitempty"`        // Caller ID (TWILIO_FROM_NUMBER when empty)
+	Profile     string            `json:"profile,omitempty"`     // Agent profile making the call ("" for the default)
+	Variables   map[string]string `json:"variables,omitempty"`   // Template variables of the profile's outbound prompt
+	CallbackURL string            `json:"callbackUrl,omitempty"` // Twilio posts the call's progress here (optional)
+}
+
+// Dialer places outbound calls through Twilio. The call's TwiML streams it
+// back to /stream, whose one-time token carries the profile and variables the
+// call was placed with, so any instance sharing the auth token can run it.
+type Dialer struct {
+	calls          CallCreator
+	tokens         *auth.StreamTokens
+	sessionManager *session.Manager
+	from           string
+	publicBaseURL  string
+	streamURL      string // wss://<public host>/stream
+	statusCallback string // Of the media streams
+}
+
+// NewDialer creates a dialer placing calls with calls, whose streams present tokens
+func NewDialer(cfg *config.Config, sessionManager *session.Manager, tokens *auth.StreamTokens, calls CallCreator) *Dialer {
+	return &Dialer{
+		calls:          calls,
+		tokens:         tokens,
+		sessionManager: sessionManager,
+		from:           cfg.TwilioFromNumber,
+		publicBaseURL:  cfg.PublicBaseURL,
+		streamURL:      strings.Replace(cfg.PublicBaseURL, "http", "ws", 1) + "/stream",
+		statusCallback: cfg.TwilioStatusCallbackURL,
+	}
+}
+
+// Dial places an outbound call
+func (d *Dialer) Dial(ctx context.Context, call OutboundCall) (*twilio.Call, error) {
+	if call.From == "" {
+		call.From = d.from
+	}
+	if !e164.MatchString(call.To) {
+		return nil, fmt.Errorf("%w: 'to' must be an E.164 phone number", ErrInvalidCall)
+	}
+	if !e164.MatchString(call.From) {
+		return nil, fmt.Errorf("%w: 'from' must be an E.164 phone number (or set TWILIO_FROM_NUMBER)", ErrInvalidCall)
+	}
+	p, ok := d.sessionManager.Profile(call.Profile)
+	if !ok {
+		return nil, fmt.Errorf("%w: %w: %s", ErrInvalidCall, session.ErrUnknownProfile, call.Profile)
+	}
+	if call.CallbackURL != "" {
+		u, err := url.Parse(call.CallbackURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("%w: 'callbackUrl' must be an http(s) URL", ErrInvalidCall)
+		}
+	}
+
+	// Calling only to have the stream refused would leave the callee in silence
+	if d.sessionManager.Draining() {
+		return nil, session.ErrDraining
+	}
+	if !d.sessionManager.HasCapacity() {
+		return nil, session.ErrMaxSessions
+	}
+
+	token, err := d.tokens.Issue(auth.StreamCall{Caller: call.To, Profile: call.Profile, Outbound: true, Variables: call.Variables})
+	if err != nil {
+		return nil, err
+	}
+	// The assistant speaks first, so there's nothing for the provider to say
+	twiml, err := p.RenderTwiML(profile.TwiMLData{
+		Profile:        p.Name,
+		From:           call.From,
+		To:             call.To,
+		StreamURL:      d.streamURL + "/" + token,
+		StatusCallback: d.statusCallback,
+		PublicBaseURL:  d.publicBaseURL,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render TwiML of profile %q: %w", p.Name, err)
+	}
+	// The variables travel in the stream URL, inside the TwiML
+	if len(twiml) > maxCallTwiMLSize {
+		return nil, fmt.Errorf("%w: 'variables' are too large to place the call", ErrInvalidCall)
+	}
+
+	created, err := d.calls.CreateCall(ctx, twilio.CallParams{
+		To:             call.To,
+		From:           call.From,
+		TwiML:          twiml,
+		StatusCallback: call.CallbackURL,
+		Timeout:        outboundRingTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Outbound call placed", "call_sid", created.SID, "to", call.To, "profile", call.Profile)
+	return created, nil
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/room4-2/OpenConverse/auth"
+	"github.com/room4-2/OpenConverse/config"
+	"github.com/room4-2/OpenConverse/session"
+	"github.com/room4-2/OpenConverse/store"
+	"github.com/room4-2/OpenConverse/twilio"
+)
+
+// fakeCalls records the calls it's asked to place instead of calling Twilio
+type fakeCalls struct {
+	placed []twilio.CallParams
+	err    error
+}
+
+func (f *fakeCalls) CreateCall(_ context.Context, params twilio.CallParams) (*twilio.Call, error) {
+	f.placed = append(f.placed, params)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &twilio.Call{SID: "CA0123456789abcdef", Status: "queued"}, nil
+}
+
+// newTestDialer returns a dialer placing its calls with calls, and its
+// session manager allowing maxSessions
+func newTestDialer(t *testing.T, calls CallCreator, maxSessions int) (*Dialer, *session.Manager) {
+	t.Helper()
+	return newTestDialerStore(t, calls, maxSessions, store.NewMemory())
+}
+
+// newTestDialerStore returns a dialer of an instance sharing sessionStore
+func newTestDialerStore(t *testing.T, calls CallCreator, maxSessions int, sessionStore store.SessionStore) (*Dialer, *session.Manager) {
+	t.Helper()
+	cfg := &config.Config{
+		MaxSessions:      maxSessions,
+		PublicBaseURL:    "https://voice.example.com",
+		TwilioFromNumber: "+13122123456",
+		TwilioTenant:     "acme",
+	}
+	sm, err := session.NewManager(cfg, sessionStore)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	tokens := auth.NewStreamTokens([]byte("twilio-auth-token"), streamTokenTTL, sm.Store())
+	return NewDialer(cfg, sm, tokens, calls), sm
+}
+
+func TestDialValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		call OutboundCall
+	}{
+		{"missing to", OutboundCall{}},
+		{"local number", OutboundCall{To: "3122010094"}},
+		{"leading zero", OutboundCall{To: "+03122010094"}},
+		{"too long", OutboundCall{To: "+1312201009412345"}},
+		{"bad from", OutboundCall{To: "+13122010094", From: "Acme"}},
+		{"unknown profile", OutboundCall{To: "+13122010094", Profile: "unknown"}},
+		{"relative callback", OutboundCall{To: "+13122010094", CallbackURL: "/status"}},
+		{"ftp callback", OutboundCall{To: "+13122010094", CallbackURL: "ftp://example.com/status"}},
+		{"variables too large", OutboundCall{To: "+13122010094", Variables: map[string]string{"notes": strings.Repeat("x", maxCallTwiMLSize)}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := &fakeCalls{}
+			d, _ := newTestDialer(t, calls, 1)
+			if _, err := d.Dial(context.Background(), tt.call); !errors.Is(err, ErrInvalidCall) {
+				t.Errorf("Dial = %v, want ErrInvalidCall", err)
+			}
+			if len(calls.placed) != 0 {
+				t.Errorf("placed %d calls, want none", len(calls.placed))
+			}
+		})
+	}
+}
+
+// Calls aren't placed when their stream would be refused
+func TestDialCapacity(t *testing.T) {
+	calls := &fakeCalls{}
+	d, sm := newTestDialer(t, calls, 1)
+	call := OutboundCall{To: "+13122010094"}
+
+	sm.SetDraining(true)
+	if _, err := d.Dial(context.Background(), call); !errors.Is(err, session.ErrDraining) {
+		t.Errorf("Dial while draining = %v, want ErrDraining", err)
+	}
+
+	d, _ = newTestDialer(t, calls, 0)
+	if _, err := d.Dial(context.Background(), call); !errors.Is(err, session.ErrMaxSessions) {
+		t.Errorf("Dial at capacity = %v, want ErrMaxSessions", err)
+	}
+	if len(calls.placed) != 0 {
+		t.Errorf("placed %d calls, want none", len(calls.placed))
+	}
+}
+
+// streamToken returns the token of the stream URL in twiml
+func streamToken(t *testing.T, twiml string) string {
+	t.Helper()
+	const prefix = `url="wss://voice.example.com/stream/`
+	start := strings.Index(twiml, prefix)
+	if start < 0 {
+		t.Fatalf("TwiML doesn't stream to a tokened URL:\n%s", twiml)
+	}
+	token, _, _ := strings.Cut(twiml[start+len(prefix):], `"`)
+	return token
+}
+
+func TestDial(t *testing.T) {
+	calls := &fakeCalls{}
+	d, _ := newTestDialer(t, calls, 1)
+
+	created, err := d.Dial(context.Background(), OutboundCall{
+		To:          "+13122010094",
+		Variables:   map[string]string{"name": "Ada"},
+		CallbackURL: "https://crm.example.com/status",
+	})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	if created.SID != "CA0123456789abcdef" {
+		t.Errorf("SID = %q", created.SID)
+	}
+	if len(calls.placed) != 1 {
+		t.Fatalf("placed %d calls, want 1", len(calls.placed))
+	}
+	params := calls.placed[0]
+	if params.To != "+13122010094" || params.From != "+13122123456" {
+		t.Errorf("call from %s to %s, want from TWILIO_FROM_NUMBER to +13122010094", params.From, params.To)
+	}
+	if params.StatusCallback != "https://crm.example.com/status" || params.Timeout != outboundRingTimeout {
+		t.Errorf("status callback %q, timeout %s", params.StatusCallback, params.Timeout)
+	}
+
+	// The TwiML streams the call back with a token carrying its options
+	call, err := d.tokens.Verify(context.Background(), streamToken(t, params.TwiML))
+	if err != nil {
+		t.Fatalf("Verify of the stream token: %v", err)
+	}
+	if !call.Outbound || call.Caller != "+13122010094" || call.Profile != "" || call.Variables["name"] != "Ada" {
+		t.Errorf("stream call = %+v", call)
+	}
+}
+
+// The stream of a call can reach another instance than the one that dialed it
+func TestDialStreamOnAnotherInstance(t *testing.T) {
+	shared := store.NewMemory()
+	calls := &fakeCalls{}
+	dialing, _ := newTestDialerStore(t, calls, 1, shared)
+	other, _ := newTestDialerStore(t, &fakeCalls{}, 1, shared)
+
+	_, err := dialing.Dial(context.Background(), OutboundCall{To: "+13122010094", Variables: map[string]string{"name": "Ada"}})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	token := streamToken(t, calls.placed[0].TwiML)
+
+	call, err := other.tokens.Verify(context.Background(), token)
+	if err != nil {
+		t.Fatalf("Verify on another instance: %v", err)
+	}
+	want := auth.StreamCall{Caller: "+13122010094", Outbound: true, Variables: map[string]string{"name": "Ada"}}
+	if !reflect.DeepEqual(call, want) {
+		t.Errorf("stream call on another instance = %+v, want %+v", call, want)
+	}
+	if _, err := dialing.tokens.Verify(context.Background(), token); !errors.Is(err, auth.ErrReusedToken) {
+		t.Errorf("Verify on the dialing instance after the other = %v, want ErrReusedToken", err)
+	}
+}
+
+func TestDialRefused(t *testing.T) {
+	refused := &twilio.APIError{Status: http.StatusBadRequest, Code: 21211, Message: "Invalid 'To' Phone Number"}
+	d, _ := newTestDialer(t, &fakeCalls{err: refused}, 1)
+
+	var apiErr *twilio.APIError
+	if _, err := d.Dial(context.Background(), OutboundCall{To: "+13122010094"}); !errors.As(err, &apiErr) || apiErr.Code != 21211 {
+		t.Errorf("Dial = %v, want Twilio's error", err)
+	}
+}
+
+func TestAdminCreateCall(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		err      error
+		draining bool
+		status   int
+	}{
+		{"placed", `{"to": "+13122010094"}`, nil, false, http.StatusCreated},
+		{"invalid body", `{"to": `, nil, false, http.StatusBadRequest},
+		{"invalid number", `{"to": "911"}`, nil, false, http.StatusBadRequest},
+		{"draining", `{"to": "+13122010094"}`, nil, true, http.StatusServiceUnavailable},
+		{"refused", `{"to": "+13122010094"}`, &twilio.APIError{Status: http.StatusBadRequest, Message: "Invalid 'To' Phone Number"}, false, http.StatusBadGateway},
+		{"unreachable", `{"to": "+13122010094"}`, context.DeadlineExceeded, false, http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, sm := newTestDialer(t, &fakeCalls{err: tt.err}, 1)
+			sm.SetDraining(tt.draining)
+			admin := NewAdminServer(&config.Config{AdminToken: "admin-token"}, sm, d)
+
+			req := httptest.NewRequest(http.MethodPost, "/calls", strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer admin-token")
+			rec := httptest.NewRecorder()
+			admin.httpServer.Handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
+
+// POST /calls can wait out the Twilio API's timeout and still answer
+func TestAdminWriteTimeout(t *testing.T) {
+	admin := NewAdminServer(&config.Config{}, nil, nil)
+	if admin.httpServer.WriteTimeout <= twilio.RequestTimeout {
+		t.Errorf("WriteTimeout %s, want more than twilio.RequestTimeout (%s)", admin.httpServer.WriteTimeout, twilio.RequestTimeout)
+	}
+}
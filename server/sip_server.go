@@ -0,0 +1,580 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/room4-2/OpenConverse/config"
+	"github.com/room4-2/OpenConverse/metrics"
+	"github.com/room4-2/OpenConverse/session"
+	"github.com/room4-2/OpenConverse/sip"
+	"github.com/room4-2/OpenConverse/transport"
+)
+
+const (
+	// sipT1 is the RTT estimate driving UDP retransmissions (RFC 3261 timer T1)
+	sipT1 = 500 * time.Millisecond
+	// sipAckTimeout is how long a 200 OK is retransmitted over UDP waiting for its ACK (timer H)
+	sipAckTimeout = 64 * sipT1
+	// sipAllow lists the methods we accept
+	sipAllow = "INVITE, ACK, BYE, CANCEL, OPTIONS"
+)
+
+// SIPServer answers calls from a PBX or SIP trunk over UDP and TCP and
+// bridges their RTP media to sessions. /health and /metrics are served over
+// HTTP on PORT.
+type SIPServer struct {
+	config         *config.Config
+	sessionManager *session.Manager
+	httpServer     *http.Server
+
+	mu       sync.Mutex
+	udp      *net.UDPConn
+	tcp      net.Listener
+	tcpConns map[net.Conn]struct{}
+	calls    map[string]*sipCall // By Call-ID
+	closed   bool
+}
+
+// sipCall is an INVITE being answered or an established call
+type sipCall struct {
+	invite    *sip.Message
+	localTag  string
+	transport string             // "UDP" or "TCP", for Via headers
+	localAddr string             // host:port of our SIP socket facing the caller
+	send      func([]byte) error // Writes to where the INVITE came from
+	cancel    context.CancelFunc // Aborts setting up the session
+	media     *transport.RTP
+	answerSDP []byte
+
+	mu      sync.Mutex
+	final   *sip.Message // Final response to the INVITE, resent for retransmissions
+	acked   chan struct{}
+	ackOnce sync.Once
+}
+
+func NewSIPServer(cfg *config.Config, sessionManager *session.Manager) *SIPServer {
+	s := &SIPServer{
+		config:         cfg,
+		sessionManager: sessionManager,
+		tcpConns:       make(map[net.Conn]struct{}),
+		calls:          make(map[string]*sipCall),
+	}
+
+	if len(cfg.SIPAllowedNetworks) == 0 {
+		slog.Warn("SIP_ALLOWED_NETWORKS not set, any host can place calls")
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", s.handleHealth)
+	mux.Handle("/metrics", metrics.Handler())
+	s.httpServer = &http.Server{
+		Addr:              fmt.Sprintf(":%d", cfg.Port),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	return s
+}
+
+// Start listens for SIP over UDP and TCP, and for health checks over HTTP
+func (s *SIPServer) Start() error {
+	addr := fmt.Sprintf(":%d", s.config.SIPPort)
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	udp, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	tcp, err := net.Listen("tcp", addr)
+	if err != nil {
+		_ = udp.Close()
+		return err
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = udp.Close()
+		_ = tcp.Close()
+		return http.ErrServerClosed
+	}
+	s.udp, s.tcp = udp, tcp
+	s.mu.Unlock()
+
+	slog.Info("SIP server starting", "sip_addr", addr, "rtp_ports",
+		fmt.Sprintf("%d-%d", s.config.RTPPortMin, s.config.RTPPortMax), "health_addr", s.httpServer.Addr)
+
+	errs := make(chan error, 3)
+	go func() { errs <- s.serveUDP(udp) }()
+	go func() { errs <- s.serveTCP(tcp) }()
+	go func() { errs <- s.httpServer.ListenAndServe() }()
+	return <-errs
+}
+
+// Shutdown stops accepting calls; established calls end with their sessions
+func (s *SIPServer) Shutdown(ctx context.Context) error {
+	slog.Info("Shutting down SIP server")
+
+	s.mu.Lock()
+	s.closed = true
+	if s.udp != nil {
+		_ = s.udp.Close()
+	}
+	if s.tcp != nil {
+		_ = s.tcp.Close()
+	}
+	for conn := range s.tcpConns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+
+	return s.httpServer.Shutdown(ctx)
+}
+
+func (s *SIPServer) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+func (s *SIPServer) serveUDP(conn *net.UDPConn) error {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if s.isClosed() {
+				return http.ErrServerClosed
+			}
+			return err
+		}
+		msg, err := sip.Parse(buf[:n])
+		if err != nil {
+			// Keep-alives and garbage
+			continue
+		}
+		s.handle(msg, addr, "UDP", func(data []byte) error {
+			_, err := conn.WriteToUDP(data, addr)
+			return err
+		})
+	}
+}
+
+func (s *SIPServer) serveTCP(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return http.ErrServerClosed
+			}
+			return err
+		}
+		go s.serveTCPConn(conn)
+	}
+}
+
+func (s *SIPServer) serveTCPConn(conn net.Conn) {
+	s.mu.Lock()
+	s.tcpConns[conn] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.tcpConns, conn)
+		s.mu.Unlock()
+		_ = conn.Close()
+	}()
+
+	var writeMu sync.Mutex
+	reply := func(data []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		_ = conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		_, err := conn.Write(data)
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+	for {
+		msg, err := sip.ReadMessage(reader)
+		if errors.Is(err, sip.ErrMissingContentLength) {
+			// The end of the message can't be found, so neither can the next one
+			slog.Warn("Closing SIP connection sending a message without Content-Length", "remote_addr", conn.RemoteAddr().String())
+			if msg.IsRequest() && msg.Method != "ACK" {
+				_ = reply(sip.NewResponse(msg, 400, "Missing Content-Length").Bytes())
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+		s.handle(msg, conn.RemoteAddr(), "TCP", reply)
+	}
+}
+
+// handle dispatches a request; responses (to our BYEs) need no handling
+func (s *SIPServer) handle(msg *sip.Message, remote net.Addr, proto string, reply func([]byte) error) {
+	if !msg.IsRequest() || msg.Method == "ACK" {
+		if msg.Method == "ACK" {
+			s.handleAck(msg)
+		}
+		return
+	}
+	if !s.allowed(remote) {
+		slog.Warn("Rejected SIP request from disallowed address", "method", msg.Method, "remote_addr", remote.String())
+		metrics.SessionCreateFailures.WithLabelValues("unauthorized").Inc()
+		_ = reply(sip.NewResponse(msg, 403, "Forbidden").Bytes())
+		return
+	}
+
+	switch msg.Method {
+	case "INVITE":
+		s.handleInvite(msg, remote, proto, reply)
+	case "BYE":
+		s.handleBye(msg, reply)
+	case "CANCEL":
+		s.handleCancel(msg, reply)
+	case "OPTIONS":
+		// PBXs poll trunks with OPTIONS to see they're up
+		res := sip.NewResponse(msg, 200, "OK")
+		res.Add("Allow", sipAllow)
+		res.Add("Accept", "application/sdp")
+		_ = reply(res.Bytes())
+	default:
+		res := sip.NewResponse(msg, 405, "Method Not Allowed")
+		res.Add("Allow", sipAllow)
+		_ = reply(res.Bytes())
+	}
+}
+
+// allowed reports whether remote may send requests, per SIP_ALLOWED_NETWORKS
+func (s *SIPServer) allowed(remote net.Addr) bool {
+	if len(s.config.SIPAllowedNetworks) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remote.String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	for _, network := range s.config.SIPAllowedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *SIPServer) handleInvite(invite *sip.Message, remote net.Addr, proto string, reply func([]byte) error) {
+	callID := invite.Get("Call-ID")
+	if callID == "" || invite.Get("From") == "" || invite.Get("To") == "" || invite.Get("Via") == "" {
+		_ = reply(sip.NewResponse(invite, 400, "Bad Request").Bytes())
+		return
+	}
+
+	s.mu.Lock()
+	call, exists := s.calls[callID]
+	s.mu.Unlock()
+	if exists {
+		s.handleReinvite(call, invite, reply)
+		return
+	}
+
+	// A rejected INVITE makes the PBX try its next route
+	if s.sessionManager.Draining() {
+		_ = reply(sip.NewResponse(invite, 503, "Service Unavailable").Bytes())
+		return
+	}
+
+	media, err := sip.Negotiate(invite.Body)
+	if err != nil {
+		slog.Warn("Rejected SIP call", "call_id", callID, "error", err)
+		res := sip.NewResponse(invite, 488, "Not Acceptable Here")
+		res.Add("Accept", "application/sdp")
+		_ = reply(res.Bytes())
+		return
+	}
+	remoteRTP := &net.UDPAddr{IP: net.ParseIP(media.Address), Port: media.Port}
+	if remoteRTP.IP == nil {
+		_ = reply(sip.NewResponse(invite, 488, "Not Acceptable Here").Bytes())
+		return
+	}
+
+	rtpConn, err := s.listenRTP()
+	if err != nil {
+		slog.Error("Failed to open RTP port", "call_id", callID, "error", err)
+		_ = reply(sip.NewResponse(invite, 503, "Service Unavailable").Bytes())
+		return
+	}
+
+	localIP := s.localIP(remote)
+	ctx, cancel := context.WithCancel(context.Background())
+	call = &sipCall{
+		invite:    invite,
+		localTag:  sip.NewTag(),
+		transport: proto,
+		localAddr: net.JoinHostPort(localIP, strconv.Itoa(s.config.SIPPort)),
+		send:      reply,
+		cancel:    cancel,
+		acked:     make(chan struct{}),
+	}
+	call.answerSDP = sip.Answer(media, localIP, rtpConn.LocalAddr().(*net.UDPAddr).Port, time.Now().Unix())
+	call.media = transport.NewRTP(rtpConn, transport.RTPConfig{
+		Remote:      remoteRTP,
+		PayloadType: media.PayloadType,
+		DTMFType:    media.DTMFType,
+		OnClose:     func() { s.sendBye(call) },
+	})
+
+	s.mu.Lock()
+	s.calls[callID] = call
+	s.mu.Unlock()
+
+	_ = reply(sip.NewResponse(invite, 100, "Trying").Bytes())
+	go s.answer(ctx, call)
+}
+
+// answer creates the call's session, then answers the INVITE and runs the
+// session until the call ends
+func (s *SIPServer) answer(ctx context.Context, call *sipCall) {
+	callID := call.invite.Get("Call-ID")
+	defer func() {
+		call.cancel()
+		s.mu.Lock()
+		delete(s.calls, callID)
+		s.mu.Unlock()
+	}()
+
+	caller := sip.User(call.invite.Get("From"))
+	clientSession, err := s.sessionManager.CreateSession(ctx, call.media, session.Options{Tenant: s.config.SIPTenant, Caller: caller})
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Error("Failed to create SIP session", "call_id", callID, "error", err)
+			if errors.Is(err, session.ErrMaxSessions) || errors.Is(err, session.ErrRateLimited) {
+				call.finish(486, "Busy Here")
+			} else {
+				call.finish(503, "Service Unavailable")
+			}
+		}
+		_ = call.media.Close()
+		return
+	}
+
+	// A CANCEL may have answered the INVITE while the session was being set up
+	if !call.finish(200, "OK") {
+		_ = s.sessionManager.RemoveSession(context.Background(), clientSession.ID)
+		return
+	}
+	if call.transport == "UDP" {
+		go s.retransmitOK(call, clientSession)
+	}
+
+	clientSession.Start()
+	clientSession.Logger().Info("SIP call answered", "call_id", callID)
+
+	// Wait for session to close
+	<-clientSession.CloseChan
+
+	// Clean up
+	_ = s.sessionManager.RemoveSession(context.Background(), clientSession.ID)
+	clientSession.Logger().Info("SIP call ended", "call_id", callID)
+}
+
+// finish sends the final response to the call's INVITE, unless one was sent
+func (call *sipCall) finish(code int, reason string) bool {
+	call.mu.Lock()
+	if call.final != nil {
+		call.mu.Unlock()
+		return false
+	}
+	res := sip.NewResponse(call.invite, code, reason)
+	res.Set("To", call.invite.Get("To")+";tag="+call.localTag)
+	if code == 200 {
+		res.Add("Contact", "<sip:openconverse@"+call.localAddr+">")
+		res.Add("Allow", sipAllow)
+		res.Add("Content-Type", "application/sdp")
+		res.Body = call.answerSDP
+	}
+	call.final = res
+	call.mu.Unlock()
+
+	_ = call.send(res.Bytes())
+	return true
+}
+
+// established reports whether the INVITE was answered with 200 OK
+func (call *sipCall) established() bool {
+	call.mu.Lock()
+	defer call.mu.Unlock()
+	return call.final != nil && call.final.StatusCode == 200
+}
+
+// retransmitOK resends the 200 OK over UDP until it's acknowledged, ending
+// the call if it never is
+func (s *SIPServer) retransmitOK(call *sipCall, clientSession *session.ClientSession) {
+	deadline := time.After(sipAckTimeout)
+	interval := sipT1
+	for {
+		select {
+		case <-call.acked:
+			return
+		case <-clientSession.CloseChan:
+			return
+		case <-deadline:
+			clientSession.Logger().Warn("SIP call never acknowledged, hanging up", "call_id", call.invite.Get("Call-ID"))
+			_ = s.sessionManager.RemoveSession(context.Background(), clientSession.ID)
+			return
+		case <-time.After(interval):
+			call.mu.Lock()
+			data := call.final.Bytes()
+			call.mu.Unlock()
+			_ = call.send(data)
+			interval = min(2*interval, 8*sipT1)
+		}
+	}
+}
+
+// handleReinvite answers a retransmitted INVITE with the response already
+// sent, and a re-INVITE (session refresh or hold) with the media unchanged
+func (s *SIPServer) handleReinvite(call *sipCall, invite *sip.Message, reply func([]byte) error) {
+	seq, _ := invite.CSeq()
+	original, _ := call.invite.CSeq()
+	if seq == original {
+		call.mu.Lock()
+		final := call.final
+		call.mu.Unlock()
+		if final != nil {
+			_ = reply(final.Bytes())
+		} else {
+			_ = reply(sip.NewResponse(invite, 100, "Trying").Bytes())
+		}
+		return
+	}
+
+	if !call.established() {
+		_ = reply(sip.NewResponse(invite, 491, "Request Pending").Bytes())
+		return
+	}
+	res := sip.NewResponse(invite, 200, "OK")
+	res.Add("Contact", "<sip:openconverse@"+call.localAddr+">")
+	res.Add("Allow", sipAllow)
+	res.Add("Content-Type", "application/sdp")
+	res.Body = call.answerSDP
+	_ = reply(res.Bytes())
+}
+
+func (s *SIPServer) handleAck(ack *sip.Message) {
+	s.mu.Lock()
+	call, ok := s.calls[ack.Get("Call-ID")]
+	s.mu.Unlock()
+	if ok {
+		call.ackOnce.Do(func() { close(call.acked) })
+	}
+}
+
+func (s *SIPServer) handleBye(bye *sip.Message, reply func([]byte) error) {
+	s.mu.Lock()
+	call, ok := s.calls[bye.Get("Call-ID")]
+	s.mu.Unlock()
+	if !ok {
+		_ = reply(sip.NewResponse(bye, 481, "Call/Transaction Does Not Exist").Bytes())
+		return
+	}
+	_ = reply(sip.NewResponse(bye, 200, "OK").Bytes())
+	call.media.Hangup()
+}
+
+func (s *SIPServer) handleCancel(cancel *sip.Message, reply func([]byte) error) {
+	s.mu.Lock()
+	call, ok := s.calls[cancel.Get("Call-ID")]
+	s.mu.Unlock()
+	if !ok {
+		_ = reply(sip.NewResponse(cancel, 481, "Call/Transaction Does Not Exist").Bytes())
+		return
+	}
+	_ = reply(sip.NewResponse(cancel, 200, "OK").Bytes())
+
+	// Once answered, the caller has to send BYE instead
+	if call.finish(487, "Request Terminated") {
+		slog.Info("SIP call cancelled", "call_id", cancel.Get("Call-ID"))
+		call.media.Hangup()
+		call.cancel()
+	}
+}
+
+// sendBye hangs up an established call
+func (s *SIPServer) sendBye(call *sipCall) {
+	if !call.established() {
+		return
+	}
+	invite := call.invite
+	target := sip.URI(invite.Get("Contact"))
+	if target == "" {
+		target = sip.URI(invite.Get("From"))
+	}
+
+	bye := &sip.Message{Method: "BYE", RequestURI: target}
+	bye.Add("Via", fmt.Sprintf("SIP/2.0/%s %s;branch=%s;rport", call.transport, call.localAddr, sip.NewBranch()))
+	for _, route := range invite.Values("Record-Route") {
+		bye.Add("Route", route)
+	}
+	bye.Add("Max-Forwards", "70")
+	bye.Add("From", invite.Get("To")+";tag="+call.localTag)
+	bye.Add("To", invite.Get("From"))
+	bye.Add("Call-ID", invite.Get("Call-ID"))
+	bye.Add("CSeq", "1 BYE")
+	if err := call.send(bye.Bytes()); err != nil {
+		slog.Debug("Failed to send SIP BYE", "call_id", invite.Get("Call-ID"), "error", err)
+	}
+}
+
+// listenRTP opens a UDP socket on a free even port of the RTP range
+func (s *SIPServer) listenRTP() (*net.UDPConn, error) {
+	first := (s.config.RTPPortMin + 1) &^ 1
+	slots := (s.config.RTPPortMax-first)/2 + 1
+	var err error
+	for range 50 {
+		port := first + 2*rand.IntN(slots)
+		var conn *net.UDPConn
+		if conn, err = net.ListenUDP("udp", &net.UDPAddr{Port: port}); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, fmt.Errorf("no free RTP port: %w", err)
+}
+
+// localIP returns the address advertised to the caller: SIP_PUBLIC_IP, else
+// the local address routing to them
+func (s *SIPServer) localIP(remote net.Addr) string {
+	if s.config.SIPPublicIP != "" {
+		return s.config.SIPPublicIP
+	}
+	host, _, err := net.SplitHostPort(remote.String())
+	if err == nil {
+		// Connecting a UDP socket sends nothing, it only picks the route
+		if conn, err := net.Dial("udp", net.JoinHostPort(host, "9")); err == nil {
+			defer conn.Close()
+			return conn.LocalAddr().(*net.UDPAddr).IP.String()
+		}
+	}
+	return "127.0.0.1"
+}
+
+func (s *SIPServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	// 503 while draining takes the instance out of the load balancer
+	if s.sessionManager.Draining() {
+		writeJSON(w, http.StatusServiceUnavailable, newHealthStatus("draining", "sip", s.sessionManager))
+		return
+	}
+	writeJSON(w, http.StatusOK, newHealthStatus("ok", "sip", s.sessionManager))
+}
@@ -0,0 +1,288 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/room4-2/OpenConverse/config"
+	"github.com/room4-2/OpenConverse/gemini/geminitest"
+	"github.com/room4-2/OpenConverse/session"
+	"github.com/room4-2/OpenConverse/sip"
+	"github.com/room4-2/OpenConverse/store"
+)
+
+// sipPhone is a SIP user agent placing calls to a SIPServer over loopback UDP
+type sipPhone struct {
+	t      *testing.T
+	conn   *net.UDPConn // Signalling, connected to the server
+	rtp    *net.UDPConn // Where the phone receives media
+	callID string
+	tag    string
+	seq    int
+}
+
+// startSIPServer serves SIP over a loopback UDP socket, with room for two
+// sessions, and returns a phone connected to it
+func startSIPServer(t *testing.T) (*sipPhone, *session.Manager) {
+	t.Helper()
+	cfg := &config.Config{
+		GeminiAPIKey: "test-key",
+		MaxSessions:  2,
+		SIPPort:      5060,
+		SIPPublicIP:  "127.0.0.1",
+		RTPPortMin:   30000,
+		RTPPortMax:   39998,
+	}
+	sm, err := session.NewManager(cfg, store.NewMemory())
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	s := NewSIPServer(cfg, sm)
+
+	udp, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.udp = udp
+	go func() { _ = s.serveUDP(udp) }()
+	t.Cleanup(func() { _ = s.Shutdown(context.Background()) })
+
+	return newSIPPhone(t, udp.LocalAddr().(*net.UDPAddr)), sm
+}
+
+// newSIPPhone returns a phone placing calls to the SIP server at addr
+func newSIPPhone(t *testing.T, addr *net.UDPAddr) *sipPhone {
+	t.Helper()
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	rtp, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = rtp.Close() })
+
+	return &sipPhone{t: t, conn: conn, rtp: rtp, callID: sip.NewTag() + "@phone", tag: sip.NewTag()}
+}
+
+// request sends a request of the phone's call
+func (p *sipPhone) request(method string, toTag string, body []byte) {
+	p.t.Helper()
+	// ACK and CANCEL share the CSeq number of the INVITE
+	if method != "ACK" && method != "CANCEL" {
+		p.seq++
+	}
+	to := "<sip:+15550199@127.0.0.1>"
+	if toTag != "" {
+		to += ";tag=" + toTag
+	}
+	req := &sip.Message{Method: method, RequestURI: "sip:+15550199@127.0.0.1"}
+	req.Add("Via", fmt.Sprintf("SIP/2.0/UDP %s;branch=z9hG4bK%s-%d", p.conn.LocalAddr(), p.tag, p.seq))
+	req.Add("Max-Forwards", "70")
+	req.Add("From", "<sip:+15550100@127.0.0.1>;tag="+p.tag)
+	req.Add("To", to)
+	req.Add("Call-ID", p.callID)
+	req.Add("CSeq", fmt.Sprintf("%d %s", p.seq, method))
+	req.Add("Contact", fmt.Sprintf("<sip:+15550100@%s>", p.conn.LocalAddr()))
+	if body != nil {
+		req.Add("Content-Type", "application/sdp")
+		req.Body = body
+	}
+	if _, err := p.conn.Write(req.Bytes()); err != nil {
+		p.t.Fatal(err)
+	}
+}
+
+// invite offers PCMU and telephone-events received on the phone's RTP socket
+func (p *sipPhone) invite() {
+	p.t.Helper()
+	port := p.rtp.LocalAddr().(*net.UDPAddr).Port
+	p.request("INVITE", "", fmt.Appendf(nil,
+		"v=0\r\no=- 1 1 IN IP4 127.0.0.1\r\ns=-\r\nc=IN IP4 127.0.0.1\r\nt=0 0\r\n"+
+			"m=audio %d RTP/AVP 0 101\r\na=rtpmap:101 telephone-event/8000\r\n", port))
+}
+
+// expect returns the next response to method with the given status,
+// skipping others (provisional responses and retransmissions)
+func (p *sipPhone) expect(code int, method string) *sip.Message {
+	p.t.Helper()
+	buf := make([]byte, 65535)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		_ = p.conn.SetReadDeadline(deadline)
+		n, err := p.conn.Read(buf)
+		if err != nil {
+			p.t.Fatalf("waiting for %d to %s: %v", code, method, err)
+		}
+		res, err := sip.Parse(buf[:n])
+		if err != nil {
+			p.t.Fatalf("response: %v", err)
+		}
+		if _, m := res.CSeq(); !res.IsRequest() && res.StatusCode == code && m == method {
+			if res.Get("Call-ID") != p.callID {
+				p.t.Errorf("response Call-ID = %q, want %q", res.Get("Call-ID"), p.callID)
+			}
+			return res
+		}
+	}
+}
+
+// waitSessions fails the test unless the manager has n sessions within a second
+func waitSessions(t *testing.T, sm *session.Manager, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for sm.GetActiveSessionCount() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("manager has %d sessions, want %d", sm.GetActiveSessionCount(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitCapacity fails the test unless the manager's HasCapacity is want
+// within a second
+func waitCapacity(t *testing.T, sm *session.Manager, want bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for sm.HasCapacity() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("HasCapacity = %v, want %v", !want, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSIPCall(t *testing.T) {
+	gm := geminitest.NewServer(t)
+	phone, sm := startSIPServer(t)
+
+	phone.invite()
+	phone.expect(100, "INVITE")
+	ok := phone.expect(200, "INVITE")
+	toTag := sip.Param(ok.Get("To"), "tag")
+	if toTag == "" {
+		t.Fatal("200 OK without a To tag")
+	}
+	if contact := sip.URI(ok.Get("Contact")); !strings.HasPrefix(contact, "sip:openconverse@127.0.0.1") {
+		t.Errorf("Contact = %q", contact)
+	}
+	answer, err := sip.Negotiate(ok.Body)
+	if err != nil {
+		t.Fatalf("SDP answer: %v\n%s", err, ok.Body)
+	}
+	if answer.PayloadType != sip.PayloadPCMU || answer.DTMFType != 101 || answer.Port < 30000 || answer.Port > 39998 {
+		t.Errorf("answer = %+v, want PCMU and telephone-events on an RTP range port", answer)
+	}
+	phone.request("ACK", toTag, nil)
+	waitSessions(t, sm, 1)
+
+	// The caller's audio reaches Gemini
+	media := &net.UDPAddr{IP: net.ParseIP(answer.Address), Port: answer.Port}
+	packet := make([]byte, 12+160)
+	packet[0] = 0x80
+	if _, err := phone.rtp.WriteToUDP(packet, media); err != nil {
+		t.Fatal(err)
+	}
+	if audio := gm.Next(); len(audio.Audio) == 0 {
+		t.Errorf("Gemini received %+v, want the caller's audio", audio)
+	}
+
+	phone.request("BYE", toTag, nil)
+	phone.expect(200, "BYE")
+	waitSessions(t, sm, 0)
+
+	// The call is gone
+	phone.request("BYE", toTag, nil)
+	phone.expect(481, "BYE")
+}
+
+func TestSIPCancel(t *testing.T) {
+	// Gemini never answers, so the INVITE stays unanswered until cancelled
+	release := make(chan struct{})
+	gemini := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	t.Cleanup(gemini.Close)
+	var releaseOnce sync.Once
+	releaseGemini := func() { releaseOnce.Do(func() { close(release) }) }
+	t.Cleanup(releaseGemini)
+	t.Setenv("GOOGLE_GEMINI_BASE_URL", "ws://"+strings.TrimPrefix(gemini.URL, "http://"))
+
+	phone, sm := startSIPServer(t)
+	other := newSIPPhone(t, phone.conn.RemoteAddr().(*net.UDPAddr))
+
+	phone.invite()
+	phone.expect(100, "INVITE")
+	other.invite()
+	other.expect(100, "INVITE")
+
+	// The calls connecting to Gemini hold both slots, before they have sessions
+	waitCapacity(t, sm, false)
+	if n := sm.GetActiveSessionCount(); n != 0 {
+		t.Errorf("manager has %d sessions before any call was answered", n)
+	}
+
+	for _, p := range []*sipPhone{phone, other} {
+		p.request("CANCEL", "", nil)
+		p.expect(200, "CANCEL")
+		terminated := p.expect(487, "INVITE")
+		p.request("ACK", sip.Param(terminated.Get("To"), "tag"), nil)
+	}
+
+	// The sessions being set up are abandoned, freeing their slots once the
+	// Gemini dial returns (genai doesn't pass the context to it)
+	releaseGemini()
+	waitCapacity(t, sm, true)
+	waitSessions(t, sm, 0)
+}
+
+// A message without Content-Length on a stream is answered with 400 and the
+// connection closed, as the next message can't be found
+func TestSIPTCPMissingContentLength(t *testing.T) {
+	cfg := &config.Config{MaxSessions: 1}
+	sm, err := session.NewManager(cfg, store.NewMemory())
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	s := NewSIPServer(cfg, sm)
+
+	server, client := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		s.serveTCPConn(server)
+		close(done)
+	}()
+	t.Cleanup(func() { _ = client.Close() })
+
+	_ = client.SetDeadline(time.Now().Add(time.Second))
+	if _, err := client.Write([]byte("OPTIONS sip:a@b SIP/2.0\r\nVia: SIP/2.0/TCP 127.0.0.1:5060;branch=z9hG4bK1\r\n" +
+		"Call-ID: abc\r\nCSeq: 1 OPTIONS\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	res, err := sip.ReadMessage(bufio.NewReader(client))
+	if err != nil {
+		t.Fatalf("reading the response: %v", err)
+	}
+	if res.StatusCode != 400 || res.Get("Call-ID") != "abc" {
+		t.Errorf("response = %d %s for %q, want 400", res.StatusCode, res.Reason, res.Get("Call-ID"))
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("connection not closed")
+	}
+}
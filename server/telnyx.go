@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/room4-2/OpenConverse/auth"
+	"github.com/room4-2/OpenConverse/profile"
+	"github.com/room4-2/OpenConverse/telnyx"
+	"github.com/room4-2/OpenConverse/transport"
+)
+
+// handleTelnyxVoice answers a Telnyx TeXML call webhook with TeXML that
+// connects the call to /telnyx/stream
+func (s *WebsocketTwilio) handleTelnyxVoice(w http.ResponseWriter, r *http.Request) {
+	body, err := readWebhookBody(w, r)
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if key := s.config.TelnyxPublicKey; key != nil &&
+		!telnyx.ValidSignature(key, r.Header.Get(telnyx.TimestampHeader), body, r.Header.Get(telnyx.SignatureHeader), time.Now()) {
+		slog.Warn("Rejected /telnyx/voice request with invalid signature", "remote_addr", r.RemoteAddr, "header", telnyx.SignatureHeader)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	p, ok := s.webhookProfile(w, r)
+	if !ok {
+		return
+	}
+	data := profile.TwiMLData{
+		Profile:       p.Name,
+		From:          r.FormValue("From"),
+		To:            r.FormValue("To"),
+		CallSid:       r.FormValue("CallSid"),
+		PublicBaseURL: s.publicBaseURL(r),
+	}
+	// TeXML takes the same verbs as TwiML, so the busy TwiML answers here too
+	if s.answerBusy(w, "Telnyx", p, data, true) {
+		return
+	}
+
+	// The stream's start event doesn't reliably carry the caller, so the token does
+	wsURL, err := s.streamURL(r, "/telnyx/stream", s.telnyxTokens, auth.StreamCall{Caller: data.From, Profile: p.Name})
+	if err != nil {
+		slog.Error("Failed to issue Telnyx stream token", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// TeXML to stream the call both ways, playing the response in the codec
+	// the transport encodes
+	var say, streamURL strings.Builder
+	if text := p.ProviderSay(); text != "" {
+		say.WriteString("\n\t<Say>")
+		_ = xml.EscapeText(&say, []byte(text))
+		say.WriteString("</Say>")
+	}
+	_ = xml.EscapeText(&streamURL, []byte(wsURL))
+	xmlResponse := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>%s
+	<Connect>
+		<Stream url="%s" bidirectionalMode="rtp" bidirectionalCodec="%s" />
+	</Connect>
+</Response>`, say.String(), streamURL.String(), transport.TelnyxBidirectionalCodec)
+
+	writeTwiML(w, xmlResponse)
+}
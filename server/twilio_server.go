@@ -1,24 +1,56 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
+	"github.com/room4-2/OpenConverse/auth"
 	"github.com/room4-2/OpenConverse/config"
+	"github.com/room4-2/OpenConverse/metrics"
+	"github.com/room4-2/OpenConverse/profile"
 	"github.com/room4-2/OpenConverse/session"
+	"github.com/room4-2/OpenConverse/tracing"
+	"github.com/room4-2/OpenConverse/transport"
+	"github.com/room4-2/OpenConverse/twilio"
 
 	"github.com/gorilla/websocket"
 )
 
+// streamTokenTTL is how long a provider has to open the media stream after fetching the call instructions
+const streamTokenTTL = 2 * time.Minute
+
+// maxWebhookBody bounds the call webhook bodies read for signature checks
+const maxWebhookBody = 64 * 1024
+
+// WebsocketTwilio serves phone calls: the call webhooks and media streams of
+// Twilio, Telnyx and Vonage
 type WebsocketTwilio struct {
 	httpServer     *http.Server
 	upgrader       websocket.Upgrader
 	sessionManager *session.Manager
+	streamTokens   *auth.StreamTokens // nil when TWILIO_AUTH_TOKEN is not set
+	telnyxTokens   *auth.StreamTokens // nil when TELNYX_API_KEY is not set
+	vonageTokens   *auth.StreamTokens // nil when VONAGE_SIGNATURE_SECRET is not set
+	dialer         *Dialer            // nil when TWILIO_ACCOUNT_SID is not set
 	config         *config.Config
 }
 
+// mediaStream is a provider's media stream endpoint
+type mediaStream struct {
+	provider     string // For logs
+	path         string // Streams connect to path or path/<token>
+	tokens       *auth.StreamTokens
+	tenant       string
+	newTransport func(*websocket.Conn) transport.Transport
+}
+
 func NewWebsocketTwilio(cfg *config.Config, sessionManager *session.Manager) *WebsocketTwilio {
 	s := &WebsocketTwilio{
 		sessionManager: sessionManager,
@@ -26,20 +58,62 @@ func NewWebsocketTwilio(cfg *config.Config, sessionManager *session.Manager) *We
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  64 * 1024,
 			WriteBufferSize: 64 * 1024,
-			// Twilio doesn't support WebSocket compression
+			// Providers don't support WebSocket compression
 			EnableCompression: false,
 			CheckOrigin: func(r *http.Request) bool {
-				// Twilio connections don't send browser Origin headers.
-				// Allow all origins for the Twilio server.
-				return true
+				// Provider connections don't send browser Origin headers,
+				// so anything carrying one comes from a browser.
+				return r.Header.Get("Origin") == ""
 			},
 		},
 	}
 
+	if cfg.TwilioAuthToken != "" {
+		s.streamTokens = auth.NewStreamTokens([]byte(cfg.TwilioAuthToken), streamTokenTTL, sessionManager.Store())
+	} else {
+		s.warnUnauthenticated("Twilio", "TWILIO_AUTH_TOKEN")
+	}
+	if cfg.TwilioAccountSID != "" {
+		client := twilio.NewClient(cfg.TwilioAccountSID, cfg.TwilioAuthToken, cfg.TwilioAPIURL, tracing.HTTPClient(twilio.RequestTimeout))
+		s.dialer = NewDialer(cfg, sessionManager, s.streamTokens, client)
+	}
+	if cfg.TelnyxAPIKey != "" {
+		s.telnyxTokens = auth.NewStreamTokens([]byte(cfg.TelnyxAPIKey), streamTokenTTL, sessionManager.Store())
+	} else {
+		s.warnUnauthenticated("Telnyx", "TELNYX_PUBLIC_KEY and TELNYX_API_KEY")
+	}
+	if cfg.VonageSignatureSecret != "" {
+		s.vonageTokens = auth.NewStreamTokens([]byte(cfg.VonageSignatureSecret), streamTokenTTL, sessionManager.Store())
+	} else {
+		s.warnUnauthenticated("Vonage", "VONAGE_SIGNATURE_SECRET")
+	}
+
+	twilioStream := s.streamHandler(mediaStream{
+		provider: "Twilio", path: "/stream", tokens: s.streamTokens, tenant: cfg.TwilioTenant,
+		newTransport: func(conn *websocket.Conn) transport.Transport { return transport.NewTwilio(conn) },
+	})
+	telnyxStream := s.streamHandler(mediaStream{
+		provider: "Telnyx", path: "/telnyx/stream", tokens: s.telnyxTokens, tenant: cfg.TelnyxTenant,
+		newTransport: func(conn *websocket.Conn) transport.Transport { return transport.NewTelnyx(conn) },
+	})
+	vonageStream := s.streamHandler(mediaStream{
+		provider: "Vonage", path: "/vonage/stream", tokens: s.vonageTokens, tenant: cfg.VonageTenant,
+		newTransport: func(conn *websocket.Conn) transport.Transport { return transport.NewVonage(conn) },
+	})
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("/stream", s.handleWebsocketTwilio)
+	mux.HandleFunc("/stream", twilioStream)
+	mux.HandleFunc("/stream/", twilioStream)
 	mux.HandleFunc("/voice", s.handleVoiceCall)
+	mux.HandleFunc("/telnyx/stream", telnyxStream)
+	mux.HandleFunc("/telnyx/stream/", telnyxStream)
+	mux.HandleFunc("/telnyx/voice", s.handleTelnyxVoice)
+	mux.HandleFunc("/vonage/stream", vonageStream)
+	mux.HandleFunc("/vonage/stream/", vonageStream)
+	mux.HandleFunc("/vonage/answer", s.handleVonageAnswer)
+	mux.HandleFunc("/vonage/event", s.handleVonageEvent)
 	mux.HandleFunc("/health", s.handleHealth)
+	mux.Handle("/metrics", metrics.Handler())
 
 	// Determine which port to use
 	port := cfg.TwilioPort
@@ -61,66 +135,246 @@ func NewWebsocketTwilio(cfg *config.Config, sessionManager *session.Manager) *We
 // Start begins listening for connections
 func (s *WebsocketTwilio) Start() error {
 	port := s.httpServer.Addr
-	log.Printf("📞 Twilio WebSocket server starting on %s", port)
-	log.Printf("📡 Twilio stream endpoint: ws://localhost%s/stream", port)
-	log.Printf("📡 Twilio voice endpoint: http://localhost%s/voice", port)
+	slog.Info("Twilio WebSocket server starting", "addr", port,
+		"stream_endpoint", "ws://localhost"+port+"/stream", "voice_endpoint", "http://localhost"+port+"/voice")
 	return s.httpServer.ListenAndServe()
 }
 
 // Shutdown gracefully stops the server
 func (s *WebsocketTwilio) Shutdown(ctx context.Context) error {
-	log.Println("Shutting down Twilio server...")
+	slog.Info("Shutting down Twilio server")
 	return s.httpServer.Shutdown(ctx)
 }
 
-func (s *WebsocketTwilio) handleWebsocketTwilio(w http.ResponseWriter, r *http.Request) {
-	conn, err := s.upgrader.Upgrade(w, r, nil)
+// warnUnauthenticated logs at startup what becomes of a provider's calls
+// without the secret named secret
+func (s *WebsocketTwilio) warnUnauthenticated(provider, secret string) {
+	if s.config.InsecurePhoneStreams {
+		slog.Warn(secret + " not set, " + provider + " requests are not authenticated")
+		return
+	}
+	slog.Warn(secret + " not set, " + provider + " media streams are refused (INSECURE_PHONE_STREAMS=true accepts them unauthenticated)")
+}
+
+// streamHandler serves a provider's media stream, running a session for each connection
+func (s *WebsocketTwilio) streamHandler(stream mediaStream) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// The call webhook points the provider at <path>/<one-time token>
+		token := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, stream.path), "/")
+		var streamCall auth.StreamCall
+		switch {
+		case stream.tokens != nil:
+			var err error
+			if streamCall, err = stream.tokens.Verify(r.Context(), token); err != nil {
+				slog.Warn("Rejected "+stream.provider+" stream", "remote_addr", r.RemoteAddr, "error", err)
+				metrics.SessionCreateFailures.WithLabelValues("unauthorized").Inc()
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+		case !s.config.InsecurePhoneStreams:
+			// Without its secret, nothing can tell the provider from anyone else
+			slog.Warn("Rejected unauthenticated "+stream.provider+" stream", "remote_addr", r.RemoteAddr)
+			metrics.SessionCreateFailures.WithLabelValues("unauthorized").Inc()
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		conn, err := s.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			slog.Warn(stream.provider+" WebSocket upgrade failed", "error", err)
+			return
+		}
+
+		// Create the session with the caller and profile of the signed webhook;
+		// the stream's start event replaces the caller when it reports one.
+		// Without tokens (INSECURE_PHONE_STREAMS) the stream is open to anyone,
+		// and so is its profile.
+		if stream.tokens == nil {
+			streamCall.Profile = r.URL.Query().Get("profile")
+		}
+		call := stream.newTransport(conn)
+		opts := session.Options{
+			Tenant:    stream.tenant,
+			Profile:   streamCall.Profile,
+			Caller:    streamCall.Caller,
+			Outbound:  streamCall.Outbound,
+			Variables: streamCall.Variables,
+		}
+		clientSession, err := s.sessionManager.CreateSession(r.Context(), call, opts)
+		if err != nil {
+			slog.Error("Failed to create "+stream.provider+" session", "error", err)
+			_ = call.Close()
+			return
+		}
+
+		clientSession.Start()
+		clientSession.Logger().Info(stream.provider + " session started")
+
+		// Wait for session to close
+		<-clientSession.CloseChan
+
+		// Clean up
+		_ = s.sessionManager.RemoveSession(r.Context(), clientSession.ID)
+		clientSession.Logger().Info(stream.provider + " session closed")
+	}
+}
+
+func (s *WebsocketTwilio) handleVoiceCall(w http.ResponseWriter, r *http.Request) {
+	if s.streamTokens != nil && !s.validTwilioRequest(r) {
+		slog.Warn("Rejected /voice request with invalid signature", "remote_addr", r.RemoteAddr, "header", twilio.SignatureHeader)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	p, ok := s.webhookProfile(w, r)
+	if !ok {
+		return
+	}
+	data := profile.TwiMLData{
+		Profile:        p.Name,
+		From:           r.FormValue("From"),
+		To:             r.FormValue("To"),
+		CallSid:        r.FormValue("CallSid"),
+		StatusCallback: s.config.TwilioStatusCallbackURL,
+		PublicBaseURL:  s.publicBaseURL(r),
+	}
+
+	if s.answerBusy(w, "Twilio", p, data, true) {
+		return
+	}
+
+	wsURL, err := s.streamURL(r, "/stream", s.streamTokens, auth.StreamCall{Caller: data.From, Profile: p.Name})
 	if err != nil {
-		log.Printf("Twilio WebSocket upgrade failed: %v", err)
+		slog.Error("Failed to issue Twilio stream token", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	data.StreamURL = wsURL
+	data.Say = p.ProviderSay()
 
-	// Create Twilio-specific session
-	clientSession, err := s.sessionManager.CreateTwilioSession(r.Context(), conn)
+	twiml, err := p.RenderTwiML(data)
 	if err != nil {
-		log.Printf("Failed to create Twilio session: %v", err)
-		conn.Close()
+		slog.Error("Failed to render TwiML", "profile", p.Name, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	writeTwiML(w, twiml)
+}
 
-	log.Printf("📞 New Twilio session created: %s", clientSession.ID)
+// writeTwiML answers a call webhook with TwiML (or TeXML)
+func writeTwiML(w http.ResponseWriter, twiml string) {
+	w.Header().Set("Content-Type", "text/xml")
+	_, _ = w.Write([]byte(twiml))
+}
 
-	// Start Twilio session (uses Twilio-specific message handler)
-	clientSession.StartTwilio()
+// answerBusy answers a call webhook when this instance has no room for the
+// call (full or draining), reporting whether it did. With twiml set, the
+// profile's busy TwiML answers it (e.g. dialing a person); otherwise, or
+// without one, a failed webhook makes the provider use the number's fallback
+// URL (e.g. another instance).
+func (s *WebsocketTwilio) answerBusy(w http.ResponseWriter, provider string, p *profile.Profile, data profile.TwiMLData, twiml bool) bool {
+	if s.sessionManager.HasCapacity() {
+		return false
+	}
+	if twiml {
+		busy, ok, err := p.RenderBusyTwiML(data)
+		if err != nil {
+			slog.Error("Failed to render busy TwiML", "profile", p.Name, "error", err)
+		}
+		if ok && err == nil {
+			slog.Warn("No room for "+provider+" call, answered with busy TwiML", "call_sid", data.CallSid, "profile", p.Name)
+			writeTwiML(w, busy)
+			return true
+		}
+	}
+	slog.Warn("No room for "+provider+" call", "call_sid", data.CallSid, "profile", p.Name)
+	http.Error(w, "Server is busy", http.StatusServiceUnavailable)
+	return true
+}
 
-	// Wait for session to close
-	<-clientSession.CloseChan
+// webhookProfile returns the profile answering a call webhook: the one named
+// by the "profile" parameter of the webhook URL (e.g. /voice?profile=sales),
+// else the default. It writes the error response when ok is false.
+func (s *WebsocketTwilio) webhookProfile(w http.ResponseWriter, r *http.Request) (_ *profile.Profile, ok bool) {
+	name := r.URL.Query().Get("profile")
+	p, ok := s.sessionManager.Profile(name)
+	if !ok {
+		slog.Warn("Rejected "+r.URL.Path+" request for unknown profile", "profile", name)
+		http.Error(w, "Unknown profile", http.StatusNotFound)
+	}
+	return p, ok
+}
 
-	// Clean up
-	_ = s.sessionManager.RemoveSession(r.Context(), clientSession.ID)
-	log.Printf("📞 Twilio session closed: %s", clientSession.ID)
+// streamURL returns the WebSocket URL of the media stream at path for call,
+// carried by a one-time token when tokens is set and in the query otherwise
+func (s *WebsocketTwilio) streamURL(r *http.Request, path string, tokens *auth.StreamTokens, call auth.StreamCall) (string, error) {
+	wsURL := strings.Replace(s.publicBaseURL(r), "http", "ws", 1) + path
+	if tokens == nil {
+		if call.Profile != "" {
+			wsURL += "?" + url.Values{"profile": {call.Profile}}.Encode()
+		}
+		return wsURL, nil
+	}
+	token, err := tokens.Issue(call)
+	if err != nil {
+		return "", err
+	}
+	return wsURL + "/" + token, nil
 }
 
-func (s *WebsocketTwilio) handleVoiceCall(w http.ResponseWriter, r *http.Request) {
-	wsURL := "wss://" + r.Host + "/stream"
+// readWebhookBody reads the body of a call webhook for its signature check,
+// leaving it in place for ParseForm
+func readWebhookBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
 
-	// TwiML to connect the call to the WebSocket stream
-	xmlResponse := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
-<Response>
-	<Say>Connecting to the assistant now.</Say>
-	<Connect>
-		<Stream url="%s" />
-	</Connect>
-</Response>`, wsURL)
+// validTwilioRequest checks the X-Twilio-Signature of a webhook request
+func (s *WebsocketTwilio) validTwilioRequest(r *http.Request) bool {
+	var params map[string][]string
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			return false
+		}
+		params = r.PostForm
+	}
+	fullURL := s.publicBaseURL(r) + r.URL.RequestURI()
+	return twilio.ValidSignature(s.config.TwilioAuthToken, fullURL, params, r.Header.Get(twilio.SignatureHeader))
+}
 
-	w.Header().Set("Content-Type", "text/xml")
-	_, _ = w.Write([]byte(xmlResponse))
+// publicBaseURL returns the URL providers use to reach this server, taken from
+// PUBLIC_BASE_URL or else the request's (forwarded) host, assuming HTTPS
+func (s *WebsocketTwilio) publicBaseURL(r *http.Request) string {
+	if s.config.PublicBaseURL != "" {
+		return s.config.PublicBaseURL
+	}
+	scheme := "https"
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	host := r.Host
+	if forwarded := r.Header.Get("X-Forwarded-Host"); forwarded != "" {
+		host = forwarded
+	}
+	return scheme + "://" + host
 }
 
 func (s *WebsocketTwilio) handleHealth(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, `{"status":"ok","server":"twilio","sessions":%d}`, s.sessionManager.GetActiveSessionCount())
+	// 503 while draining takes the instance out of the load balancer
+	if s.sessionManager.Draining() {
+		writeJSON(w, http.StatusServiceUnavailable, newHealthStatus("draining", "twilio", s.sessionManager))
+		return
+	}
+	writeJSON(w, http.StatusOK, newHealthStatus("ok", "twilio", s.sessionManager))
+}
+
+// Dialer returns the dialer of outbound calls, nil when TWILIO_ACCOUNT_SID is not set
+func (s *WebsocketTwilio) Dialer() *Dialer {
+	return s.dialer
 }
 
 // GetAddr returns the server's listen address (for logging in main)
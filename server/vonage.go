@@ -0,0 +1,113 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/room4-2/OpenConverse/auth"
+	"github.com/room4-2/OpenConverse/profile"
+	"github.com/room4-2/OpenConverse/vonage"
+
+	"github.com/bytedance/sonic"
+)
+
+// vonageCall holds the fields of Vonage's answer and event webhooks we use.
+// They arrive as query parameters on GET and as a JSON body on POST.
+type vonageCall struct {
+	From   string `json:"from"`
+	UUID   string `json:"uuid"`
+	Status string `json:"status"`
+}
+
+// nccoAction is one action of a Vonage Call Control Object
+type nccoAction struct {
+	Action   string         `json:"action"`
+	Text     string         `json:"text,omitempty"`
+	Endpoint []nccoEndpoint `json:"endpoint,omitempty"`
+}
+
+type nccoEndpoint struct {
+	Type        string            `json:"type"`
+	URI         string            `json:"uri"`
+	ContentType string            `json:"content-type"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+// handleVonageAnswer answers a Vonage answer webhook with an NCCO that
+// connects the call to /vonage/stream as 16kHz linear PCM
+func (s *WebsocketTwilio) handleVonageAnswer(w http.ResponseWriter, r *http.Request) {
+	call, ok := s.vonageWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	p, ok := s.webhookProfile(w, r)
+	if !ok {
+		return
+	}
+	// A failed webhook makes Vonage use the application's fallback answer URL
+	if s.answerBusy(w, "Vonage", p, profile.TwiMLData{Profile: p.Name, From: call.From, CallSid: call.UUID}, false) {
+		return
+	}
+
+	wsURL, err := s.streamURL(r, "/vonage/stream", s.vonageTokens, auth.StreamCall{Caller: call.From, Profile: p.Name})
+	if err != nil {
+		slog.Error("Failed to issue Vonage stream token", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Headers come back in the stream's websocket:connected event
+	var ncco []nccoAction
+	if say := p.ProviderSay(); say != "" {
+		ncco = append(ncco, nccoAction{Action: "talk", Text: say})
+	}
+	ncco = append(ncco, nccoAction{Action: "connect", Endpoint: []nccoEndpoint{{
+		Type:        "websocket",
+		URI:         wsURL,
+		ContentType: "audio/l16;rate=16000",
+		Headers:     map[string]string{"caller": call.From, "uuid": call.UUID},
+	}}})
+	writeJSON(w, http.StatusOK, ncco)
+}
+
+// handleVonageEvent acknowledges Vonage's call status events
+func (s *WebsocketTwilio) handleVonageEvent(w http.ResponseWriter, r *http.Request) {
+	call, ok := s.vonageWebhook(w, r)
+	if !ok {
+		return
+	}
+	slog.Debug("Vonage call event", "uuid", call.UUID, "status", call.Status)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// vonageWebhook verifies a Vonage webhook and decodes its call fields; it
+// writes the error response when ok is false
+func (s *WebsocketTwilio) vonageWebhook(w http.ResponseWriter, r *http.Request) (call vonageCall, ok bool) {
+	body, err := readWebhookBody(w, r)
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return call, false
+	}
+	if secret := s.config.VonageSignatureSecret; secret != "" {
+		if err := vonage.VerifyRequest([]byte(secret), r.Header.Get("Authorization"), body, time.Now()); err != nil {
+			slog.Warn("Rejected "+r.URL.Path+" request with invalid signature", "remote_addr", r.RemoteAddr, "error", err)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return call, false
+		}
+	}
+
+	if r.Method == http.MethodPost && len(body) > 0 {
+		if err := sonic.Unmarshal(body, &call); err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return call, false
+		}
+		return call, true
+	}
+	query := r.URL.Query()
+	call.From = query.Get("from")
+	call.UUID = query.Get("uuid")
+	call.Status = query.Get("status")
+	return call, true
+}
@@ -0,0 +1,367 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/room4-2/OpenConverse/audio"
+	"github.com/room4-2/OpenConverse/config"
+	"github.com/room4-2/OpenConverse/gemini/geminitest"
+	"github.com/room4-2/OpenConverse/messages"
+	"github.com/room4-2/OpenConverse/session"
+	"github.com/room4-2/OpenConverse/store"
+	"github.com/room4-2/OpenConverse/telnyx"
+	"github.com/room4-2/OpenConverse/transport"
+	"github.com/room4-2/OpenConverse/twilio"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+)
+
+// newPhoneServer returns the handler of a phone server configured by cfg
+func newPhoneServer(t *testing.T, cfg *config.Config) http.Handler {
+	t.Helper()
+	handler, _ := newPhoneServerManager(t, cfg)
+	return handler
+}
+
+// newPhoneServerManager returns the handler of a phone server configured by
+// cfg and its session manager
+func newPhoneServerManager(t *testing.T, cfg *config.Config) (http.Handler, *session.Manager) {
+	t.Helper()
+	cfg.MaxSessions = 10
+	cfg.PublicBaseURL = "https://voice.example.com"
+	sm, err := session.NewManager(cfg, store.NewMemory())
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	return NewWebsocketTwilio(cfg, sm).httpServer.Handler, sm
+}
+
+func TestTwilioWebhookSignature(t *testing.T) {
+	const authToken = "twilio-auth-token"
+	handler := newPhoneServer(t, &config.Config{TwilioAuthToken: authToken})
+
+	params := url.Values{"CallSid": {"CA1234567890ABCDE"}, "From": {"+13122010094"}, "To": {"+13122123456"}}
+	post := func(target, signature string, params url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(params.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if signature != "" {
+			req.Header.Set(twilio.SignatureHeader, signature)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := post("/voice", twilio.Signature(authToken, "https://voice.example.com/voice", params), params)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("signed webhook: status %d, body %s", rec.Code, rec.Body)
+	}
+	if !strings.Contains(rec.Body.String(), `url="wss://voice.example.com/stream/`) {
+		t.Errorf("TwiML doesn't stream to a tokened URL:\n%s", rec.Body)
+	}
+	if rec := post("/voice?attempt=2", twilio.Signature(authToken, "https://voice.example.com/voice?attempt=2", params), params); rec.Code != http.StatusOK {
+		t.Errorf("signed webhook with a query string: status %d, body %s", rec.Code, rec.Body)
+	}
+
+	tampered := url.Values{"CallSid": {"CA1234567890ABCDE"}, "From": {"+15550100000"}, "To": {"+13122123456"}}
+	for name, rec := range map[string]*httptest.ResponseRecorder{
+		"unsigned":        post("/voice", "", params),
+		"other token":     post("/voice", twilio.Signature("other-token", "https://voice.example.com/voice", params), params),
+		"other URL":       post("/voice", twilio.Signature(authToken, "https://evil.example/voice", params), params),
+		"tampered params": post("/voice", twilio.Signature(authToken, "https://voice.example.com/voice", params), tampered),
+		"tampered query":  post("/voice?attempt=3", twilio.Signature(authToken, "https://voice.example.com/voice?attempt=2", params), params),
+	} {
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s webhook: status %d, want 403", name, rec.Code)
+		}
+	}
+}
+
+func TestVoiceProfile(t *testing.T) {
+	profiles := filepath.Join(t.TempDir(), "profiles.json")
+	err := os.WriteFile(profiles, []byte(`[{"name": "sales", "system_prompt": "You sell.",
+		"twiml": "<Response><Say>Sales</Say><Connect><Stream url=\"{{.StreamURL}}\" /></Connect></Response>"}]`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const authToken = "twilio-auth-token"
+	handler := newPhoneServer(t, &config.Config{TwilioAuthToken: authToken, AgentProfilesFile: profiles})
+
+	params := url.Values{"CallSid": {"CA1234567890ABCDE"}, "From": {"+13122010094"}}
+	post := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(params.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set(twilio.SignatureHeader, twilio.Signature(authToken, "https://voice.example.com"+target, params))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := post("/voice?profile=sales")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "<Say>Sales</Say>") {
+		t.Errorf("/voice?profile=sales: status %d, want the sales TwiML:\n%s", rec.Code, rec.Body)
+	}
+	if rec := post("/voice"); rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "Sales") {
+		t.Errorf("/voice: status %d, want the default TwiML:\n%s", rec.Code, rec.Body)
+	}
+	if rec := post("/voice?profile=unknown"); rec.Code != http.StatusNotFound {
+		t.Errorf("/voice?profile=unknown: status %d, want 404", rec.Code)
+	}
+}
+
+// Every provider's call webhook turns calls away while the node has no room
+func TestCallWebhooksBusy(t *testing.T) {
+	profiles := filepath.Join(t.TempDir(), "profiles.json")
+	err := os.WriteFile(profiles, []byte(`[{"name": "default", "system_prompt": "You help.",
+		"busy_twiml": "<Response><Dial>+15550001111</Dial></Response>"}]`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, sm := newPhoneServerManager(t, &config.Config{AgentProfilesFile: profiles})
+
+	post := func(target, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	form := "application/x-www-form-urlencoded"
+	calls := map[string]func() *httptest.ResponseRecorder{
+		"twilio": func() *httptest.ResponseRecorder { return post("/voice", form, "CallSid=CA1&From=%2B13122010094") },
+		"telnyx": func() *httptest.ResponseRecorder {
+			return post("/telnyx/voice", form, "CallSid=v3%3Aabc&From=%2B13122010094")
+		},
+		"vonage": func() *httptest.ResponseRecorder {
+			return post("/vonage/answer", "application/json", `{"from":"447700900000","uuid":"aaaa"}`)
+		},
+	}
+
+	for name, call := range calls {
+		if rec := call(); rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "+15550001111") {
+			t.Errorf("%s with room: status %d, body %s", name, rec.Code, rec.Body)
+		}
+	}
+
+	sm.SetDraining(true)
+	for _, name := range []string{"twilio", "telnyx"} {
+		if rec := calls[name](); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "<Dial>+15550001111</Dial>") {
+			t.Errorf("%s without room: status %d, want the busy TwiML:\n%s", name, rec.Code, rec.Body)
+		}
+	}
+	// NCCOs can't take TwiML, so Vonage uses the fallback answer URL
+	if rec := calls["vonage"](); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("vonage without room: status %d, want 503", rec.Code)
+	}
+}
+
+func TestTelnyxWebhookSignature(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := newPhoneServer(t, &config.Config{TelnyxPublicKey: publicKey, TelnyxAPIKey: "telnyx-api-key"})
+
+	body := "CallSid=v3%3Aabc&From=%2B13122010094&To=%2B13122123456"
+	post := func(timestamp, signature string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/telnyx/voice", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set(telnyx.TimestampHeader, timestamp)
+		req.Header.Set(telnyx.SignatureHeader, signature)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	sign := func(key ed25519.PrivateKey, timestamp string) string {
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(key, []byte(timestamp+"|"+body)))
+	}
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	rec := post(now, sign(privateKey, now))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("signed webhook: status %d, body %s", rec.Code, rec.Body)
+	}
+	if !strings.Contains(rec.Body.String(), `url="wss://voice.example.com/telnyx/stream/`) {
+		t.Errorf("TeXML doesn't stream to a tokened URL:\n%s", rec.Body)
+	}
+	// The caller travels inside the signed token, not beside it
+	if strings.Contains(rec.Body.String(), "caller=") {
+		t.Errorf("TeXML passes the caller outside the token:\n%s", rec.Body)
+	}
+
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	for name, rec := range map[string]*httptest.ResponseRecorder{
+		"unsigned":   post("", ""),
+		"other key":  post(now, sign(otherKey, now)),
+		"stale":      post(stale, sign(privateKey, stale)),
+		"wrong time": post(stale, sign(privateKey, now)),
+	} {
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s webhook: status %d, want 403", name, rec.Code)
+		}
+	}
+}
+
+func TestVonageWebhookSignature(t *testing.T) {
+	secret := "vonage-signature-secret"
+	handler := newPhoneServer(t, &config.Config{VonageSignatureSecret: secret})
+
+	body := `{"from":"447700900000","to":"447700900001","uuid":"aaaaaaaa-bbbb-cccc-dddd-0123456789ab"}`
+	sum := sha256.Sum256([]byte(body))
+	sign := func(key string, payloadHash string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"iat":          time.Now().Unix(),
+			"payload_hash": payloadHash,
+		})
+		signed, err := token.SignedString([]byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "Bearer " + signed
+	}
+	post := func(path, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := post("/vonage/answer", sign(secret, hex.EncodeToString(sum[:])))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("signed answer webhook: status %d, body %s", rec.Code, rec.Body)
+	}
+	for _, want := range []string{`"uri":"wss://voice.example.com/vonage/stream/`, `"caller":"447700900000"`} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("NCCO missing %s:\n%s", want, rec.Body)
+		}
+	}
+	if rec := post("/vonage/event", sign(secret, hex.EncodeToString(sum[:]))); rec.Code != http.StatusNoContent {
+		t.Errorf("signed event webhook: status %d, want 204", rec.Code)
+	}
+
+	for name, authorization := range map[string]string{
+		"unsigned":      "",
+		"other secret":  sign("other-secret", hex.EncodeToString(sum[:])),
+		"tampered body": sign(secret, strings.Repeat("0", 64)),
+	} {
+		for _, path := range []string{"/vonage/answer", "/vonage/event"} {
+			if rec := post(path, authorization); rec.Code != http.StatusForbidden {
+				t.Errorf("%s %s: status %d, want 403", name, path, rec.Code)
+			}
+		}
+	}
+}
+
+// Without a provider's secret its media stream can't tell the provider from
+// anyone else, so it is refused unless INSECURE_PHONE_STREAMS opts out
+func TestUnauthenticatedStreams(t *testing.T) {
+	geminitest.NewServer(t)
+	for _, insecure := range []bool{false, true} {
+		handler, sm := newPhoneServerManager(t, &config.Config{GeminiAPIKey: "test-key", InsecurePhoneStreams: insecure})
+		srv := httptest.NewServer(handler)
+		t.Cleanup(srv.Close)
+
+		for _, path := range []string{"/stream", "/telnyx/stream", "/vonage/stream"} {
+			conn, res, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+path+"?profile=default", nil)
+			if !insecure {
+				if err == nil || res == nil || res.StatusCode != http.StatusForbidden {
+					t.Errorf("%s without a secret: %v, want 403", path, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%s with INSECURE_PHONE_STREAMS: %v", path, err)
+			}
+			waitSessions(t, sm, 1)
+			_ = conn.Close()
+			waitSessions(t, sm, 0)
+		}
+	}
+}
+
+// The audio played to a Telnyx caller is encoded in the codec the TeXML
+// declared for it, even when the caller's audio is in another
+func TestTelnyxCodecsMatch(t *testing.T) {
+	handler := newPhoneServer(t, &config.Config{InsecurePhoneStreams: true})
+	req := httptest.NewRequest(http.MethodPost, "/telnyx/voice", strings.NewReader("CallSid=v3%3Aabc&From=%2B13122010094"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	var texml struct {
+		Stream struct {
+			Codec string `xml:"bidirectionalCodec,attr"`
+		} `xml:"Connect>Stream"`
+	}
+	if err := xml.Unmarshal(rec.Body.Bytes(), &texml); err != nil {
+		t.Fatalf("TeXML: %v\n%s", err, rec.Body)
+	}
+	encoders := map[string]func([]byte) []byte{"PCMU": audio.PCM24kToMuLaw8k, "PCMA": audio.PCM24kToALaw8k}
+	encode, ok := encoders[texml.Stream.Codec]
+	if !ok {
+		t.Fatalf("TeXML declares bidirectionalCodec %q, want PCMU or PCMA", texml.Stream.Codec)
+	}
+
+	streams := make(chan *transport.Telnyx, 1)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		streams <- transport.NewTelnyx(conn)
+	}))
+	t.Cleanup(srv.Close)
+	provider, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = provider.Close() })
+	stream := <-streams
+
+	for _, callCodec := range []string{"PCMU", "PCMA"} {
+		start := `{"event":"start","start":{"media_format":{"encoding":"` + callCodec + `","sample_rate":8000}},"stream_id":"s"}`
+		if err := provider.WriteMessage(websocket.TextMessage, []byte(start)); err != nil {
+			t.Fatal(err)
+		}
+		if event, err := stream.Receive(); err != nil || event.Type != transport.EventStart {
+			t.Fatalf("Receive = %+v, %v, want the start event", event, err)
+		}
+
+		pcm := geminitest.PCM(960)
+		if err := stream.Send(messages.NewAudioMessage("s1", base64.StdEncoding.EncodeToString(pcm))); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		_ = provider.SetReadDeadline(time.Now().Add(time.Second))
+		var media struct {
+			Media struct {
+				Payload string `json:"payload"`
+			} `json:"media"`
+		}
+		if err := provider.ReadJSON(&media); err != nil {
+			t.Fatalf("provider read: %v", err)
+		}
+		if got, _ := base64.StdEncoding.DecodeString(media.Media.Payload); !bytes.Equal(got, encode(pcm)) {
+			t.Errorf("call in %s: response audio isn't the declared %s", callCodec, texml.Stream.Codec)
+		}
+	}
+}
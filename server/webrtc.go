@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/room4-2/OpenConverse/auth"
+	"github.com/room4-2/OpenConverse/metrics"
+	"github.com/room4-2/OpenConverse/session"
+	"github.com/room4-2/OpenConverse/transport"
+)
+
+const (
+	// maxOfferSize bounds an SDP offer
+	maxOfferSize = 64 * 1024
+	// iceGatherTimeout bounds gathering our ICE candidates for the answer
+	iceGatherTimeout = 5 * time.Second
+)
+
+// handleWebRTCOffer answers a browser's SDP offer, WHIP-style: the answer
+// comes back with 201 Created and the session's URL in Location, which the
+// browser DELETEs to hang up
+func (s *Server) handleWebRTCOffer(w http.ResponseWriter, r *http.Request) {
+	s.allowCORS(w, r)
+
+	if s.sessionManager.Draining() {
+		metrics.SessionCreateFailures.WithLabelValues("draining").Inc()
+		http.Error(w, "Server is draining", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Authenticate before negotiating so rejected clients never reach Gemini
+	var claims *auth.Claims
+	if s.authenticator != nil {
+		var err error
+		claims, err = auth.Request(s.authenticator, r)
+		if err != nil {
+			slog.Warn("WebRTC authentication failed", "remote_addr", r.RemoteAddr, "error", err)
+			metrics.SessionCreateFailures.WithLabelValues("unauthorized").Inc()
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/sdp") {
+		http.Error(w, "Offer must be application/sdp", http.StatusUnsupportedMediaType)
+		return
+	}
+	offer, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxOfferSize))
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), iceGatherTimeout)
+	defer cancel()
+	peer, answer, err := transport.NewWebRTC(ctx, s.webrtcAPI, string(offer))
+	if err != nil {
+		slog.Warn("WebRTC negotiation failed", "remote_addr", r.RemoteAddr, "error", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	clientSession, err := s.sessionManager.CreateSession(r.Context(), peer, session.ClaimsOptions(claims))
+	if err != nil {
+		slog.Error("Failed to create session", "error", err)
+		_ = peer.Close()
+		switch {
+		case errors.Is(err, session.ErrRateLimited):
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		case errors.Is(err, session.ErrMaxSessions):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		default:
+			http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// The session outlives this request
+	clientSession.Start()
+	clientSession.Logger().Info("WebRTC session started")
+	go func() {
+		<-clientSession.CloseChan
+		_ = s.sessionManager.RemoveSession(context.Background(), clientSession.ID)
+		clientSession.Logger().Info("WebRTC session closed")
+	}()
+
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Location", "/webrtc/"+clientSession.ID)
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write([]byte(answer))
+}
+
+// handleWebRTCDelete hangs up a WebRTC session; its URL is the capability
+func (s *Server) handleWebRTCDelete(w http.ResponseWriter, r *http.Request) {
+	s.allowCORS(w, r)
+
+	clientSession, ok := s.sessionManager.GetSession(r.PathValue("id"))
+	if !ok || clientSession.Transport() != "webrtc" {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	_ = clientSession.Close()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleWebRTCPreflight(w http.ResponseWriter, r *http.Request) {
+	s.allowCORS(w, r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// allowCORS lets browsers on ALLOWED_ORIGINS call /webrtc and read the Location header
+func (s *Server) allowCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
+	for _, allowed := range s.config.AllowedOrigins {
+		if allowed == "*" || allowed == origin {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", "POST, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-API-Key")
+			w.Header().Set("Access-Control-Expose-Headers", "Location")
+			w.Header().Add("Vary", "Origin")
+			return
+		}
+	}
+}
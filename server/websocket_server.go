@@ -2,33 +2,43 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"time"
 
+	"github.com/room4-2/OpenConverse/auth"
 	"github.com/room4-2/OpenConverse/config"
 	"github.com/room4-2/OpenConverse/messages"
+	"github.com/room4-2/OpenConverse/metrics"
 	"github.com/room4-2/OpenConverse/session"
+	"github.com/room4-2/OpenConverse/transport"
 
 	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v4"
 )
 
 type Server struct {
 	httpServer     *http.Server
 	upgrader       websocket.Upgrader
 	sessionManager *session.Manager
+	authenticator  auth.Authenticator // nil leaves /ws and /webrtc open
+	webrtcAPI      *webrtc.API        // Set by Start
 	config         *config.Config
 }
 
-func NewServerWebsocket(cfg *config.Config, sessionManager *session.Manager) *Server {
+func NewServerWebsocket(cfg *config.Config, sessionManager *session.Manager, authenticator auth.Authenticator) *Server {
 	s := &Server{
 		sessionManager: sessionManager,
+		authenticator:  authenticator,
 		config:         cfg,
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:    64 * 1024, // 64KB for audio chunks
 			WriteBufferSize:   64 * 1024, // 64KB for audio chunks
 			EnableCompression: true,
+			// Selected when the client passes its credential in Sec-WebSocket-Protocol
+			Subprotocols: []string{auth.Subprotocol},
 			CheckOrigin: func(r *http.Request) bool {
 				// Check allowed origins
 				origin := r.Header.Get("Origin")
@@ -44,7 +54,12 @@ func NewServerWebsocket(cfg *config.Config, sessionManager *session.Manager) *Se
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/ws", s.handleWebSocket)
+	mux.HandleFunc("POST /webrtc", s.handleWebRTCOffer)
+	mux.HandleFunc("DELETE /webrtc/{id}", s.handleWebRTCDelete)
+	mux.HandleFunc("OPTIONS /webrtc", s.handleWebRTCPreflight)
+	mux.HandleFunc("OPTIONS /webrtc/{id}", s.handleWebRTCPreflight)
 	mux.HandleFunc("/health", s.handleHealth)
+	mux.Handle("/metrics", metrics.Handler())
 
 	s.httpServer = &http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.Port),
@@ -58,52 +73,86 @@ func NewServerWebsocket(cfg *config.Config, sessionManager *session.Manager) *Se
 
 // Start begins listening for connections
 func (s *Server) Start() error {
-	log.Printf("🚀 WebSocket server starting on port %d", s.config.Port)
-	log.Printf("📡 WebSocket endpoint: ws://localhost:%d/ws", s.config.Port)
+	api, err := transport.NewWebRTCAPI(transport.WebRTCConfig{
+		PublicIP: s.config.WebRTCPublicIP,
+		UDPPort:  s.config.WebRTCUDPPort,
+	})
+	if err != nil {
+		return err
+	}
+	s.webrtcAPI = api
+
+	slog.Info("WebSocket server starting", "port", s.config.Port,
+		"endpoint", fmt.Sprintf("ws://localhost:%d/ws", s.config.Port))
 	return s.httpServer.ListenAndServe()
 }
 
 // Shutdown gracefully stops the server
 func (s *Server) Shutdown(ctx context.Context) error {
-	log.Println("🛑 Shutting down server...")
-	s.sessionManager.Shutdown()
+	slog.Info("Shutting down WebSocket server")
 	return s.httpServer.Shutdown(ctx)
 }
 
 func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	// Refuse before upgrading so the client can retry against another instance
+	if s.sessionManager.Draining() {
+		metrics.SessionCreateFailures.WithLabelValues("draining").Inc()
+		http.Error(w, "Server is draining", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Authenticate before upgrading so rejected clients never reach Gemini
+	var claims *auth.Claims
+	if s.authenticator != nil {
+		var err error
+		claims, err = auth.Request(s.authenticator, r)
+		if err != nil {
+			slog.Warn("WebSocket authentication failed", "remote_addr", r.RemoteAddr, "error", err)
+			metrics.SessionCreateFailures.WithLabelValues("unauthorized").Inc()
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
 	// Upgrade HTTP to WebSocket
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("WebSocket upgrade failed: %v", err)
+		slog.Warn("WebSocket upgrade failed", "error", err)
 		return
 	}
 
 	// Create session
-	clientSession, err := s.sessionManager.CreateSession(r.Context(), conn)
+	client := transport.NewWebSocket(conn)
+	clientSession, err := s.sessionManager.CreateSession(r.Context(), client, session.ClaimsOptions(claims))
 	if err != nil {
-		log.Printf("Failed to create session: %v", err)
+		slog.Error("Failed to create session", "error", err)
 		// Send error and close
-		errMsg := messages.NewErrorMessage("", messages.ErrCodeSessionFailed, err.Error())
-		_ = conn.WriteJSON(errMsg)
-		conn.Close()
+		code := messages.ErrCodeSessionFailed
+		if errors.Is(err, session.ErrRateLimited) {
+			code = messages.ErrCodeRateLimited
+		}
+		_ = client.Send(messages.NewErrorMessage("", code, err.Error()))
+		_ = client.Close()
 		return
 	}
 
-	log.Printf("✅ New session created: %s", clientSession.ID)
-
 	// Start session (handles messages in goroutines)
 	clientSession.Start()
+	clientSession.Logger().Info("Session started")
 
 	// Wait for session to close
 	<-clientSession.CloseChan
 
 	// Clean up
 	_ = s.sessionManager.RemoveSession(r.Context(), clientSession.ID)
-	log.Printf("🔌 Session closed: %s", clientSession.ID)
+	clientSession.Logger().Info("Session closed")
 }
 
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, `{"status":"ok","sessions":%d}`, s.sessionManager.GetActiveSessionCount())
+	// 503 while draining takes the instance out of the load balancer
+	if s.sessionManager.Draining() {
+		writeJSON(w, http.StatusServiceUnavailable, newHealthStatus("draining", "", s.sessionManager))
+		return
+	}
+	writeJSON(w, http.StatusOK, newHealthStatus("ok", "", s.sessionManager))
 }
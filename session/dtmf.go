@@ -0,0 +1,231 @@
+package session
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/room4-2/OpenConverse/functions"
+
+	"google.golang.org/genai"
+)
+
+const (
+	// dtmfNotice tells Gemini what the caller typed outside collect_digits
+	dtmfNotice = "[System notice] The caller typed %s on their phone keypad."
+	// collectDigitsTimeout is how long collect_digits waits for the caller's entry
+	collectDigitsTimeout = 30 * time.Second
+)
+
+// keypadTransports deliver the keys pressed by the caller
+var keypadTransports = map[string]bool{"twilio": true, "telnyx": true, "vonage": true, "sip": true}
+
+// digitCollector groups the keys a caller presses into entries. An entry ends
+// with the terminator key, after interDigit without a key or at its maximum
+// length, and is passed to flush from the goroutine that ended it.
+type digitCollector struct {
+	interDigit time.Duration
+	terminator string
+	maxDigits  int
+	flush      func(entry string)
+
+	mu         sync.Mutex
+	digits     []byte
+	limit      int // Length ending the current entry (0 = no limit)
+	timer      *time.Timer
+	generation int // Ignores the timers of entries already ended
+}
+
+func newDigitCollector(interDigit time.Duration, terminator string, maxDigits int, flush func(entry string)) *digitCollector {
+	return &digitCollector{interDigit: interDigit, terminator: terminator, maxDigits: maxDigits, limit: maxDigits, flush: flush}
+}
+
+// press adds a key to the current entry
+func (c *digitCollector) press(digit string) {
+	c.mu.Lock()
+	if digit == c.terminator {
+		entry := c.takeLocked()
+		c.mu.Unlock()
+		if entry != "" {
+			c.flush(entry)
+		}
+		return
+	}
+
+	c.digits = append(c.digits, digit...)
+	if c.limit > 0 && len(c.digits) >= c.limit {
+		entry := c.takeLocked()
+		c.mu.Unlock()
+		c.flush(entry)
+		return
+	}
+
+	c.generation++
+	generation := c.generation
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.timer = time.AfterFunc(c.interDigit, func() {
+		c.mu.Lock()
+		if c.generation != generation {
+			c.mu.Unlock()
+			return
+		}
+		entry := c.takeLocked()
+		c.mu.Unlock()
+		c.flush(entry)
+	})
+	c.mu.Unlock()
+}
+
+// expect discards the current entry and ends the next one at limit keys
+// (0 keeps the configured maximum)
+func (c *digitCollector) expect(limit int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.takeLocked()
+	if limit > 0 {
+		c.limit = limit
+	}
+}
+
+// stop discards the current entry
+func (c *digitCollector) stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.takeLocked()
+}
+
+// takeLocked ends the current entry and returns it. Must be called with c.mu held.
+func (c *digitCollector) takeLocked() string {
+	entry := string(c.digits)
+	c.digits = c.digits[:0]
+	c.limit = c.maxDigits
+	c.generation++
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	return entry
+}
+
+// digitRequest is a collect_digits call waiting for the caller's entry
+type digitRequest struct {
+	call  *genai.FunctionCall
+	name  string
+	timer *time.Timer // Gives up when the caller types nothing
+}
+
+// SetDTMF sets how the keys pressed by the caller are grouped into entries:
+// an entry ends with terminator ("" for none), after interDigit without a
+// key, or at maxDigits keys (0 for no limit)
+func (cs *ClientSession) SetDTMF(interDigit time.Duration, terminator string, maxDigits int) {
+	cs.digits = newDigitCollector(interDigit, terminator, maxDigits, cs.handleDigits)
+}
+
+// collectedEntry returns what the caller typed for the collect_digits call
+// named name; tools read it through functions.CollectedDigits
+func (cs *ClientSession) collectedEntry(name string) (string, bool) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	digits, ok := cs.collectedDigits[name]
+	return digits, ok
+}
+
+// handleDigits answers the pending collect_digits call with an entry, or
+// else tells Gemini what the caller typed
+func (cs *ClientSession) handleDigits(entry string) {
+	cs.mu.Lock()
+	request := cs.digitRequest
+	if request != nil {
+		request.timer.Stop()
+		cs.digitRequest = nil
+		if cs.collectedDigits == nil {
+			cs.collectedDigits = make(map[string]string)
+		}
+		cs.collectedDigits[request.name] = entry
+	}
+	cs.mu.Unlock()
+
+	if request != nil {
+		cs.log.Info("Caller typed digits for collect_digits", "name", request.name, "length", len(entry))
+		cs.answerDigitRequest(request, map[string]any{"status": "entered", "name": request.name, "length": len(entry)})
+		return
+	}
+
+	cs.log.Debug("Caller typed digits", "length", len(entry))
+	if err := cs.GeminiProxy.SendText(fmt.Sprintf(dtmfNotice, entry)); err != nil {
+		cs.log.Error("Failed to send digits to Gemini", "error", err)
+	}
+}
+
+// collectDigits starts a collect_digits call, answered once the caller typed
+// the entry. It returns the response of a call that fails right away.
+func (cs *ClientSession) collectDigits(fc *genai.FunctionCall) map[string]any {
+	if cs.digits == nil || !keypadTransports[cs.Transport()] {
+		return map[string]any{"error": "Keypad input is only available on phone calls"}
+	}
+	name, _ := fc.Args["name"].(string)
+	if name == "" {
+		return map[string]any{"error": "name is required"}
+	}
+	maxDigits := 0
+	if n, ok := fc.Args["max_digits"].(float64); ok && n > 0 {
+		maxDigits = int(n)
+	}
+
+	request := &digitRequest{call: fc, name: name}
+	cs.mu.Lock()
+	if cs.closed || cs.digitRequest != nil {
+		cs.mu.Unlock()
+		return map[string]any{"error": "Already collecting digits"}
+	}
+	cs.digitRequest = request
+	request.timer = time.AfterFunc(collectDigitsTimeout, func() { cs.expireDigitRequest(request) })
+	cs.mu.Unlock()
+
+	// Keys typed before the request aren't part of the entry
+	cs.digits.expect(maxDigits)
+	cs.log.Info("Collecting digits", "name", name, "max_digits", maxDigits)
+	return nil
+}
+
+// expireDigitRequest answers a collect_digits call the caller typed nothing for
+func (cs *ClientSession) expireDigitRequest(request *digitRequest) {
+	cs.mu.Lock()
+	if cs.digitRequest != request {
+		cs.mu.Unlock()
+		return
+	}
+	cs.digitRequest = nil
+	cs.mu.Unlock()
+
+	cs.digits.stop()
+	cs.log.Info("Caller typed no digits for collect_digits", "name", request.name)
+	cs.answerDigitRequest(request, map[string]any{"status": "timeout", "name": request.name})
+}
+
+// cancelDigitRequest drops the pending collect_digits call when Gemini cancels it
+func (cs *ClientSession) cancelDigitRequest(ids []string) {
+	cs.mu.Lock()
+	request := cs.digitRequest
+	if request == nil || !slices.Contains(ids, request.call.ID) {
+		cs.mu.Unlock()
+		return
+	}
+	request.timer.Stop()
+	cs.digitRequest = nil
+	cs.mu.Unlock()
+
+	cs.digits.stop()
+	cs.log.Info("Gemini cancelled collect_digits", "name", request.name)
+}
+
+func (cs *ClientSession) answerDigitRequest(request *digitRequest, response map[string]any) {
+	cs.sendToolResponses([]*genai.FunctionResponse{{
+		ID:       request.call.ID,
+		Name:     functions.CollectDigitsName,
+		Response: response,
+	}})
+}
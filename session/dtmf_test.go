@@ -0,0 +1,107 @@
+package session
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/room4-2/OpenConverse/functions"
+	"github.com/room4-2/OpenConverse/gemini/geminitest"
+	"github.com/room4-2/OpenConverse/profile"
+	"github.com/room4-2/OpenConverse/transport"
+
+	"google.golang.org/genai"
+)
+
+// startKeypadSession starts a session on ft taking the caller's keys, with
+// entries ended by "#"
+func startKeypadSession(t *testing.T, ft *fakeTransport) (*ClientSession, *geminitest.Server) {
+	t.Helper()
+	gm := geminitest.NewServer(t)
+	cs, err := NewClientSession(context.Background(), "test-session", ft, gm.Connect, 1<<20)
+	if err != nil {
+		t.Fatalf("NewClientSession: %v", err)
+	}
+	cs.profile = &profile.Profile{Name: profile.Default}
+	cs.SetDTMF(time.Second, "#", 0)
+	cs.Start()
+	t.Cleanup(func() { _ = cs.Close() })
+
+	ft.waitFor(t, "connected status", isStatus("connected"))
+	return cs, gm
+}
+
+// waitDigitRequest fails the test unless cs waits for a collect_digits entry
+// within a second
+func waitDigitRequest(t *testing.T, cs *ClientSession) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		cs.mu.RLock()
+		waiting := cs.digitRequest != nil
+		cs.mu.RUnlock()
+		if waiting {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("collect_digits call not started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// An entry typed for collect_digits answers the call with its length only,
+// and reaches tools through the tool call context, never Gemini
+func TestCollectDigits(t *testing.T) {
+	ft := newFakeTransport(true)
+	ft.name = "twilio"
+	cs, gm := startKeypadSession(t, ft)
+
+	gm.Send(&genai.LiveServerMessage{ToolCall: &genai.LiveServerToolCall{FunctionCalls: []*genai.FunctionCall{{
+		ID:   "call-1",
+		Name: functions.CollectDigitsName,
+		Args: map[string]any{"name": "pin", "max_digits": float64(4)},
+	}}}})
+	waitDigitRequest(t, cs)
+
+	for _, digit := range []string{"1", "2", "3", "4"} {
+		ft.receive(transport.Event{Type: transport.EventDTMF, Digit: digit})
+	}
+
+	msg := gm.Next()
+	if len(msg.ToolResponses) != 1 {
+		t.Fatalf("Gemini received %+v, want the collect_digits response", msg)
+	}
+	response := msg.ToolResponses[0].Response
+	if response["status"] != "entered" || response["length"] != float64(4) {
+		t.Errorf("response = %v, want status entered and length 4", response)
+	}
+	for _, value := range response {
+		if s, ok := value.(string); ok && strings.Contains(s, "1234") {
+			t.Errorf("response %v contains the digits", response)
+		}
+	}
+
+	if digits, ok := functions.CollectedDigits(cs.toolContext(), "pin"); !ok || digits != "1234" {
+		t.Errorf("CollectedDigits = %q, %v; want 1234", digits, ok)
+	}
+	if _, ok := functions.CollectedDigits(cs.toolContext(), "account"); ok {
+		t.Error("CollectedDigits found an entry never collected")
+	}
+	gm.Idle(100 * time.Millisecond)
+}
+
+// Keys typed outside collect_digits are told to Gemini
+func TestDigitsSentToGemini(t *testing.T) {
+	ft := newFakeTransport(true)
+	ft.name = "twilio"
+	_, gm := startKeypadSession(t, ft)
+
+	for _, digit := range []string{"4", "2", "#"} {
+		ft.receive(transport.Event{Type: transport.EventDTMF, Digit: digit})
+	}
+	if msg := gm.Next(); !strings.Contains(msg.Text, "typed 42 ") {
+		t.Errorf("Gemini received %+v, want the digits typed", msg)
+	}
+}
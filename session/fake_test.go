@@ -0,0 +1,102 @@
+package session
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/room4-2/OpenConverse/gemini"
+	"github.com/room4-2/OpenConverse/messages"
+	"github.com/room4-2/OpenConverse/transport"
+)
+
+// fakeTransport is a client connection driven by the test: events pushed
+// with receive are returned by Receive, and the messages the session sends
+// are collected in sent
+type fakeTransport struct {
+	name      string // Transport name, "fake" unless a test plays a provider
+	streaming bool
+
+	events chan transport.Event
+	sent   chan *messages.ServerMessage
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newFakeTransport(streaming bool) *fakeTransport {
+	return &fakeTransport{
+		name:      "fake",
+		streaming: streaming,
+		events:    make(chan transport.Event, 16),
+		sent:      make(chan *messages.ServerMessage, 64),
+		closed:    make(chan struct{}),
+	}
+}
+
+func (f *fakeTransport) Name() string    { return f.name }
+func (f *fakeTransport) Streaming() bool { return f.streaming }
+
+func (f *fakeTransport) Receive() (transport.Event, error) {
+	select {
+	case event := <-f.events:
+		return event, nil
+	case <-f.closed:
+		return transport.Event{}, io.EOF
+	}
+}
+
+func (f *fakeTransport) Send(msg *messages.ServerMessage) error {
+	select {
+	case f.sent <- msg:
+	case <-f.closed:
+		return io.ErrClosedPipe
+	}
+	return nil
+}
+
+func (f *fakeTransport) Close() error {
+	f.closeOnce.Do(func() { close(f.closed) })
+	return nil
+}
+
+// receive has the next Receive return event
+func (f *fakeTransport) receive(event transport.Event) {
+	f.events <- event
+}
+
+// isClosed reports whether the session closed the connection
+func (f *fakeTransport) isClosed() bool {
+	select {
+	case <-f.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// waitFor returns the first sent message matching match, failing the test
+// if none is sent within a second
+func (f *fakeTransport) waitFor(t *testing.T, what string, match func(*messages.ServerMessage) bool) *messages.ServerMessage {
+	t.Helper()
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case msg := <-f.sent:
+			if match(msg) {
+				return msg
+			}
+		case <-deadline:
+			t.Fatalf("timed out waiting for %s", what)
+			return nil
+		}
+	}
+}
+
+// disconnected is a Connector returning a proxy without a Live session, for
+// tests that never talk to Gemini
+func disconnected(context.Context) (*gemini.Proxy, error) {
+	return &gemini.Proxy{}, nil
+}
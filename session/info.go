@@ -0,0 +1,113 @@
+package session
+
+import (
+	"time"
+
+	"github.com/room4-2/OpenConverse/messages"
+	"github.com/room4-2/OpenConverse/store"
+	"github.com/room4-2/OpenConverse/transport"
+
+	"github.com/bytedance/sonic"
+)
+
+// Info is a point-in-time view of a session for operators
+type Info struct {
+	ID           string                `json:"id"`
+	Transport    string                `json:"transport"`
+	Agent        string                `json:"agent"`
+	Tenant       string                `json:"tenant,omitempty"`
+	Caller       string                `json:"caller,omitempty"`
+	Outbound     bool                  `json:"outbound,omitempty"` // The server placed the call
+	Call         *transport.CallInfo   `json:"call,omitempty"`     // What the provider reported about the call
+	Node         string                `json:"node,omitempty"`     // Instance running the session
+	CreatedAt    time.Time             `json:"createdAt"`
+	AgeSeconds   int64                 `json:"ageSeconds"`
+	LastActivity time.Time             `json:"lastActivity"`
+	Usage        messages.UsagePayload `json:"usage"`
+}
+
+// UsageTotals are the token counters of finished sessions ("prompt_tokens",
+// "total_tokens", "prompt_tokens_audio", ...) per agent profile and per tenant
+type UsageTotals struct {
+	Agents  map[string]map[string]int64 `json:"agents"`
+	Tenants map[string]map[string]int64 `json:"tenants,omitempty"` // nil when tenants aren't configured
+}
+
+// Details is Info plus the session's live transcript and turn latencies.
+// Sessions running on another node have no transcript or turns.
+type Details struct {
+	Info
+	Transcript []TranscriptEntry             `json:"transcript"`
+	Turns      []messages.TurnLatencyPayload `json:"turns"`
+}
+
+// Info returns a snapshot of the session
+func (cs *ClientSession) Info() Info {
+	cs.mu.RLock()
+	caller := cs.Caller
+	call := cs.Call
+	lastActivity := cs.LastActivity
+	cs.mu.RUnlock()
+
+	return Info{
+		ID:           cs.ID,
+		Transport:    cs.Transport(),
+		Agent:        cs.Agent,
+		Tenant:       cs.Tenant,
+		Caller:       caller,
+		Outbound:     cs.Outbound,
+		Call:         call,
+		CreatedAt:    cs.CreatedAt,
+		AgeSeconds:   int64(time.Since(cs.CreatedAt).Seconds()),
+		LastActivity: lastActivity,
+		Usage:        cs.Usage.Snapshot(),
+	}
+}
+
+// Details returns a snapshot of the session with its transcript so far
+func (cs *ClientSession) Details() Details {
+	return Details{
+		Info:       cs.Info(),
+		Transcript: cs.Transcript.Entries(),
+		Turns:      cs.TurnLatencies(),
+	}
+}
+
+// NodeInfo describes an instance sharing the session store
+type NodeInfo struct {
+	ID        string    `json:"id"`
+	StartedAt time.Time `json:"startedAt"`
+	Heartbeat time.Time `json:"heartbeat"`
+	Sessions  int       `json:"sessions"`
+}
+
+// infoFromRecord rebuilds the Info of a session from its store record
+func infoFromRecord(record store.Record) Info {
+	info := Info{
+		ID:           record.ID,
+		Transport:    record.Transport,
+		Agent:        record.Agent,
+		Tenant:       record.Tenant,
+		Caller:       record.Caller,
+		Node:         record.Node,
+		CreatedAt:    record.CreatedAt,
+		LastActivity: record.LastActivity,
+		Usage: messages.UsagePayload{
+			PromptTokens:        record.Counters["prompt_tokens"],
+			ResponseTokens:      record.Counters["response_tokens"],
+			ToolUsePromptTokens: record.Counters["tool_use_prompt_tokens"],
+			ThoughtsTokens:      record.Counters["thoughts_tokens"],
+			TotalTokens:         record.Counters["total_tokens"],
+		},
+	}
+	if record.Call != "" {
+		var call transport.CallInfo
+		if err := sonic.UnmarshalString(record.Call, &call); err == nil {
+			info.Call = &call
+		}
+	}
+	if !info.CreatedAt.IsZero() {
+		info.AgeSeconds = int64(time.Since(info.CreatedAt).Seconds())
+	}
+	return info
+}
@@ -2,54 +2,145 @@ package session
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"sync"
 	"time"
 
+	"github.com/room4-2/OpenConverse/auth"
 	"github.com/room4-2/OpenConverse/config"
 	"github.com/room4-2/OpenConverse/functions"
-
+	"github.com/room4-2/OpenConverse/gemini"
+	"github.com/room4-2/OpenConverse/metrics"
+	"github.com/room4-2/OpenConverse/profile"
+	"github.com/room4-2/OpenConverse/store"
+	"github.com/room4-2/OpenConverse/tenant"
+	"github.com/room4-2/OpenConverse/transport"
+
+	"github.com/bytedance/sonic"
 	"github.com/google/uuid"
-	"github.com/gorilla/websocket"
-	"github.com/redis/go-redis/v9"
 	"google.golang.org/genai"
 )
 
+const (
+	quotaCheckInterval = 15 * time.Second // How often running sessions are checked against tenant quotas
+
+	heartbeatInterval = 10 * time.Second // How often this instance refreshes its sessions in the store
+)
+
+var (
+	// quotaGracePeriod is the time left to wrap up after a tenant exceeds its quota
+	quotaGracePeriod = 30 * time.Second
+
+	// drainPollInterval is how often Drain checks whether sessions have ended
+	drainPollInterval = 500 * time.Millisecond
+)
+
+var (
+	// ErrMaxSessions is returned when the server already runs MaxSessions sessions
+	ErrMaxSessions = errors.New("maximum sessions reached")
+	// ErrRateLimited is returned when the session's tenant is over one of its quotas
+	ErrRateLimited = errors.New("rate limited")
+	// ErrUnknownTenant is returned when a session is created for a tenant that isn't configured
+	ErrUnknownTenant = errors.New("unknown tenant")
+	// ErrUnknownProfile is returned when a session requests an agent profile that isn't configured
+	ErrUnknownProfile = errors.New("unknown agent profile")
+	// ErrDraining is returned when the server is draining and doesn't accept new sessions
+	ErrDraining = errors.New("server is draining")
+	// ErrSessionNotFound is returned when no active session has the requested ID
+	ErrSessionNotFound = errors.New("session not found")
+)
+
 // Manager manages all client sessions
 type Manager struct {
 	sessions  map[string]*ClientSession
 	mu        sync.RWMutex
-	redis     *redis.Client
+	pending   int  // Slots reserved by sessions still connecting to Gemini
+	draining  bool // New sessions are refused while draining
+	stopped   bool // Set by Shutdown; sessions still connecting are closed
+	store     store.SessionStore
+	unstored  map[string]bool // Sessions whose record couldn't be Put; the heartbeat retries them
+	storeMu   sync.Mutex      // Keeps a retried Put from landing after the session's Delete
+	startedAt time.Time
 	config    *config.Config
 	geminiKey string
-}
-
-// NewManager creates a session manager with Redis connection
-func NewManager(cfg *config.Config) (*Manager, error) {
-	var redisClient *redis.Client
 
-	// Try to connect to Redis, but don't fail if unavailable
-	redisClient = redis.NewClient(&redis.Options{
-		Addr:     cfg.RedisURL,
-		Password: cfg.RedisPassword,
-		DB:       0,
-	})
+	profiles *profile.Registry
+	pools    map[string]*gemini.Pool // Pre-warmed Gemini connections by agent profile
+	tenants  *tenant.Registry        // nil when no tenants are configured
+	quotas   *tenant.Tracker
+}
 
-	// Test Redis connection
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// NewManager creates a session manager that records sessions in sessionStore
+func NewManager(cfg *config.Config, sessionStore store.SessionStore) (*Manager, error) {
+	defaultProfile := &profile.Profile{Name: profile.Default, SystemPrompt: DefaultSystemPrompt}
+	profiles, err := profile.NewRegistry(nil, defaultProfile)
+	if cfg.AgentProfilesFile != "" {
+		profiles, err = profile.LoadRegistry(cfg.AgentProfilesFile, defaultProfile)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	if err := redisClient.Ping(ctx).Err(); err != nil {
-		// Redis unavailable, continue without it
-		redisClient = nil
+	var tenants *tenant.Registry
+	if cfg.TenantsFile != "" {
+		tenants, err = tenant.LoadRegistry(cfg.TenantsFile)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return &Manager{
+	sm := &Manager{
 		sessions:  make(map[string]*ClientSession),
-		redis:     redisClient,
+		unstored:  make(map[string]bool),
+		store:     sessionStore,
+		startedAt: time.Now(),
 		config:    cfg,
 		geminiKey: cfg.GeminiAPIKey,
-	}, nil
+		profiles:  profiles,
+		pools:     make(map[string]*gemini.Pool),
+		tenants:   tenants,
+		quotas:    tenant.NewTracker(sessionStore),
+	}
+	sm.startPools()
+
+	return sm, nil
+}
+
+// startPools pre-warms Gemini connections for every profile with a pool size
+func (sm *Manager) startPools() {
+	for _, p := range sm.profiles.All() {
+		size := sm.config.GeminiPoolSize
+		if p.PoolSize != nil {
+			size = *p.PoolSize
+		}
+		if size <= 0 {
+			continue
+		}
+
+		setup := sm.setupOptions(p)
+		pool := gemini.NewPool(p.Name, size, sm.config.GeminiPoolMaxAge, func(ctx context.Context) (*gemini.Proxy, error) {
+			return ConnectGemini(ctx, sm.geminiKey, setup)
+		})
+		pool.Start()
+		sm.pools[p.Name] = pool
+	}
+}
+
+// connector returns how sessions of profile p get their Gemini connection:
+// from the profile's pool when one is ready, otherwise connected on demand
+func (sm *Manager) connector(p *profile.Profile) Connector {
+	return func(ctx context.Context) (*gemini.Proxy, error) {
+		if pool, ok := sm.pools[p.Name]; ok {
+			if proxy := pool.Get(); proxy != nil {
+				metrics.GeminiPoolCheckouts.WithLabelValues(p.Name, "hit").Inc()
+				return proxy, nil
+			}
+			metrics.GeminiPoolCheckouts.WithLabelValues(p.Name, "miss").Inc()
+		}
+		return ConnectGemini(ctx, sm.geminiKey, sm.setupOptions(p))
+	}
 }
 
 func buildTools() []*genai.Tool {
@@ -57,65 +148,340 @@ func buildTools() []*genai.Tool {
 		{
 			FunctionDeclarations: []*genai.FunctionDeclaration{
 				functions.GetCompanyInformationsDocsFunctionDeclaration(),
+				functions.CollectDigitsFunctionDeclaration(),
+				functions.CallInfoFunctionDeclaration(),
 			},
 		},
 	}
 }
 
-// CreateSession creates a new client session
-func (sm *Manager) CreateSession(ctx context.Context, clientConn *websocket.Conn) (*ClientSession, error) {
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
+// setupOptions builds the Gemini Live configuration for a session of agent profile p
+func (sm *Manager) setupOptions(p *profile.Profile) gemini.SetupOptions {
+	opts := gemini.SetupOptions{
+		SystemPrompt: p.SystemPrompt,
+		Tools:        buildTools(),
+		Voice:        p.Voice,
+	}
+	if sm.config.CompressionTriggerTokens > 0 {
+		opts.Compression = &gemini.ContextCompression{
+			TriggerTokens: sm.config.CompressionTriggerTokens,
+			TargetTokens:  sm.config.CompressionTargetTokens,
+		}
+	}
+	return opts
+}
+
+// Profile returns the named agent profile; an empty name selects the default profile
+func (sm *Manager) Profile(name string) (*profile.Profile, bool) {
+	return sm.profiles.Get(name)
+}
+
+// Store returns the session store shared by the instances of the cluster
+func (sm *Manager) Store() store.SessionStore {
+	return sm.store
+}
 
-	if len(sm.sessions) >= sm.config.MaxSessions {
-		return nil, fmt.Errorf("maximum sessions reached")
+// Tenants returns the configured tenants, or nil when tenants are not configured
+func (sm *Manager) Tenants() *tenant.Registry {
+	return sm.tenants
+}
+
+// admitTenant reserves a session slot for tenantID. Must be called with sm.mu held.
+// Returns a nil tenant when sessions of tenantID are not subject to quotas.
+func (sm *Manager) admitTenant(tenantID string) (*tenant.Tenant, error) {
+	if tenantID == "" || sm.tenants == nil {
+		return nil, nil
 	}
 
-	sessionID := uuid.New().String()
+	t, ok := sm.tenants.Get(tenantID)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTenant, tenantID)
+	}
+
+	liveDuration, liveTokens := sm.liveTenantUsage(tenantID)
+	if err := sm.quotas.Acquire(t, liveDuration, liveTokens); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRateLimited, err)
+	}
+	return t, nil
+}
+
+// liveTenantUsage sums the duration and tokens of tenantID's running sessions.
+// Must be called with sm.mu held.
+func (sm *Manager) liveTenantUsage(tenantID string) (time.Duration, int64) {
+	var duration time.Duration
+	var tokens int64
+	for _, session := range sm.sessions {
+		if session.Tenant != tenantID {
+			continue
+		}
+		duration += time.Since(session.CreatedAt)
+		tokens += session.Usage.Snapshot().TotalTokens
+	}
+	return duration, tokens
+}
+
+// countCreateFailure records a failed session creation by reason
+func countCreateFailure(err *error) {
+	if *err == nil {
+		return
+	}
 
-	session, err := NewClientSession(ctx, sessionID, clientConn, sm.geminiKey, DefaultSystemPrompt, sm.config.MaxBufferSize, buildTools())
+	reason := "gemini_connect"
+	switch {
+	case errors.Is(*err, ErrMaxSessions):
+		reason = "max_sessions"
+	case errors.Is(*err, ErrRateLimited):
+		reason = "rate_limited"
+	case errors.Is(*err, ErrUnknownTenant):
+		reason = "unknown_tenant"
+	case errors.Is(*err, ErrUnknownProfile):
+		reason = "unknown_profile"
+	case errors.Is(*err, ErrDraining):
+		reason = "draining"
+	}
+	metrics.SessionCreateFailures.WithLabelValues(reason).Inc()
+}
+
+// reservation is a session slot held while a session's Gemini connection is set up
+type reservation struct {
+	sessionID string
+	tenantID  string
+	tenant    *tenant.Tenant // nil when the session isn't subject to tenant quotas
+}
+
+// reserve claims a session slot for tenantID, on this instance and in the
+// session store, so the Gemini connection (a network round-trip of hundreds
+// of milliseconds) can be set up without holding sm.mu. The slot must be
+// committed or rolled back.
+func (sm *Manager) reserve(ctx context.Context, sessionID, tenantID string) (*reservation, error) {
+	r, err := sm.reserveLocal(sessionID, tenantID)
 	if err != nil {
 		return nil, err
 	}
 
-	sm.storeSession(ctx, sessionID, session)
-	return session, nil
+	limits := store.Limits{Cluster: sm.config.ClusterMaxSessions}
+	if r.tenant != nil {
+		limits.Tenant = r.tenant.MaxConcurrentSessions
+	}
+	err = sm.store.Reserve(ctx, sessionID, tenantID, limits)
+	switch {
+	case errors.Is(err, store.ErrClusterFull):
+		err = fmt.Errorf("%w across the cluster", ErrMaxSessions)
+	case errors.Is(err, store.ErrTenantFull):
+		err = fmt.Errorf("%w: %w across the cluster", ErrRateLimited, tenant.ErrConcurrentSessions)
+	case err != nil:
+		// Fail open: a store outage shouldn't stop calls, local limits still apply
+		sm.storeError("reserve session", err)
+		return r, nil
+	default:
+		return r, nil
+	}
+
+	sm.mu.Lock()
+	sm.pending--
+	sm.mu.Unlock()
+	if r.tenant != nil {
+		_ = sm.quotas.Release(ctx, r.tenant, 0, 0) // Nothing consumed, so nothing to store
+	}
+	return nil, err
 }
 
-// CreateTwilioSession creates a new Twilio voice call session
-func (sm *Manager) CreateTwilioSession(ctx context.Context, clientConn *websocket.Conn) (*ClientSession, error) {
+// reserveLocal claims a slot within this instance's limits
+func (sm *Manager) reserveLocal(sessionID, tenantID string) (*reservation, error) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	if len(sm.sessions) >= sm.config.MaxSessions {
-		return nil, fmt.Errorf("maximum sessions reached")
+	if sm.draining {
+		return nil, ErrDraining
+	}
+	if len(sm.sessions)+sm.pending >= sm.config.MaxSessions {
+		return nil, ErrMaxSessions
+	}
+
+	t, err := sm.admitTenant(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	sm.pending++
+	return &reservation{sessionID: sessionID, tenantID: tenantID, tenant: t}, nil
+}
+
+// commit turns a reserved slot into the connected session. It fails if the
+// manager was shut down while the session was connecting.
+func (sm *Manager) commit(ctx context.Context, r *reservation, session *ClientSession) error {
+	sm.mu.Lock()
+	sm.pending--
+	if sm.stopped {
+		sm.mu.Unlock()
+		sm.releaseReservation(r)
+		session.Close()
+		return ErrDraining
+	}
+	sm.sessions[session.ID] = session
+	sm.mu.Unlock()
+
+	sm.storeSession(ctx, session)
+	return nil
+}
+
+// rollback frees a reserved slot whose session failed to connect
+func (sm *Manager) rollback(r *reservation) {
+	sm.mu.Lock()
+	sm.pending--
+	sm.mu.Unlock()
+
+	sm.releaseReservation(r)
+}
+
+func (sm *Manager) releaseReservation(r *reservation) {
+	if r.tenant != nil {
+		_ = sm.quotas.Release(context.Background(), r.tenant, 0, 0) // Nothing consumed, so nothing to store
+	}
+	if err := sm.store.Release(context.Background(), r.sessionID, r.tenantID); err != nil {
+		sm.storeError("release session", err)
+	}
+}
+
+// storeError counts and logs a failed session store operation. Outages are
+// logged once by the store itself.
+func (sm *Manager) storeError(op string, err error) {
+	metrics.SessionStoreErrors.WithLabelValues(sm.store.Name(), op).Inc()
+	if errors.Is(err, store.ErrUnavailable) {
+		return
+	}
+	slog.Warn("Session store operation failed", "operation", op, "store", sm.store.Name(), "error", err)
+}
+
+// Options describe who a new session serves
+type Options struct {
+	Tenant  string       // Tenant billed for the session ("" when unknown)
+	Profile string       // Agent profile serving the session ("" for the default)
+	Caller  string       // Caller identity, when known before the stream starts
+	Claims  *auth.Claims // Authenticated client claims (nil for anonymous clients)
+
+	Outbound  bool              // The server placed the call, so the assistant speaks first
+	Variables map[string]string // Template variables of an outbound call
+}
+
+// ClaimsOptions returns the options of a session for an authenticated client
+// (claims is nil for anonymous clients)
+func ClaimsOptions(claims *auth.Claims) Options {
+	if claims == nil {
+		return Options{}
+	}
+	return Options{
+		Tenant:  claims.Tenant,
+		Profile: claims.Profile,
+		Caller:  claims.Subject,
+		Claims:  claims,
+	}
+}
+
+// CreateSession creates a new session for a client connected over t
+func (sm *Manager) CreateSession(ctx context.Context, t transport.Transport, opts Options) (_ *ClientSession, err error) {
+	defer countCreateFailure(&err)
+
+	p, ok := sm.profiles.Get(opts.Profile)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProfile, opts.Profile)
 	}
 
 	sessionID := uuid.New().String()
 
-	session, err := NewTwilioClientSession(ctx, sessionID, clientConn, sm.geminiKey, DefaultSystemPrompt, sm.config.MaxBufferSize, buildTools())
+	r, err := sm.reserve(ctx, sessionID, opts.Tenant)
 	if err != nil {
 		return nil, err
 	}
 
-	sm.storeSession(ctx, sessionID, session)
+	session, err := NewClientSession(ctx, sessionID, t, sm.connector(p), sm.config.MaxBufferSize)
+	if err != nil {
+		sm.rollback(r)
+		return nil, err
+	}
+	session.Agent = p.Name
+	session.Tenant = opts.Tenant
+	session.Claims = opts.Claims
+	session.Caller = opts.Caller
+	session.Outbound = opts.Outbound
+	session.Variables = opts.Variables
+	session.profile = p
+	session.SetDurationLimit(sm.config.MaxSessionDuration, sm.config.SessionEndWarning)
+	session.SetDTMF(sm.config.DTMFInterDigitTimeout, sm.config.DTMFTerminator, sm.config.DTMFMaxDigits)
+
+	if err := sm.commit(ctx, r, session); err != nil {
+		return nil, err
+	}
 	return session, nil
 }
 
-// storeSession saves a session to memory and Redis
-func (sm *Manager) storeSession(ctx context.Context, sessionID string, session *ClientSession) {
-	sm.sessions[sessionID] = session
+// storeSession records a committed session in metrics and the session store
+func (sm *Manager) storeSession(ctx context.Context, session *ClientSession) {
+	metrics.ActiveSessions.WithLabelValues(session.Transport(), session.Agent).Inc()
 
-	if sm.redis != nil {
-		sm.redis.HSet(ctx, "session:"+sessionID, map[string]interface{}{
-			"created_at":    session.CreatedAt.Format(time.RFC3339),
-			"last_activity": session.LastActivity.Format(time.RFC3339),
-			"status":        "active",
-			"is_twilio":     session.IsTwilio,
-		})
-		sm.redis.SAdd(ctx, "active_sessions", sessionID)
-		sm.redis.Expire(ctx, "session:"+sessionID, sm.config.SessionTimeout)
+	if err := sm.store.Put(ctx, sm.record(session)); err != nil {
+		sm.storeError("put session", err)
+		sm.mu.Lock()
+		if _, running := sm.sessions[session.ID]; running {
+			sm.unstored[session.ID] = true
+		}
+		sm.mu.Unlock()
+	}
+}
+
+// retryPuts writes the records of running sessions whose Put failed, e.g.
+// during a store outage; Touch only refreshes records that exist
+func (sm *Manager) retryPuts(ctx context.Context) {
+	sm.storeMu.Lock()
+	defer sm.storeMu.Unlock()
+
+	sm.mu.RLock()
+	var sessions []*ClientSession
+	for id := range sm.unstored {
+		if session, ok := sm.sessions[id]; ok {
+			sessions = append(sessions, session)
+		}
+	}
+	sm.mu.RUnlock()
+
+	for _, session := range sessions {
+		if err := sm.store.Put(ctx, sm.record(session)); err != nil {
+			sm.storeError("put session", err)
+			return
+		}
+		sm.mu.Lock()
+		delete(sm.unstored, session.ID)
+		sm.mu.Unlock()
+	}
+}
+
+// record returns the store record of a session running on this instance
+func (sm *Manager) record(session *ClientSession) store.Record {
+	info := session.Info()
+	record := store.Record{
+		ID:           info.ID,
+		Node:         sm.config.NodeID,
+		Transport:    info.Transport,
+		Agent:        info.Agent,
+		Tenant:       info.Tenant,
+		Caller:       info.Caller,
+		Status:       store.StatusActive,
+		CreatedAt:    info.CreatedAt,
+		LastActivity: info.LastActivity,
+		Counters:     usageFields(info.Usage),
 	}
+	if info.Call != nil {
+		record.CallSID = info.Call.CallSID
+		if data, err := sonic.MarshalString(info.Call); err == nil {
+			record.Call = data
+		}
+	}
+	return record
+}
+
+// StoreStatus returns the name of the session store and whether it is reachable
+func (sm *Manager) StoreStatus() (name string, healthy bool) {
+	return sm.store.Name(), sm.store.Healthy()
 }
 
 // GetSession retrieves a session by ID
@@ -127,25 +493,262 @@ func (sm *Manager) GetSession(sessionID string) (*ClientSession, bool) {
 	return session, exists
 }
 
-// RemoveSession cleans up and removes a session
-func (sm *Manager) RemoveSession(ctx context.Context, sessionID string) error {
+// Sessions returns the active sessions
+func (sm *Manager) Sessions() []*ClientSession {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	sessions := make([]*ClientSession, 0, len(sm.sessions))
+	for _, session := range sm.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// sessionInfo returns a snapshot of a session running on this instance
+func (sm *Manager) sessionInfo(session *ClientSession) Info {
+	info := session.Info()
+	info.Node = sm.config.NodeID
+	return info
+}
+
+// ClusterSessions returns the active sessions of every instance sharing the session store
+func (sm *Manager) ClusterSessions(ctx context.Context) ([]Info, error) {
+	local := sm.Sessions()
+	infos := make([]Info, 0, len(local))
+	seen := make(map[string]bool, len(local))
+	for _, session := range local {
+		infos = append(infos, sm.sessionInfo(session))
+		seen[session.ID] = true
+	}
+
+	records, err := sm.store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		// This instance's sessions are listed from memory, which is fresher
+		if !seen[record.ID] {
+			infos = append(infos, infoFromRecord(record))
+		}
+	}
+	return infos, nil
+}
+
+// SessionDetails returns a session running on any instance. Only sessions
+// on this instance include their transcript and turn latencies.
+func (sm *Manager) SessionDetails(ctx context.Context, sessionID string) (Details, error) {
+	if session, exists := sm.GetSession(sessionID); exists {
+		details := session.Details()
+		details.Node = sm.config.NodeID
+		return details, nil
+	}
+
+	record, exists, err := sm.store.Get(ctx, sessionID)
+	if err != nil {
+		return Details{}, err
+	}
+	if !exists {
+		return Details{}, ErrSessionNotFound
+	}
+	return Details{Info: infoFromRecord(record)}, nil
+}
+
+// Nodes returns the instances sharing the session store
+func (sm *Manager) Nodes(ctx context.Context) ([]NodeInfo, error) {
+	nodes, err := sm.store.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]NodeInfo, len(nodes))
+	for i, node := range nodes {
+		infos[i] = NodeInfo{ID: node.ID, StartedAt: node.StartedAt, Heartbeat: node.Heartbeat, Sessions: node.Sessions}
+	}
+	return infos, nil
+}
+
+// TerminateSession ends a session on an operator's request. Sessions on
+// other instances are ended by their own instance through the session store.
+// The session is removed by its connection handler once it has closed.
+func (sm *Manager) TerminateSession(ctx context.Context, sessionID string) error {
+	if sm.terminateLocal(sessionID) {
+		return nil
+	}
+
+	_, exists, err := sm.store.Get(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrSessionNotFound
+	}
+	return sm.store.Terminate(ctx, sessionID)
+}
+
+// terminateLocal ends sessionID if it runs on this instance
+func (sm *Manager) terminateLocal(sessionID string) bool {
+	session, exists := sm.GetSession(sessionID)
+	if !exists {
+		return false
+	}
+
+	session.Logger().Info("Session terminated by operator")
+	session.End("terminated", "Session terminated by operator")
+	return true
+}
+
+// SetDraining starts or stops refusing new sessions; active sessions are not affected
+func (sm *Manager) SetDraining(draining bool) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
+	sm.draining = draining
+}
+
+// Draining returns whether new sessions are being refused
+func (sm *Manager) Draining() bool {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.draining
+}
+
+// Drain refuses new sessions and waits until active ones have ended, timeout
+// has passed or ctx is done. Callers still connected `warning` before the
+// deadline are told goodbye. Remaining sessions are left for Shutdown to close.
+func (sm *Manager) Drain(ctx context.Context, timeout, warning time.Duration) {
+	sm.SetDraining(true)
+
+	deadline := time.Now().Add(timeout)
+	warned := warning <= 0
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		sm.mu.RLock()
+		active := len(sm.sessions) + sm.pending
+		sm.mu.RUnlock()
+
+		if active == 0 {
+			slog.Info("All sessions drained")
+			return
+		}
 
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			slog.Warn("Drain timeout reached, closing remaining sessions", "sessions", active)
+			return
+		}
+
+		if !warned && remaining <= warning {
+			warned = true
+			for _, session := range sm.Sessions() {
+				session.WarnShutdown(remaining.Round(time.Second))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RemoveSession cleans up and removes a session
+func (sm *Manager) RemoveSession(ctx context.Context, sessionID string) error {
+	sm.mu.Lock()
 	session, exists := sm.sessions[sessionID]
+	delete(sm.sessions, sessionID)
+	sm.mu.Unlock()
+
 	if !exists {
 		return nil
 	}
 
+	// Closing waits for queued messages to flush, so keep it outside the lock
 	session.Close()
-	delete(sm.sessions, sessionID)
+	sm.archiveSession(ctx, sessionID, session)
+
+	return nil
+}
+
+// archiveSession adds a finished session's token usage to the per-agent and
+// per-tenant counters of the store and stores its final record
+func (sm *Manager) archiveSession(ctx context.Context, sessionID string, session *ClientSession) {
+	metrics.ActiveSessions.WithLabelValues(session.Transport(), session.Agent).Dec()
+
+	usage := session.Usage.Snapshot()
+	session.Logger().Info("Session usage",
+		"prompt_tokens", usage.PromptTokens, "response_tokens", usage.ResponseTokens,
+		"tool_use_prompt_tokens", usage.ToolUsePromptTokens, "total_tokens", usage.TotalTokens)
 
-	if sm.redis != nil {
-		sm.redis.Del(ctx, "session:"+sessionID)
-		sm.redis.SRem(ctx, "active_sessions", sessionID)
+	if session.Tenant != "" && sm.tenants != nil {
+		if t, ok := sm.tenants.Get(session.Tenant); ok {
+			if err := sm.quotas.Release(ctx, t, time.Since(session.CreatedAt), usage.TotalTokens); err != nil {
+				sm.storeError("add quota counters", err)
+			}
+		}
 	}
 
-	return nil
+	fields := usageFields(usage)
+	record := sm.record(session)
+	record.Status = store.StatusClosed
+	record.EndedAt = time.Now()
+	turns := session.TurnLatencies()
+	for name, value := range latencyFields(turns) {
+		record.Counters[name] = value
+	}
+	for _, turn := range turns {
+		if data, err := sonic.Marshal(turn); err == nil {
+			record.Turns = append(record.Turns, data)
+		}
+	}
+
+	// The session is no longer listed, so no retried Put can follow this Delete
+	sm.mu.Lock()
+	delete(sm.unstored, sessionID)
+	sm.mu.Unlock()
+	sm.storeMu.Lock()
+	err := sm.store.Delete(ctx, record)
+	sm.storeMu.Unlock()
+	if err != nil {
+		sm.storeError("delete session", err)
+	}
+	if err := sm.store.AddCounters(ctx, "usage:agent:"+session.Agent, fields); err != nil {
+		sm.storeError("add usage counters", err)
+	}
+	if session.Tenant != "" {
+		if err := sm.store.AddCounters(ctx, "usage:tenant:"+session.Tenant, fields); err != nil {
+			sm.storeError("add usage counters", err)
+		}
+	}
+}
+
+// UsageTotals returns the token usage of finished sessions, summed across
+// the cluster per agent profile and per tenant
+func (sm *Manager) UsageTotals(ctx context.Context) (UsageTotals, error) {
+	totals := UsageTotals{Agents: make(map[string]map[string]int64)}
+	for _, p := range sm.profiles.All() {
+		counters, err := sm.store.Counters(ctx, "usage:agent:"+p.Name)
+		if err != nil {
+			return UsageTotals{}, err
+		}
+		totals.Agents[p.Name] = counters
+	}
+	if sm.tenants == nil {
+		return totals, nil
+	}
+
+	totals.Tenants = make(map[string]map[string]int64)
+	for _, id := range sm.tenants.IDs() {
+		counters, err := sm.store.Counters(ctx, "usage:tenant:"+id)
+		if err != nil {
+			return UsageTotals{}, err
+		}
+		totals.Tenants[id] = counters
+	}
+	return totals, nil
 }
 
 // GetActiveSessionCount returns current session count
@@ -155,36 +758,151 @@ func (sm *Manager) GetActiveSessionCount() int {
 	return len(sm.sessions)
 }
 
+// HasCapacity reports whether a new session would be admitted by this
+// instance: the manager isn't draining and its sessions, counting those still
+// connecting to Gemini, are short of MaxSessions
+func (sm *Manager) HasCapacity() bool {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return !sm.draining && !sm.stopped && len(sm.sessions)+sm.pending < sm.config.MaxSessions
+}
+
 // CleanupInactiveSessions removes sessions that have been inactive
 func (sm *Manager) CleanupInactiveSessions(ctx context.Context) {
 	sm.mu.Lock()
-	defer sm.mu.Unlock()
-
 	now := time.Now()
+	var inactive []*ClientSession
 	for id, session := range sm.sessions {
-		if now.Sub(session.LastActivity) > sm.config.SessionTimeout {
-			session.Close()
+		session.mu.RLock()
+		lastActivity := session.LastActivity
+		session.mu.RUnlock()
+
+		if now.Sub(lastActivity) > sm.config.SessionTimeout {
+			inactive = append(inactive, session)
 			delete(sm.sessions, id)
+		}
+	}
+	sm.mu.Unlock()
 
-			if sm.redis != nil {
-				sm.redis.Del(ctx, "session:"+id)
-				sm.redis.SRem(ctx, "active_sessions", id)
-			}
+	for _, session := range inactive {
+		session.Close()
+		sm.archiveSession(ctx, session.ID, session)
+	}
+}
+
+// syncQuotas reads the tenants' usage across the cluster from the store
+func (sm *Manager) syncQuotas(ctx context.Context) {
+	if sm.tenants == nil {
+		return
+	}
+	if err := sm.quotas.Sync(ctx, sm.tenants.IDs()); err != nil {
+		sm.storeError("read quota counters", err)
+	}
+}
+
+// EnforceQuotas warns, then ends, running sessions whose tenant has exceeded
+// its minutes or token budget mid-call
+func (sm *Manager) EnforceQuotas() {
+	if sm.tenants == nil {
+		return
+	}
+
+	type tenantSessions struct {
+		sessions []*ClientSession
+		duration time.Duration
+		tokens   int64
+	}
+
+	sm.mu.RLock()
+	byTenant := make(map[string]*tenantSessions)
+	for _, session := range sm.sessions {
+		if session.Tenant == "" {
+			continue
+		}
+		ts, ok := byTenant[session.Tenant]
+		if !ok {
+			ts = &tenantSessions{}
+			byTenant[session.Tenant] = ts
+		}
+		ts.sessions = append(ts.sessions, session)
+		ts.duration += time.Since(session.CreatedAt)
+		ts.tokens += session.Usage.Snapshot().TotalTokens
+	}
+	sm.mu.RUnlock()
+
+	for tenantID, ts := range byTenant {
+		t, ok := sm.tenants.Get(tenantID)
+		if !ok {
+			continue
+		}
+		err := sm.quotas.Exceeded(t, ts.duration, ts.tokens)
+		if err == nil {
+			continue
+		}
+		for _, session := range ts.sessions {
+			session.QuotaExceeded(err, quotaGracePeriod)
 		}
 	}
 }
 
-// StartCleanupRoutine starts periodic cleanup of inactive sessions
+// heartbeat refreshes this instance's sessions in the session store and
+// reaps sessions left behind by instances that stopped heartbeating
+func (sm *Manager) heartbeat(ctx context.Context) {
+	sessions := sm.Sessions()
+	records := make([]store.Record, len(sessions))
+	for i, session := range sessions {
+		records[i] = sm.record(session)
+	}
+	node := store.Node{ID: sm.config.NodeID, StartedAt: sm.startedAt, Sessions: len(sessions)}
+
+	sm.retryPuts(ctx)
+	if err := sm.store.Touch(ctx, node, records); err != nil {
+		sm.storeError("heartbeat", err)
+		return
+	}
+
+	reaped, err := sm.store.Reap(ctx)
+	if err != nil {
+		sm.storeError("reap orphaned sessions", err)
+	}
+	if reaped > 0 {
+		slog.Info("Reaped orphaned sessions", "sessions", reaped)
+	}
+}
+
+// StartCleanupRoutine starts periodic cleanup of inactive sessions,
+// enforcement of tenant quotas and session store heartbeats
 func (sm *Manager) StartCleanupRoutine(ctx context.Context) {
 	ticker := time.NewTicker(1 * time.Minute)
 	defer ticker.Stop()
 
+	quotaTicker := time.NewTicker(quotaCheckInterval)
+	defer quotaTicker.Stop()
+
+	heartbeatTicker := time.NewTicker(heartbeatInterval)
+	defer heartbeatTicker.Stop()
+
+	sm.heartbeat(ctx)
+	sm.syncQuotas(ctx)
+	terminations := sm.store.Terminations(ctx)
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
 			sm.CleanupInactiveSessions(ctx)
+		case <-quotaTicker.C:
+			sm.syncQuotas(ctx)
+			sm.EnforceQuotas()
+		case <-heartbeatTicker.C:
+			sm.heartbeat(ctx)
+		case sessionID, ok := <-terminations:
+			if !ok {
+				terminations = nil
+				continue
+			}
+			go sm.terminateLocal(sessionID)
 		}
 	}
 }
@@ -192,14 +910,35 @@ func (sm *Manager) StartCleanupRoutine(ctx context.Context) {
 // Shutdown closes all sessions
 func (sm *Manager) Shutdown() {
 	sm.mu.Lock()
-	defer sm.mu.Unlock()
+	sm.draining = true
+	sm.stopped = true
+	sessions := sm.sessions
+	sm.sessions = make(map[string]*ClientSession)
+	sm.mu.Unlock()
+
+	for _, pool := range sm.pools {
+		pool.Close()
+	}
 
-	for id, session := range sm.sessions {
-		session.Close()
-		delete(sm.sessions, id)
+	// Close concurrently: each Close waits for the session's queued messages to flush
+	var wg sync.WaitGroup
+	for _, session := range sessions {
+		wg.Add(1)
+		go func(session *ClientSession) {
+			defer wg.Done()
+			session.End("server_shutdown", "Server is shutting down")
+		}(session)
+	}
+	wg.Wait()
+
+	for id, session := range sessions {
+		sm.archiveSession(context.Background(), id, session)
 	}
 
-	if sm.redis != nil {
-		sm.redis.Close()
+	if err := sm.store.Leave(context.Background(), sm.config.NodeID); err != nil {
+		sm.storeError("leave", err)
+	}
+	if err := sm.store.Close(); err != nil {
+		sm.storeError("close", err)
 	}
 }
@@ -0,0 +1,237 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/room4-2/OpenConverse/config"
+	"github.com/room4-2/OpenConverse/gemini/geminitest"
+	"github.com/room4-2/OpenConverse/messages"
+	"github.com/room4-2/OpenConverse/metrics"
+	"github.com/room4-2/OpenConverse/store"
+	"github.com/room4-2/OpenConverse/tenant"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// failingStore is a Memory store whose Reserve fails
+type failingStore struct {
+	*store.Memory
+}
+
+func (failingStore) Name() string { return "failing" }
+
+func (failingStore) Reserve(context.Context, string, string, store.Limits) error {
+	return errors.New("connection reset")
+}
+
+func newTestManager(t *testing.T, s store.SessionStore) *Manager {
+	t.Helper()
+	sm, err := NewManager(&config.Config{MaxSessions: 2, ClusterMaxSessions: 2}, s)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	return sm
+}
+
+func TestReserveFailsOpenAndCounts(t *testing.T) {
+	sm := newTestManager(t, failingStore{store.NewMemory()})
+	failures := metrics.SessionStoreErrors.WithLabelValues("failing", "reserve session")
+	before := testutil.ToFloat64(failures)
+
+	r, err := sm.reserve(context.Background(), "s1", "")
+	if err != nil {
+		t.Fatalf("reserve with a failing store = %v, want the session admitted", err)
+	}
+	if got := testutil.ToFloat64(failures) - before; got != 1 {
+		t.Errorf("store errors counted = %v, want 1", got)
+	}
+	sm.rollback(r)
+}
+
+// Sessions still connecting to Gemini hold their slot
+func TestHasCapacityCountsReservations(t *testing.T) {
+	sm := newTestManager(t, store.NewMemory())
+	ctx := context.Background()
+
+	first, err := sm.reserve(ctx, "s1", "")
+	if err != nil {
+		t.Fatalf("reserve: %v", err)
+	}
+	if !sm.HasCapacity() {
+		t.Fatal("HasCapacity = false with one of two slots reserved")
+	}
+	second, err := sm.reserve(ctx, "s2", "")
+	if err != nil {
+		t.Fatalf("reserve: %v", err)
+	}
+	if sm.HasCapacity() {
+		t.Errorf("HasCapacity = true with both slots reserved and %d sessions", sm.GetActiveSessionCount())
+	}
+
+	sm.rollback(first)
+	if !sm.HasCapacity() {
+		t.Error("HasCapacity = false after a reservation was rolled back")
+	}
+	sm.rollback(second)
+
+	sm.SetDraining(true)
+	if sm.HasCapacity() {
+		t.Error("HasCapacity = true while draining")
+	}
+}
+
+// Running sessions of a tenant that has used up its minutes are warned, then ended
+func TestEnforceQuotas(t *testing.T) {
+	prev := quotaGracePeriod
+	quotaGracePeriod = 100 * time.Millisecond
+	t.Cleanup(func() { quotaGracePeriod = prev })
+
+	sm := newTestManager(t, store.NewMemory())
+	acme := &tenant.Tenant{ID: "acme", MinutesPerDay: 10}
+	tenants, err := tenant.NewRegistry([]*tenant.Tenant{acme, {ID: "globex", MinutesPerDay: 10}})
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+	sm.tenants = tenants
+
+	ft := newFakeTransport(true)
+	over, gm := startSession(t, ft, 1<<20)
+	over.Tenant = "acme"
+	within, _ := startSession(t, newFakeTransport(true), 1<<20)
+	within.Tenant = "globex"
+	sm.mu.Lock()
+	sm.sessions[over.ID] = over
+	sm.sessions["within-quota"] = within
+	sm.mu.Unlock()
+
+	// A session finished on another instance used up acme's minutes for the day
+	if err := tenant.NewTracker(sm.store).Release(context.Background(), acme, 10*time.Minute, 0); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	sm.syncQuotas(context.Background())
+	sm.EnforceQuotas()
+
+	ft.waitFor(t, "quota_warning status", isStatus("quota_warning"))
+	if notice := gm.Next(); !strings.Contains(notice.Text, "calling allowance") {
+		t.Errorf("Gemini was sent %q, want the quota notice", notice.Text)
+	}
+	ft.waitFor(t, "rate limited error", isError(messages.ErrCodeRateLimited))
+	waitClosed(t, over)
+
+	if within.IsClosed() {
+		t.Error("session of a tenant within its quota was closed")
+	}
+}
+
+// drainTestManager returns a manager polling fast while draining, running
+// a session on ft
+func drainTestManager(t *testing.T, ft *fakeTransport) (*Manager, *ClientSession, *geminitest.Server) {
+	t.Helper()
+	prev := drainPollInterval
+	drainPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { drainPollInterval = prev })
+
+	sm := newTestManager(t, store.NewMemory())
+	cs, gm := startSession(t, ft, 1<<20)
+	sm.mu.Lock()
+	sm.sessions[cs.ID] = cs
+	sm.mu.Unlock()
+	return sm, cs, gm
+}
+
+// drain runs Drain in the background, closing the returned channel once it returns
+func drain(sm *Manager, ctx context.Context, timeout, warning time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		sm.Drain(ctx, timeout, warning)
+		close(done)
+	}()
+	return done
+}
+
+// Drain refuses new sessions and returns as soon as the running ones end
+func TestDrainReturnsOnceSessionsEnd(t *testing.T) {
+	ft := newFakeTransport(true)
+	sm, cs, _ := drainTestManager(t, ft)
+
+	done := drain(sm, context.Background(), time.Minute, 0)
+	deadline := time.Now().Add(time.Second)
+	for !sm.Draining() {
+		if time.Now().After(deadline) {
+			t.Fatal("not draining")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, err := sm.reserve(context.Background(), "new-session", ""); !errors.Is(err, ErrDraining) {
+		t.Errorf("reserve while draining = %v, want ErrDraining", err)
+	}
+
+	select {
+	case <-done:
+		t.Fatal("Drain returned with a session running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := sm.RemoveSession(context.Background(), cs.ID); err != nil {
+		t.Fatalf("RemoveSession: %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Drain still waiting after the last session ended")
+	}
+}
+
+// Callers still connected at the warning are told goodbye, and those still
+// connected at the deadline are closed by Shutdown
+func TestDrainTimeout(t *testing.T) {
+	ft := newFakeTransport(true)
+	sm, cs, gm := drainTestManager(t, ft)
+
+	start := time.Now()
+	done := drain(sm, context.Background(), 300*time.Millisecond, 200*time.Millisecond)
+
+	ft.waitFor(t, "server_shutdown warning", isStatus("server_shutdown"))
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("caller warned after %s, before only 200ms were left", elapsed)
+	}
+	if notice := gm.Next(); !strings.Contains(notice.Text, "restarting") {
+		t.Errorf("Gemini was sent %q, want the shutdown notice", notice.Text)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Drain did not return at its deadline")
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("Drain returned after %s, before its 300ms deadline", elapsed)
+	}
+	if cs.IsClosed() {
+		t.Fatal("Drain closed the session, want it left for Shutdown")
+	}
+
+	sm.Shutdown()
+	ft.waitFor(t, "server_shutdown status", isStatus("server_shutdown"))
+	waitClosed(t, cs)
+}
+
+// A second signal (a cancelled ctx) ends the wait at once
+func TestDrainCancelled(t *testing.T) {
+	sm, cs, _ := drainTestManager(t, newFakeTransport(true))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := drain(sm, ctx, time.Minute, 0)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Drain still waiting after its context was cancelled")
+	}
+	if cs.IsClosed() {
+		t.Error("Drain closed the session")
+	}
+}
@@ -3,198 +3,398 @@ package session
 import (
 	"context"
 	"encoding/base64"
-	"encoding/binary"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"sync"
 	"time"
 
-	"github.com/bytedance/sonic"
-
+	"github.com/room4-2/OpenConverse/auth"
 	"github.com/room4-2/OpenConverse/functions"
 	"github.com/room4-2/OpenConverse/gemini"
+	"github.com/room4-2/OpenConverse/logging"
 	"github.com/room4-2/OpenConverse/messages"
+	"github.com/room4-2/OpenConverse/metrics"
+	"github.com/room4-2/OpenConverse/profile"
+	"github.com/room4-2/OpenConverse/tracing"
+	"github.com/room4-2/OpenConverse/transport"
 
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/genai"
 )
 
-var muLawToPcmTable [256]int16
+const (
+	writeBufferSize   = 256
+	closeFlushTimeout = 2 * time.Second // How long Close waits for queued messages to be written
+)
 
+// Notices sent to Gemini as a user turn so the assistant tells the caller the call is about to end
 const (
-	writeBufferSize = 256
-	writeTimeout    = 10 * time.Second
+	sessionEndingNotice = "[System notice] This call will end automatically in %s because it has reached its maximum duration. " +
+		"Briefly and politely let the caller know, and help them wrap up the conversation."
+	quotaExceededNotice = "[System notice] This account has used up its calling allowance, so the call will end in %s. " +
+		"Briefly and politely let the caller know, and help them wrap up the conversation."
+	shutdownNotice = "[System notice] This service is restarting and this call will end in %s. " +
+		"Briefly and politely say goodbye to the caller and invite them to call back shortly."
 )
 
+// Connector opens the Gemini Live connection of a new session
+type Connector func(ctx context.Context) (*gemini.Proxy, error)
+
+// ConnectGemini opens a Live session, recording how long the setup took
+func ConnectGemini(ctx context.Context, geminiKey string, setup gemini.SetupOptions) (*gemini.Proxy, error) {
+	connectStart := time.Now()
+	proxy, err := gemini.NewProxy(ctx, geminiKey)
+	if err != nil {
+		metrics.GeminiConnectSeconds.WithLabelValues("error").Observe(time.Since(connectStart).Seconds())
+		return nil, fmt.Errorf("failed to create Gemini proxy: %w", err)
+	}
+
+	if err := proxy.Setup(ctx, setup); err != nil {
+		metrics.GeminiConnectSeconds.WithLabelValues("error").Observe(time.Since(connectStart).Seconds())
+		proxy.Close()
+		return nil, fmt.Errorf("failed to setup Gemini session: %w", err)
+	}
+	metrics.GeminiConnectSeconds.WithLabelValues("success").Observe(time.Since(connectStart).Seconds())
+	return proxy, nil
+}
+
 // ClientSession represents a single user's connection
 type ClientSession struct {
 	ID           string
-	IsTwilio     bool   // Whether this is a Twilio voice call session
-	StreamSid    string // Twilio stream SID (set on "start" event)
-	ClientConn   *websocket.Conn
+	Agent        string       // Agent profile serving this session
+	Tenant       string       // Tenant billed for this session ("" when unknown)
+	Claims       *auth.Claims // Authenticated client claims (nil for anonymous clients)
 	GeminiProxy  *gemini.Proxy
 	AudioBuffer  *AudioBuffer // Buffer for incoming audio chunks
+	Usage        *Usage       // Gemini token usage accumulated over the session
+	Transcript   *Transcript  // Live transcript of both sides of the conversation
+	Caller       string       // Caller identity: phone number for calls, token subject for clients
+	Called       string       // Number the caller dialed, when known
 	CreatedAt    time.Time
 	LastActivity time.Time
 
+	Outbound  bool              // The server placed the call; the assistant speaks first
+	Variables map[string]string // Template variables of an outbound call
+
+	Call *transport.CallInfo // What the provider reported about the call (nil until the stream starts)
+
+	transport transport.Transport // Client connection and its protocol
+	profile   *profile.Profile    // Agent profile serving the session
+
 	// Use channels for non-blocking writes
-	writeChan chan any
+	writeChan chan *messages.ServerMessage
+	writeDone chan struct{} // Closed when writePump exits
+
+	maxDuration time.Duration // Session is ended once it has been running this long (0 = no limit)
+	endWarning  time.Duration // Caller is warned this long before maxDuration
+	quotaWarned bool          // Whether the caller was already told the tenant's quota is exhausted
+	opened      bool          // Whether the assistant was asked to speak first
+
+	digits          *digitCollector   // Groups the caller's key presses into entries (nil until SetDTMF)
+	digitRequest    *digitRequest     // collect_digits call waiting for an entry
+	collectedDigits map[string]string // Entries typed for collect_digits, by name; never sent to Gemini
+
+	turns turnTracker // Per-turn latency milestones
+
+	log  *slog.Logger // Carries the session ID, tenant, agent and transport
+	span trace.Span   // Root span of the session's trace, ended by Close
 
 	mu        sync.RWMutex
+	started   bool // Whether Start ran; Close only waits on writePump once it did
 	closed    bool
 	CloseChan chan struct{}
 	ctx       context.Context
 	cancel    context.CancelFunc
 }
 
-// NewClientSession creates a session with Gemini connection
-func NewClientSession(ctx context.Context, id string, clientConn *websocket.Conn, geminiKey string, systemPrompt string, maxBufferSize int, tools []*genai.Tool) (*ClientSession, error) {
-	proxy, err := gemini.NewProxy(ctx, geminiKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create Gemini proxy: %w", err)
-	}
+// NewClientSession creates a session for a client connected over t, whose
+// Gemini connection is opened (or checked out of a pool) by connect
+func NewClientSession(ctx context.Context, id string, t transport.Transport, connect Connector, maxBufferSize int) (*ClientSession, error) {
+	// Each session is its own trace, outliving the request that opened it
+	ctx, span := tracing.Tracer().Start(ctx, "session", trace.WithNewRoot(),
+		trace.WithAttributes(attribute.String("session.id", id)))
 
-	if err := proxy.Setup(ctx, systemPrompt, tools); err != nil {
-		proxy.Close()
-		return nil, fmt.Errorf("failed to setup Gemini session: %w", err)
+	proxy, err := connect(ctx)
+	if err != nil {
+		failSpan(span, err)
+		span.End()
+		return nil, err
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-
-	// Configure WebSocket for better performance
-	clientConn.SetReadLimit(512 * 1024) // 512KB max message
-	clientConn.EnableWriteCompression(true)
-	_ = clientConn.SetCompressionLevel(6)
+	ctx, cancel := context.WithCancel(trace.ContextWithSpan(context.Background(), span))
 
 	session := &ClientSession{
 		ID:           id,
-		ClientConn:   clientConn,
 		GeminiProxy:  proxy,
 		AudioBuffer:  NewAudioBuffer(maxBufferSize),
+		Usage:        NewUsage(),
+		Transcript:   NewTranscript(),
 		CreatedAt:    time.Now(),
 		LastActivity: time.Now(),
-		writeChan:    make(chan any, writeBufferSize),
+		transport:    t,
+		writeChan:    make(chan *messages.ServerMessage, writeBufferSize),
+		writeDone:    make(chan struct{}),
 		CloseChan:    make(chan struct{}),
 		ctx:          ctx,
 		cancel:       cancel,
+		log:          slog.With(logging.KeySessionID, id),
+		span:         span,
 	}
+	proxy.Logger = session.log
 
 	return session, nil
 }
 
-// NewTwilioClientSession creates a session for Twilio voice calls
-func NewTwilioClientSession(ctx context.Context, id string, clientConn *websocket.Conn, geminiKey string, systemPrompt string, maxBufferSize int, tools []*genai.Tool) (*ClientSession, error) {
-	session, err := NewClientSession(ctx, id, clientConn, geminiKey, systemPrompt, maxBufferSize, tools)
-	if err != nil {
-		return nil, err
-	}
-	session.IsTwilio = true
+// Transport names the client protocol of the session (e.g. "websocket" or "twilio")
+func (cs *ClientSession) Transport() string {
+	return cs.transport.Name()
+}
 
-	// Twilio doesn't support WebSocket compression
-	clientConn.EnableWriteCompression(false)
+// Logger returns the session's logger
+func (cs *ClientSession) Logger() *slog.Logger {
+	return cs.log
+}
 
-	return session, nil
+// bindAttributes attaches the attributes known once the session is set up to
+// its logger and trace
+func (cs *ClientSession) bindAttributes() {
+	attrs := []any{logging.KeySessionID, cs.ID, logging.KeyTransport, cs.Transport(), logging.KeyAgent, cs.Agent}
+	if cs.Tenant != "" {
+		attrs = append(attrs, logging.KeyTenant, cs.Tenant)
+	}
+	cs.log = slog.With(attrs...)
+	cs.GeminiProxy.Logger = cs.log
+
+	cs.span.SetAttributes(
+		attribute.String("session.transport", cs.Transport()),
+		attribute.String("session.agent", cs.Agent),
+		attribute.String("session.tenant", cs.Tenant),
+	)
 }
 
-// Start begins the bidirectional message handling for standard WebSocket clients
+// Start begins the bidirectional message handling
 func (cs *ClientSession) Start() {
+	cs.mu.Lock()
+	if cs.closed {
+		cs.mu.Unlock()
+		return
+	}
+	cs.started = true
+	cs.mu.Unlock()
+
+	cs.bindAttributes()
 	go cs.writePump()
 	cs.setupGeminiCallbacks()
 	cs.GeminiProxy.StartReceiving(cs.ctx)
 	cs.queueMessage(messages.NewStatusMessage(cs.ID, "connected", "Session established"))
 	go cs.handleClientMessages()
+	go cs.watchDuration()
 }
 
-// StartTwilio begins the bidirectional message handling for Twilio voice calls
-func (cs *ClientSession) StartTwilio() {
-	go cs.writePump()
-	cs.setupTwilioGeminiCallbacks()
-	cs.GeminiProxy.StartReceiving(cs.ctx)
-	go cs.handleClientMessagesFromTwilio()
+// SetDurationLimit caps how long the session may run; the caller is warned
+// `warning` before the limit and the session is then ended gracefully
+func (cs *ClientSession) SetDurationLimit(maxDuration, warning time.Duration) {
+	cs.maxDuration = maxDuration
+	cs.endWarning = warning
+}
+
+// watchDuration enforces maxDuration so a stuck call can't run indefinitely
+func (cs *ClientSession) watchDuration() {
+	if cs.maxDuration <= 0 {
+		return
+	}
+
+	warnAfter := cs.maxDuration - cs.endWarning - time.Since(cs.CreatedAt)
+	if cs.endWarning > 0 && warnAfter > 0 {
+		select {
+		case <-cs.ctx.Done():
+			return
+		case <-time.After(warnAfter):
+			cs.warnSessionEnding(cs.endWarning)
+		}
+	}
+
+	select {
+	case <-cs.ctx.Done():
+		return
+	case <-time.After(cs.maxDuration - time.Since(cs.CreatedAt)):
+		cs.log.Info("Maximum session duration reached, ending session", "max_duration", cs.maxDuration)
+		cs.End("session_expired", "Maximum session duration reached")
+	}
+}
+
+// warnSessionEnding asks Gemini to tell the caller the session ends in `remaining`
+func (cs *ClientSession) warnSessionEnding(remaining time.Duration) {
+	cs.log.Info("Session ending soon, warning caller", "remaining", remaining)
+	cs.warnCaller("session_ending", fmt.Sprintf("Session ends in %s", remaining), fmt.Sprintf(sessionEndingNotice, remaining))
+}
+
+// WarnShutdown asks Gemini to say goodbye because the server stops in `remaining`
+func (cs *ClientSession) WarnShutdown(remaining time.Duration) {
+	cs.log.Info("Server shutting down, warning caller", "remaining", remaining)
+	cs.warnCaller("server_shutdown", fmt.Sprintf("Server shuts down in %s", remaining), fmt.Sprintf(shutdownNotice, remaining))
 }
 
-// setupGeminiCallbacks configures callbacks for standard WebSocket clients
+// QuotaExceeded warns the caller (once) that the tenant is over its quota and
+// ends the session after `grace`
+func (cs *ClientSession) QuotaExceeded(reason error, grace time.Duration) {
+	cs.mu.Lock()
+	if cs.closed || cs.quotaWarned {
+		cs.mu.Unlock()
+		return
+	}
+	cs.quotaWarned = true
+	cs.mu.Unlock()
+
+	cs.log.Warn("Tenant over quota, ending session", "reason", reason, "grace", grace)
+	cs.warnCaller("quota_warning", fmt.Sprintf("%v, session ends in %s", reason, grace), fmt.Sprintf(quotaExceededNotice, grace))
+
+	time.AfterFunc(grace, func() {
+		cs.queueMessage(messages.NewErrorMessage(cs.ID, messages.ErrCodeRateLimited, reason.Error()))
+		cs.Close()
+	})
+}
+
+// warnCaller sends a status to the client and asks Gemini to relay `notice` to the caller
+func (cs *ClientSession) warnCaller(status, message, notice string) {
+	cs.queueMessage(messages.NewStatusMessage(cs.ID, status, message))
+	if err := cs.GeminiProxy.SendText(notice); err != nil {
+		cs.log.Error("Failed to send notice to Gemini", "status", status, "error", err)
+	}
+}
+
+// End notifies the client with a final status and closes the session once
+// queued messages have been flushed
+func (cs *ClientSession) End(status, message string) {
+	cs.queueMessage(messages.NewStatusMessage(cs.ID, status, message))
+	cs.Close()
+}
+
+// setupGeminiCallbacks relays Gemini's responses to the client and tracks the session's turns
 func (cs *ClientSession) setupGeminiCallbacks() {
 	cs.GeminiProxy.OnAudioRaw = func(base64Data string) {
+		cs.turns.responseChunk(time.Now())
+		metrics.AudioBytes.WithLabelValues(cs.Transport(), "out").Add(float64(base64.StdEncoding.DecodedLen(len(base64Data))))
 		cs.queueMessage(messages.NewAudioMessage(cs.ID, base64Data))
 	}
 
 	cs.GeminiProxy.OnText = func(text string) {
+		cs.turns.responseChunk(time.Now())
+		cs.log.Debug("Gemini text", logging.KeyTranscript, text)
 		cs.queueMessage(messages.NewTextMessage(cs.ID, text))
 	}
 
 	cs.GeminiProxy.OnComplete = func() {
-		cs.queueMessage(messages.NewStatusMessage(cs.ID, "turn_complete", ""))
+		cs.queueMessage(messages.NewTurnCompleteMessage(cs.ID, cs.completeTurn()))
 	}
 
 	cs.setupGeminiErrorCallback()
+	cs.setupGeminiUsageCallback()
+	cs.setupGeminiTranscriptionCallbacks()
 
 	cs.GeminiProxy.OnToolCall = func(functionCalls []*genai.FunctionCall) {
 		cs.handleToolCalls(functionCalls)
 	}
+	cs.GeminiProxy.OnToolCallCancellation = cs.cancelDigitRequest
 }
 
-// setupTwilioGeminiCallbacks configures callbacks for Twilio voice call sessions
-func (cs *ClientSession) setupTwilioGeminiCallbacks() {
-	cs.GeminiProxy.OnAudioRaw = func(base64Data string) {
-		cs.mu.RLock()
-		streamSid := cs.StreamSid
-		cs.mu.RUnlock()
+// observeAudioWritten records time-to-first-audio when a response's first audio reaches the client
+func (cs *ClientSession) observeAudioWritten(msg *messages.ServerMessage) {
+	if msg.Type != messages.TypeAudio {
+		return
+	}
+	if latency, ok := cs.turns.audioWritten(time.Now()); ok {
+		metrics.TimeToFirstAudioSeconds.WithLabelValues(cs.Transport()).Observe(latency.Seconds())
+	}
+}
 
-		if streamSid == "" {
-			log.Printf("⚠️ [%s] Received audio from Gemini but no StreamSid set yet", cs.ID[:8])
-			return
-		}
+// completeTurn closes the current turn and records its latencies, returning
+// nil when the turn didn't answer user speech
+func (cs *ClientSession) completeTurn() *messages.TurnLatencyPayload {
+	timings, latency := cs.turns.complete(time.Now())
+	cs.traceTurn(timings, latency)
+	if latency == nil {
+		return nil
+	}
 
-		// Decode Gemini's PCM audio (24kHz, 16-bit, little-endian)
-		pcmData, err := base64.StdEncoding.DecodeString(base64Data)
-		if err != nil {
-			log.Printf("❌ [%s] Failed to decode base64 audio: %v", cs.ID[:8], err)
-			return
+	stages := []struct {
+		name string
+		ms   *int64
+	}{
+		{"audio_sent", latency.AudioSentMs},
+		{"first_response", latency.FirstResponseMs},
+		{"first_audio", latency.FirstAudioMs},
+		{"turn_complete", latency.TurnCompleteMs},
+	}
+	for _, stage := range stages {
+		if stage.ms != nil {
+			metrics.TurnLatencySeconds.WithLabelValues(cs.Transport(), stage.name).Observe(float64(*stage.ms) / 1000)
 		}
+	}
+	return latency
+}
 
-		// Downsample 24kHz -> 8kHz (take every 3rd sample) and convert PCM -> mu-law
-		sampleCount := len(pcmData) / 2
-		muLawData := make([]byte, 0, sampleCount/3+1)
-		for i := 0; i < sampleCount; i += 3 {
-			offset := i * 2
-			if offset+1 >= len(pcmData) {
-				break
-			}
-			sample := int16(binary.LittleEndian.Uint16(pcmData[offset : offset+2]))
-			muLawData = append(muLawData, PcmToMuLawByte(sample))
+// traceTurn records a finished turn as a span with an event per milestone
+func (cs *ClientSession) traceTurn(timings turnTimings, latency *messages.TurnLatencyPayload) {
+	_, span := tracing.Tracer().Start(cs.ctx, "turn", trace.WithTimestamp(timings.start()))
+	if latency != nil {
+		span.SetAttributes(attribute.Int("turn.number", latency.Turn))
+	}
+
+	milestones := []struct {
+		name string
+		at   time.Time
+	}{
+		{"speech_end", timings.speechEnd},
+		{"audio_sent", timings.audioSent},
+		{"first_response", timings.firstResponse},
+		{"first_audio", timings.firstWrite},
+	}
+	for _, milestone := range milestones {
+		if !milestone.at.IsZero() {
+			span.AddEvent(milestone.name, trace.WithTimestamp(milestone.at))
 		}
-
-		// Send mu-law audio back to Twilio as base64
-		encoded := base64.StdEncoding.EncodeToString(muLawData)
-		cs.queueMessage(messages.NewTwilioMessageBack(streamSid, encoded))
 	}
+	span.End(trace.WithTimestamp(timings.complete))
+}
 
-	cs.GeminiProxy.OnText = func(text string) {
-		log.Printf("📝 [%s] Gemini text (Twilio session): %s", cs.ID[:8], text)
-	}
+// TurnLatencies returns the latencies of the session's completed turns
+func (cs *ClientSession) TurnLatencies() []messages.TurnLatencyPayload {
+	return cs.turns.History()
+}
 
-	cs.GeminiProxy.OnComplete = func() {
-		log.Printf("✅ [%s] Gemini turn complete (Twilio session)", cs.ID[:8])
+// setupGeminiUsageCallback accumulates the session's token usage
+func (cs *ClientSession) setupGeminiUsageCallback() {
+	cs.GeminiProxy.OnUsage = func(usage *genai.UsageMetadata) {
+		cs.Usage.Add(usage)
+		countTokens(cs.Agent, cs.Tenant, usage)
 	}
+}
 
-	cs.setupGeminiErrorCallback()
-
-	cs.GeminiProxy.OnToolCall = func(functionCalls []*genai.FunctionCall) {
-		cs.handleToolCalls(functionCalls)
+// setupGeminiTranscriptionCallbacks records both sides of the conversation in the live transcript
+func (cs *ClientSession) setupGeminiTranscriptionCallbacks() {
+	cs.GeminiProxy.OnInputTranscription = func(text string) {
+		cs.log.Debug("User transcription", logging.KeyTranscript, text)
+		cs.Transcript.Add(RoleUser, text, time.Now())
+	}
+	cs.GeminiProxy.OnOutputTranscription = func(text string) {
+		cs.log.Debug("Model transcription", logging.KeyTranscript, text)
+		cs.Transcript.Add(RoleModel, text, time.Now())
 	}
 }
 
-// setupGeminiErrorCallback sets up error handling common to both session types
+// setupGeminiErrorCallback reports Gemini errors and closes the session when Gemini disconnects
 func (cs *ClientSession) setupGeminiErrorCallback() {
 	cs.GeminiProxy.OnError = func(err error) {
-		log.Printf("❌ [%s] Gemini error: %v", cs.ID[:8], err)
-		if !cs.IsTwilio {
-			cs.queueMessage(messages.NewErrorMessage(cs.ID, messages.ErrCodeGeminiError, err.Error()))
-		}
+		cs.log.Error("Gemini error", "error", err)
+		cs.queueMessage(messages.NewErrorMessage(cs.ID, messages.ErrCodeGeminiError, err.Error()))
 		if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) ||
 			websocket.IsUnexpectedCloseError(err) {
-			log.Printf("🔌 [%s] Closing session due to Gemini connection error", cs.ID[:8])
+			cs.log.Info("Closing session due to Gemini connection error")
 			cs.Close()
 		}
 	}
@@ -202,64 +402,88 @@ func (cs *ClientSession) setupGeminiErrorCallback() {
 
 // writePump handles all outgoing messages in a single goroutine
 func (cs *ClientSession) writePump() {
-	defer func() {
-		// Send close message before exiting
-		_ = cs.ClientConn.SetWriteDeadline(time.Now().Add(writeTimeout))
-		_ = cs.ClientConn.WriteMessage(
-			websocket.CloseMessage,
-			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
-		)
-	}()
+	defer close(cs.writeDone)
 
 	for {
 		select {
-		case <-cs.CloseChan:
-			return
 		case msg, ok := <-cs.writeChan:
 			if !ok {
 				// Channel closed, exit gracefully
 				return
 			}
 
-			_ = cs.ClientConn.SetWriteDeadline(time.Now().Add(writeTimeout))
-
-			if err := cs.ClientConn.WriteJSON(msg); err != nil {
+			if !cs.writeBatch(msg) {
 				return
 			}
+		}
+	}
+}
+
+// writeBatch writes msg and whatever else is already queued, as one traced
+// client write. It returns false once the connection or queue is closed.
+func (cs *ClientSession) writeBatch(msg *messages.ServerMessage) bool {
+	_, span := tracing.Tracer().Start(cs.ctx, "client.write")
+	written := 0
+	defer func() {
+		span.SetAttributes(attribute.Int("messages", written))
+		span.End()
+	}()
+
+	if err := cs.transport.Send(msg); err != nil {
+		failSpan(span, err)
+		return false
+	}
+	written++
+	cs.observeAudioWritten(msg)
 
-			n := len(cs.writeChan)
-			for i := 0; i < n; i++ {
-				select {
-				case msg, ok := <-cs.writeChan:
-					if !ok {
-						return
-					}
-					if err := cs.ClientConn.WriteJSON(msg); err != nil {
-						return
-					}
-				default:
-					// No more messages, continue outer loop
-				}
+	n := len(cs.writeChan)
+	for i := 0; i < n; i++ {
+		select {
+		case msg, ok := <-cs.writeChan:
+			if !ok {
+				return false
 			}
+			if err := cs.transport.Send(msg); err != nil {
+				failSpan(span, err)
+				return false
+			}
+			written++
+			cs.observeAudioWritten(msg)
+		default:
+			// No more messages, continue outer loop
 		}
 	}
+	return true
+}
+
+// failSpan marks span as failed with err
+func failSpan(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
 }
 
 // queueMessage adds a message to the write queue (non-blocking)
-func (cs *ClientSession) queueMessage(msg any) {
+func (cs *ClientSession) queueMessage(msg *messages.ServerMessage) {
+	// Hold the read lock while sending so Close can't close writeChan underneath us
 	cs.mu.RLock()
-	closed := cs.closed
-	cs.mu.RUnlock()
-	if closed {
+	if cs.closed {
+		cs.mu.RUnlock()
 		return
 	}
+	queued := false
 	select {
 	case cs.writeChan <- msg:
+		queued = true
+	default:
+		// Queue full, drop message (shouldn't happen with proper sizing)
+		metrics.DroppedMessages.WithLabelValues(cs.Transport()).Inc()
+	}
+	cs.mu.RUnlock()
+
+	if queued {
 		cs.mu.Lock()
 		cs.LastActivity = time.Now()
 		cs.mu.Unlock()
-	default:
-		// Queue full, drop message (shouldn't happen with proper sizing)
 	}
 }
 
@@ -276,13 +500,34 @@ func (cs *ClientSession) Close() error {
 		cs.mu.Unlock()
 		return nil
 	}
+	// Report final token usage while the write queue is still open
+	select {
+	case cs.writeChan <- messages.NewUsageMessage(cs.ID, cs.Usage.Snapshot()):
+	default:
+	}
 	cs.closed = true
+	if cs.digitRequest != nil {
+		cs.digitRequest.timer.Stop()
+		cs.digitRequest = nil
+	}
+	// Close the write channel first so writePump flushes what is queued and exits
+	close(cs.writeChan)
+	started := cs.started
 	cs.mu.Unlock()
 
-	cs.cancel()
+	// A session closed before Start has no writePump to flush the queue
+	if started {
+		select {
+		case <-cs.writeDone:
+		case <-time.After(closeFlushTimeout):
+		}
+	}
 
-	// Close the write channel first to stop writePump
-	close(cs.writeChan)
+	cs.cancel()
+	cs.span.End()
+	if cs.digits != nil {
+		cs.digits.stop()
+	}
 
 	// Signal close (for other goroutines waiting on this)
 	close(cs.CloseChan)
@@ -297,239 +542,159 @@ func (cs *ClientSession) Close() error {
 		cs.GeminiProxy.Close()
 	}
 
-	// Close client connection - don't write close message as writePump is stopped
-	if cs.ClientConn != nil {
-		cs.ClientConn.Close()
-	}
+	// Close client connection once writePump has flushed the queue
+	_ = cs.transport.Close()
 
 	return nil
 }
 
-// handleClientMessagesFromTwilio processes Twilio WebSocket protocol messages.
-// Twilio sends: connected, start, media, stop events.
-// Audio is streamed directly to Gemini (no buffering) — Gemini handles VAD.
-func (cs *ClientSession) handleClientMessagesFromTwilio() {
+// handleClientMessages processes the events decoded by the transport until
+// the client disconnects or the session closes
+func (cs *ClientSession) handleClientMessages() {
 	defer cs.Close()
+
 	for {
-		select {
-		case <-cs.CloseChan:
-			return
-		default:
-			_, message, err := cs.ClientConn.ReadMessage()
-			if err != nil {
-				if !cs.IsClosed() {
-					log.Printf("❌ [%s] Twilio WebSocket read error: %v", cs.ID[:8], err)
-				}
-				return
+		event, err := cs.transport.Receive()
+		if err != nil {
+			if err != io.EOF && !cs.IsClosed() {
+				cs.log.Error("Client read error", "error", err)
 			}
+			return
+		}
 
+		cs.mu.Lock()
+		cs.LastActivity = time.Now()
+		cs.mu.Unlock()
+
+		switch event.Type {
+		case transport.EventAudio:
+			cs.handleAudio(event.Audio)
+
+		case transport.EventStart:
+			// Calls the server placed already know who they're with
 			cs.mu.Lock()
-			cs.LastActivity = time.Now()
+			if event.Caller != "" && !cs.Outbound {
+				cs.Caller = event.Caller
+			}
+			if event.Called != "" && !cs.Outbound {
+				cs.Called = event.Called
+			}
+			if event.Call != nil {
+				cs.Call = event.Call
+			}
 			cs.mu.Unlock()
-
-			var msg map[string]interface{}
-			if err := sonic.Unmarshal(message, &msg); err != nil {
-				log.Printf("⚠️ [%s] Failed to parse Twilio message: %v", cs.ID[:8], err)
-				continue
+			if call := event.Call; call != nil {
+				cs.log.Info("Stream started", "stream_id", event.StreamID, "call_sid", call.CallSID,
+					"account_sid", call.AccountSID, "tracks", call.Tracks, "parameters", len(call.Parameters))
+			} else {
+				cs.log.Info("Stream started", "stream_id", event.StreamID)
 			}
+			cs.open()
 
-			event, ok := msg["event"].(string)
-			if !ok {
-				log.Printf("⚠️ [%s] Twilio message missing 'event' field", cs.ID[:8])
-				continue
-			}
+		case transport.EventEndTurn:
+			// Flush buffered audio and send to Gemini as a batch
+			cs.handleEndTurn()
 
-			switch event {
-			case "connected":
-				log.Printf("📞 [%s] Twilio stream connected", cs.ID[:8])
-
-			case "start":
-				startData, ok := msg["start"].(map[string]interface{})
-				if !ok {
-					log.Printf("⚠️ [%s] Twilio 'start' event missing start data", cs.ID[:8])
-					continue
-				}
-				streamSid, ok := startData["streamSid"].(string)
-				if !ok {
-					log.Printf("⚠️ [%s] Twilio 'start' event missing streamSid", cs.ID[:8])
-					continue
-				}
-				cs.mu.Lock()
-				cs.StreamSid = streamSid
-				cs.mu.Unlock()
-				log.Printf("📞 [%s] Twilio stream started, StreamSid: %s", cs.ID[:8], streamSid)
-
-			case "media":
-				media, ok := msg["media"].(map[string]interface{})
-				if !ok {
-					continue
-				}
-				payloadStr, ok := media["payload"].(string)
-				if !ok {
-					continue
-				}
-
-				// Decode base64 mu-law audio from Twilio
-				muLawData, err := base64.StdEncoding.DecodeString(payloadStr)
-				if err != nil {
-					log.Printf("⚠️ [%s] Failed to decode Twilio audio: %v", cs.ID[:8], err)
-					continue
-				}
-
-				// Convert mu-law (8kHz) -> PCM (8kHz) -> upsample to PCM (16kHz) for Gemini
-				pcmData := muLawToPCMUpsample(muLawData)
-
-				// Stream directly to Gemini (no buffering — Gemini handles VAD)
-				if err := cs.GeminiProxy.SendAudio(pcmData); err != nil {
-					log.Printf("❌ [%s] Failed to send audio to Gemini: %v", cs.ID[:8], err)
-				}
-
-			case "stop":
-				log.Printf("📞 [%s] Twilio stream stopped", cs.ID[:8])
-				return
+		case transport.EventPing:
+			cs.queueMessage(messages.NewStatusMessage(cs.ID, "pong", ""))
 
-			case "mark":
-				// Mark events are informational, ignore
-				log.Printf("📞 [%s] Twilio mark event received", cs.ID[:8])
+		case transport.EventStop:
+			cs.log.Info("Client stopped the stream")
+			return
 
-			default:
-				log.Printf("⚠️ [%s] Unknown Twilio event: %s", cs.ID[:8], event)
+		case transport.EventDTMF:
+			if cs.digits != nil {
+				cs.digits.press(event.Digit)
 			}
+
+		case transport.EventInvalid:
+			cs.log.Warn("Invalid client message", "error", event.Err)
+			cs.queueMessage(messages.NewErrorMessage(cs.ID, messages.ErrCodeInvalidMessage, event.Err.Error()))
 		}
 	}
 }
 
-// muLawToPCMUpsample converts mu-law 8kHz audio to PCM 16kHz (16-bit LE) for Gemini
-func muLawToPCMUpsample(muLawData []byte) []byte {
-	// Each mu-law byte -> 1 PCM sample (8kHz)
-	// Upsample 8kHz -> 16kHz by duplicating each sample
-	// Output: 2 bytes per sample * 2 samples per input byte = 4 bytes per mu-law byte
-	pcmData := make([]byte, len(muLawData)*4)
-	for i, b := range muLawData {
-		pcmVal := muLawToPcmTable[b]
-		sample := make([]byte, 2)
-		binary.LittleEndian.PutUint16(sample, uint16(pcmVal))
-		// Write sample twice (duplicate for 8kHz -> 16kHz upsampling)
-		copy(pcmData[i*4:i*4+2], sample)
-		copy(pcmData[i*4+2:i*4+4], sample)
+// open has the assistant speak first, once the stream started, on calls
+// the server placed and for profiles with a greeting or opening prompt
+func (cs *ClientSession) open() {
+	cs.mu.Lock()
+	if cs.opened {
+		cs.mu.Unlock()
+		return
+	}
+	cs.opened = true
+	data := profile.CallData{Caller: cs.Caller, To: cs.Called, Outbound: cs.Outbound, Variables: cs.Variables}
+	if cs.Call != nil {
+		data.CallSID = cs.Call.CallSID
+		data.Parameters = cs.Call.Parameters
+	}
+	cs.mu.Unlock()
+	if data.Outbound {
+		data.To = data.Caller
 	}
-	return pcmData
-}
-
-func (cs *ClientSession) handleClientMessages() {
-	defer cs.Close()
-
-	for {
-		select {
-		case <-cs.CloseChan:
-			return
-		default:
-			messageType, message, err := cs.ClientConn.ReadMessage()
-			if err != nil {
-				return
-			}
-
-			cs.mu.Lock()
-			cs.LastActivity = time.Now()
-			cs.mu.Unlock()
-
-			// Handle binary messages (raw PCM audio) - buffer instead of sending immediately
-			if messageType == websocket.BinaryMessage {
-				log.Printf("🎤 [%s] Buffering binary audio: %d bytes from client", cs.ID[:8], len(message))
-				if err := cs.AudioBuffer.Append(message); err != nil {
-					cs.queueMessage(messages.NewErrorMessage(cs.ID, messages.ErrCodeBufferFull,
-						fmt.Sprintf("Audio buffer full (max %d bytes)", cs.AudioBuffer.MaxSize())))
-				}
-				continue
-			}
-
-			// Handle text messages (JSON)
-			var clientMsg messages.ClientMessage
-			if err := sonic.Unmarshal(message, &clientMsg); err != nil {
-				cs.queueMessage(messages.NewErrorMessage(cs.ID, messages.ErrCodeInvalidMessage, "Invalid message format"))
-				continue
-			}
 
-			cs.processClientMessage(&clientMsg)
-		}
+	opening, err := cs.profile.Opening(data)
+	if err != nil {
+		cs.log.Error("Failed to render opening prompt", "error", err)
+		return
+	}
+	if opening == "" {
+		return
+	}
+	if err := cs.GeminiProxy.SendText(opening); err != nil {
+		cs.log.Error("Failed to send opening to Gemini", "error", err)
 	}
 }
 
-func (cs *ClientSession) processClientMessage(msg *messages.ClientMessage) {
-	switch msg.Type {
-	case "audio":
-		var payload messages.AudioPayload
-		if err := sonic.Unmarshal(msg.Payload, &payload); err != nil {
-			cs.queueMessage(messages.NewErrorMessage(cs.ID, messages.ErrCodeInvalidMessage, "Invalid audio payload"))
-			return
-		}
-		// Decode base64 and buffer the audio
-		audioBytes, err := base64.StdEncoding.DecodeString(payload.Data)
-		if err != nil {
-			cs.queueMessage(messages.NewErrorMessage(cs.ID, messages.ErrCodeInvalidMessage, "Invalid base64 audio data"))
-			return
-		}
-		log.Printf("🎤 [%s] Buffering JSON audio: %d bytes from client", cs.ID[:8], len(audioBytes))
-		if err := cs.AudioBuffer.Append(audioBytes); err != nil {
+// handleAudio streams caller audio straight to Gemini, or buffers it until
+// the end of the turn when the transport isn't streaming
+func (cs *ClientSession) handleAudio(pcm []byte) {
+	metrics.AudioBytes.WithLabelValues(cs.Transport(), "in").Add(float64(len(pcm)))
+
+	if !cs.transport.Streaming() {
+		cs.log.Debug("Buffering audio from client", "bytes", len(pcm))
+		if err := cs.AudioBuffer.Append(pcm); err != nil {
 			cs.queueMessage(messages.NewErrorMessage(cs.ID, messages.ErrCodeBufferFull,
 				fmt.Sprintf("Audio buffer full (max %d bytes)", cs.AudioBuffer.MaxSize())))
 		}
+		return
+	}
 
-	case "audio_binary":
-		// Handle binary audio (more efficient)
-		var payload messages.AudioPayload
-		if err := sonic.Unmarshal(msg.Payload, &payload); err != nil {
-			return
-		}
-		// Decode and buffer
-		audioBytes, err := base64.StdEncoding.DecodeString(payload.Data)
-		if err != nil {
-			return
-		}
-		_ = cs.AudioBuffer.Append(audioBytes)
-
-	case "control":
-		var payload messages.ControlPayload
-		if err := sonic.Unmarshal(msg.Payload, &payload); err != nil {
-			cs.queueMessage(messages.NewErrorMessage(cs.ID, messages.ErrCodeInvalidMessage, "Invalid control payload"))
-			return
-		}
-		cs.handleControlMessage(&payload)
-
-	default:
-		cs.queueMessage(messages.NewErrorMessage(cs.ID, messages.ErrCodeInvalidMessage, "Unknown message type: "+msg.Type))
+	// Gemini does its own VAD; ours only times the end of the caller's speech
+	voiced := pcmIsSpeech(pcm)
+	if voiced {
+		cs.turns.speech(time.Now())
 	}
-}
 
-func (cs *ClientSession) handleControlMessage(payload *messages.ControlPayload) {
-	switch payload.Action {
-	case "ping":
-		cs.queueMessage(messages.NewStatusMessage(cs.ID, "pong", ""))
-	case "end_turn":
-		// Flush buffered audio and send to Gemini as a batch
-		cs.handleEndTurn()
-	default:
-		cs.queueMessage(messages.NewErrorMessage(cs.ID, messages.ErrCodeInvalidMessage, "Unknown control action: "+payload.Action))
+	if err := cs.GeminiProxy.SendAudio(pcm); err != nil {
+		cs.log.Error("Failed to send audio to Gemini", "error", err)
+	} else if voiced {
+		cs.turns.audioSent(time.Now())
 	}
 }
 
 // handleEndTurn flushes the audio buffer and sends to Gemini
 func (cs *ClientSession) handleEndTurn() {
 	if cs.AudioBuffer.IsEmpty() {
-		log.Printf("⚠️ [%s] end_turn received but buffer is empty, ignoring", cs.ID[:8])
+		cs.log.Warn("end_turn received but buffer is empty, ignoring")
 		return
 	}
 	// Get chunk count before flushing (Flush clears the buffer)
 	chunkCount := cs.AudioBuffer.ChunkCount()
 
+	// The user finished speaking: the response latency clock starts now
+	cs.turns.speech(time.Now())
+
 	// Flush all buffered audio
 	audioData := cs.AudioBuffer.Flush()
-	log.Printf("📤 [%s] Sending batch audio to Gemini: %d bytes (%d chunks)", cs.ID[:8], len(audioData), chunkCount)
+	cs.log.Debug("Sending batch audio to Gemini", "bytes", len(audioData), "chunks", chunkCount)
 
 	if err := cs.GeminiProxy.SendAudioBatch(audioData); err != nil {
-		log.Printf("❌ [%s] Failed to send audio to Gemini: %v", cs.ID[:8], err)
+		cs.log.Error("Failed to send audio to Gemini", "error", err)
 		cs.queueMessage(messages.NewErrorMessage(cs.ID, messages.ErrCodeGeminiError, err.Error()))
+		return
 	}
+	cs.turns.audioSent(time.Now())
 }
 
 // IsClosed returns whether the session is closed
@@ -539,27 +704,56 @@ func (cs *ClientSession) IsClosed() bool {
 	return cs.closed
 }
 
+// callInfo answers get_call_info with what is known about the call
+func (cs *ClientSession) callInfo() map[string]any {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	info := map[string]any{
+		"transport": cs.Transport(),
+		"profile":   cs.Agent,
+		"caller":    cs.Caller,
+		"outbound":  cs.Outbound,
+	}
+	if cs.Called != "" {
+		info["called"] = cs.Called
+	}
+	if len(cs.Variables) > 0 {
+		info["variables"] = cs.Variables
+	}
+	if cs.Call != nil {
+		info["call_sid"] = cs.Call.CallSID
+		if len(cs.Call.Parameters) > 0 {
+			info["parameters"] = cs.Call.Parameters
+		}
+	}
+	return info
+}
+
 // handleToolCalls processes function calls from Gemini and sends responses
 func (cs *ClientSession) handleToolCalls(functionCalls []*genai.FunctionCall) {
 	var responses []*genai.FunctionResponse
 
 	for _, fc := range functionCalls {
-		log.Printf("🔧 [%s] Function call: %s (id: %s)", cs.ID[:8], fc.Name, fc.ID)
+		cs.log.Info("Function call", "tool", fc.Name, "call_id", fc.ID)
 
-		var response map[string]any
+		start := time.Now()
+		ctx, span := tracing.Tracer().Start(cs.toolContext(), "tool.call",
+			trace.WithAttributes(attribute.String("tool.name", fc.Name), attribute.String("tool.call_id", fc.ID)))
 
-		switch fc.Name {
-		// Documentation function
-		case "GetCompanyInformationsDocs":
-			docs := functions.GetCompanyInformationsDocs()
-			response = map[string]any{"output": docs}
-			log.Printf("🔧 [%s] Returning company docs (%d chars)", cs.ID[:8], len(docs))
+		response, toolLabel := cs.callTool(ctx, fc)
 
-		default:
-			response = map[string]any{"error": fmt.Sprintf("Unknown function: %s", fc.Name)}
-			log.Printf("⚠️ [%s] Unknown function called: %s", cs.ID[:8], fc.Name)
+		metrics.ToolCalls.WithLabelValues(toolLabel).Inc()
+		metrics.ToolCallSeconds.WithLabelValues(toolLabel).Observe(time.Since(start).Seconds())
+		if toolErr, failed := response["error"]; failed {
+			metrics.ToolCallErrors.WithLabelValues(toolLabel).Inc()
+			span.SetStatus(codes.Error, fmt.Sprint(toolErr))
 		}
+		span.End()
 
+		if response == nil {
+			continue
+		}
 		responses = append(responses, &genai.FunctionResponse{
 			ID:       fc.ID,
 			Name:     fc.Name,
@@ -567,93 +761,45 @@ func (cs *ClientSession) handleToolCalls(functionCalls []*genai.FunctionCall) {
 		})
 	}
 
-	// Send all responses back to Gemini
-	if err := cs.GeminiProxy.SendToolResponse(responses); err != nil {
-		log.Printf("❌ [%s] Failed to send tool response: %v", cs.ID[:8], err)
-		if !cs.IsTwilio {
-			cs.queueMessage(messages.NewErrorMessage(cs.ID, messages.ErrCodeGeminiError, err.Error()))
-		}
-	}
-}
-
-func (cs *ClientSession) MuLawByteToPCMBytes(b byte) []byte {
-	pcmVal := muLawToPcmTable[b]
-	res := make([]byte, 2)
-	binary.LittleEndian.PutUint16(res, uint16(pcmVal))
-	return res
-}
-
-func init() {
-	for i := 0; i < 256; i++ {
-		muLawToPcmTable[i] = decodeMuLawByte(byte(i))
+	if len(responses) > 0 {
+		cs.sendToolResponses(responses)
 	}
 }
 
-// The Core Algorithm
-// This logic is based on the Sun Microsystems G.711 reference implementation.
-// ========================================================================
-func decodeMuLawByte(uVal byte) int16 {
-	// 1. Toggle bits (Mu-law definition requires inverting bits before processing)
-	uVal = ^uVal
-
-	// 2. Extract components
-	// Sign bit (Mask 0x80)
-	// Exponent (Mask 0x70)
-	// Mantissa (Mask 0x0F)
-	sign := uVal & 0x80
-	exponent := (uVal >> 4) & 0x07
-	mantissa := uVal & 0x0F
-
-	// 3. Calculate sample location
-	// The geometric bias for mu-law is 33 (0x21).
-	// We shift the mantissa to align it, add the bias (132 or 0x84 due to alignment),
-	// and then shift by the exponent.
-	sample := int16((int32(mantissa)<<3 + 0x84) << exponent)
-
-	// 4. Subtract the bias back out
-	sample -= 0x84
-
-	// 5. Apply the sign
-	if sign != 0 {
-		return -sample
-	}
-	return sample
+// toolContext is the context tools run in: the session's, carrying the
+// caller's collect_digits entries
+func (cs *ClientSession) toolContext() context.Context {
+	return functions.WithCollectedDigits(cs.ctx, cs.collectedEntry)
 }
 
-func PcmToMuLawByte(pcm int16) byte {
-	const (
-		bias = 0x84 // 132
-		clip = 32635
-	)
-
-	// 1. Get the sign bit
-	sign := (pcm >> 8) & 0x80
+// callTool runs a function call and returns its response, nil when it is
+// answered later, and the tool's metric label. Tools reading what the caller
+// typed get it from ctx with functions.CollectedDigits
+func (cs *ClientSession) callTool(ctx context.Context, fc *genai.FunctionCall) (map[string]any, string) {
+	switch fc.Name {
+	// Documentation function
+	case "GetCompanyInformationsDocs":
+		docs := functions.GetCompanyInformationsDocs()
+		cs.log.Debug("Returning company docs", "chars", len(docs))
+		return map[string]any{"output": docs}, fc.Name
 
-	// 2. Magnitude (absolute value)
-	if pcm < 0 {
-		pcm = -pcm
-	}
+	// Answered once the caller has typed the entry
+	case functions.CollectDigitsName:
+		return cs.collectDigits(fc), fc.Name
 
-	// 3. Clip the magnitude
-	if pcm > clip {
-		pcm = clip
+	case functions.CallInfoName:
+		return cs.callInfo(), fc.Name
 	}
 
-	// 4. Add bias
-	pcm += bias
+	cs.log.Warn("Unknown function called", "tool", fc.Name)
+	// Keep metric label cardinality bounded
+	return map[string]any{"error": fmt.Sprintf("Unknown function: %s", fc.Name)}, "unknown"
+}
 
-	// 5. Calculate the exponent and mantissa
-	exponent := 7
-	// Move the exponent down until we find the highest bit
-	for mask := 0x4000; (pcm&int16(mask)) == 0 && exponent > 0; mask >>= 1 {
-		exponent--
+// sendToolResponses sends the results of tool calls back to Gemini
+func (cs *ClientSession) sendToolResponses(responses []*genai.FunctionResponse) {
+	if err := cs.GeminiProxy.SendToolResponse(responses); err != nil {
+		cs.log.Error("Failed to send tool response", "error", err)
+		cs.queueMessage(messages.NewErrorMessage(cs.ID, messages.ErrCodeGeminiError, err.Error()))
 	}
-
-	mantissa := (pcm >> (exponent + 3)) & 0x0F
-
-	// 6. Assemble the byte
-	ulawByte := byte(sign | (int16(exponent) << 4) | mantissa)
-
-	// 7. Invert bits (compressed format requirement)
-	return ^ulawByte
 }
@@ -0,0 +1,380 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/room4-2/OpenConverse/functions"
+	"github.com/room4-2/OpenConverse/gemini/geminitest"
+	"github.com/room4-2/OpenConverse/messages"
+	"github.com/room4-2/OpenConverse/profile"
+	"github.com/room4-2/OpenConverse/transport"
+
+	"google.golang.org/genai"
+)
+
+func newTestSession(t *testing.T, ft *fakeTransport) *ClientSession {
+	t.Helper()
+	cs, err := NewClientSession(context.Background(), "test-session", ft, disconnected, 1<<20)
+	if err != nil {
+		t.Fatalf("NewClientSession: %v", err)
+	}
+	return cs
+}
+
+// startSession starts a session on ft connected to a fake Gemini, with
+// room for maxBuffer bytes of buffered audio
+func startSession(t *testing.T, ft *fakeTransport, maxBuffer int) (*ClientSession, *geminitest.Server) {
+	t.Helper()
+	gm := geminitest.NewServer(t)
+	cs, err := NewClientSession(context.Background(), "test-session", ft, gm.Connect, maxBuffer)
+	if err != nil {
+		t.Fatalf("NewClientSession: %v", err)
+	}
+	cs.profile = &profile.Profile{Name: profile.Default}
+	cs.Start()
+	t.Cleanup(func() { _ = cs.Close() })
+
+	ft.waitFor(t, "connected status", isStatus("connected"))
+	return cs, gm
+}
+
+func isStatus(status string) func(*messages.ServerMessage) bool {
+	return func(msg *messages.ServerMessage) bool {
+		payload, ok := msg.Payload.(messages.StatusPayload)
+		return msg.Type == messages.TypeStatus && ok && payload.Status == status
+	}
+}
+
+func isError(code string) func(*messages.ServerMessage) bool {
+	return func(msg *messages.ServerMessage) bool {
+		payload, ok := msg.Payload.(messages.ErrorPayload)
+		return msg.Type == messages.TypeError && ok && payload.Code == code
+	}
+}
+
+// waitClosed fails the test unless cs closes within a second
+func waitClosed(t *testing.T, cs *ClientSession) {
+	t.Helper()
+	select {
+	case <-cs.CloseChan:
+	case <-time.After(time.Second):
+		t.Fatal("session did not close")
+	}
+}
+
+func TestCloseBeforeStart(t *testing.T) {
+	ft := newFakeTransport(false)
+	cs := newTestSession(t, ft)
+
+	start := time.Now()
+	if err := cs.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= closeFlushTimeout/2 {
+		t.Errorf("Close of an un-started session took %v, want no flush wait", elapsed)
+	}
+
+	select {
+	case <-cs.CloseChan:
+	default:
+		t.Error("CloseChan not closed")
+	}
+	if !ft.isClosed() {
+		t.Error("transport not closed")
+	}
+
+	// Starting a closed session must not revive it
+	cs.Start()
+	if !cs.IsClosed() {
+		t.Error("session reopened by Start")
+	}
+}
+
+func TestEventStartRecordsCall(t *testing.T) {
+	ft := newFakeTransport(true)
+	cs, _ := startSession(t, ft, 1<<20)
+
+	call := &transport.CallInfo{CallSID: "CA123", StreamSID: "MZ456", Parameters: map[string]string{"order": "42"}}
+	ft.receive(transport.Event{Type: transport.EventStart, StreamID: "MZ456", Caller: "+15550100", Called: "+15550199", Call: call})
+	ft.receive(transport.Event{Type: transport.EventPing})
+	ft.waitFor(t, "pong", isStatus("pong"))
+
+	info := cs.Info()
+	if info.Caller != "+15550100" || info.Call == nil || info.Call.CallSID != "CA123" {
+		t.Errorf("Info after EventStart = %+v", info)
+	}
+	if got := cs.callInfo()["called"]; got != "+15550199" {
+		t.Errorf("called = %v, want +15550199", got)
+	}
+}
+
+func TestEventStartKeepsOutboundCaller(t *testing.T) {
+	ft := newFakeTransport(true)
+	cs, _ := startSession(t, ft, 1<<20)
+	cs.mu.Lock()
+	cs.Outbound = true
+	cs.Caller = "+15550123"
+	cs.mu.Unlock()
+
+	ft.receive(transport.Event{Type: transport.EventStart, Caller: "+15550100"})
+	ft.receive(transport.Event{Type: transport.EventPing})
+	ft.waitFor(t, "pong", isStatus("pong"))
+
+	if caller := cs.Info().Caller; caller != "+15550123" {
+		t.Errorf("caller = %q, want the number the server dialed", caller)
+	}
+}
+
+func TestBufferedAudioSentOnEndTurn(t *testing.T) {
+	ft := newFakeTransport(false)
+	_, gm := startSession(t, ft, 1<<20)
+
+	first, second := geminitest.PCM(640), geminitest.PCM(320)
+	ft.receive(transport.Event{Type: transport.EventAudio, Audio: first})
+	ft.receive(transport.Event{Type: transport.EventAudio, Audio: second})
+	gm.Idle(50 * time.Millisecond)
+
+	ft.receive(transport.Event{Type: transport.EventEndTurn})
+	audio := gm.Next()
+	if want := append(append([]byte(nil), first...), second...); !bytes.Equal(audio.Audio, want) {
+		t.Errorf("Gemini received %d bytes of audio, want the %d buffered", len(audio.Audio), len(want))
+	}
+	if end := gm.Next(); !end.AudioStreamEnd {
+		t.Errorf("turn not completed after the audio: %+v", end)
+	}
+
+	// Nothing is buffered anymore, so a second end of turn is ignored
+	ft.receive(transport.Event{Type: transport.EventEndTurn})
+	gm.Idle(50 * time.Millisecond)
+}
+
+func TestBufferFull(t *testing.T) {
+	ft := newFakeTransport(false)
+	_, gm := startSession(t, ft, 1000)
+
+	ft.receive(transport.Event{Type: transport.EventAudio, Audio: geminitest.PCM(800)})
+	ft.receive(transport.Event{Type: transport.EventAudio, Audio: geminitest.PCM(800)})
+	ft.waitFor(t, "buffer full error", isError(messages.ErrCodeBufferFull))
+
+	// The audio that fit is still sent
+	ft.receive(transport.Event{Type: transport.EventEndTurn})
+	if audio := gm.Next(); len(audio.Audio) != 800 {
+		t.Errorf("Gemini received %d bytes, want 800", len(audio.Audio))
+	}
+}
+
+func TestStreamingAudioSentAsItArrives(t *testing.T) {
+	ft := newFakeTransport(true)
+	cs, gm := startSession(t, ft, 1<<20)
+
+	for i := range 3 {
+		pcm := geminitest.PCM(320 * (i + 1))
+		ft.receive(transport.Event{Type: transport.EventAudio, Audio: pcm})
+		if audio := gm.Next(); !bytes.Equal(audio.Audio, pcm) {
+			t.Fatalf("chunk %d: Gemini received %d bytes, want %d", i, len(audio.Audio), len(pcm))
+		}
+	}
+	if !cs.AudioBuffer.IsEmpty() {
+		t.Error("streamed audio was buffered")
+	}
+}
+
+func TestGeminiAudioRelayed(t *testing.T) {
+	ft := newFakeTransport(true)
+	_, gm := startSession(t, ft, 1<<20)
+
+	gm.SendAudio(geminitest.PCM(480))
+	gm.SendTurnComplete()
+
+	audio := ft.waitFor(t, "audio", func(msg *messages.ServerMessage) bool { return msg.Type == messages.TypeAudio })
+	if payload, ok := audio.Payload.(messages.AudioResponsePayload); !ok || payload.Data == "" {
+		t.Errorf("audio message payload = %#v", audio.Payload)
+	}
+	ft.waitFor(t, "turn complete", isStatus("turn_complete"))
+}
+
+func TestEventInvalidReportsError(t *testing.T) {
+	ft := newFakeTransport(true)
+	cs, _ := startSession(t, ft, 1<<20)
+
+	ft.receive(transport.Event{Type: transport.EventInvalid, Err: errors.New("bad frame")})
+	msg := ft.waitFor(t, "invalid message error", isError(messages.ErrCodeInvalidMessage))
+	if payload := msg.Payload.(messages.ErrorPayload); payload.Message != "bad frame" {
+		t.Errorf("error message = %q", payload.Message)
+	}
+	if cs.IsClosed() {
+		t.Error("an invalid frame closed the session")
+	}
+}
+
+func TestEventStopClosesSession(t *testing.T) {
+	ft := newFakeTransport(true)
+	cs, _ := startSession(t, ft, 1<<20)
+
+	ft.receive(transport.Event{Type: transport.EventStop})
+	waitClosed(t, cs)
+	select {
+	case <-ft.closed:
+	case <-time.After(time.Second):
+		t.Error("transport not closed")
+	}
+}
+
+func TestDisconnectClosesSession(t *testing.T) {
+	ft := newFakeTransport(true)
+	cs, _ := startSession(t, ft, 1<<20)
+
+	_ = ft.Close()
+	waitClosed(t, cs)
+}
+
+func TestCloseFlushesQueue(t *testing.T) {
+	ft := newFakeTransport(true)
+	cs, _ := startSession(t, ft, 1<<20)
+
+	const queued = 20
+	for i := range queued {
+		cs.queueMessage(messages.NewTextMessage(cs.ID, string(rune('a'+i))))
+	}
+	if err := cs.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if !ft.isClosed() {
+		t.Fatal("transport not closed")
+	}
+
+	// Everything queued reached the client before the connection closed,
+	// followed by the final usage report
+	var texts int
+	var last *messages.ServerMessage
+	for len(ft.sent) > 0 {
+		last = <-ft.sent
+		if last.Type == messages.TypeText {
+			texts++
+		}
+	}
+	if texts != queued {
+		t.Errorf("client received %d of %d queued messages", texts, queued)
+	}
+	if last == nil || !isStatus("usage")(last) {
+		t.Errorf("last message = %+v, want the usage report", last)
+	}
+}
+
+// Sessions are warned before their maximum duration, then ended
+func TestDurationLimit(t *testing.T) {
+	ft := newFakeTransport(true)
+	gm := geminitest.NewServer(t)
+	cs, err := NewClientSession(context.Background(), "test-session", ft, gm.Connect, 1<<20)
+	if err != nil {
+		t.Fatalf("NewClientSession: %v", err)
+	}
+	cs.profile = &profile.Profile{Name: profile.Default}
+	const maxDuration, warning = 400 * time.Millisecond, 200 * time.Millisecond
+	cs.SetDurationLimit(maxDuration, warning)
+	cs.Start()
+	t.Cleanup(func() { _ = cs.Close() })
+	ft.waitFor(t, "connected status", isStatus("connected"))
+
+	ft.waitFor(t, "session_ending status", isStatus("session_ending"))
+	if elapsed := time.Since(cs.CreatedAt); elapsed < maxDuration-warning {
+		t.Errorf("warned after %s, want %s before the %s limit", elapsed, warning, maxDuration)
+	}
+	if notice := gm.Next(); !strings.Contains(notice.Text, "maximum duration") {
+		t.Errorf("Gemini was sent %q, want the session ending notice", notice.Text)
+	}
+	if cs.IsClosed() {
+		t.Fatal("session closed at the warning")
+	}
+
+	ft.waitFor(t, "session_expired status", isStatus("session_expired"))
+	waitClosed(t, cs)
+	if elapsed := time.Since(cs.CreatedAt); elapsed < maxDuration {
+		t.Errorf("ended after %s, want %s", elapsed, maxDuration)
+	}
+}
+
+// Without a limit, sessions run until they are closed
+func TestNoDurationLimit(t *testing.T) {
+	ft := newFakeTransport(true)
+	cs, gm := startSession(t, ft, 1<<20)
+
+	gm.Idle(100 * time.Millisecond)
+	if cs.IsClosed() {
+		t.Error("session without a duration limit was closed")
+	}
+}
+
+// get_call_info tells the model who is on the call and how it was set up
+func TestCallInfoTool(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(cs *ClientSession, ft *fakeTransport)
+		want  map[string]any
+	}{
+		{
+			name: "inbound",
+			setup: func(cs *ClientSession, ft *fakeTransport) {
+				call := &transport.CallInfo{CallSID: "CA123", StreamSID: "MZ456", Parameters: map[string]string{"CustomerId": "42"}}
+				ft.receive(transport.Event{Type: transport.EventStart, Caller: "+13122010094", Called: "+13122123456", Call: call})
+				ft.receive(transport.Event{Type: transport.EventPing})
+				ft.waitFor(t, "pong", isStatus("pong"))
+			},
+			want: map[string]any{
+				"transport":  "twilio",
+				"profile":    "support",
+				"caller":     "+13122010094",
+				"called":     "+13122123456",
+				"outbound":   false,
+				"call_sid":   "CA123",
+				"parameters": map[string]any{"CustomerId": "42"},
+			},
+		},
+		{
+			name: "outbound",
+			setup: func(cs *ClientSession, ft *fakeTransport) {
+				cs.mu.Lock()
+				cs.Outbound = true
+				cs.Caller = "+13122010094"
+				cs.Variables = map[string]string{"name": "Ada", "order": "1234"}
+				cs.mu.Unlock()
+			},
+			want: map[string]any{
+				"transport": "twilio",
+				"profile":   "support",
+				"caller":    "+13122010094",
+				"outbound":  true,
+				"variables": map[string]any{"name": "Ada", "order": "1234"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ft := newFakeTransport(true)
+			ft.name = "twilio"
+			cs, gm := startSession(t, ft, 1<<20)
+			cs.mu.Lock()
+			cs.Agent = "support"
+			cs.mu.Unlock()
+			tt.setup(cs, ft)
+
+			gm.Send(&genai.LiveServerMessage{ToolCall: &genai.LiveServerToolCall{
+				FunctionCalls: []*genai.FunctionCall{{ID: "call-1", Name: functions.CallInfoName}},
+			}})
+			msg := gm.Next()
+			if len(msg.ToolResponses) != 1 || msg.ToolResponses[0].ID != "call-1" {
+				t.Fatalf("Gemini received %+v, want the get_call_info response", msg)
+			}
+			if got := msg.ToolResponses[0].Response; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("get_call_info = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package session
+
+import (
+	"sync"
+	"time"
+)
+
+// maxTranscriptEntries bounds the live transcript kept per session
+const maxTranscriptEntries = 500
+
+// Transcript speakers
+const (
+	RoleUser  = "user"
+	RoleModel = "model"
+)
+
+// TranscriptEntry is one speaker's utterance
+type TranscriptEntry struct {
+	Role string    `json:"role"` // RoleUser or RoleModel
+	Text string    `json:"text"`
+	At   time.Time `json:"at"` // When the utterance started
+}
+
+// Transcript accumulates the Gemini transcriptions of a session's audio
+type Transcript struct {
+	mu      sync.Mutex
+	entries []TranscriptEntry
+}
+
+// NewTranscript creates an empty transcript
+func NewTranscript() *Transcript {
+	return &Transcript{}
+}
+
+// Add appends a transcription fragment, extending the last entry while the same role is speaking
+func (t *Transcript) Add(role, text string, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if n := len(t.entries); n > 0 && t.entries[n-1].Role == role {
+		t.entries[n-1].Text += text
+		return
+	}
+
+	if len(t.entries) >= maxTranscriptEntries {
+		t.entries = t.entries[1:]
+	}
+	t.entries = append(t.entries, TranscriptEntry{Role: role, Text: text, At: at})
+}
+
+// Entries returns a copy of the transcript so far
+func (t *Transcript) Entries() []TranscriptEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]TranscriptEntry(nil), t.entries...)
+}
@@ -0,0 +1,183 @@
+package session
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/room4-2/OpenConverse/messages"
+)
+
+const (
+	// speechEnergyThreshold is the mean absolute 16-bit sample amplitude above
+	// which an inbound streamed audio frame counts as speech
+	speechEnergyThreshold = 500
+
+	// maxTurnHistory bounds the per-session turn latencies kept for the session record
+	maxTurnHistory = 200
+)
+
+// turnTimings are the milestones of one conversational turn
+type turnTimings struct {
+	speechEnd     time.Time // User stopped speaking (end_turn, or last voiced phone frame)
+	audioSent     time.Time // User audio finished being sent to Gemini
+	firstResponse time.Time // First response chunk received from Gemini
+	firstWrite    time.Time // First response audio written to the client
+	complete      time.Time // Gemini reported the turn complete
+}
+
+// turnTracker timestamps each turn's milestones, from the end of the user's
+// speech to the end of the model's response
+type turnTracker struct {
+	mu         sync.Mutex
+	turn       int
+	current    turnTimings
+	next       turnTimings // Speech heard while the model is still responding belongs to the next turn
+	responding bool        // Whether the model is producing a response
+	history    []messages.TurnLatencyPayload
+}
+
+// speech records that the user was speaking (or finished speaking) at `at`
+func (t *turnTracker) speech(at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending().speechEnd = at
+}
+
+// audioSent records that the user's audio up to `at` was sent to Gemini
+func (t *turnTracker) audioSent(at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if p := t.pending(); !p.speechEnd.IsZero() {
+		p.audioSent = at
+	}
+}
+
+// pending returns the turn user input is attributed to. Must be called with t.mu held.
+func (t *turnTracker) pending() *turnTimings {
+	if t.responding {
+		return &t.next
+	}
+	return &t.current
+}
+
+// responseChunk records a chunk of the model's response
+func (t *turnTracker) responseChunk(at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.responding {
+		return
+	}
+	t.responding = true
+	t.current.firstResponse = at
+}
+
+// audioWritten records response audio written to the client and returns the
+// user-perceived latency when it is the first audio of a response to speech
+func (t *turnTracker) audioWritten(at time.Time) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.responding || !t.current.firstWrite.IsZero() {
+		return 0, false
+	}
+	t.current.firstWrite = at
+
+	if t.current.speechEnd.IsZero() {
+		return 0, false
+	}
+	return at.Sub(t.current.speechEnd), true
+}
+
+// complete closes the current turn and returns its milestones, with latencies
+// that are nil when the turn wasn't a response to user speech (e.g. a greeting)
+func (t *turnTracker) complete(at time.Time) (turnTimings, *messages.TurnLatencyPayload) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	timings := t.current
+	timings.complete = at
+	t.current, t.next = t.next, turnTimings{}
+	t.responding = false
+
+	if timings.speechEnd.IsZero() {
+		return timings, nil
+	}
+
+	t.turn++
+	latency := &messages.TurnLatencyPayload{
+		Turn:            t.turn,
+		AudioSentMs:     sinceSpeechEnd(timings.speechEnd, timings.audioSent),
+		FirstResponseMs: sinceSpeechEnd(timings.speechEnd, timings.firstResponse),
+		FirstAudioMs:    sinceSpeechEnd(timings.speechEnd, timings.firstWrite),
+		TurnCompleteMs:  sinceSpeechEnd(timings.speechEnd, timings.complete),
+	}
+	if len(t.history) < maxTurnHistory {
+		t.history = append(t.history, *latency)
+	}
+	return timings, latency
+}
+
+// start returns the earliest milestone reached in the turn
+func (tt turnTimings) start() time.Time {
+	for _, at := range []time.Time{tt.speechEnd, tt.audioSent, tt.firstResponse, tt.firstWrite} {
+		if !at.IsZero() {
+			return at
+		}
+	}
+	return tt.complete
+}
+
+// History returns the latencies of the session's completed turns
+func (t *turnTracker) History() []messages.TurnLatencyPayload {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]messages.TurnLatencyPayload(nil), t.history...)
+}
+
+// latencyFields summarizes turn latencies as session record counters
+func latencyFields(history []messages.TurnLatencyPayload) map[string]int64 {
+	fields := map[string]int64{"turns": int64(len(history))}
+
+	var firstAudioSum, firstAudioMax, firstAudioCount int64
+	for _, turn := range history {
+		if turn.FirstAudioMs == nil {
+			continue
+		}
+		firstAudioSum += *turn.FirstAudioMs
+		firstAudioMax = max(firstAudioMax, *turn.FirstAudioMs)
+		firstAudioCount++
+	}
+	if firstAudioCount > 0 {
+		fields["avg_first_audio_ms"] = firstAudioSum / firstAudioCount
+		fields["max_first_audio_ms"] = firstAudioMax
+	}
+	return fields
+}
+
+// sinceSpeechEnd returns the milliseconds from speechEnd to milestone, or nil if the milestone wasn't reached
+func sinceSpeechEnd(speechEnd, milestone time.Time) *int64 {
+	if milestone.IsZero() {
+		return nil
+	}
+	ms := milestone.Sub(speechEnd).Milliseconds()
+	return &ms
+}
+
+// pcmIsSpeech is a minimal energy-based voice activity detector for 16-bit PCM frames
+func pcmIsSpeech(pcm []byte) bool {
+	samples := len(pcm) / 2
+	if samples == 0 {
+		return false
+	}
+	var sum int
+	for i := 0; i < samples; i++ {
+		sample := int(int16(binary.LittleEndian.Uint16(pcm[i*2:])))
+		if sample < 0 {
+			sample = -sample
+		}
+		sum += sample
+	}
+	return sum/samples > speechEnergyThreshold
+}
@@ -0,0 +1,185 @@
+package session
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/room4-2/OpenConverse/messages"
+)
+
+// turnEvent is one call to a turnTracker, at a millisecond offset
+type turnEvent struct {
+	op string // "speech", "sent", "chunk", "write" or "complete"
+	at int64
+}
+
+func ms(n int64) *int64 { return &n }
+
+func TestTurnTracker(t *testing.T) {
+	tests := []struct {
+		name       string
+		events     []turnEvent
+		firstAudio []int64                        // Latencies returned by audioWritten
+		turns      []*messages.TurnLatencyPayload // Returned by each complete, nil for turns without speech
+	}{
+		{
+			name: "answer to speech",
+			events: []turnEvent{
+				{"speech", 0}, {"speech", 100}, {"sent", 120}, {"chunk", 400}, {"chunk", 420},
+				{"write", 450}, {"write", 500}, {"complete", 1100},
+			},
+			firstAudio: []int64{350},
+			turns: []*messages.TurnLatencyPayload{
+				{Turn: 1, AudioSentMs: ms(20), FirstResponseMs: ms(300), FirstAudioMs: ms(350), TurnCompleteMs: ms(1000)},
+			},
+		},
+		{
+			name:   "greeting",
+			events: []turnEvent{{"chunk", 0}, {"write", 10}, {"complete", 500}},
+			turns:  []*messages.TurnLatencyPayload{nil},
+		},
+		{
+			name: "barge-in mid-response",
+			events: []turnEvent{
+				{"speech", 0}, {"sent", 10}, {"chunk", 200}, {"write", 210},
+				// The caller talks over the response, starting the next turn
+				{"speech", 400}, {"sent", 410}, {"complete", 600},
+				{"chunk", 700}, {"write", 720}, {"complete", 900},
+			},
+			firstAudio: []int64{210, 320},
+			turns: []*messages.TurnLatencyPayload{
+				{Turn: 1, AudioSentMs: ms(10), FirstResponseMs: ms(200), FirstAudioMs: ms(210), TurnCompleteMs: ms(600)},
+				{Turn: 2, AudioSentMs: ms(10), FirstResponseMs: ms(300), FirstAudioMs: ms(320), TurnCompleteMs: ms(500)},
+			},
+		},
+		{
+			name: "turn complete before the audio is written",
+			events: []turnEvent{
+				{"speech", 0}, {"sent", 10}, {"chunk", 100}, {"complete", 150},
+				// Audio flushed after the turn ended belongs to no turn
+				{"write", 200},
+			},
+			turns: []*messages.TurnLatencyPayload{
+				{Turn: 1, AudioSentMs: ms(10), FirstResponseMs: ms(100), TurnCompleteMs: ms(150)},
+			},
+		},
+		{
+			name:   "silence only",
+			events: []turnEvent{{"sent", 50}, {"sent", 100}, {"chunk", 150}, {"write", 160}, {"complete", 200}},
+			turns:  []*messages.TurnLatencyPayload{nil},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tracker turnTracker
+			start := time.Date(2026, 3, 14, 12, 0, 0, 0, time.UTC)
+			var firstAudio []int64
+			var turns, history []*messages.TurnLatencyPayload
+
+			for _, event := range tt.events {
+				at := start.Add(time.Duration(event.at) * time.Millisecond)
+				switch event.op {
+				case "speech":
+					tracker.speech(at)
+				case "sent":
+					tracker.audioSent(at)
+				case "chunk":
+					tracker.responseChunk(at)
+				case "write":
+					if latency, ok := tracker.audioWritten(at); ok {
+						firstAudio = append(firstAudio, latency.Milliseconds())
+					}
+				case "complete":
+					timings, latency := tracker.complete(at)
+					if !timings.complete.Equal(at) {
+						t.Errorf("complete at %dms returned timings completed at %s", event.at, timings.complete)
+					}
+					turns = append(turns, latency)
+					if latency != nil {
+						history = append(history, latency)
+					}
+				default:
+					t.Fatalf("unknown event %q", event.op)
+				}
+			}
+
+			if !reflect.DeepEqual(firstAudio, tt.firstAudio) {
+				t.Errorf("first audio latencies = %v, want %v", firstAudio, tt.firstAudio)
+			}
+			if len(turns) != len(tt.turns) {
+				t.Fatalf("%d turns completed, want %d", len(turns), len(tt.turns))
+			}
+			for i := range turns {
+				if !reflect.DeepEqual(turns[i], tt.turns[i]) {
+					t.Errorf("turn %d latencies = %s, want %s", i+1, formatLatency(turns[i]), formatLatency(tt.turns[i]))
+				}
+			}
+
+			got := tracker.History()
+			if len(got) != len(history) {
+				t.Fatalf("History has %d turns, want %d", len(got), len(history))
+			}
+			for i := range got {
+				if !reflect.DeepEqual(got[i], *history[i]) {
+					t.Errorf("History[%d] = %s, want %s", i, formatLatency(&got[i]), formatLatency(history[i]))
+				}
+			}
+		})
+	}
+}
+
+// formatLatency prints the milestones of a turn, "-" for those not reached
+func formatLatency(l *messages.TurnLatencyPayload) string {
+	if l == nil {
+		return "<nil>"
+	}
+	format := func(v *int64) string {
+		if v == nil {
+			return "-"
+		}
+		return fmt.Sprintf("%dms", *v)
+	}
+	return fmt.Sprintf("#%d sent %s response %s audio %s complete %s", l.Turn,
+		format(l.AudioSentMs), format(l.FirstResponseMs), format(l.FirstAudioMs), format(l.TurnCompleteMs))
+}
+
+// pcmFrame is a 16-bit PCM frame of n samples alternating between +amplitude and -amplitude
+func pcmFrame(n int, amplitude int16) []byte {
+	pcm := make([]byte, 2*n)
+	for i := range n {
+		sample := amplitude
+		if i%2 == 1 {
+			sample = -amplitude
+		}
+		binary.LittleEndian.PutUint16(pcm[2*i:], uint16(sample))
+	}
+	return pcm
+}
+
+func TestPCMIsSpeech(t *testing.T) {
+	tests := []struct {
+		name string
+		pcm  []byte
+		want bool
+	}{
+		{"empty", nil, false},
+		{"half a sample", []byte{0xff}, false},
+		{"silence", pcmFrame(160, 0), false},
+		{"line noise", pcmFrame(160, 200), false},
+		{"at the threshold", pcmFrame(160, speechEnergyThreshold), false},
+		{"voice", pcmFrame(160, 3000), true},
+		{"loud negative samples", pcmFrame(160, -32768), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pcmIsSpeech(tt.pcm); got != tt.want {
+				t.Errorf("pcmIsSpeech = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package session
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/room4-2/OpenConverse/messages"
+	"github.com/room4-2/OpenConverse/metrics"
+
+	"google.golang.org/genai"
+)
+
+// Usage accumulates the Gemini token counts reported during a session
+type Usage struct {
+	mu    sync.Mutex
+	total messages.UsagePayload
+}
+
+// NewUsage creates an empty usage accumulator
+func NewUsage() *Usage {
+	return &Usage{
+		total: messages.UsagePayload{
+			PromptTokensByModality:        make(map[string]int64),
+			ResponseTokensByModality:      make(map[string]int64),
+			ToolUsePromptTokensByModality: make(map[string]int64),
+		},
+	}
+}
+
+// Add accumulates the counts from one Gemini usage report
+func (u *Usage) Add(md *genai.UsageMetadata) {
+	if md == nil {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.total.PromptTokens += int64(md.PromptTokenCount)
+	u.total.ResponseTokens += int64(md.ResponseTokenCount)
+	u.total.ToolUsePromptTokens += int64(md.ToolUsePromptTokenCount)
+	u.total.ThoughtsTokens += int64(md.ThoughtsTokenCount)
+	u.total.TotalTokens += int64(md.TotalTokenCount)
+
+	addModalities(u.total.PromptTokensByModality, md.PromptTokensDetails)
+	addModalities(u.total.ResponseTokensByModality, md.ResponseTokensDetails)
+	addModalities(u.total.ToolUsePromptTokensByModality, md.ToolUsePromptTokensDetails)
+}
+
+// countTokens adds one Gemini usage report to the token metrics
+func countTokens(agent, tenant string, md *genai.UsageMetadata) {
+	if md == nil {
+		return
+	}
+	for kind, count := range map[string]int32{
+		"prompt":          md.PromptTokenCount,
+		"response":        md.ResponseTokenCount,
+		"tool_use_prompt": md.ToolUsePromptTokenCount,
+		"thoughts":        md.ThoughtsTokenCount,
+	} {
+		if count > 0 {
+			metrics.Tokens.WithLabelValues(agent, tenant, kind).Add(float64(count))
+		}
+	}
+}
+
+func addModalities(dst map[string]int64, details []*genai.ModalityTokenCount) {
+	for _, d := range details {
+		if d == nil {
+			continue
+		}
+		dst[string(d.Modality)] += int64(d.TokenCount)
+	}
+}
+
+// Snapshot returns a copy of the accumulated counts
+func (u *Usage) Snapshot() messages.UsagePayload {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	snapshot := u.total
+	snapshot.PromptTokensByModality = copyCounts(u.total.PromptTokensByModality)
+	snapshot.ResponseTokensByModality = copyCounts(u.total.ResponseTokensByModality)
+	snapshot.ToolUsePromptTokensByModality = copyCounts(u.total.ToolUsePromptTokensByModality)
+	return snapshot
+}
+
+func copyCounts(src map[string]int64) map[string]int64 {
+	dst := make(map[string]int64, len(src))
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
+
+// usageFields flattens a usage snapshot into session record counters
+// (e.g. "prompt_tokens", "prompt_tokens_audio")
+func usageFields(usage messages.UsagePayload) map[string]int64 {
+	fields := map[string]int64{
+		"prompt_tokens":          usage.PromptTokens,
+		"response_tokens":        usage.ResponseTokens,
+		"tool_use_prompt_tokens": usage.ToolUsePromptTokens,
+		"thoughts_tokens":        usage.ThoughtsTokens,
+		"total_tokens":           usage.TotalTokens,
+	}
+	for modality, count := range usage.PromptTokensByModality {
+		fields["prompt_tokens_"+strings.ToLower(modality)] = count
+	}
+	for modality, count := range usage.ResponseTokensByModality {
+		fields["response_tokens_"+strings.ToLower(modality)] = count
+	}
+	for modality, count := range usage.ToolUsePromptTokensByModality {
+		fields["tool_use_prompt_tokens_"+strings.ToLower(modality)] = count
+	}
+	return fields
+}
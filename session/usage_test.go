@@ -0,0 +1,144 @@
+package session
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/room4-2/OpenConverse/gemini/geminitest"
+	"github.com/room4-2/OpenConverse/messages"
+	"github.com/room4-2/OpenConverse/metrics"
+	"github.com/room4-2/OpenConverse/profile"
+	"github.com/room4-2/OpenConverse/store"
+	"github.com/room4-2/OpenConverse/tenant"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/genai"
+)
+
+// usageReport is a usage report of an audio turn
+func usageReport(prompt, response int32) *genai.UsageMetadata {
+	return &genai.UsageMetadata{
+		PromptTokenCount:      prompt,
+		ResponseTokenCount:    response,
+		TotalTokenCount:       prompt + response,
+		PromptTokensDetails:   []*genai.ModalityTokenCount{{Modality: genai.MediaModalityAudio, TokenCount: prompt}},
+		ResponseTokensDetails: []*genai.ModalityTokenCount{{Modality: genai.MediaModalityAudio, TokenCount: response}, nil},
+	}
+}
+
+func TestUsageAddAndSnapshot(t *testing.T) {
+	u := NewUsage()
+	u.Add(nil)
+	u.Add(usageReport(100, 40))
+	u.Add(&genai.UsageMetadata{
+		PromptTokenCount:    20,
+		ThoughtsTokenCount:  5,
+		TotalTokenCount:     25,
+		PromptTokensDetails: []*genai.ModalityTokenCount{{Modality: genai.MediaModalityText, TokenCount: 20}},
+	})
+
+	snapshot := u.Snapshot()
+	want := messages.UsagePayload{
+		PromptTokens:                  120,
+		ResponseTokens:                40,
+		ThoughtsTokens:                5,
+		TotalTokens:                   165,
+		PromptTokensByModality:        map[string]int64{"AUDIO": 100, "TEXT": 20},
+		ResponseTokensByModality:      map[string]int64{"AUDIO": 40},
+		ToolUsePromptTokensByModality: map[string]int64{},
+	}
+	if !reflect.DeepEqual(snapshot, want) {
+		t.Fatalf("Snapshot = %+v, want %+v", snapshot, want)
+	}
+
+	// A snapshot is a copy, unchanged by later reports
+	u.Add(usageReport(10, 10))
+	if snapshot.PromptTokens != 120 || snapshot.PromptTokensByModality["AUDIO"] != 100 {
+		t.Errorf("snapshot changed by a later Add: %+v", snapshot)
+	}
+	snapshot.ResponseTokensByModality["AUDIO"] = 0
+	if got := u.Snapshot().ResponseTokensByModality["AUDIO"]; got != 50 {
+		t.Errorf("response audio tokens = %d after editing a snapshot, want 50", got)
+	}
+}
+
+// Usage reported by Gemini reaches the session record, the token metrics, the
+// client when the session ends and the store's per-agent and per-tenant counters
+func TestSessionUsage(t *testing.T) {
+	sm := newTestManager(t, store.NewMemory())
+	tenants, err := tenant.NewRegistry([]*tenant.Tenant{{ID: "acme"}})
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+	sm.tenants = tenants
+	promptTokens := metrics.Tokens.WithLabelValues(profile.Default, "acme", "prompt")
+	before := testutil.ToFloat64(promptTokens)
+
+	gm := geminitest.NewServer(t)
+	ft := newFakeTransport(true)
+	cs, err := NewClientSession(context.Background(), "usage-session", ft, gm.Connect, 1<<20)
+	if err != nil {
+		t.Fatalf("NewClientSession: %v", err)
+	}
+	cs.profile = &profile.Profile{Name: profile.Default}
+	cs.Agent = profile.Default
+	cs.Tenant = "acme"
+	cs.Start()
+	t.Cleanup(func() { _ = cs.Close() })
+	ft.waitFor(t, "connected status", isStatus("connected"))
+	sm.mu.Lock()
+	sm.sessions[cs.ID] = cs
+	sm.mu.Unlock()
+
+	gm.Send(&genai.LiveServerMessage{UsageMetadata: usageReport(100, 40)})
+	gm.Send(&genai.LiveServerMessage{UsageMetadata: usageReport(60, 20)})
+
+	deadline := time.Now().Add(time.Second)
+	for sm.record(cs).Counters["total_tokens"] != 220 {
+		if time.Now().After(deadline) {
+			t.Fatalf("record counters = %v, want 220 total tokens", sm.record(cs).Counters)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	counters := sm.record(cs).Counters
+	if counters["prompt_tokens"] != 160 || counters["response_tokens_audio"] != 60 {
+		t.Errorf("record counters = %v, want 160 prompt and 60 response audio tokens", counters)
+	}
+	if got := testutil.ToFloat64(promptTokens) - before; got != 160 {
+		t.Errorf("prompt tokens counted = %v, want 160", got)
+	}
+
+	if err := sm.RemoveSession(context.Background(), cs.ID); err != nil {
+		t.Fatalf("RemoveSession: %v", err)
+	}
+	msg := ft.waitFor(t, "usage status", isStatus("usage"))
+	if usage := msg.Payload.(messages.StatusPayload).Usage; usage == nil || usage.TotalTokens != 220 {
+		t.Errorf("final usage = %+v, want 220 total tokens", usage)
+	}
+
+	want := map[string]int64{"prompt_tokens": 160, "response_tokens": 60, "total_tokens": 220}
+	for _, name := range []string{"usage:agent:" + profile.Default, "usage:tenant:acme"} {
+		stored, err := sm.store.Counters(context.Background(), name)
+		if err != nil {
+			t.Fatalf("Counters(%s): %v", name, err)
+		}
+		for field, value := range want {
+			if stored[field] != value {
+				t.Errorf("%s %s = %d, want %d", name, field, stored[field], value)
+			}
+		}
+	}
+
+	totals, err := sm.UsageTotals(context.Background())
+	if err != nil {
+		t.Fatalf("UsageTotals: %v", err)
+	}
+	if got := totals.Agents[profile.Default]["total_tokens"]; got != 220 {
+		t.Errorf("agent total tokens = %d, want 220", got)
+	}
+	if got := totals.Tenants["acme"]["prompt_tokens_audio"]; got != 160 {
+		t.Errorf("tenant prompt audio tokens = %d, want 160", got)
+	}
+}
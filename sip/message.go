@@ -0,0 +1,294 @@
+// Package sip implements the subset of SIP (RFC 3261) and SDP (RFC 4566)
+// a media gateway answering calls needs: parsing and writing messages,
+// dialog identifiers and audio offer/answer
+package sip
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxBodySize bounds a message body; SDP is a few hundred bytes
+const maxBodySize = 64 * 1024
+
+var (
+	// ErrMalformed is returned for data that isn't a SIP message
+	ErrMalformed = errors.New("malformed SIP message")
+	// ErrMissingContentLength is returned by ReadMessage for a message
+	// without Content-Length, whose end can't be found on a stream
+	ErrMissingContentLength = errors.New("SIP message on a stream without Content-Length")
+)
+
+// compactNames maps compact header forms to their full names
+var compactNames = map[string]string{
+	"v": "Via",
+	"f": "From",
+	"t": "To",
+	"i": "Call-ID",
+	"m": "Contact",
+	"l": "Content-Length",
+	"c": "Content-Type",
+	"k": "Supported",
+}
+
+// Header is one header line
+type Header struct {
+	Name  string
+	Value string
+}
+
+// Message is a SIP request (Method set) or response (StatusCode set)
+type Message struct {
+	Method     string
+	RequestURI string
+
+	StatusCode int
+	Reason     string
+
+	Headers []Header // In wire order; names use their full form
+	Body    []byte
+}
+
+// IsRequest reports whether m is a request
+func (m *Message) IsRequest() bool { return m.Method != "" }
+
+// Get returns the first value of the named header
+func (m *Message) Get(name string) string {
+	for _, h := range m.Headers {
+		if strings.EqualFold(h.Name, name) {
+			return h.Value
+		}
+	}
+	return ""
+}
+
+// Values returns every value of the named header, in order
+func (m *Message) Values(name string) []string {
+	var values []string
+	for _, h := range m.Headers {
+		if strings.EqualFold(h.Name, name) {
+			values = append(values, h.Value)
+		}
+	}
+	return values
+}
+
+// Add appends a header
+func (m *Message) Add(name, value string) {
+	m.Headers = append(m.Headers, Header{Name: name, Value: value})
+}
+
+// Set replaces the named header, or appends it
+func (m *Message) Set(name, value string) {
+	for i, h := range m.Headers {
+		if strings.EqualFold(h.Name, name) {
+			m.Headers[i].Value = value
+			m.del(name, i+1)
+			return
+		}
+	}
+	m.Add(name, value)
+}
+
+// del removes the named header from index from onwards
+func (m *Message) del(name string, from int) {
+	kept := m.Headers[:from]
+	for _, h := range m.Headers[from:] {
+		if !strings.EqualFold(h.Name, name) {
+			kept = append(kept, h)
+		}
+	}
+	m.Headers = kept
+}
+
+// CSeq returns the sequence number and method of the CSeq header
+func (m *Message) CSeq() (int, string) {
+	num, method, _ := strings.Cut(strings.TrimSpace(m.Get("CSeq")), " ")
+	n, _ := strconv.Atoi(num)
+	return n, strings.TrimSpace(method)
+}
+
+// Bytes encodes m for the wire, setting Content-Length
+func (m *Message) Bytes() []byte {
+	var b bytes.Buffer
+	if m.IsRequest() {
+		fmt.Fprintf(&b, "%s %s SIP/2.0\r\n", m.Method, m.RequestURI)
+	} else {
+		fmt.Fprintf(&b, "SIP/2.0 %d %s\r\n", m.StatusCode, m.Reason)
+	}
+	for _, h := range m.Headers {
+		if strings.EqualFold(h.Name, "Content-Length") {
+			continue
+		}
+		fmt.Fprintf(&b, "%s: %s\r\n", h.Name, h.Value)
+	}
+	fmt.Fprintf(&b, "Content-Length: %d\r\n\r\n", len(m.Body))
+	b.Write(m.Body)
+	return b.Bytes()
+}
+
+// Parse decodes a datagram holding one message. Datagrams may omit
+// Content-Length; the body is then the rest of the datagram.
+func Parse(data []byte) (*Message, error) {
+	return readMessage(bufio.NewReader(bytes.NewReader(data)), false)
+}
+
+// ReadMessage reads one message from a stream, using Content-Length to find
+// its end. Leading blank lines (keep-alives) are skipped. Stream messages
+// must carry Content-Length (RFC 3261 section 18.3): without it ReadMessage
+// returns the message's start line and headers, so it can be answered with
+// a 400, and ErrMissingContentLength, after which the stream is unusable.
+func ReadMessage(r *bufio.Reader) (*Message, error) {
+	return readMessage(r, true)
+}
+
+func readMessage(r *bufio.Reader, stream bool) (*Message, error) {
+	var line string
+	for line == "" {
+		var err error
+		if line, err = readLine(r); err != nil {
+			return nil, err
+		}
+	}
+
+	m := &Message{}
+	if rest, ok := strings.CutPrefix(line, "SIP/2.0 "); ok {
+		code, reason, _ := strings.Cut(rest, " ")
+		status, err := strconv.Atoi(code)
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		m.StatusCode, m.Reason = status, reason
+	} else {
+		parts := strings.Fields(line)
+		if len(parts) != 3 || parts[2] != "SIP/2.0" {
+			return nil, ErrMalformed
+		}
+		m.Method, m.RequestURI = parts[0], parts[1]
+	}
+
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		if line == "" {
+			break
+		}
+		// Folded continuation lines belong to the previous header
+		if (line[0] == ' ' || line[0] == '\t') && len(m.Headers) > 0 {
+			m.Headers[len(m.Headers)-1].Value += " " + strings.TrimSpace(line)
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, ErrMalformed
+		}
+		name = strings.TrimSpace(name)
+		if full, ok := compactNames[strings.ToLower(name)]; ok {
+			name = full
+		}
+		m.Add(name, strings.TrimSpace(value))
+	}
+
+	if length := m.Get("Content-Length"); length != "" {
+		n, err := strconv.Atoi(length)
+		if err != nil || n < 0 || n > maxBodySize {
+			return nil, ErrMalformed
+		}
+		m.Body = make([]byte, n)
+		if _, err := io.ReadFull(r, m.Body); err != nil {
+			return nil, ErrMalformed
+		}
+	} else if stream {
+		return m, ErrMissingContentLength
+	} else {
+		body, _ := io.ReadAll(io.LimitReader(r, maxBodySize))
+		m.Body = body
+	}
+	return m, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// NewResponse builds a response to req, copying the headers that identify
+// its transaction
+func NewResponse(req *Message, code int, reason string) *Message {
+	res := &Message{StatusCode: code, Reason: reason}
+	for _, via := range req.Values("Via") {
+		res.Add("Via", via)
+	}
+	res.Add("From", req.Get("From"))
+	res.Add("To", req.Get("To"))
+	res.Add("Call-ID", req.Get("Call-ID"))
+	res.Add("CSeq", req.Get("CSeq"))
+	return res
+}
+
+// Param returns a ;name=value parameter of a header value, e.g. the tag of a To header
+func Param(value, name string) string {
+	// Parameters of the URI inside <> aren't header parameters
+	if end := strings.IndexByte(value, '>'); end >= 0 {
+		value = value[end+1:]
+	}
+	for _, param := range strings.Split(value, ";")[1:] {
+		key, val, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if strings.EqualFold(key, name) {
+			return val
+		}
+	}
+	return ""
+}
+
+// URI returns the URI of a name-addr header value such as
+// "Alice" <sip:alice@example.com>;tag=1
+func URI(value string) string {
+	if start := strings.IndexByte(value, '<'); start >= 0 {
+		if end := strings.IndexByte(value[start:], '>'); end >= 0 {
+			return value[start+1 : start+end]
+		}
+	}
+	uri, _, _ := strings.Cut(strings.TrimSpace(value), ";")
+	return uri
+}
+
+// User returns the user part of the URI in a header value, e.g. a caller's number
+func User(value string) string {
+	uri := URI(value)
+	if number, ok := strings.CutPrefix(uri, "tel:"); ok {
+		number, _, _ = strings.Cut(number, ";")
+		return number
+	}
+	uri = strings.TrimPrefix(strings.TrimPrefix(uri, "sips:"), "sip:")
+	user, _, ok := strings.Cut(uri, "@")
+	if !ok {
+		return ""
+	}
+	user, _, _ = strings.Cut(user, ";")
+	return user
+}
+
+// NewTag returns a random tag or branch suffix
+func NewTag() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// NewBranch returns a random Via branch carrying the RFC 3261 magic cookie
+func NewBranch() string {
+	return "z9hG4bK" + NewTag()
+}
@@ -0,0 +1,186 @@
+package sip
+
+import (
+	"bufio"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const testSDP = "v=0\r\no=- 1 1 IN IP4 192.0.2.10\r\ns=-\r\nc=IN IP4 192.0.2.10\r\nt=0 0\r\nm=audio 4000 RTP/AVP 0 101\r\n"
+
+// testInvite is an INVITE as a PBX sends it, with compact and folded headers
+var testInvite = "INVITE sip:+15550199@192.0.2.1 SIP/2.0\r\n" +
+	"Via: SIP/2.0/UDP 192.0.2.10:5060;branch=z9hG4bK776asdhds\r\n" +
+	"v: SIP/2.0/UDP 192.0.2.20:5060;branch=z9hG4bK1\r\n" +
+	"f: \"Alice\" <sip:+15550100@192.0.2.10;user=phone>;tag=1928301774\r\n" +
+	"To: <sip:+15550199@192.0.2.1>\r\n" +
+	"i: a84b4c76e66710@pbx.example.com\r\n" +
+	"CSeq: 314159 INVITE\r\n" +
+	"Subject: a long\r\n" +
+	" folded subject\r\n" +
+	"c: application/sdp\r\n" +
+	"l: " + strconv.Itoa(len(testSDP)) + "\r\n" +
+	"\r\n" + testSDP
+
+func TestParseInvite(t *testing.T) {
+	m, err := Parse([]byte(testInvite))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	if !m.IsRequest() || m.Method != "INVITE" || m.RequestURI != "sip:+15550199@192.0.2.1" {
+		t.Errorf("start line = %q %q", m.Method, m.RequestURI)
+	}
+	if vias := m.Values("Via"); len(vias) != 2 || !strings.HasSuffix(vias[1], "branch=z9hG4bK1") {
+		t.Errorf("Via = %q, want both, the compact one expanded", vias)
+	}
+	if got := m.Get("call-id"); got != "a84b4c76e66710@pbx.example.com" {
+		t.Errorf("Call-ID = %q", got)
+	}
+	if got := m.Get("Subject"); got != "a long folded subject" {
+		t.Errorf("folded Subject = %q", got)
+	}
+	if seq, method := m.CSeq(); seq != 314159 || method != "INVITE" {
+		t.Errorf("CSeq = %d %q", seq, method)
+	}
+	if string(m.Body) != testSDP {
+		t.Errorf("Body = %q", m.Body)
+	}
+
+	from := m.Get("From")
+	if tag := Param(from, "tag"); tag != "1928301774" {
+		t.Errorf("From tag = %q", tag)
+	}
+	if user := Param(from, "user"); user != "" {
+		t.Errorf("URI parameter user=%q read as a header parameter", user)
+	}
+	if caller := User(from); caller != "+15550100" {
+		t.Errorf("caller = %q", caller)
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	m, err := Parse([]byte("SIP/2.0 486 Busy Here\r\nCall-ID: abc\r\nContent-Length: 0\r\n\r\n"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if m.IsRequest() || m.StatusCode != 486 || m.Reason != "Busy Here" {
+		t.Errorf("status line = %d %q", m.StatusCode, m.Reason)
+	}
+}
+
+// Datagrams may omit Content-Length; the body is the rest of the datagram
+func TestParseDatagramWithoutContentLength(t *testing.T) {
+	m, err := Parse([]byte("MESSAGE sip:a@b SIP/2.0\r\nCall-ID: abc\r\n\r\nhello"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if string(m.Body) != "hello" {
+		t.Errorf("Body = %q, want the rest of the datagram", m.Body)
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	tests := map[string]string{
+		"empty":                  "",
+		"not SIP":                "GET / HTTP/1.1\r\n\r\n",
+		"bad status code":        "SIP/2.0 OK\r\n\r\n",
+		"header without colon":   "BYE sip:a@b SIP/2.0\r\nCall-ID abc\r\n\r\n",
+		"unterminated headers":   "BYE sip:a@b SIP/2.0\r\nCall-ID: abc\r\n",
+		"bad Content-Length":     "BYE sip:a@b SIP/2.0\r\nContent-Length: ten\r\n\r\n",
+		"negative length":        "BYE sip:a@b SIP/2.0\r\nContent-Length: -1\r\n\r\n",
+		"oversized body":         "BYE sip:a@b SIP/2.0\r\nContent-Length: 1000000\r\n\r\n",
+		"body shorter than said": "BYE sip:a@b SIP/2.0\r\nContent-Length: 10\r\n\r\nshort",
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if m, err := Parse([]byte(data)); err == nil {
+				t.Errorf("Parse = %+v, want an error", m)
+			}
+		})
+	}
+}
+
+func TestReadMessageStream(t *testing.T) {
+	bye := "BYE sip:a@b SIP/2.0\r\nCall-ID: 1\r\nCSeq: 2 BYE\r\nContent-Length: 0\r\n\r\n"
+	// A keep-alive, then an INVITE and a BYE back to back
+	r := bufio.NewReader(strings.NewReader("\r\n\r\n" + testInvite + bye))
+
+	invite, err := ReadMessage(r)
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if invite.Method != "INVITE" || string(invite.Body) != testSDP {
+		t.Errorf("first message = %s with body %q", invite.Method, invite.Body)
+	}
+
+	second, err := ReadMessage(r)
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if second.Method != "BYE" || second.Get("Call-ID") != "1" {
+		t.Errorf("second message = %s %q", second.Method, second.Get("Call-ID"))
+	}
+}
+
+// RFC 3261 section 18.3: a stream message's end is only known from its
+// Content-Length
+func TestReadMessageRequiresContentLength(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("OPTIONS sip:a@b SIP/2.0\r\nCall-ID: abc\r\nCSeq: 1 OPTIONS\r\n\r\nOPTIONS sip:a@b SIP/2.0\r\n"))
+
+	m, err := ReadMessage(r)
+	if !errors.Is(err, ErrMissingContentLength) {
+		t.Fatalf("ReadMessage = %v, want ErrMissingContentLength", err)
+	}
+	if m == nil || m.Method != "OPTIONS" || m.Get("Call-ID") != "abc" {
+		t.Fatalf("message = %+v, want its start line and headers to answer it", m)
+	}
+	if res := NewResponse(m, 400, "Missing Content-Length"); res.Get("Call-ID") != "abc" {
+		t.Errorf("response Call-ID = %q", res.Get("Call-ID"))
+	}
+}
+
+func TestBytesRoundTrip(t *testing.T) {
+	req, err := Parse([]byte(testInvite))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := NewResponse(req, 200, "OK")
+	res.Set("To", req.Get("To")+";tag=abc")
+	res.Body = []byte("v=0\r\n")
+
+	got, err := Parse(res.Bytes())
+	if err != nil {
+		t.Fatalf("Parse of an encoded response: %v", err)
+	}
+	if got.StatusCode != 200 || got.Get("Content-Length") != "5" || string(got.Body) != "v=0\r\n" {
+		t.Errorf("round trip = %d, length %q, body %q", got.StatusCode, got.Get("Content-Length"), got.Body)
+	}
+	if vias := got.Values("Via"); len(vias) != 2 || vias[0] != req.Values("Via")[0] {
+		t.Errorf("response Via = %q, want the request's", vias)
+	}
+	if tag := Param(got.Get("To"), "tag"); tag != "abc" {
+		t.Errorf("To tag = %q", tag)
+	}
+	if seq, method := got.CSeq(); seq != 314159 || method != "INVITE" {
+		t.Errorf("CSeq = %d %q", seq, method)
+	}
+}
+
+func TestUser(t *testing.T) {
+	tests := map[string]string{
+		`"Alice" <sip:+15550100@pbx.example.com>;tag=1`: "+15550100",
+		"<sip:alice;ext=1@example.com>":                 "alice",
+		"sip:bob@example.com;tag=2":                     "bob",
+		"<tel:+15550100;phone-context=example.com>":     "+15550100",
+		"<sips:carol@example.com>":                      "carol",
+		"<sip:example.com>":                             "",
+	}
+	for value, want := range tests {
+		if got := User(value); got != want {
+			t.Errorf("User(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package sip
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Static RTP payload types of the G.711 codecs (RFC 3551)
+const (
+	PayloadPCMU = 0
+	PayloadPCMA = 8
+)
+
+// ErrNoAudio is returned for an offer without an audio stream we can play
+var ErrNoAudio = errors.New("no supported audio stream in SDP offer")
+
+// Media is the negotiated audio stream of a call
+type Media struct {
+	Address     string // Where the remote party receives RTP
+	Port        int
+	PayloadType int // PayloadPCMU or PayloadPCMA
+	DTMFType    int // Payload type of RFC 4733 telephone-events, -1 when not offered
+}
+
+// Negotiate picks the first G.711 codec of an SDP offer's audio stream, and
+// telephone-events when offered
+func Negotiate(offer []byte) (Media, error) {
+	media := Media{DTMFType: -1}
+	inAudio := false
+	var formats []int
+	rtpmap := map[int]string{}
+
+	for _, line := range strings.Split(string(offer), "\n") {
+		line = strings.TrimSpace(line)
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "c":
+			// c=IN IP4 192.0.2.1; a media-level line overrides the session one
+			fields := strings.Fields(value)
+			if len(fields) == 3 && (media.Address == "" || inAudio) {
+				media.Address = fields[2]
+			}
+		case "m":
+			// Only the first audio stream is used
+			if inAudio || media.Port != 0 {
+				inAudio = false
+				continue
+			}
+			fields := strings.Fields(value)
+			if len(fields) < 4 || fields[0] != "audio" || !strings.HasPrefix(fields[2], "RTP/AVP") {
+				continue
+			}
+			port, err := strconv.Atoi(fields[1])
+			if err != nil {
+				continue
+			}
+			inAudio, media.Port = true, port
+			for _, f := range fields[3:] {
+				if pt, err := strconv.Atoi(f); err == nil {
+					formats = append(formats, pt)
+				}
+			}
+		case "a":
+			// a=rtpmap:101 telephone-event/8000
+			if !inAudio {
+				continue
+			}
+			if mapping, ok := strings.CutPrefix(value, "rtpmap:"); ok {
+				pt, encoding, _ := strings.Cut(mapping, " ")
+				if n, err := strconv.Atoi(pt); err == nil {
+					rtpmap[n] = strings.ToLower(encoding)
+				}
+			}
+		}
+	}
+
+	if media.Port == 0 || media.Address == "" {
+		return media, ErrNoAudio
+	}
+	media.PayloadType = -1
+	for _, pt := range formats {
+		switch {
+		case media.PayloadType < 0 && (pt == PayloadPCMU || pt == PayloadPCMA):
+			media.PayloadType = pt
+		case media.DTMFType < 0 && rtpmap[pt] == "telephone-event/8000":
+			media.DTMFType = pt
+		}
+	}
+	if media.PayloadType < 0 {
+		return media, ErrNoAudio
+	}
+	return media, nil
+}
+
+// Answer builds the SDP answer to a negotiated offer, receiving RTP at
+// address:port
+func Answer(media Media, address string, port int, sessionID int64) []byte {
+	codec := "PCMU"
+	if media.PayloadType == PayloadPCMA {
+		codec = "PCMA"
+	}
+	formats := strconv.Itoa(media.PayloadType)
+	if media.DTMFType >= 0 {
+		formats += " " + strconv.Itoa(media.DTMFType)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "v=0\r\n")
+	fmt.Fprintf(&b, "o=OpenConverse %d %d IN IP4 %s\r\n", sessionID, sessionID, address)
+	fmt.Fprintf(&b, "s=OpenConverse\r\n")
+	fmt.Fprintf(&b, "c=IN IP4 %s\r\n", address)
+	fmt.Fprintf(&b, "t=0 0\r\n")
+	fmt.Fprintf(&b, "m=audio %d RTP/AVP %s\r\n", port, formats)
+	fmt.Fprintf(&b, "a=rtpmap:%d %s/8000\r\n", media.PayloadType, codec)
+	if media.DTMFType >= 0 {
+		fmt.Fprintf(&b, "a=rtpmap:%d telephone-event/8000\r\n", media.DTMFType)
+		fmt.Fprintf(&b, "a=fmtp:%d 0-15\r\n", media.DTMFType)
+	}
+	fmt.Fprintf(&b, "a=ptime:20\r\n")
+	fmt.Fprintf(&b, "a=sendrecv\r\n")
+	return []byte(b.String())
+}
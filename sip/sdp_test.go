@@ -0,0 +1,108 @@
+package sip
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name  string
+		offer string
+		want  Media
+	}{
+		{
+			name: "PCMU with telephone-events",
+			offer: "v=0\r\nc=IN IP4 192.0.2.10\r\nm=audio 4000 RTP/AVP 0 8 101\r\n" +
+				"a=rtpmap:0 PCMU/8000\r\na=rtpmap:101 telephone-event/8000\r\na=fmtp:101 0-16\r\n",
+			want: Media{Address: "192.0.2.10", Port: 4000, PayloadType: PayloadPCMU, DTMFType: 101},
+		},
+		{
+			name:  "the offerer's preference wins",
+			offer: "v=0\r\nc=IN IP4 192.0.2.10\r\nm=audio 4000 RTP/AVP 18 8 0\r\n",
+			want:  Media{Address: "192.0.2.10", Port: 4000, PayloadType: PayloadPCMA, DTMFType: -1},
+		},
+		{
+			name: "media-level connection overrides the session's",
+			offer: "v=0\r\nc=IN IP4 192.0.2.10\r\nm=video 5000 RTP/AVP 96\r\nc=IN IP4 192.0.2.99\r\n" +
+				"m=audio 4002 RTP/AVP 0\r\nc=IN IP4 192.0.2.20\r\n",
+			want: Media{Address: "192.0.2.20", Port: 4002, PayloadType: PayloadPCMU, DTMFType: -1},
+		},
+		{
+			name: "only the first audio stream",
+			offer: "v=0\nc=IN IP4 192.0.2.10\nm=audio 4000 RTP/AVP 8\nm=audio 4100 RTP/AVP 0 101\n" +
+				"a=rtpmap:101 telephone-event/8000\n",
+			want: Media{Address: "192.0.2.10", Port: 4000, PayloadType: PayloadPCMA, DTMFType: -1},
+		},
+		{
+			name:  "telephone-events of another rate are ignored",
+			offer: "v=0\r\nc=IN IP4 192.0.2.10\r\nm=audio 4000 RTP/AVP 0 100\r\na=rtpmap:100 telephone-event/48000\r\n",
+			want:  Media{Address: "192.0.2.10", Port: 4000, PayloadType: PayloadPCMU, DTMFType: -1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Negotiate([]byte(tt.offer))
+			if err != nil {
+				t.Fatalf("Negotiate: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Negotiate = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNegotiateNoAudio(t *testing.T) {
+	tests := map[string]string{
+		"empty":            "",
+		"no G.711":         "v=0\r\nc=IN IP4 192.0.2.10\r\nm=audio 4000 RTP/AVP 9 18\r\n",
+		"no connection":    "v=0\r\nm=audio 4000 RTP/AVP 0\r\n",
+		"video only":       "v=0\r\nc=IN IP4 192.0.2.10\r\nm=video 5000 RTP/AVP 96\r\n",
+		"secure RTP":       "v=0\r\nc=IN IP4 192.0.2.10\r\nm=audio 4000 UDP/TLS/RTP/SAVP 0\r\n",
+		"bad port":         "v=0\r\nc=IN IP4 192.0.2.10\r\nm=audio x RTP/AVP 0\r\n",
+		"rejected stream":  "v=0\r\nc=IN IP4 192.0.2.10\r\nm=audio 0 RTP/AVP 0\r\n",
+		"no payload types": "v=0\r\nc=IN IP4 192.0.2.10\r\nm=audio 4000 RTP/AVP\r\n",
+	}
+	for name, offer := range tests {
+		t.Run(name, func(t *testing.T) {
+			if media, err := Negotiate([]byte(offer)); !errors.Is(err, ErrNoAudio) {
+				t.Errorf("Negotiate = %+v, %v; want ErrNoAudio", media, err)
+			}
+		})
+	}
+}
+
+func TestAnswer(t *testing.T) {
+	offered := Media{Address: "192.0.2.10", Port: 4000, PayloadType: PayloadPCMA, DTMFType: 101}
+	answer := Answer(offered, "198.51.100.1", 10002, 42)
+
+	for _, line := range []string{
+		"o=OpenConverse 42 42 IN IP4 198.51.100.1\r\n",
+		"m=audio 10002 RTP/AVP 8 101\r\n",
+		"a=rtpmap:8 PCMA/8000\r\n",
+		"a=rtpmap:101 telephone-event/8000\r\n",
+		"a=fmtp:101 0-15\r\n",
+		"a=ptime:20\r\n",
+	} {
+		if !strings.Contains(string(answer), line) {
+			t.Errorf("answer lacks %q:\n%s", line, answer)
+		}
+	}
+
+	// The answer is itself an offer we accept, for the same media
+	got, err := Negotiate(answer)
+	if err != nil {
+		t.Fatalf("Negotiate of the answer: %v", err)
+	}
+	if want := (Media{Address: "198.51.100.1", Port: 10002, PayloadType: PayloadPCMA, DTMFType: 101}); got != want {
+		t.Errorf("answer negotiates %+v, want %+v", got, want)
+	}
+
+	// Without telephone-events only the codec is answered
+	plain := string(Answer(Media{PayloadType: PayloadPCMU, DTMFType: -1}, "198.51.100.1", 10002, 42))
+	if !strings.Contains(plain, "m=audio 10002 RTP/AVP 0\r\n") || strings.Contains(plain, "telephone-event") {
+		t.Errorf("answer without DTMF:\n%s", plain)
+	}
+}
@@ -0,0 +1,36 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/room4-2/OpenConverse/config"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// FromConfig builds the session store selected by SESSION_STORE
+func FromConfig(cfg *config.Config) (SessionStore, error) {
+	switch cfg.SessionStore {
+	case "memory":
+		return NewMemory(), nil
+	case "redis":
+		redis.SetLogger(redisLogger{})
+		return NewRedis(redis.NewClient(&redis.Options{
+			Addr:     cfg.RedisURL,
+			Password: cfg.RedisPassword,
+			DB:       0,
+		})), nil
+	default:
+		return nil, fmt.Errorf("unknown session store %q", cfg.SessionStore)
+	}
+}
+
+// redisLogger sends go-redis's own messages, such as failed reconnect
+// attempts, to the debug log; outages are reported once by the store
+type redisLogger struct{}
+
+func (redisLogger) Printf(ctx context.Context, format string, v ...interface{}) {
+	slog.DebugContext(ctx, fmt.Sprintf(format, v...), "component", "redis")
+}
@@ -0,0 +1,276 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory is a SessionStore kept in process memory. It suits a single
+// instance and tests; nothing survives a restart.
+type Memory struct {
+	mu          sync.Mutex
+	active      map[string]activeEntry // Registered sessions by ID
+	records     map[string]Record
+	counters    map[string]map[string]int64
+	nodes       map[string]Node
+	claims      map[string]time.Time // Claimed keys by expiry
+	subscribers []chan string
+}
+
+// activeEntry is a registered session and its last heartbeat
+type activeEntry struct {
+	tenant string
+	seen   time.Time
+}
+
+// NewMemory creates an empty in-memory store
+func NewMemory() *Memory {
+	return &Memory{
+		active:   make(map[string]activeEntry),
+		records:  make(map[string]Record),
+		counters: make(map[string]map[string]int64),
+		nodes:    make(map[string]Node),
+		claims:   make(map[string]time.Time),
+	}
+}
+
+func (m *Memory) Name() string  { return "memory" }
+func (m *Memory) Healthy() bool { return true }
+
+func (m *Memory) Reserve(ctx context.Context, sessionID, tenantID string, limits Limits) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if limits.Cluster > 0 && len(m.active) >= limits.Cluster {
+		return ErrClusterFull
+	}
+	if tenantID != "" && limits.Tenant > 0 {
+		count := 0
+		for _, entry := range m.active {
+			if entry.tenant == tenantID {
+				count++
+			}
+		}
+		if count >= limits.Tenant {
+			return ErrTenantFull
+		}
+	}
+
+	m.active[sessionID] = activeEntry{tenant: tenantID, seen: clock()}
+	return nil
+}
+
+func (m *Memory) Release(ctx context.Context, sessionID, tenantID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.active, sessionID)
+	return nil
+}
+
+func (m *Memory) Put(ctx context.Context, record Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.active[record.ID] = activeEntry{tenant: record.Tenant, seen: clock()}
+	m.records[record.ID] = copyRecord(record)
+	return nil
+}
+
+func (m *Memory) Touch(ctx context.Context, node Node, records []Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := clock()
+	node.Heartbeat = now
+	m.nodes[node.ID] = node
+	for _, record := range records {
+		// The session may have ended since the caller listed it
+		if stored, ok := m.records[record.ID]; !ok || stored.Status == StatusClosed {
+			continue
+		}
+		record.Status = StatusActive
+		m.active[record.ID] = activeEntry{tenant: record.Tenant, seen: now}
+		m.records[record.ID] = copyRecord(record)
+	}
+	m.pruneRecords(now)
+	return nil
+}
+
+func (m *Memory) Delete(ctx context.Context, record Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.active, record.ID)
+	m.records[record.ID] = copyRecord(record)
+	return nil
+}
+
+func (m *Memory) Get(ctx context.Context, sessionID string) (Record, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.active[sessionID]; !ok {
+		return Record{}, false, nil
+	}
+	record, ok := m.records[sessionID]
+	if !ok {
+		return Record{}, false, nil
+	}
+	return copyRecord(record), true, nil
+}
+
+func (m *Memory) List(ctx context.Context) ([]Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	records := make([]Record, 0, len(m.active))
+	for id := range m.active {
+		if record, ok := m.records[id]; ok {
+			records = append(records, copyRecord(record))
+		}
+	}
+	return records, nil
+}
+
+func (m *Memory) AddCounters(ctx context.Context, name string, values map[string]int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counters, ok := m.counters[name]
+	if !ok {
+		counters = make(map[string]int64, len(values))
+		m.counters[name] = counters
+	}
+	for field, value := range values {
+		counters[field] += value
+	}
+	return nil
+}
+
+func (m *Memory) Counters(ctx context.Context, name string) (map[string]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return copyCounters(m.counters[name]), nil
+}
+
+func (m *Memory) Reap(ctx context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := clock()
+	reaped := 0
+	for id, entry := range m.active {
+		if now.Sub(entry.seen) < HeartbeatTTL {
+			continue
+		}
+		delete(m.active, id)
+		reaped++
+		if record, ok := m.records[id]; ok {
+			record.Status = StatusOrphaned
+			record.EndedAt = now
+			m.records[id] = record
+		}
+	}
+	for id, node := range m.nodes {
+		if now.Sub(node.Heartbeat) >= HeartbeatTTL {
+			delete(m.nodes, id)
+		}
+	}
+	return reaped, nil
+}
+
+func (m *Memory) Nodes(ctx context.Context) ([]Node, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	nodes := make([]Node, 0, len(m.nodes))
+	for _, node := range m.nodes {
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+func (m *Memory) Leave(ctx context.Context, nodeID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.nodes, nodeID)
+	return nil
+}
+
+func (m *Memory) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := clock()
+	for k, expiry := range m.claims {
+		if !now.Before(expiry) {
+			delete(m.claims, k)
+		}
+	}
+	if _, claimed := m.claims[key]; claimed {
+		return false, nil
+	}
+	m.claims[key] = now.Add(ttl)
+	return true, nil
+}
+
+func (m *Memory) Terminate(ctx context.Context, sessionID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, ch := range m.subscribers {
+		select {
+		case ch <- sessionID:
+		default: // Subscriber is busy; the session is ended by its operator retrying
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Terminations(ctx context.Context) <-chan string {
+	ch := make(chan string, 16)
+
+	m.mu.Lock()
+	m.subscribers = append(m.subscribers, ch)
+	m.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		for i, sub := range m.subscribers {
+			if sub == ch {
+				m.subscribers = append(m.subscribers[:i], m.subscribers[i+1:]...)
+				break
+			}
+		}
+		close(ch)
+	}()
+	return ch
+}
+
+func (m *Memory) Close() error { return nil }
+
+// pruneRecords drops finished records older than RecordRetention. Must be called with m.mu held.
+func (m *Memory) pruneRecords(now time.Time) {
+	for id, record := range m.records {
+		if record.Status != StatusActive && !record.EndedAt.IsZero() && now.Sub(record.EndedAt) > RecordRetention {
+			delete(m.records, id)
+		}
+	}
+}
+
+func copyRecord(record Record) Record {
+	record.Counters = copyCounters(record.Counters)
+	record.Turns = append([][]byte(nil), record.Turns...)
+	return record
+}
+
+func copyCounters(src map[string]int64) map[string]int64 {
+	dst := make(map[string]int64, len(src))
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
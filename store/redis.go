@@ -0,0 +1,538 @@
+package store
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis keys. They all share the {openconverse} hash tag, so on Redis Cluster
+// they live in one slot and the scripts and transactions touching several of
+// them don't fail with CROSSSLOT.
+const (
+	keyPrefix         = "{openconverse}:"
+	activeSessionsKey = keyPrefix + "sessions:active"  // Sorted set of session IDs scored by last heartbeat (unix ms)
+	tenantSessionsKey = keyPrefix + "sessions:tenant:" // + tenant ID: sorted set of that tenant's session IDs
+	sessionKey        = keyPrefix + "session:"         // + session ID: hash with the session record
+	nodesKey          = keyPrefix + "nodes"            // Sorted set of node IDs scored by last heartbeat (unix ms)
+	nodeKey           = keyPrefix + "node:"            // + node ID: hash describing the node
+	claimKey          = keyPrefix + "claim:"           // + claimed key: set while the claim lasts
+	counterKey        = keyPrefix                      // + counter name: hash of the counters
+	terminateChannel  = "sessions:terminate"           // Pub/sub channel carrying IDs of sessions to terminate
+)
+
+const (
+	activeRecordTTL     = 10 * time.Minute // Expiry of an active session's record, refreshed by Touch
+	healthCheckInterval = 5 * time.Second  // How often the connection is checked
+	healthCheckTimeout  = 2 * time.Second
+)
+
+// recordFields are the hash fields of a session record that aren't counters
+var recordFields = map[string]bool{
+	"node": true, "transport": true, "is_twilio": true, "agent": true, "tenant": true, "caller": true,
+	"call_sid": true, "call": true, "status": true, "created_at": true, "last_activity": true, "ended_at": true,
+}
+
+// reserveScript atomically checks the session limits and registers a session.
+// KEYS: active set and, for tenant sessions, the tenant set.
+// ARGV: now, stale-before, cluster limit, tenant limit (0 = unlimited), session ID.
+// Returns 0 when registered, 1 when the cluster is full and 2 when the tenant is.
+var reserveScript = redis.NewScript(`
+local max = tonumber(ARGV[3])
+if max > 0 and redis.call('ZCOUNT', KEYS[1], ARGV[2], '+inf') >= max then
+	return 1
+end
+if #KEYS > 1 then
+	redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', '(' .. ARGV[2])
+	local tenantMax = tonumber(ARGV[4])
+	if tenantMax > 0 and redis.call('ZCARD', KEYS[2]) >= tenantMax then
+		return 2
+	end
+	redis.call('ZADD', KEYS[2], ARGV[1], ARGV[5])
+end
+redis.call('ZADD', KEYS[1], ARGV[1], ARGV[5])
+return 0
+`)
+
+// touchScript refreshes a session's record and registration, unless the
+// record is missing or the session was closed since the caller listed it.
+// KEYS: session hash, active set and, for tenant sessions, the tenant set.
+// ARGV: heartbeat score, record expiry (ms), session ID, then the record's
+// field/value pairs.
+// Returns 1 when refreshed and 0 when skipped.
+var touchScript = redis.NewScript(`
+local status = redis.call('HGET', KEYS[1], 'status')
+if not status or status == 'closed' then
+	return 0
+end
+redis.call('HSET', KEYS[1], unpack(ARGV, 4))
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+for i = 2, #KEYS do
+	redis.call('ZADD', KEYS[i], ARGV[1], ARGV[3])
+end
+return 1
+`)
+
+// Redis is a SessionStore shared by every instance using the same Redis.
+// The connection is checked in the background: while Redis is unreachable
+// operations fail fast with ErrUnavailable, and the next Touch after it
+// comes back refreshes the records of the sessions still running.
+type Redis struct {
+	client  redis.UniversalClient
+	healthy atomic.Bool
+	done    chan struct{}
+}
+
+// NewRedis creates a store on client and starts checking its connection
+func NewRedis(client redis.UniversalClient) *Redis {
+	r := &Redis{
+		client: client,
+		done:   make(chan struct{}),
+	}
+	r.healthy.Store(true)
+	r.checkHealth()
+	go r.monitor()
+	return r
+}
+
+func (r *Redis) Name() string  { return "redis" }
+func (r *Redis) Healthy() bool { return r.healthy.Load() }
+
+// monitor checks the connection until Close
+func (r *Redis) monitor() {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			r.checkHealth()
+		}
+	}
+}
+
+// checkHealth pings Redis and logs when it becomes unreachable or comes back
+func (r *Redis) checkHealth() {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+
+	err := r.client.Ping(ctx).Err()
+	healthy := err == nil
+	if r.healthy.Swap(healthy) == healthy {
+		return
+	}
+	if healthy {
+		slog.Info("Session store reconnected", "store", r.Name())
+	} else {
+		slog.Warn("Session store unavailable, retrying", "store", r.Name(), "error", err)
+	}
+}
+
+// available fails fast while Redis is unreachable
+func (r *Redis) available() error {
+	if !r.healthy.Load() {
+		return ErrUnavailable
+	}
+	return nil
+}
+
+func (r *Redis) Reserve(ctx context.Context, sessionID, tenantID string, limits Limits) error {
+	if err := r.available(); err != nil {
+		return err
+	}
+
+	keys := []string{activeSessionsKey}
+	if tenantID != "" {
+		keys = append(keys, tenantSessionsKey+tenantID)
+	}
+
+	now := clock()
+	result, err := reserveScript.Run(ctx, r.client, keys,
+		now.UnixMilli(), now.Add(-HeartbeatTTL).UnixMilli(), limits.Cluster, limits.Tenant, sessionID).Int()
+	if err != nil {
+		return err
+	}
+
+	switch result {
+	case 1:
+		return ErrClusterFull
+	case 2:
+		return ErrTenantFull
+	}
+	return nil
+}
+
+func (r *Redis) Release(ctx context.Context, sessionID, tenantID string) error {
+	if err := r.available(); err != nil {
+		return err
+	}
+
+	pipe := r.client.Pipeline()
+	removeActive(ctx, pipe, sessionID, tenantID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *Redis) Put(ctx context.Context, record Record) error {
+	if err := r.available(); err != nil {
+		return err
+	}
+
+	score := float64(clock().UnixMilli())
+	pipe := r.client.Pipeline()
+	putActive(ctx, pipe, record, score)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *Redis) Touch(ctx context.Context, node Node, records []Record) error {
+	if err := r.available(); err != nil {
+		return err
+	}
+
+	now := clock()
+	score := float64(now.UnixMilli())
+
+	pipe := r.client.Pipeline()
+	pipe.ZAdd(ctx, nodesKey, redis.Z{Score: score, Member: node.ID})
+	pipe.HSet(ctx, nodeKey+node.ID, map[string]interface{}{
+		"started_at": node.StartedAt.Format(time.RFC3339),
+		"heartbeat":  now.Format(time.RFC3339),
+		"sessions":   node.Sessions,
+	})
+	pipe.Expire(ctx, nodeKey+node.ID, HeartbeatTTL)
+	for _, record := range records {
+		touchActive(ctx, pipe, record, score)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *Redis) Delete(ctx context.Context, record Record) error {
+	if err := r.available(); err != nil {
+		return err
+	}
+
+	key := sessionKey + record.ID
+	pipe := r.client.Pipeline()
+	pipe.HSet(ctx, key, recordHash(record))
+	pipe.Expire(ctx, key, RecordRetention)
+	if len(record.Turns) > 0 {
+		entries := make([]interface{}, len(record.Turns))
+		for i, turn := range record.Turns {
+			entries[i] = turn
+		}
+		pipe.RPush(ctx, key+":turns", entries...)
+		pipe.Expire(ctx, key+":turns", RecordRetention)
+	}
+	removeActive(ctx, pipe, record.ID, record.Tenant)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *Redis) Get(ctx context.Context, sessionID string) (Record, bool, error) {
+	if err := r.available(); err != nil {
+		return Record{}, false, err
+	}
+
+	hash, err := r.client.HGetAll(ctx, sessionKey+sessionID).Result()
+	if err != nil {
+		return Record{}, false, err
+	}
+	if len(hash) == 0 || hash["status"] != StatusActive {
+		return Record{}, false, nil
+	}
+	return parseRecord(sessionID, hash), true, nil
+}
+
+func (r *Redis) List(ctx context.Context) ([]Record, error) {
+	if err := r.available(); err != nil {
+		return nil, err
+	}
+
+	ids, err := r.client.ZRangeByScore(ctx, activeSessionsKey, &redis.ZRangeBy{Min: staleBefore(), Max: "+inf"}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := r.client.Pipeline()
+	hashes := make([]*redis.MapStringStringCmd, len(ids))
+	for i, id := range ids {
+		hashes[i] = pipe.HGetAll(ctx, sessionKey+id)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	records := make([]Record, 0, len(ids))
+	for i, id := range ids {
+		// Reserved sessions still connecting have no record yet
+		if hash := hashes[i].Val(); len(hash) > 0 {
+			records = append(records, parseRecord(id, hash))
+		}
+	}
+	return records, nil
+}
+
+func (r *Redis) AddCounters(ctx context.Context, name string, values map[string]int64) error {
+	if err := r.available(); err != nil {
+		return err
+	}
+
+	pipe := r.client.Pipeline()
+	for field, value := range values {
+		pipe.HIncrBy(ctx, counterKey+name, field, value)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *Redis) Counters(ctx context.Context, name string) (map[string]int64, error) {
+	if err := r.available(); err != nil {
+		return nil, err
+	}
+
+	hash, err := r.client.HGetAll(ctx, counterKey+name).Result()
+	if err != nil {
+		return nil, err
+	}
+	counters := make(map[string]int64, len(hash))
+	for field, value := range hash {
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			counters[field] = n
+		}
+	}
+	return counters, nil
+}
+
+// Reap is run by every node; ZREM decides which one handles each orphan
+func (r *Redis) Reap(ctx context.Context) (int, error) {
+	if err := r.available(); err != nil {
+		return 0, err
+	}
+
+	stale := "(" + staleBefore()
+	orphans, err := r.client.ZRangeByScore(ctx, activeSessionsKey, &redis.ZRangeBy{Min: "-inf", Max: stale}).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	reaped := 0
+	for _, sessionID := range orphans {
+		removed, err := r.client.ZRem(ctx, activeSessionsKey, sessionID).Result()
+		if err != nil {
+			return reaped, err
+		}
+		if removed == 0 {
+			continue // Another node got it first
+		}
+		reaped++
+
+		key := sessionKey + sessionID
+		tenantID, err := r.client.HGet(ctx, key, "tenant").Result()
+		if err != nil && err != redis.Nil {
+			return reaped, err
+		}
+		pipe := r.client.Pipeline()
+		if tenantID != "" {
+			pipe.ZRem(ctx, tenantSessionsKey+tenantID, sessionID)
+		}
+		pipe.HSet(ctx, key, map[string]interface{}{
+			"status":   StatusOrphaned,
+			"ended_at": clock().Format(time.RFC3339),
+		})
+		pipe.Expire(ctx, key, RecordRetention)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return reaped, err
+		}
+	}
+
+	if err := r.client.ZRemRangeByScore(ctx, nodesKey, "-inf", stale).Err(); err != nil {
+		return reaped, err
+	}
+	return reaped, nil
+}
+
+func (r *Redis) Nodes(ctx context.Context) ([]Node, error) {
+	if err := r.available(); err != nil {
+		return nil, err
+	}
+
+	ids, err := r.client.ZRangeByScore(ctx, nodesKey, &redis.ZRangeBy{Min: staleBefore(), Max: "+inf"}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]Node, 0, len(ids))
+	for _, id := range ids {
+		hash, err := r.client.HGetAll(ctx, nodeKey+id).Result()
+		if err != nil {
+			return nil, err
+		}
+		node := Node{ID: id}
+		node.StartedAt, _ = time.Parse(time.RFC3339, hash["started_at"])
+		node.Heartbeat, _ = time.Parse(time.RFC3339, hash["heartbeat"])
+		node.Sessions, _ = strconv.Atoi(hash["sessions"])
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+func (r *Redis) Leave(ctx context.Context, nodeID string) error {
+	if err := r.available(); err != nil {
+		return err
+	}
+
+	pipe := r.client.Pipeline()
+	pipe.ZRem(ctx, nodesKey, nodeID)
+	pipe.Del(ctx, nodeKey+nodeID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *Redis) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	if err := r.available(); err != nil {
+		return false, err
+	}
+	return r.client.SetNX(ctx, claimKey+key, 1, ttl).Result()
+}
+
+func (r *Redis) Terminate(ctx context.Context, sessionID string) error {
+	if err := r.available(); err != nil {
+		return err
+	}
+	return r.client.Publish(ctx, terminateChannel, sessionID).Err()
+}
+
+// Terminations subscribes to the terminate channel; the subscription
+// reconnects by itself after Redis outages
+func (r *Redis) Terminations(ctx context.Context) <-chan string {
+	ids := make(chan string)
+	pubsub := r.client.Subscribe(ctx, terminateChannel)
+
+	go func() {
+		defer close(ids)
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				select {
+				case ids <- msg.Payload:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ids
+}
+
+func (r *Redis) Close() error {
+	close(r.done)
+	return r.client.Close()
+}
+
+// putActive writes an active session's record and registers it with a heartbeat score
+func putActive(ctx context.Context, pipe redis.Pipeliner, record Record, score float64) {
+	record.Status = StatusActive
+	pipe.HSet(ctx, sessionKey+record.ID, recordHash(record))
+	pipe.Expire(ctx, sessionKey+record.ID, activeRecordTTL)
+	pipe.ZAdd(ctx, activeSessionsKey, redis.Z{Score: score, Member: record.ID})
+	if record.Tenant != "" {
+		pipe.ZAdd(ctx, tenantSessionsKey+record.Tenant, redis.Z{Score: score, Member: record.ID})
+	}
+}
+
+// touchActive refreshes an active session's record and heartbeat score,
+// unless the session ended since the record was taken
+func touchActive(ctx context.Context, pipe redis.Pipeliner, record Record, score float64) {
+	record.Status = StatusActive
+	keys := []string{sessionKey + record.ID, activeSessionsKey}
+	if record.Tenant != "" {
+		keys = append(keys, tenantSessionsKey+record.Tenant)
+	}
+	args := []interface{}{score, activeRecordTTL.Milliseconds(), record.ID}
+	for field, value := range recordHash(record) {
+		args = append(args, field, value)
+	}
+	// Eval rather than Run: a pipeline can't fall back to EVAL when the script isn't cached
+	touchScript.Eval(ctx, pipe, keys, args...)
+}
+
+// removeActive unregisters a session
+func removeActive(ctx context.Context, pipe redis.Pipeliner, sessionID, tenantID string) {
+	pipe.ZRem(ctx, activeSessionsKey, sessionID)
+	if tenantID != "" {
+		pipe.ZRem(ctx, tenantSessionsKey+tenantID, sessionID)
+	}
+}
+
+// staleBefore is the heartbeat score below which sessions and nodes are orphaned
+func staleBefore() string {
+	return strconv.FormatInt(clock().Add(-HeartbeatTTL).UnixMilli(), 10)
+}
+
+// recordHash flattens a record into hash fields
+func recordHash(record Record) map[string]interface{} {
+	hash := map[string]interface{}{
+		"node":          record.Node,
+		"transport":     record.Transport,
+		"is_twilio":     record.Transport == "twilio",
+		"agent":         record.Agent,
+		"tenant":        record.Tenant,
+		"caller":        record.Caller,
+		"call_sid":      record.CallSID,
+		"call":          record.Call,
+		"status":        record.Status,
+		"created_at":    record.CreatedAt.Format(time.RFC3339),
+		"last_activity": record.LastActivity.Format(time.RFC3339),
+	}
+	if !record.EndedAt.IsZero() {
+		hash["ended_at"] = record.EndedAt.Format(time.RFC3339)
+	}
+	for field, value := range record.Counters {
+		hash[field] = value
+	}
+	return hash
+}
+
+// parseRecord rebuilds a record from its hash fields
+func parseRecord(sessionID string, hash map[string]string) Record {
+	record := Record{
+		ID:        sessionID,
+		Node:      hash["node"],
+		Transport: hash["transport"],
+		Agent:     hash["agent"],
+		Tenant:    hash["tenant"],
+		Caller:    hash["caller"],
+		CallSID:   hash["call_sid"],
+		Call:      hash["call"],
+		Status:    hash["status"],
+		Counters:  make(map[string]int64),
+	}
+	record.CreatedAt, _ = time.Parse(time.RFC3339, hash["created_at"])
+	record.LastActivity, _ = time.Parse(time.RFC3339, hash["last_activity"])
+	record.EndedAt, _ = time.Parse(time.RFC3339, hash["ended_at"])
+	for field, value := range hash {
+		if recordFields[field] {
+			continue
+		}
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			record.Counters[field] = n
+		}
+	}
+	return record
+}
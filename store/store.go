@@ -0,0 +1,119 @@
+// Package store persists session records, usage counters and the
+// cluster-wide session registry shared by server instances
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Session record statuses
+const (
+	StatusActive   = "active"
+	StatusClosed   = "closed"
+	StatusOrphaned = "orphaned" // The session's node stopped heartbeating
+)
+
+const (
+	// HeartbeatTTL is how long sessions and nodes stay registered without a Touch
+	HeartbeatTTL = 45 * time.Second
+	// RecordRetention is how long a finished session's record is kept for billing
+	RecordRetention = 24 * time.Hour
+)
+
+// clock returns the current time; tests move it forward to expire heartbeats
+var clock = time.Now
+
+var (
+	// ErrUnavailable is returned while the store can't be reached
+	ErrUnavailable = errors.New("session store unavailable")
+	// ErrClusterFull is returned by Reserve when the cluster runs its maximum number of sessions
+	ErrClusterFull = errors.New("cluster session limit reached")
+	// ErrTenantFull is returned by Reserve when the tenant runs its maximum number of sessions
+	ErrTenantFull = errors.New("tenant concurrent session limit reached")
+)
+
+// Record is the stored state of a session
+type Record struct {
+	ID           string
+	Node         string // Instance running the session
+	Transport    string
+	Agent        string
+	Tenant       string
+	Caller       string
+	CallSID      string // The provider's call identifier, when known
+	Call         string // JSON-encoded call metadata reported by the provider, when known
+	Status       string
+	CreatedAt    time.Time
+	LastActivity time.Time
+	EndedAt      time.Time
+	Counters     map[string]int64 // Token usage and turn latency fields, e.g. "total_tokens"
+	Turns        [][]byte         // JSON-encoded turn latencies, stored when the session ends
+}
+
+// Node is a server instance registered in the store
+type Node struct {
+	ID        string
+	StartedAt time.Time
+	Heartbeat time.Time
+	Sessions  int
+}
+
+// Limits are the concurrent session limits checked by Reserve (0 = unlimited)
+type Limits struct {
+	Cluster int // Sessions across all nodes
+	Tenant  int // Sessions of the reserving tenant across all nodes
+}
+
+// SessionStore keeps track of the sessions of every node sharing it.
+// Implementations must be safe for concurrent use.
+type SessionStore interface {
+	// Name identifies the implementation, e.g. "redis"
+	Name() string
+	// Healthy reports whether the store is currently reachable
+	Healthy() bool
+
+	// Reserve registers a session that is being created, within limits
+	Reserve(ctx context.Context, sessionID, tenantID string, limits Limits) error
+	// Release frees a reservation whose session never started
+	Release(ctx context.Context, sessionID, tenantID string) error
+	// Put writes the record of an active session
+	Put(ctx context.Context, record Record) error
+	// Touch refreshes node and its active sessions, keeping them registered.
+	// Records that were never Put or were since Deleted are left alone.
+	Touch(ctx context.Context, node Node, records []Record) error
+	// Delete ends a session: it leaves the active sessions and its final
+	// record is kept for RecordRetention
+	Delete(ctx context.Context, record Record) error
+	// Get returns the record of an active session on any node
+	Get(ctx context.Context, sessionID string) (Record, bool, error)
+	// List returns the records of active sessions on every node
+	List(ctx context.Context) ([]Record, error)
+
+	// AddCounters adds values to the named counters (e.g. "usage:agent:default")
+	AddCounters(ctx context.Context, name string, values map[string]int64) error
+	// Counters returns the named counters
+	Counters(ctx context.Context, name string) (map[string]int64, error)
+
+	// Reap marks sessions and nodes that stopped heartbeating as orphaned and
+	// removes them, returning how many sessions were reaped
+	Reap(ctx context.Context) (int, error)
+	// Nodes returns the nodes that heartbeated within HeartbeatTTL
+	Nodes(ctx context.Context) ([]Node, error)
+	// Leave removes a node on shutdown
+	Leave(ctx context.Context, nodeID string) error
+
+	// Claim records key for ttl and reports whether this call recorded it,
+	// false when any node already claimed it within its ttl. Stream tokens
+	// use it to stay one-time across nodes.
+	Claim(ctx context.Context, key string, ttl time.Duration) (bool, error)
+
+	// Terminate asks the node running sessionID to end it
+	Terminate(ctx context.Context, sessionID string) error
+	// Terminations delivers the IDs passed to Terminate by any node until ctx is done
+	Terminations(ctx context.Context) <-chan string
+
+	// Close releases the store's connections
+	Close() error
+}
@@ -0,0 +1,377 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// Every SessionStore must pass the same contract
+func TestMemoryContract(t *testing.T) {
+	testContract(t, func(t *testing.T) SessionStore { return NewMemory() })
+}
+
+func TestRedisContract(t *testing.T) {
+	testContract(t, func(t *testing.T) SessionStore {
+		server := miniredis.RunT(t)
+		return NewRedis(redis.NewClient(&redis.Options{Addr: server.Addr()}))
+	})
+}
+
+// Every key shares the {openconverse} hash tag, so Redis Cluster keeps them
+// in one slot and multi-key scripts don't fail with CROSSSLOT
+func TestRedisKeysShareSlot(t *testing.T) {
+	server := miniredis.RunT(t)
+	s := NewRedis(redis.NewClient(&redis.Options{Addr: server.Addr()}))
+	t.Cleanup(func() { _ = s.Close() })
+	ctx := context.Background()
+
+	record := Record{ID: "s1", Node: "node-1", Tenant: "acme", CreatedAt: clock(), LastActivity: clock()}
+	steps := []error{
+		s.Reserve(ctx, record.ID, record.Tenant, Limits{Cluster: 10, Tenant: 10}),
+		s.Put(ctx, record),
+		s.Touch(ctx, Node{ID: "node-1", StartedAt: clock()}, []Record{record}),
+		s.AddCounters(ctx, "usage:tenant:acme", map[string]int64{"turns": 1}),
+	}
+	for _, err := range steps {
+		if err != nil {
+			t.Fatalf("store operation failed: %v", err)
+		}
+	}
+	if _, err := s.Claim(ctx, "stream-token:abc", time.Minute); err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+
+	keys := server.Keys()
+	if len(keys) == 0 {
+		t.Fatal("no keys written")
+	}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, "{openconverse}:") {
+			t.Errorf("key %q is outside the {openconverse} hash slot", key)
+		}
+	}
+}
+
+func testContract(t *testing.T, newStore func(t *testing.T) SessionStore) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s SessionStore)
+	}{
+		{"ReserveClusterLimit", testReserveClusterLimit},
+		{"ReserveTenantLimit", testReserveTenantLimit},
+		{"PutGetListDelete", testPutGetListDelete},
+		{"TouchSkipsEndedSessions", testTouchSkipsEndedSessions},
+		{"Reap", testReap},
+		{"Counters", testCounters},
+		{"Terminations", testTerminations},
+		{"Claim", testClaim},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setClock(t, time.Now())
+			s := newStore(t)
+			t.Cleanup(func() { _ = s.Close() })
+			tt.run(t, s)
+		})
+	}
+}
+
+// setClock freezes the store's clock at now for the duration of the test
+func setClock(t *testing.T, now time.Time) {
+	t.Helper()
+	prev := clock
+	clock = func() time.Time { return now }
+	t.Cleanup(func() { clock = prev })
+}
+
+// advance moves the store's clock forward by d
+func advance(d time.Duration) {
+	now := clock().Add(d)
+	clock = func() time.Time { return now }
+}
+
+func testRecord(id, tenant string) Record {
+	now := clock()
+	return Record{
+		ID:           id,
+		Node:         "node-1",
+		Transport:    "websocket",
+		Agent:        "default",
+		Tenant:       tenant,
+		Caller:       "+15550100",
+		Status:       StatusActive,
+		CreatedAt:    now,
+		LastActivity: now,
+		Counters:     map[string]int64{"total_tokens": 42},
+	}
+}
+
+func testReserveClusterLimit(t *testing.T, s SessionStore) {
+	ctx := context.Background()
+	limits := Limits{Cluster: 2}
+
+	for _, id := range []string{"s1", "s2"} {
+		if err := s.Reserve(ctx, id, "", limits); err != nil {
+			t.Fatalf("Reserve(%s): %v", id, err)
+		}
+	}
+	if err := s.Reserve(ctx, "s3", "", limits); !errors.Is(err, ErrClusterFull) {
+		t.Fatalf("Reserve over the cluster limit = %v, want ErrClusterFull", err)
+	}
+
+	if err := s.Release(ctx, "s1", ""); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if err := s.Reserve(ctx, "s3", "", limits); err != nil {
+		t.Fatalf("Reserve after Release: %v", err)
+	}
+	if err := s.Reserve(ctx, "s4", "", Limits{}); err != nil {
+		t.Fatalf("Reserve without limits: %v", err)
+	}
+}
+
+func testReserveTenantLimit(t *testing.T, s SessionStore) {
+	ctx := context.Background()
+	limits := Limits{Cluster: 10, Tenant: 1}
+
+	if err := s.Reserve(ctx, "a1", "acme", limits); err != nil {
+		t.Fatalf("Reserve: %v", err)
+	}
+	if err := s.Reserve(ctx, "a2", "acme", limits); !errors.Is(err, ErrTenantFull) {
+		t.Fatalf("Reserve over the tenant limit = %v, want ErrTenantFull", err)
+	}
+	if err := s.Reserve(ctx, "b1", "globex", limits); err != nil {
+		t.Fatalf("Reserve for another tenant: %v", err)
+	}
+
+	// A tenant's stale sessions don't count against its limit
+	advance(HeartbeatTTL + time.Second)
+	if _, err := s.Reap(ctx); err != nil {
+		t.Fatalf("Reap: %v", err)
+	}
+	if err := s.Reserve(ctx, "a2", "acme", limits); err != nil {
+		t.Fatalf("Reserve after the tenant's session went stale: %v", err)
+	}
+}
+
+func testPutGetListDelete(t *testing.T, s SessionStore) {
+	ctx := context.Background()
+
+	if _, ok, err := s.Get(ctx, "s1"); err != nil || ok {
+		t.Fatalf("Get of an unknown session = %v, %v; want not found", ok, err)
+	}
+
+	for _, record := range []Record{testRecord("s1", "acme"), testRecord("s2", "")} {
+		if err := s.Put(ctx, record); err != nil {
+			t.Fatalf("Put(%s): %v", record.ID, err)
+		}
+	}
+
+	got, ok, err := s.Get(ctx, "s1")
+	if err != nil || !ok {
+		t.Fatalf("Get = %v, %v; want found", ok, err)
+	}
+	if got.Tenant != "acme" || got.Caller != "+15550100" || got.Status != StatusActive || got.Counters["total_tokens"] != 42 {
+		t.Errorf("Get returned %+v", got)
+	}
+
+	if ids := listIDs(t, s); len(ids) != 2 || ids[0] != "s1" || ids[1] != "s2" {
+		t.Errorf("List = %v, want [s1 s2]", ids)
+	}
+
+	ended := testRecord("s1", "acme")
+	ended.Status = StatusClosed
+	ended.EndedAt = clock()
+	if err := s.Delete(ctx, ended); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, ok, _ := s.Get(ctx, "s1"); ok {
+		t.Error("Get found a deleted session")
+	}
+	if ids := listIDs(t, s); len(ids) != 1 || ids[0] != "s2" {
+		t.Errorf("List after Delete = %v, want [s2]", ids)
+	}
+
+	// The tenant's slot was freed with the session
+	if err := s.Reserve(ctx, "s3", "acme", Limits{Tenant: 1}); err != nil {
+		t.Errorf("Reserve after Delete: %v", err)
+	}
+}
+
+func testTouchSkipsEndedSessions(t *testing.T, s SessionStore) {
+	ctx := context.Background()
+	node := Node{ID: "node-1", StartedAt: clock(), Sessions: 2}
+
+	running := testRecord("running", "acme")
+	ended := testRecord("ended", "acme")
+	for _, record := range []Record{running, ended} {
+		if err := s.Put(ctx, record); err != nil {
+			t.Fatalf("Put(%s): %v", record.ID, err)
+		}
+	}
+
+	// The heartbeat listed both sessions before "ended" was deleted
+	closed := ended
+	closed.Status = StatusClosed
+	closed.EndedAt = clock()
+	if err := s.Delete(ctx, closed); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := s.Touch(ctx, node, []Record{running, ended, testRecord("unknown", "")}); err != nil {
+		t.Fatalf("Touch: %v", err)
+	}
+
+	if ids := listIDs(t, s); len(ids) != 1 || ids[0] != "running" {
+		t.Errorf("List after Touch = %v, want [running]", ids)
+	}
+	if err := s.Reserve(ctx, "next", "acme", Limits{Tenant: 2}); err != nil {
+		t.Errorf("Touch re-registered a closed session with its tenant: %v", err)
+	}
+
+	nodes, err := s.Nodes(ctx)
+	if err != nil {
+		t.Fatalf("Nodes: %v", err)
+	}
+	if len(nodes) != 1 || nodes[0].ID != "node-1" || nodes[0].Sessions != 2 {
+		t.Errorf("Nodes = %+v", nodes)
+	}
+}
+
+func testReap(t *testing.T, s SessionStore) {
+	ctx := context.Background()
+	stale := Node{ID: "stale", StartedAt: clock()}
+	live := Node{ID: "live", StartedAt: clock()}
+
+	if err := s.Put(ctx, testRecord("orphan", "acme")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := s.Touch(ctx, stale, nil); err != nil {
+		t.Fatalf("Touch: %v", err)
+	}
+
+	advance(HeartbeatTTL / 2)
+	if err := s.Put(ctx, testRecord("alive", "")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if n, err := s.Reap(ctx); err != nil || n != 0 {
+		t.Fatalf("Reap before the TTL = %d, %v; want 0", n, err)
+	}
+
+	advance(HeartbeatTTL/2 + time.Second)
+	if err := s.Touch(ctx, live, []Record{testRecord("alive", "")}); err != nil {
+		t.Fatalf("Touch: %v", err)
+	}
+	if n, err := s.Reap(ctx); err != nil || n != 1 {
+		t.Fatalf("Reap = %d, %v; want 1", n, err)
+	}
+	if n, err := s.Reap(ctx); err != nil || n != 0 {
+		t.Fatalf("second Reap = %d, %v; want 0", n, err)
+	}
+
+	if _, ok, _ := s.Get(ctx, "orphan"); ok {
+		t.Error("Get found a reaped session")
+	}
+	if ids := listIDs(t, s); len(ids) != 1 || ids[0] != "alive" {
+		t.Errorf("List after Reap = %v, want [alive]", ids)
+	}
+
+	nodes, err := s.Nodes(ctx)
+	if err != nil {
+		t.Fatalf("Nodes: %v", err)
+	}
+	if len(nodes) != 1 || nodes[0].ID != "live" {
+		t.Errorf("Nodes after Reap = %+v, want [live]", nodes)
+	}
+
+	if err := s.Leave(ctx, "live"); err != nil {
+		t.Fatalf("Leave: %v", err)
+	}
+	if nodes, _ := s.Nodes(ctx); len(nodes) != 0 {
+		t.Errorf("Nodes after Leave = %+v", nodes)
+	}
+}
+
+func testCounters(t *testing.T, s SessionStore) {
+	ctx := context.Background()
+
+	for range 2 {
+		if err := s.AddCounters(ctx, "usage:agent:default", map[string]int64{"total_tokens": 10, "turns": 1}); err != nil {
+			t.Fatalf("AddCounters: %v", err)
+		}
+	}
+	counters, err := s.Counters(ctx, "usage:agent:default")
+	if err != nil {
+		t.Fatalf("Counters: %v", err)
+	}
+	if counters["total_tokens"] != 20 || counters["turns"] != 2 {
+		t.Errorf("Counters = %v", counters)
+	}
+	if counters, _ := s.Counters(ctx, "usage:agent:other"); len(counters) != 0 {
+		t.Errorf("Counters of an unknown name = %v", counters)
+	}
+}
+
+func testTerminations(t *testing.T, s SessionStore) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ids := s.Terminations(ctx)
+
+	// Subscriptions may take a moment to be established, so keep asking
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	deadline := time.After(2 * time.Second)
+	for {
+		if err := s.Terminate(ctx, "s1"); err != nil {
+			t.Fatalf("Terminate: %v", err)
+		}
+		select {
+		case id := <-ids:
+			if id != "s1" {
+				t.Fatalf("Terminations delivered %q, want s1", id)
+			}
+			cancel()
+			for range ids {
+				// Drain until the channel is closed
+			}
+			return
+		case <-ticker.C:
+		case <-deadline:
+			t.Fatal("Terminate was not delivered")
+		}
+	}
+}
+
+func testClaim(t *testing.T, s SessionStore) {
+	ctx := context.Background()
+	if ok, err := s.Claim(ctx, "token:a", time.Minute); err != nil || !ok {
+		t.Fatalf("first Claim = %v, %v, want true", ok, err)
+	}
+	if ok, err := s.Claim(ctx, "token:a", time.Minute); err != nil || ok {
+		t.Errorf("second Claim = %v, %v, want false", ok, err)
+	}
+	if ok, err := s.Claim(ctx, "token:b", time.Minute); err != nil || !ok {
+		t.Errorf("Claim of another key = %v, %v, want true", ok, err)
+	}
+}
+
+func listIDs(t *testing.T, s SessionStore) []string {
+	t.Helper()
+	records, err := s.List(context.Background())
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	ids := make([]string, len(records))
+	for i, record := range records {
+		ids[i] = record.ID
+	}
+	sort.Strings(ids)
+	return ids
+}
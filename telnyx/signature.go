@@ -0,0 +1,53 @@
+// Package telnyx verifies Telnyx webhooks
+package telnyx
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Headers carrying Telnyx's webhook signature
+const (
+	SignatureHeader = "Telnyx-Signature-Ed25519"
+	TimestampHeader = "Telnyx-Timestamp"
+)
+
+// SignatureTolerance is how old a signed webhook may be, limiting replays
+const SignatureTolerance = 5 * time.Minute
+
+// ParsePublicKey decodes the base64 public key shown in the Telnyx portal
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("not base64")
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("not an Ed25519 public key")
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// ValidSignature reports whether signature is Telnyx's Ed25519 signature of
+// "timestamp|body", made within SignatureTolerance of now
+func ValidSignature(publicKey ed25519.PublicKey, timestamp string, body []byte, signature string, now time.Time) bool {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > SignatureTolerance || age < -SignatureTolerance {
+		return false
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	signed := make([]byte, 0, len(timestamp)+1+len(body))
+	signed = append(signed, timestamp...)
+	signed = append(signed, '|')
+	signed = append(signed, body...)
+	return ed25519.Verify(publicKey, signed, sig)
+}
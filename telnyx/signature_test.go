@@ -0,0 +1,65 @@
+package telnyx
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestValidSignature(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, _, _ := ed25519.GenerateKey(nil)
+
+	now := time.Unix(1_760_000_000, 0)
+	body := []byte(`CallSid=v3%3Aabc&From=%2B13122010094&To=%2B13122123456`)
+	sign := func(timestamp string, body []byte) string {
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, append([]byte(timestamp+"|"), body...)))
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	tests := []struct {
+		name      string
+		key       ed25519.PublicKey
+		timestamp string
+		body      []byte
+		signature string
+		want      bool
+	}{
+		{"valid", publicKey, timestamp, body, sign(timestamp, body), true},
+		{"recent", publicKey, "1759999800", body, sign("1759999800", body), true},
+		{"other key", otherKey, timestamp, body, sign(timestamp, body), false},
+		{"tampered body", publicKey, timestamp, []byte(`CallSid=v3%3Aabc&From=%2B15550100`), sign(timestamp, body), false},
+		{"replayed timestamp", publicKey, "1760000001", body, sign(timestamp, body), false},
+		{"stale", publicKey, "1759999000", body, sign("1759999000", body), false},
+		{"future", publicKey, "1760001000", body, sign("1760001000", body), false},
+		{"non-numeric timestamp", publicKey, "yesterday", body, sign("yesterday", body), false},
+		{"not base64", publicKey, timestamp, body, "not base64!", false},
+		{"missing", publicKey, timestamp, body, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ValidSignature(tt.key, tt.timestamp, tt.body, tt.signature, now); got != tt.want {
+				t.Errorf("ValidSignature = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	publicKey, _, _ := ed25519.GenerateKey(nil)
+	key, err := ParsePublicKey(base64.StdEncoding.EncodeToString(publicKey))
+	if err != nil || !key.Equal(publicKey) {
+		t.Errorf("ParsePublicKey = %v, %v", key, err)
+	}
+
+	for _, s := range []string{"", "not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := ParsePublicKey(s); err == nil {
+			t.Errorf("ParsePublicKey(%q) accepted an invalid key", s)
+		}
+	}
+}
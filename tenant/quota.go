@@ -0,0 +1,192 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Quota errors, returned when a tenant is over one of its limits
+var (
+	ErrConcurrentSessions = errors.New("concurrent session limit reached")
+	ErrDailyMinutes       = errors.New("daily minutes exhausted")
+	ErrMonthlyMinutes     = errors.New("monthly minutes exhausted")
+	ErrTokenBudget        = errors.New("monthly token budget exhausted")
+)
+
+// Fields of the day and month counters in the shared store
+const (
+	durationField = "duration_ms"
+	tokensField   = "tokens"
+)
+
+// clock returns the current time; tests move it across day and month boundaries
+var clock = time.Now
+
+// Counters sums the usage of every instance; store.SessionStore implements it
+type Counters interface {
+	AddCounters(ctx context.Context, name string, values map[string]int64) error
+	Counters(ctx context.Context, name string) (map[string]int64, error)
+}
+
+// usage is what a tenant has consumed in the current day and month
+type usage struct {
+	active int
+
+	day           string // UTC date the day counters belong to (2006-01-02)
+	dayDuration   time.Duration
+	month         string // UTC month the month counters belong to (2006-01)
+	monthDuration time.Duration
+	monthTokens   int64
+}
+
+// Tracker accounts tenant usage and enforces tenant limits.
+// Finished sessions are recorded with Release; running sessions are passed
+// to Exceeded by the caller so their consumption counts before they end.
+//
+// Released usage is added to day and month counters in the shared store, and
+// Sync reads them back, so every instance enforces the minutes and tokens used
+// across the cluster and the counts survive restarts. Limits are checked
+// against the counts as of the last Sync plus this instance's releases since,
+// so admitting a session never waits on the store.
+type Tracker struct {
+	counters Counters // nil keeps usage in this process only
+
+	mu    sync.Mutex
+	usage map[string]*usage
+}
+
+// NewTracker creates a usage tracker summing usage in counters (nil to keep
+// it in this process only)
+func NewTracker(counters Counters) *Tracker {
+	return &Tracker{
+		counters: counters,
+		usage:    make(map[string]*usage),
+	}
+}
+
+// periods returns the current UTC day and month
+func periods() (day, month string) {
+	now := clock().UTC()
+	return now.Format("2006-01-02"), now.Format("2006-01")
+}
+
+// dayCounters and monthCounters name a tenant's counters in the shared store
+func dayCounters(tenantID, day string) string     { return "quota:" + tenantID + ":day:" + day }
+func monthCounters(tenantID, month string) string { return "quota:" + tenantID + ":month:" + month }
+
+// current returns the tenant's usage, resetting counters on day/month rollover
+func (t *Tracker) current(tenantID string) *usage {
+	day, month := periods()
+
+	u, ok := t.usage[tenantID]
+	if !ok {
+		u = &usage{day: day, month: month}
+		t.usage[tenantID] = u
+	}
+	if u.day != day {
+		u.day = day
+		u.dayDuration = 0
+	}
+	if u.month != month {
+		u.month = month
+		u.monthDuration = 0
+		u.monthTokens = 0
+	}
+	return u
+}
+
+// Acquire reserves a session slot for the tenant, or returns the limit that prevents it
+func (t *Tracker) Acquire(tn *Tenant, liveDuration time.Duration, liveTokens int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	u := t.current(tn.ID)
+	if tn.MaxConcurrentSessions > 0 && u.active >= tn.MaxConcurrentSessions {
+		return ErrConcurrentSessions
+	}
+	if err := exceeded(tn, u, liveDuration, liveTokens); err != nil {
+		return err
+	}
+
+	u.active++
+	return nil
+}
+
+// Release frees a session slot and records what the session consumed, here
+// and in the shared counters
+func (t *Tracker) Release(ctx context.Context, tn *Tenant, duration time.Duration, tokens int64) error {
+	t.mu.Lock()
+	u := t.current(tn.ID)
+	if u.active > 0 {
+		u.active--
+	}
+	u.dayDuration += duration
+	u.monthDuration += duration
+	u.monthTokens += tokens
+	day, month := u.day, u.month
+	t.mu.Unlock()
+
+	if t.counters == nil || (duration <= 0 && tokens <= 0) {
+		return nil
+	}
+	if err := t.counters.AddCounters(ctx, dayCounters(tn.ID, day), map[string]int64{durationField: duration.Milliseconds()}); err != nil {
+		return err
+	}
+	return t.counters.AddCounters(ctx, monthCounters(tn.ID, month), map[string]int64{durationField: duration.Milliseconds(), tokensField: tokens})
+}
+
+// Sync replaces the day and month usage of tenantIDs with the shared
+// counters, which include the sessions finished by every instance
+func (t *Tracker) Sync(ctx context.Context, tenantIDs []string) error {
+	if t.counters == nil {
+		return nil
+	}
+
+	for _, id := range tenantIDs {
+		day, month := periods()
+		dayUsage, err := t.counters.Counters(ctx, dayCounters(id, day))
+		if err != nil {
+			return err
+		}
+		monthUsage, err := t.counters.Counters(ctx, monthCounters(id, month))
+		if err != nil {
+			return err
+		}
+
+		t.mu.Lock()
+		u := t.current(id)
+		if u.day == day {
+			u.dayDuration = time.Duration(dayUsage[durationField]) * time.Millisecond
+		}
+		if u.month == month {
+			u.monthDuration = time.Duration(monthUsage[durationField]) * time.Millisecond
+			u.monthTokens = monthUsage[tokensField]
+		}
+		t.mu.Unlock()
+	}
+	return nil
+}
+
+// Exceeded reports whether the tenant is over a limit once the consumption of
+// its running sessions (liveDuration, liveTokens) is added
+func (t *Tracker) Exceeded(tn *Tenant, liveDuration time.Duration, liveTokens int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return exceeded(tn, t.current(tn.ID), liveDuration, liveTokens)
+}
+
+func exceeded(tn *Tenant, u *usage, liveDuration time.Duration, liveTokens int64) error {
+	if tn.MinutesPerDay > 0 && u.dayDuration+liveDuration >= time.Duration(tn.MinutesPerDay)*time.Minute {
+		return ErrDailyMinutes
+	}
+	if tn.MinutesPerMonth > 0 && u.monthDuration+liveDuration >= time.Duration(tn.MinutesPerMonth)*time.Minute {
+		return ErrMonthlyMinutes
+	}
+	if tn.MonthlyTokenBudget > 0 && u.monthTokens+liveTokens >= tn.MonthlyTokenBudget {
+		return ErrTokenBudget
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/room4-2/OpenConverse/store"
+)
+
+var ctx = context.Background()
+
+// setClock fixes the tracker's clock at now and returns a function moving it
+func setClock(t *testing.T, now time.Time) func(time.Time) {
+	t.Helper()
+	prev := clock
+	clock = func() time.Time { return now }
+	t.Cleanup(func() { clock = prev })
+	return func(to time.Time) { now = to }
+}
+
+func TestAcquireConcurrentSessions(t *testing.T) {
+	setClock(t, time.Date(2026, 3, 14, 12, 0, 0, 0, time.UTC))
+	tr := NewTracker(nil)
+	tn := &Tenant{ID: "acme", MaxConcurrentSessions: 2}
+
+	for i := range 2 {
+		if err := tr.Acquire(tn, 0, 0); err != nil {
+			t.Fatalf("Acquire %d: %v", i+1, err)
+		}
+	}
+	if err := tr.Acquire(tn, 0, 0); !errors.Is(err, ErrConcurrentSessions) {
+		t.Errorf("Acquire over the limit = %v, want ErrConcurrentSessions", err)
+	}
+	if err := tr.Acquire(&Tenant{ID: "globex", MaxConcurrentSessions: 2}, 0, 0); err != nil {
+		t.Errorf("Acquire of another tenant: %v", err)
+	}
+
+	tr.Release(ctx, tn, time.Minute, 100)
+	if err := tr.Acquire(tn, 0, 0); err != nil {
+		t.Errorf("Acquire after a Release: %v", err)
+	}
+
+	unlimited := &Tenant{ID: "initech"}
+	for range 100 {
+		if err := tr.Acquire(unlimited, 0, 0); err != nil {
+			t.Fatalf("Acquire of a tenant without limits: %v", err)
+		}
+	}
+}
+
+func TestQuotaLimits(t *testing.T) {
+	tests := []struct {
+		name         string
+		tenant       Tenant
+		used         time.Duration // Recorded by a finished session
+		usedTokens   int64
+		liveDuration time.Duration // Of running sessions
+		liveTokens   int64
+		want         error
+	}{
+		{"unlimited", Tenant{}, 1000 * time.Hour, 1 << 40, 0, 0, nil},
+		{"under daily minutes", Tenant{MinutesPerDay: 10}, 5 * time.Minute, 0, 4 * time.Minute, 0, nil},
+		{"daily minutes", Tenant{MinutesPerDay: 10}, 10 * time.Minute, 0, 0, 0, ErrDailyMinutes},
+		{"daily minutes with running sessions", Tenant{MinutesPerDay: 10}, 5 * time.Minute, 0, 5 * time.Minute, 0, ErrDailyMinutes},
+		{"monthly minutes", Tenant{MinutesPerMonth: 60}, 30 * time.Minute, 0, 30 * time.Minute, 0, ErrMonthlyMinutes},
+		{"daily before monthly", Tenant{MinutesPerDay: 10, MinutesPerMonth: 10}, 10 * time.Minute, 0, 0, 0, ErrDailyMinutes},
+		{"under token budget", Tenant{MonthlyTokenBudget: 1000}, 0, 500, 0, 499, nil},
+		{"token budget", Tenant{MonthlyTokenBudget: 1000}, 0, 1000, 0, 0, ErrTokenBudget},
+		{"token budget with running sessions", Tenant{MonthlyTokenBudget: 1000}, 0, 600, 0, 400, ErrTokenBudget},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setClock(t, time.Date(2026, 3, 14, 12, 0, 0, 0, time.UTC))
+			tr := NewTracker(nil)
+			tn := tt.tenant
+			tn.ID = "acme"
+
+			if err := tr.Acquire(&tn, 0, 0); err != nil {
+				t.Fatalf("Acquire of an unused tenant: %v", err)
+			}
+			tr.Release(ctx, &tn, tt.used, tt.usedTokens)
+
+			if err := tr.Exceeded(&tn, tt.liveDuration, tt.liveTokens); !errors.Is(err, tt.want) {
+				t.Errorf("Exceeded = %v, want %v", err, tt.want)
+			}
+			if err := tr.Acquire(&tn, tt.liveDuration, tt.liveTokens); !errors.Is(err, tt.want) {
+				t.Errorf("Acquire = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuotaRollover(t *testing.T) {
+	moveClock := setClock(t, time.Date(2026, 3, 31, 23, 0, 0, 0, time.UTC))
+	tr := NewTracker(nil)
+	tn := &Tenant{ID: "acme", MinutesPerDay: 10, MinutesPerMonth: 15, MonthlyTokenBudget: 1000}
+
+	if err := tr.Acquire(tn, 0, 0); err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	tr.Release(ctx, tn, 10*time.Minute, 1000)
+	if err := tr.Exceeded(tn, 0, 0); !errors.Is(err, ErrDailyMinutes) {
+		t.Fatalf("Exceeded = %v, want ErrDailyMinutes", err)
+	}
+
+	// The day and month counters start over at midnight UTC
+	moveClock(time.Date(2026, 4, 1, 0, 0, 1, 0, time.UTC))
+	if err := tr.Exceeded(tn, 0, 0); err != nil {
+		t.Errorf("Exceeded in a new month = %v, want nil", err)
+	}
+	tr.Release(ctx, tn, 10*time.Minute, 0)
+
+	// Only the day counter starts over within the month
+	moveClock(time.Date(2026, 4, 2, 9, 0, 0, 0, time.UTC))
+	if err := tr.Exceeded(tn, 0, 0); err != nil {
+		t.Errorf("Exceeded in a new day = %v, want nil", err)
+	}
+	if err := tr.Exceeded(tn, 5*time.Minute, 0); !errors.Is(err, ErrMonthlyMinutes) {
+		t.Errorf("Exceeded with 20 minutes this month = %v, want ErrMonthlyMinutes", err)
+	}
+}
+
+// A session started before a rollover and released after it frees its slot
+// without leaving today's counters below zero
+func TestReleaseAfterRollover(t *testing.T) {
+	moveClock := setClock(t, time.Date(2026, 3, 14, 23, 50, 0, 0, time.UTC))
+	tr := NewTracker(nil)
+	tn := &Tenant{ID: "acme", MaxConcurrentSessions: 1, MinutesPerDay: 30}
+
+	if err := tr.Acquire(tn, 0, 0); err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	moveClock(time.Date(2026, 3, 15, 0, 10, 0, 0, time.UTC))
+	tr.Release(ctx, tn, 20*time.Minute, 0)
+	// A spurious Release (e.g. of a rolled back reservation) can't free a slot that isn't held
+	tr.Release(ctx, tn, 0, 0)
+
+	tr.mu.Lock()
+	u := *tr.usage[tn.ID]
+	tr.mu.Unlock()
+	if u.active != 0 || u.dayDuration < 0 || u.monthDuration < 0 || u.monthTokens < 0 {
+		t.Fatalf("usage after Release = %+v, want no active sessions and no negative counters", u)
+	}
+	if u.dayDuration != 20*time.Minute {
+		t.Errorf("today's minutes = %s, want the released session's 20m", u.dayDuration)
+	}
+
+	if err := tr.Acquire(tn, 0, 0); err != nil {
+		t.Fatalf("Acquire after the rollover: %v", err)
+	}
+	if err := tr.Acquire(tn, 0, 0); !errors.Is(err, ErrConcurrentSessions) {
+		t.Errorf("second Acquire = %v, want ErrConcurrentSessions", err)
+	}
+	if err := tr.Exceeded(tn, 10*time.Minute, 0); !errors.Is(err, ErrDailyMinutes) {
+		t.Errorf("Exceeded with 30 minutes today = %v, want ErrDailyMinutes", err)
+	}
+}
+
+// Instances sharing a store enforce the usage of the whole cluster, and it
+// survives a restart
+func TestTrackersShareCounters(t *testing.T) {
+	moveClock := setClock(t, time.Date(2026, 3, 30, 12, 0, 0, 0, time.UTC))
+	shared := store.NewMemory()
+	first, second := NewTracker(shared), NewTracker(shared)
+	tn := &Tenant{ID: "acme", MinutesPerDay: 10, MinutesPerMonth: 30, MonthlyTokenBudget: 1000}
+
+	if err := first.Acquire(tn, 0, 0); err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	if err := first.Release(ctx, tn, 6*time.Minute, 600); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if err := second.Exceeded(tn, 5*time.Minute, 0); err != nil {
+		t.Fatalf("Exceeded before Sync = %v, want the other instance's usage unknown", err)
+	}
+	if err := second.Sync(ctx, []string{"acme"}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if err := second.Exceeded(tn, 4*time.Minute, 0); !errors.Is(err, ErrDailyMinutes) {
+		t.Errorf("Exceeded with 10 minutes across instances = %v, want ErrDailyMinutes", err)
+	}
+	if err := second.Exceeded(tn, 0, 400); !errors.Is(err, ErrTokenBudget) {
+		t.Errorf("Exceeded with 1000 tokens across instances = %v, want ErrTokenBudget", err)
+	}
+
+	// Both instances' sessions add up, also for an instance started afterwards
+	if err := second.Release(ctx, tn, 3*time.Minute, 0); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	restarted := NewTracker(shared)
+	if err := restarted.Sync(ctx, []string{"acme"}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if err := restarted.Exceeded(tn, time.Minute, 0); !errors.Is(err, ErrDailyMinutes) {
+		t.Errorf("Exceeded after a restart = %v, want ErrDailyMinutes", err)
+	}
+
+	// The day counters start over, the month counters carry on
+	moveClock(time.Date(2026, 3, 31, 9, 0, 0, 0, time.UTC))
+	if err := restarted.Sync(ctx, []string{"acme"}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if err := restarted.Exceeded(tn, 9*time.Minute, 0); err != nil {
+		t.Errorf("Exceeded in a new day = %v, want nil", err)
+	}
+	monthly := &Tenant{ID: "acme", MinutesPerMonth: 30}
+	if err := restarted.Exceeded(monthly, 21*time.Minute, 0); !errors.Is(err, ErrMonthlyMinutes) {
+		t.Errorf("Exceeded with 30 minutes this month = %v, want ErrMonthlyMinutes", err)
+	}
+
+	moveClock(time.Date(2026, 4, 1, 0, 0, 1, 0, time.UTC))
+	if err := restarted.Sync(ctx, []string{"acme"}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if err := restarted.Exceeded(tn, 9*time.Minute, 999); err != nil {
+		t.Errorf("Exceeded in a new month = %v, want nil", err)
+	}
+}
@@ -0,0 +1,93 @@
+package tenant
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/bytedance/sonic"
+)
+
+// Tenant is a customer of the server, identified by its API keys
+type Tenant struct {
+	ID      string   `json:"id"`
+	APIKeys []string `json:"api_keys"`
+	Profile string   `json:"profile,omitempty"` // Agent profile used with the tenant's API keys
+
+	// Limits (0 means unlimited)
+	MaxConcurrentSessions int   `json:"max_concurrent_sessions"`
+	MinutesPerDay         int   `json:"minutes_per_day"`
+	MinutesPerMonth       int   `json:"minutes_per_month"`
+	MonthlyTokenBudget    int64 `json:"monthly_token_budget"`
+}
+
+// Registry holds the configured tenants
+type Registry struct {
+	tenants map[string]*Tenant // by tenant ID
+	byKey   map[string]*Tenant // by API key
+}
+
+// LoadRegistry reads tenants from a JSON file containing an array of tenants
+func LoadRegistry(path string) (*Registry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenants file: %w", err)
+	}
+
+	var tenants []*Tenant
+	if err := sonic.Unmarshal(data, &tenants); err != nil {
+		return nil, fmt.Errorf("failed to parse tenants file: %w", err)
+	}
+
+	return NewRegistry(tenants)
+}
+
+// NewRegistry indexes tenants by ID and API key
+func NewRegistry(tenants []*Tenant) (*Registry, error) {
+	r := &Registry{
+		tenants: make(map[string]*Tenant, len(tenants)),
+		byKey:   make(map[string]*Tenant),
+	}
+
+	for _, t := range tenants {
+		if t.ID == "" {
+			return nil, fmt.Errorf("tenant is missing an id")
+		}
+		if _, exists := r.tenants[t.ID]; exists {
+			return nil, fmt.Errorf("duplicate tenant id %q", t.ID)
+		}
+		r.tenants[t.ID] = t
+
+		for _, key := range t.APIKeys {
+			if key == "" {
+				return nil, fmt.Errorf("tenant %q has an empty API key", t.ID)
+			}
+			if other, exists := r.byKey[key]; exists {
+				return nil, fmt.Errorf("API key of tenant %q is also used by tenant %q", t.ID, other.ID)
+			}
+			r.byKey[key] = t
+		}
+	}
+
+	return r, nil
+}
+
+// Get returns the tenant with the given ID
+func (r *Registry) Get(id string) (*Tenant, bool) {
+	t, ok := r.tenants[id]
+	return t, ok
+}
+
+// IDs returns the IDs of the configured tenants
+func (r *Registry) IDs() []string {
+	ids := make([]string, 0, len(r.tenants))
+	for id := range r.tenants {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Lookup returns the tenant owning the given API key
+func (r *Registry) Lookup(apiKey string) (*Tenant, bool) {
+	t, ok := r.byKey[apiKey]
+	return t, ok
+}
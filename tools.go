@@ -6,4 +6,6 @@ package tools
 
 import (
 	_ "github.com/golangci/golangci-lint/cmd/golangci-lint"
+	// Source of audio/opus.wasm; go generate ./audio copies it from here
+	_ "github.com/jj11hh/opus"
 )
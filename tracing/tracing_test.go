@@ -0,0 +1,126 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// installProvider makes a provider exporting to an in-memory exporter the
+// global one for the duration of the test
+func installProvider(t *testing.T) (*tracetest.InMemoryExporter, func()) {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider, err := NewProvider(exporter)
+	if err != nil {
+		t.Fatalf("NewProvider: %v", err)
+	}
+
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		_ = provider.Shutdown(context.Background())
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+
+	flush := func() {
+		if err := provider.ForceFlush(context.Background()); err != nil {
+			t.Fatalf("ForceFlush: %v", err)
+		}
+	}
+	return exporter, flush
+}
+
+func TestProviderExportsSpans(t *testing.T) {
+	exporter, flush := installProvider(t)
+
+	ctx, parent := Tracer().Start(context.Background(), "session")
+	_, child := Tracer().Start(ctx, "turn")
+	child.End()
+	parent.End()
+	flush()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(spans))
+	}
+	turn, session := spans[0], spans[1]
+	if turn.Name != "turn" || session.Name != "session" {
+		t.Fatalf("span names = %q, %q", turn.Name, session.Name)
+	}
+	if turn.Parent.SpanID() != session.SpanContext.SpanID() {
+		t.Error("turn span is not a child of the session span")
+	}
+	if got := session.InstrumentationScope.Name; got != instrumentationName {
+		t.Errorf("instrumentation scope = %q, want %q", got, instrumentationName)
+	}
+
+	var service string
+	for _, attr := range session.Resource.Attributes() {
+		if attr.Key == "service.name" {
+			service = attr.Value.AsString()
+		}
+	}
+	if service == "" {
+		t.Error("resource has no service.name")
+	}
+}
+
+func TestHTTPClientRecordsAndPropagates(t *testing.T) {
+	exporter, flush := installProvider(t)
+
+	var traceparent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	client := HTTPClient(5 * time.Second)
+	if client.Timeout != 5*time.Second {
+		t.Errorf("timeout = %v, want 5s", client.Timeout)
+	}
+
+	ctx, parent := Tracer().Start(context.Background(), "dial")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	parent.End()
+	flush()
+
+	if traceparent == "" {
+		t.Fatal("request carried no traceparent header")
+	}
+
+	var request *tracetest.SpanStub
+	spans := exporter.GetSpans()
+	for i := range spans {
+		if spans[i].SpanKind == trace.SpanKindClient {
+			request = &spans[i]
+		}
+	}
+	if request == nil {
+		t.Fatalf("no client span among %d exported spans", len(spans))
+	}
+	if request.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Error("client span is not a child of the caller's span")
+	}
+	if request.SpanContext.TraceID() != parent.SpanContext().TraceID() {
+		t.Error("client span is in a different trace")
+	}
+}
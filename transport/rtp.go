@@ -0,0 +1,268 @@
+package transport
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/room4-2/OpenConverse/audio"
+	"github.com/room4-2/OpenConverse/messages"
+)
+
+const (
+	rtpHeaderSize  = 12
+	rtpPacketTime  = 20 * time.Millisecond
+	rtpFrameSize   = 160 // 20ms of 8kHz G.711
+	rtpTimeout     = 60 * time.Second
+	rtpPayloadPCMA = 8
+)
+
+// RTPConfig describes the media stream negotiated for an RTP call
+type RTPConfig struct {
+	Remote      *net.UDPAddr // Where the remote party receives RTP, from its SDP
+	PayloadType int          // 0 (PCMU) or 8 (PCMA)
+	DTMFType    int          // RFC 4733 telephone-event payload type, -1 when not negotiated
+	OnClose     func()       // Called when we close a call the remote party didn't hang up, to send BYE
+}
+
+// RTP is the media of a SIP call: G.711 8kHz audio in RTP packets over UDP,
+// streamed to Gemini as it arrives. Response audio is sent in 20ms packets
+// paced in real time; keys pressed arrive as RFC 4733 telephone-events.
+type RTP struct {
+	conn      *net.UDPConn
+	config    RTPConfig
+	hungUp    atomic.Bool // Remote party sent BYE
+	startSent bool        // EventStart returned; Receive goroutine only
+	stopSent  bool        // EventStop returned after hang up; Receive goroutine only
+
+	mu      sync.Mutex
+	remote  *net.UDPAddr
+	latched bool   // remote moved to the source of the first packet (symmetric RTP, for NAT)
+	pending []byte // Encoded audio waiting for its packet slot
+
+	// RFC 4733 events repeat for their duration; a new timestamp is a new key press
+	lastDTMF    uint32
+	hasLastDTMF bool
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// NewRTP wraps the UDP socket of a call's media and starts sending
+func NewRTP(conn *net.UDPConn, config RTPConfig) *RTP {
+	t := &RTP{conn: conn, config: config, remote: config.Remote, done: make(chan struct{})}
+	go t.sendLoop()
+	return t
+}
+
+func (t *RTP) Name() string    { return "sip" }
+func (t *RTP) Streaming() bool { return true }
+
+// Hangup ends the stream after the remote party sent BYE or CANCEL
+func (t *RTP) Hangup() {
+	t.hungUp.Store(true)
+	_ = t.conn.SetReadDeadline(time.Now())
+}
+
+func (t *RTP) Receive() (Event, error) {
+	// The stream starts with the call, answered before its session runs
+	if !t.startSent {
+		t.startSent = true
+		return Event{Type: EventStart}, nil
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		if t.hungUp.Load() {
+			if !t.stopSent {
+				t.stopSent = true
+				return Event{Type: EventStop}, nil
+			}
+			return Event{}, io.EOF
+		}
+
+		_ = t.conn.SetReadDeadline(time.Now().Add(rtpTimeout))
+		n, addr, err := t.conn.ReadFromUDP(buf)
+		if err != nil {
+			if t.hungUp.Load() {
+				continue
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return Event{}, io.EOF
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return Event{}, fmt.Errorf("no RTP received for %s", rtpTimeout)
+			}
+			return Event{}, err
+		}
+
+		payloadType, timestamp, payload, ok := parseRTP(buf[:n])
+		if !ok {
+			continue
+		}
+
+		t.mu.Lock()
+		if !t.latched {
+			t.remote, t.latched = addr, true
+		}
+		t.mu.Unlock()
+
+		switch payloadType {
+		case t.config.PayloadType:
+			if t.config.PayloadType == rtpPayloadPCMA {
+				return Event{Type: EventAudio, Audio: audio.ALaw8kToPCM16k(payload)}, nil
+			}
+			return Event{Type: EventAudio, Audio: audio.MuLaw8kToPCM16k(payload)}, nil
+
+		case t.config.DTMFType:
+			if len(payload) < 4 || int(payload[0]) >= len(dtmfDigits) {
+				continue
+			}
+			if t.hasLastDTMF && timestamp == t.lastDTMF {
+				continue
+			}
+			t.lastDTMF, t.hasLastDTMF = timestamp, true
+			return Event{Type: EventDTMF, Digit: string(dtmfDigits[payload[0]])}, nil
+		}
+		// Comfort noise and other payload types are ignored
+	}
+}
+
+// parseRTP returns the payload type, timestamp and payload of an RTP packet
+func parseRTP(packet []byte) (payloadType int, timestamp uint32, payload []byte, ok bool) {
+	if len(packet) < rtpHeaderSize || packet[0]>>6 != 2 {
+		return 0, 0, nil, false
+	}
+	headerSize := rtpHeaderSize + 4*int(packet[0]&0x0F) // CSRC list
+	if packet[0]&0x10 != 0 {
+		// Header extension: 4-byte preamble with its length in 32-bit words
+		if len(packet) < headerSize+4 {
+			return 0, 0, nil, false
+		}
+		headerSize += 4 + 4*int(binary.BigEndian.Uint16(packet[headerSize+2:]))
+	}
+	end := len(packet)
+	if packet[0]&0x20 != 0 {
+		// Padding: the last byte counts the padding bytes
+		end -= int(packet[end-1])
+	}
+	if headerSize > end {
+		return 0, 0, nil, false
+	}
+	return int(packet[1] & 0x7F), binary.BigEndian.Uint32(packet[4:]), packet[headerSize:end], true
+}
+
+// Send queues response audio for the caller; other messages are dropped
+func (t *RTP) Send(msg *messages.ServerMessage) error {
+	if msg.Type != messages.TypeAudio {
+		return nil
+	}
+	payload, ok := msg.Payload.(messages.AudioResponsePayload)
+	if !ok {
+		return nil
+	}
+
+	// Decode Gemini's PCM audio (24kHz, 16-bit, little-endian)
+	pcmData, err := base64.StdEncoding.DecodeString(payload.Data)
+	if err != nil {
+		return fmt.Errorf("failed to decode Gemini audio: %w", err)
+	}
+	var encoded []byte
+	if t.config.PayloadType == rtpPayloadPCMA {
+		encoded = audio.PCM24kToALaw8k(pcmData)
+	} else {
+		encoded = audio.PCM24kToMuLaw8k(pcmData)
+	}
+
+	t.mu.Lock()
+	t.pending = append(t.pending, encoded...)
+	t.mu.Unlock()
+	return nil
+}
+
+// sendLoop sends a packet of queued audio every 20ms. A partial frame waits
+// one interval for more audio, then is padded with silence.
+func (t *RTP) sendLoop() {
+	ticker := time.NewTicker(rtpPacketTime)
+	defer ticker.Stop()
+
+	var ids [6]byte
+	_, _ = rand.Read(ids[:])
+	ssrc := binary.BigEndian.Uint32(ids[0:4])
+	seq := binary.BigEndian.Uint16(ids[4:6])
+	timestamp := ssrc // Any random start will do
+	silence := audio.PCMToMuLaw(0)
+	if t.config.PayloadType == rtpPayloadPCMA {
+		silence = audio.PCMToALaw(0)
+	}
+
+	packet := make([]byte, rtpHeaderSize+rtpFrameSize)
+	talking := false
+	waited := false
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+		}
+		// The timestamp keeps time through silences
+		timestamp += rtpFrameSize
+
+		t.mu.Lock()
+		frame := len(t.pending)
+		if frame > rtpFrameSize {
+			frame = rtpFrameSize
+		}
+		if frame == 0 || (frame < rtpFrameSize && !waited) {
+			waited = frame > 0
+			talking = talking && frame > 0
+			t.mu.Unlock()
+			continue
+		}
+		copy(packet[rtpHeaderSize:], t.pending[:frame])
+		for i := rtpHeaderSize + frame; i < len(packet); i++ {
+			packet[i] = silence
+		}
+		t.pending = t.pending[frame:]
+		if len(t.pending) == 0 {
+			t.pending = nil
+		}
+		remote := t.remote
+		t.mu.Unlock()
+		waited = false
+
+		packet[0] = 0x80 // Version 2
+		packet[1] = byte(t.config.PayloadType)
+		if !talking {
+			// The marker bit starts a talkspurt
+			packet[1] |= 0x80
+			talking = true
+		}
+		seq++
+		binary.BigEndian.PutUint16(packet[2:], seq)
+		binary.BigEndian.PutUint32(packet[4:], timestamp)
+		binary.BigEndian.PutUint32(packet[8:], ssrc)
+		_, _ = t.conn.WriteToUDP(packet, remote)
+	}
+}
+
+// Close stops the media and, unless the remote party hung up, ends the call
+func (t *RTP) Close() error {
+	var err error
+	t.closeOnce.Do(func() {
+		close(t.done)
+		if !t.hungUp.Load() && t.config.OnClose != nil {
+			t.config.OnClose()
+		}
+		err = t.conn.Close()
+	})
+	return err
+}
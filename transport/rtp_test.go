@@ -0,0 +1,197 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// rtpPacket builds an RTP packet of payload type pt
+func rtpPacket(pt byte, seq uint16, timestamp uint32, payload []byte) []byte {
+	packet := make([]byte, rtpHeaderSize, rtpHeaderSize+len(payload))
+	packet[0] = 0x80
+	packet[1] = pt
+	binary.BigEndian.PutUint16(packet[2:], seq)
+	binary.BigEndian.PutUint32(packet[4:], timestamp)
+	binary.BigEndian.PutUint32(packet[8:], 0x1234)
+	return append(packet, payload...)
+}
+
+// dtmfEvent builds an RFC 4733 telephone-event payload
+func dtmfEvent(event byte, end bool, duration uint16) []byte {
+	payload := []byte{event, 10, 0, 0}
+	if end {
+		payload[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(payload[2:], duration)
+	return payload
+}
+
+func TestParseRTP(t *testing.T) {
+	payload := []byte{1, 2, 3, 4}
+	plain := rtpPacket(0, 1, 160, payload)
+
+	withCSRC := rtpPacket(8, 1, 160, append([]byte{0, 0, 0, 1, 0, 0, 0, 2}, payload...))
+	withCSRC[0] |= 2
+
+	withExtension := rtpPacket(0, 1, 160, append([]byte{0xBE, 0xDE, 0, 1, 9, 9, 9, 9}, payload...))
+	withExtension[0] |= 0x10
+
+	withPadding := rtpPacket(0, 1, 160, append(append([]byte(nil), payload...), 0, 0, 3))
+	withPadding[0] |= 0x20
+
+	tests := []struct {
+		name    string
+		packet  []byte
+		wantPT  int
+		wantOK  bool
+		payload []byte
+	}{
+		{"plain", plain, 0, true, payload},
+		{"CSRC list", withCSRC, 8, true, payload},
+		{"header extension", withExtension, 0, true, payload},
+		{"padding", withPadding, 0, true, payload},
+		{"marker bit", rtpPacket(0x80|8, 1, 160, payload), 8, true, payload},
+		{"header only", rtpPacket(0, 1, 160, nil), 0, true, []byte{}},
+		{"short", plain[:rtpHeaderSize-1], 0, false, nil},
+		{"empty", nil, 0, false, nil},
+		{"version 1", func() []byte { p := rtpPacket(0, 1, 160, payload); p[0] = 0x40; return p }(), 0, false, nil},
+		{"CSRC list past the end", func() []byte { p := rtpPacket(0, 1, 160, payload); p[0] |= 0x0F; return p }(), 0, false, nil},
+		{"truncated extension", func() []byte { p := rtpPacket(0, 1, 160, []byte{0xBE, 0xDE}); p[0] |= 0x10; return p }(), 0, false, nil},
+		{"extension past the end", func() []byte {
+			p := rtpPacket(0, 1, 160, []byte{0xBE, 0xDE, 0, 9})
+			p[0] |= 0x10
+			return p
+		}(), 0, false, nil},
+		{"padding past the header", func() []byte {
+			p := rtpPacket(0, 1, 160, []byte{0, 200})
+			p[0] |= 0x20
+			return p
+		}(), 0, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pt, timestamp, got, ok := parseRTP(tt.packet)
+			if ok != tt.wantOK {
+				t.Fatalf("parseRTP ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if pt != tt.wantPT || timestamp != 160 || !bytes.Equal(got, tt.payload) {
+				t.Errorf("parseRTP = %d, %d, %v; want %d, 160, %v", pt, timestamp, got, tt.wantPT, tt.payload)
+			}
+		})
+	}
+}
+
+// newTestRTP returns an RTP call transport on a loopback socket and the
+// remote party's socket sending to it
+func newTestRTP(t *testing.T, config RTPConfig) (*RTP, *net.UDPConn) {
+	t.Helper()
+	local, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := net.DialUDP("udp", nil, local.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = remote.Close() })
+
+	config.Remote = remote.LocalAddr().(*net.UDPAddr)
+	tr := NewRTP(local, config)
+	t.Cleanup(func() { _ = tr.Close() })
+	return tr, remote
+}
+
+// receiveEvent returns the next event of tr, failing the test after a second
+func receiveEvent(t *testing.T, tr *RTP) Event {
+	t.Helper()
+	events := make(chan Event, 1)
+	errs := make(chan error, 1)
+	go func() {
+		event, err := tr.Receive()
+		if err != nil {
+			errs <- err
+			return
+		}
+		events <- event
+	}()
+	select {
+	case event := <-events:
+		return event
+	case err := <-errs:
+		t.Fatalf("Receive: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for an RTP event")
+	}
+	return Event{}
+}
+
+// RFC 4733 events repeat every packet time while the key is held, and the
+// packet ending them is sent three times; each key press is one digit
+func TestRTPDTMFDeduplicated(t *testing.T) {
+	tr, remote := newTestRTP(t, RTPConfig{PayloadType: 0, DTMFType: 101})
+
+	if event := receiveEvent(t, tr); event.Type != EventStart {
+		t.Fatalf("first event = %v, want EventStart", event.Type)
+	}
+
+	seq := uint16(0)
+	send := func(packet []byte) {
+		t.Helper()
+		seq++
+		binary.BigEndian.PutUint16(packet[2:], seq)
+		if _, err := remote.Write(packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// "5" held for three packets, then its end sent three times
+	for _, duration := range []uint16{160, 320, 480} {
+		send(rtpPacket(101, 0, 8000, dtmfEvent(5, false, duration)))
+	}
+	for range 3 {
+		send(rtpPacket(101, 0, 8000, dtmfEvent(5, true, 640)))
+	}
+	// Audio between the key presses, then "#" pressed once
+	send(rtpPacket(0, 0, 8800, bytes.Repeat([]byte{0xFF}, rtpFrameSize)))
+	for range 3 {
+		send(rtpPacket(101, 0, 9600, dtmfEvent(11, true, 320)))
+	}
+	// Malformed events are dropped
+	send(rtpPacket(101, 0, 10400, []byte{1, 2}))
+	send(rtpPacket(101, 0, 11200, dtmfEvent(16, true, 160)))
+	send(rtpPacket(0, 0, 12000, bytes.Repeat([]byte{0xFF}, rtpFrameSize)))
+
+	if event := receiveEvent(t, tr); event.Type != EventDTMF || event.Digit != "5" {
+		t.Fatalf("event = %+v, want digit 5", event)
+	}
+	if event := receiveEvent(t, tr); event.Type != EventAudio || len(event.Audio) != 4*rtpFrameSize {
+		t.Fatalf("event = %v with %d bytes, want 20ms of 16kHz audio", event.Type, len(event.Audio))
+	}
+	if event := receiveEvent(t, tr); event.Type != EventDTMF || event.Digit != "#" {
+		t.Fatalf("event = %+v, want digit #", event)
+	}
+	if event := receiveEvent(t, tr); event.Type != EventAudio {
+		t.Fatalf("event = %+v, want the audio after the malformed events", event)
+	}
+}
+
+func TestRTPHangup(t *testing.T) {
+	closed := false
+	tr, _ := newTestRTP(t, RTPConfig{PayloadType: 8, DTMFType: -1, OnClose: func() { closed = true }})
+	receiveEvent(t, tr)
+
+	tr.Hangup()
+	if event := receiveEvent(t, tr); event.Type != EventStop {
+		t.Fatalf("event after Hangup = %v, want EventStop", event.Type)
+	}
+	_ = tr.Close()
+	if closed {
+		t.Error("OnClose called for a call the remote party hung up")
+	}
+}
@@ -0,0 +1,188 @@
+package transport
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/room4-2/OpenConverse/audio"
+	"github.com/room4-2/OpenConverse/messages"
+
+	"github.com/bytedance/sonic"
+	"github.com/gorilla/websocket"
+)
+
+// TelnyxBidirectionalCodec is the codec TeXML declares for the audio played
+// to the caller (bidirectionalCodec), so response audio is always encoded in
+// it, whatever the codec of the caller's audio
+const TelnyxBidirectionalCodec = "PCMU"
+
+// Telnyx is the media streaming protocol of Telnyx calls: G.711 (PCMU or
+// PCMA) 8kHz audio in JSON "media" events, streamed to Gemini as it arrives.
+// Telnyx sends: connected, start, media, mark, dtmf, error and stop events.
+type Telnyx struct {
+	conn *websocket.Conn
+
+	mu       sync.RWMutex
+	encoding string // Codec of the caller's audio, set by the "start" event
+}
+
+// telnyxMessage is an inbound Telnyx event; only the fields we use are decoded
+type telnyxMessage struct {
+	Event    string `json:"event"`
+	StreamID string `json:"stream_id"`
+	Start    *struct {
+		CallControlID string `json:"call_control_id"`
+		From          string `json:"from"`
+		MediaFormat   struct {
+			Encoding   string `json:"encoding"`
+			SampleRate int    `json:"sample_rate"`
+		} `json:"media_format"`
+	} `json:"start"`
+	Media *struct {
+		Track   string `json:"track"`
+		Payload string `json:"payload"`
+	} `json:"media"`
+	DTMF *struct {
+		Digit string `json:"digit"`
+	} `json:"dtmf"`
+	Payload *struct {
+		Code   int    `json:"code"`
+		Title  string `json:"title"`
+		Detail string `json:"detail"`
+	} `json:"payload"`
+}
+
+// telnyxMedia is an outbound audio event
+type telnyxMedia struct {
+	Event string `json:"event"`
+	Media struct {
+		Payload string `json:"payload"`
+	} `json:"media"`
+}
+
+// NewTelnyx wraps an upgraded Telnyx media stream connection
+func NewTelnyx(conn *websocket.Conn) *Telnyx {
+	conn.SetReadLimit(readLimit)
+	conn.EnableWriteCompression(false)
+
+	return &Telnyx{conn: conn}
+}
+
+func (t *Telnyx) Name() string    { return "telnyx" }
+func (t *Telnyx) Streaming() bool { return true }
+
+func (t *Telnyx) Receive() (Event, error) {
+	for {
+		_, message, err := t.conn.ReadMessage()
+		if err != nil {
+			return Event{}, readError(err)
+		}
+
+		var msg telnyxMessage
+		if err := sonic.Unmarshal(message, &msg); err != nil {
+			return Event{Type: EventInvalid, Err: fmt.Errorf("failed to parse Telnyx message: %w", err)}, nil
+		}
+
+		switch msg.Event {
+		case "connected", "mark":
+			// Informational, ignore
+
+		case "start":
+			if msg.Start == nil {
+				return invalid("Telnyx 'start' event missing start data"), nil
+			}
+			encoding := msg.Start.MediaFormat.Encoding
+			if encoding == "" {
+				encoding = "PCMU"
+			}
+			// Anything but 8kHz G.711 would be decoded as noise, so end the call instead
+			if (encoding != "PCMU" && encoding != "PCMA") ||
+				(msg.Start.MediaFormat.SampleRate != 0 && msg.Start.MediaFormat.SampleRate != 8000) {
+				return Event{}, fmt.Errorf("unsupported Telnyx media format %s/%d", encoding, msg.Start.MediaFormat.SampleRate)
+			}
+
+			t.mu.Lock()
+			t.encoding = encoding
+			t.mu.Unlock()
+			return Event{Type: EventStart, StreamID: msg.StreamID, Caller: msg.Start.From}, nil
+
+		case "media":
+			// With both tracks streamed, only the caller's is audio for Gemini
+			if msg.Media == nil || (msg.Media.Track != "" && msg.Media.Track != "inbound") {
+				continue
+			}
+			data, err := base64.StdEncoding.DecodeString(msg.Media.Payload)
+			if err != nil {
+				return Event{Type: EventInvalid, Err: fmt.Errorf("failed to decode Telnyx audio: %w", err)}, nil
+			}
+			t.mu.RLock()
+			encoding := t.encoding
+			t.mu.RUnlock()
+			if encoding == "PCMA" {
+				return Event{Type: EventAudio, Audio: audio.ALaw8kToPCM16k(data)}, nil
+			}
+			return Event{Type: EventAudio, Audio: audio.MuLaw8kToPCM16k(data)}, nil
+
+		case "error":
+			if msg.Payload == nil {
+				return invalid("Telnyx reported an error"), nil
+			}
+			return Event{Type: EventInvalid, Err: fmt.Errorf("Telnyx error %d: %s: %s",
+				msg.Payload.Code, msg.Payload.Title, msg.Payload.Detail)}, nil
+
+		case "dtmf":
+			if msg.DTMF == nil {
+				return invalid("Telnyx 'dtmf' event missing dtmf data"), nil
+			}
+			return keyPressed(msg.DTMF.Digit), nil
+
+		case "stop":
+			return Event{Type: EventStop}, nil
+
+		case "":
+			return invalid("Telnyx message missing 'event' field"), nil
+
+		default:
+			return invalid("Unknown Telnyx event: " + msg.Event), nil
+		}
+	}
+}
+
+// Send plays response audio to the caller; other messages are dropped
+func (t *Telnyx) Send(msg *messages.ServerMessage) error {
+	if msg.Type != messages.TypeAudio {
+		return nil
+	}
+	payload, ok := msg.Payload.(messages.AudioResponsePayload)
+	if !ok {
+		return nil
+	}
+
+	t.mu.RLock()
+	started := t.encoding != ""
+	t.mu.RUnlock()
+	if !started {
+		// Audio before the "start" event has nowhere to go
+		return nil
+	}
+
+	// Decode Gemini's PCM audio (24kHz, 16-bit, little-endian)
+	pcmData, err := base64.StdEncoding.DecodeString(payload.Data)
+	if err != nil {
+		return fmt.Errorf("failed to decode Gemini audio: %w", err)
+	}
+
+	// Encode in TelnyxBidirectionalCodec
+	var out telnyxMedia
+	out.Event = "media"
+	out.Media.Payload = base64.StdEncoding.EncodeToString(audio.PCM24kToMuLaw8k(pcmData))
+	_ = t.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return t.conn.WriteJSON(out)
+}
+
+// Close sends a close frame, then closes the connection
+func (t *Telnyx) Close() error {
+	return closeConn(t.conn)
+}
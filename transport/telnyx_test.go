@@ -0,0 +1,169 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/room4-2/OpenConverse/audio"
+	"github.com/room4-2/OpenConverse/messages"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestTelnyxReplay(t *testing.T) {
+	tests := []struct {
+		recording string
+		decode    func([]byte) []byte
+	}{
+		{"telnyx_pcmu.jsonl", audio.MuLaw8kToPCM16k},
+		{"telnyx_pcma.jsonl", audio.ALaw8kToPCM16k},
+	}
+	for _, tt := range tests {
+		t.Run(tt.recording, func(t *testing.T) {
+			tr, provider := connect(t, NewTelnyx)
+			replay(t, provider, tt.recording)
+
+			start := receive(t, tr)
+			if start.Type != EventStart || start.Caller != "+13122010094" || start.StreamID != "32de0dc2-7d0d-4d1c-a4c4-a0b7e4d5b2a6" {
+				t.Fatalf("first event = %+v, want the start of the stream", start)
+			}
+
+			// The outbound track is skipped; the inbound one is decoded to 16kHz PCM
+			media := receive(t, tr)
+			if media.Type != EventAudio {
+				t.Fatalf("event after start = %+v, want audio", media)
+			}
+			raw := inboundPayload(t, tt.recording)
+			if want := tt.decode(raw); !bytes.Equal(media.Audio, want) {
+				t.Errorf("decoded %d bytes, want %d decoded with the stream's codec", len(media.Audio), len(want))
+			}
+			if len(media.Audio) != len(raw)*4 {
+				t.Errorf("decoded %d bytes from %d samples, want 16kHz 16-bit", len(media.Audio), len(raw))
+			}
+
+			if dtmf := receive(t, tr); dtmf.Type != EventDTMF || dtmf.Digit != "5" {
+				t.Errorf("event after mark = %+v, want DTMF 5", dtmf)
+			}
+			if failure := receive(t, tr); failure.Type != EventInvalid || failure.Err == nil {
+				t.Errorf("error event = %+v, want EventInvalid", failure)
+			}
+			if stop := receive(t, tr); stop.Type != EventStop {
+				t.Errorf("last event = %+v, want stop", stop)
+			}
+
+			_ = provider.Close()
+			if _, err := tr.Receive(); err == nil {
+				t.Error("Receive after the provider hung up returned no error")
+			}
+		})
+	}
+}
+
+// inboundPayload returns the G.711 payload of the inbound media event of a recording
+func inboundPayload(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var msg telnyxMessage
+		if json.Unmarshal(line, &msg) != nil || msg.Media == nil || msg.Media.Track != "inbound" {
+			continue
+		}
+		payload, err := base64.StdEncoding.DecodeString(msg.Media.Payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return payload
+	}
+	t.Fatalf("no inbound media in %s", name)
+	return nil
+}
+
+func TestTelnyxUnsupportedFormat(t *testing.T) {
+	tr, provider := connect(t, NewTelnyx)
+	start := `{"event":"start","start":{"from":"+13122010094","media_format":{"encoding":"L16","sample_rate":16000,"channels":1}},"stream_id":"s"}`
+	if err := provider.WriteMessage(websocket.TextMessage, []byte(start)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tr.Receive(); err == nil {
+		t.Fatal("Receive accepted a 16kHz L16 stream")
+	}
+}
+
+func TestTelnyxMalformedFrames(t *testing.T) {
+	tr, provider := connect(t, NewTelnyx)
+	for _, frame := range []string{
+		`not json`,
+		`{"sequence_number":"1"}`,
+		`{"event":"start","stream_id":"s"}`,
+		`{"event":"dtmf","stream_id":"s"}`,
+		`{"event":"dtmf","dtmf":{"digit":"X"},"stream_id":"s"}`,
+		`{"event":"transcription","stream_id":"s"}`,
+	} {
+		if err := provider.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+			t.Fatal(err)
+		}
+		if event := receive(t, tr); event.Type != EventInvalid || event.Err == nil {
+			t.Errorf("%s: event = %+v, want EventInvalid", frame, event)
+		}
+	}
+}
+
+func TestTelnyxSend(t *testing.T) {
+	for _, encoding := range []string{"PCMU", "PCMA"} {
+		t.Run(encoding, func(t *testing.T) {
+			tr, provider := connect(t, NewTelnyx)
+
+			// Audio before the stream started has nowhere to go
+			if err := tr.Send(geminiAudio(960)); err != nil {
+				t.Fatalf("Send before start: %v", err)
+			}
+
+			start := `{"event":"start","start":{"media_format":{"encoding":"` + encoding + `","sample_rate":8000,"channels":1}},"stream_id":"s"}`
+			if err := provider.WriteMessage(websocket.TextMessage, []byte(start)); err != nil {
+				t.Fatal(err)
+			}
+			receive(t, tr)
+
+			msg := geminiAudio(960)
+			if err := tr.Send(messages.NewStatusMessage("s1", "connected", "")); err != nil {
+				t.Fatalf("Send status: %v", err)
+			}
+			if err := tr.Send(msg); err != nil {
+				t.Fatalf("Send audio: %v", err)
+			}
+
+			_ = provider.SetReadDeadline(time.Now().Add(time.Second))
+			_, frame, err := provider.ReadMessage()
+			if err != nil {
+				t.Fatalf("provider read: %v", err)
+			}
+			var out telnyxMedia
+			if err := json.Unmarshal(frame, &out); err != nil || out.Event != "media" {
+				t.Fatalf("frame = %s, want a media event", frame)
+			}
+			// Response audio is in the codec TeXML declared, whatever the caller's
+			pcm, _ := base64.StdEncoding.DecodeString(msg.Payload.(messages.AudioResponsePayload).Data)
+			want := audio.PCM24kToMuLaw8k(pcm)
+			if got, _ := base64.StdEncoding.DecodeString(out.Media.Payload); !bytes.Equal(got, want) {
+				t.Errorf("sent %d bytes, want %d bytes of %s", len(got), len(want), TelnyxBidirectionalCodec)
+			}
+		})
+	}
+}
+
+func TestTelnyxHangup(t *testing.T) {
+	tr, provider := connect(t, NewTelnyx)
+	_ = provider.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	if _, err := tr.Receive(); !errors.Is(err, io.EOF) {
+		t.Errorf("Receive after a normal close = %v, want io.EOF", err)
+	}
+}
@@ -0,0 +1,86 @@
+// Package transport adapts client protocols to sessions: each Transport
+// decodes inbound frames into audio and events and encodes what the
+// session sends back
+package transport
+
+import (
+	"strings"
+
+	"github.com/room4-2/OpenConverse/messages"
+)
+
+// EventType identifies an inbound event
+type EventType int
+
+const (
+	// EventAudio carries caller audio as 16kHz 16-bit mono PCM (little-endian)
+	EventAudio EventType = iota
+	// EventStart reports that the media stream started: the call was answered
+	// or the client connected. Every transport reports it before other events.
+	EventStart
+	// EventEndTurn reports that the client finished speaking; buffered audio is sent to Gemini
+	EventEndTurn
+	// EventPing is a keep-alive from the client, answered with a "pong" status
+	EventPing
+	// EventStop reports that the client ended the stream
+	EventStop
+	// EventInvalid reports a frame that couldn't be decoded; Err says why
+	EventInvalid
+	// EventDTMF reports a key pressed by the caller
+	EventDTMF
+)
+
+// Event is a decoded inbound frame
+type Event struct {
+	Type     EventType
+	Audio    []byte    // EventAudio
+	StreamID string    // EventStart: the provider's stream identifier
+	Caller   string    // EventStart: the caller's identity, e.g. their phone number, when known
+	Called   string    // EventStart: the number the caller dialed, when known
+	Call     *CallInfo // EventStart: what the provider reported about the call, when it does
+	Digit    string    // EventDTMF: 0-9, *, # or A-D
+	Err      error     // EventInvalid
+}
+
+// CallInfo is what a telephony provider reports about a call when its media
+// stream starts
+type CallInfo struct {
+	CallSID    string            `json:"callSid,omitempty"`    // The provider's call identifier
+	AccountSID string            `json:"accountSid,omitempty"` // Account the call belongs to
+	StreamSID  string            `json:"streamSid,omitempty"`  // Identifier of the media stream
+	Tracks     []string          `json:"tracks,omitempty"`     // Audio streamed: "inbound", "outbound"
+	Encoding   string            `json:"encoding,omitempty"`   // Audio encoding, e.g. "audio/x-mulaw"
+	SampleRate int               `json:"sampleRate,omitempty"` // In Hz
+	Channels   int               `json:"channels,omitempty"`
+	Parameters map[string]string `json:"parameters,omitempty"` // Custom parameters the call passed to the stream
+}
+
+// dtmfDigits are the keys of a phone keypad, in RFC 4733 event code order
+const dtmfDigits = "0123456789*#ABCD"
+
+// keyPressed returns the EventDTMF of a key reported by a provider
+func keyPressed(digit string) Event {
+	digit = strings.ToUpper(digit)
+	if len(digit) != 1 || !strings.Contains(dtmfDigits, digit) {
+		return invalid("Unknown DTMF digit: " + digit)
+	}
+	return Event{Type: EventDTMF, Digit: digit}
+}
+
+// Transport is a client connection speaking one protocol. Receive is called
+// from a single goroutine and Send from another; Close may be called from any.
+type Transport interface {
+	// Name identifies the protocol in metrics, logs and session records
+	Name() string
+	// Streaming reports whether caller audio is sent to Gemini as it arrives,
+	// letting Gemini detect turns, rather than buffered until EventEndTurn
+	Streaming() bool
+	// Receive blocks until the next inbound event. It returns io.EOF once the
+	// client closed the connection normally, and another error if it failed.
+	Receive() (Event, error)
+	// Send writes a message to the client. Messages the protocol has no use
+	// for (e.g. status updates on a phone call) are dropped.
+	Send(msg *messages.ServerMessage) error
+	// Close closes the connection
+	Close() error
+}
@@ -0,0 +1,88 @@
+package transport
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/room4-2/OpenConverse/messages"
+
+	"github.com/gorilla/websocket"
+)
+
+// connect upgrades a WebSocket on a test server, wraps the server's end with
+// wrap and returns it with the provider's end
+func connect[T Transport](t *testing.T, wrap func(*websocket.Conn) T) (T, *websocket.Conn) {
+	t.Helper()
+	conns := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(srv.Close)
+
+	provider, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = provider.Close() })
+
+	select {
+	case conn := <-conns:
+		tr := wrap(conn)
+		t.Cleanup(func() { _ = tr.Close() })
+		return tr, provider
+	case <-time.After(time.Second):
+		t.Fatal("server side of the WebSocket never connected")
+		panic("unreachable")
+	}
+}
+
+// replay sends the recorded text frames of a testdata file, one per line
+func replay(t *testing.T, provider *websocket.Conn, name string) {
+	t.Helper()
+	f, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		if err := provider.WriteMessage(websocket.TextMessage, scanner.Bytes()); err != nil {
+			t.Fatalf("replay %s: %v", name, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// receive returns the next event, failing the test on error
+func receive(t *testing.T, tr Transport) Event {
+	t.Helper()
+	event, err := tr.Receive()
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	return event
+}
+
+// geminiAudio is a response audio message carrying n bytes of 24kHz PCM
+func geminiAudio(n int) *messages.ServerMessage {
+	pcm := make([]byte, n)
+	for i := range pcm {
+		pcm[i] = byte(i * 7)
+	}
+	return messages.NewAudioMessage("s1", base64.StdEncoding.EncodeToString(pcm))
+}
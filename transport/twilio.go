@@ -0,0 +1,140 @@
+package transport
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/room4-2/OpenConverse/audio"
+	"github.com/room4-2/OpenConverse/messages"
+	"github.com/room4-2/OpenConverse/twilio"
+
+	"github.com/gorilla/websocket"
+)
+
+// Twilio is the Media Streams protocol of Twilio voice calls: mu-law 8kHz
+// audio in JSON "media" events, streamed to Gemini as it arrives.
+// Twilio sends: connected, start, media, mark, dtmf and stop events.
+type Twilio struct {
+	conn *websocket.Conn
+
+	mu        sync.RWMutex
+	streamSid string // Set by the "start" event; outbound audio needs it
+}
+
+// NewTwilio wraps an upgraded Twilio media stream connection
+func NewTwilio(conn *websocket.Conn) *Twilio {
+	conn.SetReadLimit(readLimit)
+	// Twilio doesn't support WebSocket compression
+	conn.EnableWriteCompression(false)
+
+	return &Twilio{conn: conn}
+}
+
+// callInfo returns what a "start" event says about the call
+func callInfo(start *twilio.Start) *CallInfo {
+	return &CallInfo{
+		CallSID:    start.CallSid,
+		AccountSID: start.AccountSid,
+		StreamSID:  start.StreamSid,
+		Tracks:     start.Tracks,
+		Encoding:   start.MediaFormat.Encoding,
+		SampleRate: start.MediaFormat.SampleRate,
+		Channels:   start.MediaFormat.Channels,
+		Parameters: start.CustomParameters,
+	}
+}
+
+func (t *Twilio) Name() string    { return "twilio" }
+func (t *Twilio) Streaming() bool { return true }
+
+func (t *Twilio) Receive() (Event, error) {
+	for {
+		_, message, err := t.conn.ReadMessage()
+		if err != nil {
+			return Event{}, readError(err)
+		}
+
+		msg, err := twilio.DecodeMessage(message)
+		if err != nil {
+			return Event{Type: EventInvalid, Err: err}, nil
+		}
+
+		switch msg.Event {
+		case twilio.EventConnected, twilio.EventMark:
+			// Informational, ignore
+
+		case twilio.EventStart:
+			if err := msg.Start.CheckFormat(); err != nil {
+				// Nothing of the call could be understood or answered
+				return Event{}, err
+			}
+
+			t.mu.Lock()
+			t.streamSid = msg.Start.StreamSid
+			t.mu.Unlock()
+			// The call's TwiML passes its numbers as custom stream parameters;
+			// "caller" is the parameter of earlier versions
+			params := msg.Start.CustomParameters
+			caller := params["From"]
+			if caller == "" {
+				caller = params["caller"]
+			}
+			return Event{Type: EventStart, StreamID: msg.Start.StreamSid, Caller: caller, Called: params["To"], Call: callInfo(msg.Start)}, nil
+
+		case twilio.EventMedia:
+			// Only the caller's track is audio for Gemini
+			if msg.Media.Track != "" && msg.Media.Track != "inbound" {
+				continue
+			}
+			muLawData, err := msg.Media.Audio()
+			if err != nil {
+				return Event{Type: EventInvalid, Err: fmt.Errorf("failed to decode Twilio audio: %w", err)}, nil
+			}
+			// Convert mu-law (8kHz) -> PCM (8kHz) -> upsample to PCM (16kHz) for Gemini
+			return Event{Type: EventAudio, Audio: audio.MuLaw8kToPCM16k(muLawData)}, nil
+
+		case twilio.EventDTMF:
+			return keyPressed(msg.DTMF.Digit), nil
+
+		case twilio.EventStop:
+			return Event{Type: EventStop}, nil
+		}
+	}
+}
+
+// Send plays response audio to the caller; other messages are dropped
+func (t *Twilio) Send(msg *messages.ServerMessage) error {
+	if msg.Type != messages.TypeAudio {
+		return nil
+	}
+	payload, ok := msg.Payload.(messages.AudioResponsePayload)
+	if !ok {
+		return nil
+	}
+
+	t.mu.RLock()
+	streamSid := t.streamSid
+	t.mu.RUnlock()
+	if streamSid == "" {
+		// Audio before the "start" event has nowhere to go
+		return nil
+	}
+
+	// Decode Gemini's PCM audio (24kHz, 16-bit, little-endian)
+	pcmData, err := base64.StdEncoding.DecodeString(payload.Data)
+	if err != nil {
+		return fmt.Errorf("failed to decode Gemini audio: %w", err)
+	}
+
+	// Send mu-law audio back to Twilio as base64
+	encoded := base64.StdEncoding.EncodeToString(audio.PCM24kToMuLaw8k(pcmData))
+	_ = t.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return t.conn.WriteJSON(twilio.NewMediaMessage(streamSid, encoded))
+}
+
+// Close sends a close frame, then closes the connection
+func (t *Twilio) Close() error {
+	return closeConn(t.conn)
+}
@@ -0,0 +1,138 @@
+package transport
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/room4-2/OpenConverse/audio"
+	"github.com/room4-2/OpenConverse/messages"
+
+	"github.com/bytedance/sonic"
+	"github.com/gorilla/websocket"
+)
+
+// vonageFrameSize is 20ms of 16kHz 16-bit audio; Vonage only plays binary
+// frames of exactly this size
+const vonageFrameSize = 640
+
+// Vonage is the WebSocket protocol of Vonage voice calls: raw L16 16kHz
+// audio in binary frames, streamed to Gemini as it arrives, and JSON text
+// events. The first event, websocket:connected, carries the headers set in
+// the NCCO connecting the call.
+type Vonage struct {
+	conn *websocket.Conn
+
+	pending []byte // Outbound audio short of a full frame; only touched by Send
+}
+
+// vonageMessage is an inbound Vonage text event
+type vonageMessage struct {
+	Event       string `json:"event"`
+	ContentType string `json:"content-type"`
+	// Headers from the NCCO's websocket endpoint
+	Caller string `json:"caller"`
+	UUID   string `json:"uuid"`
+	// websocket:dtmf
+	Digit string `json:"digit"`
+}
+
+// NewVonage wraps an upgraded Vonage WebSocket connection
+func NewVonage(conn *websocket.Conn) *Vonage {
+	conn.SetReadLimit(readLimit)
+	conn.EnableWriteCompression(false)
+
+	return &Vonage{conn: conn}
+}
+
+func (t *Vonage) Name() string    { return "vonage" }
+func (t *Vonage) Streaming() bool { return true }
+
+func (t *Vonage) Receive() (Event, error) {
+	for {
+		messageType, message, err := t.conn.ReadMessage()
+		if err != nil {
+			return Event{}, readError(err)
+		}
+
+		// Binary frames are already the 16kHz PCM Gemini takes
+		if messageType == websocket.BinaryMessage {
+			return Event{Type: EventAudio, Audio: message}, nil
+		}
+
+		var msg vonageMessage
+		if err := sonic.Unmarshal(message, &msg); err != nil {
+			return Event{Type: EventInvalid, Err: fmt.Errorf("failed to parse Vonage message: %w", err)}, nil
+		}
+
+		switch msg.Event {
+		case "websocket:connected":
+			// The NCCO asks for 16kHz; anything else would play at the wrong speed
+			contentType := strings.ReplaceAll(strings.ToLower(msg.ContentType), " ", "")
+			if contentType != "" && contentType != "audio/l16;rate=16000" {
+				return Event{}, fmt.Errorf("unsupported Vonage content type %q", msg.ContentType)
+			}
+			return Event{Type: EventStart, StreamID: msg.UUID, Caller: msg.Caller}, nil
+
+		case "websocket:dtmf":
+			return keyPressed(msg.Digit), nil
+
+		case "":
+			return invalid("Vonage message missing 'event' field"), nil
+
+		default:
+			return invalid("Unknown Vonage event: " + msg.Event), nil
+		}
+	}
+}
+
+// Send plays response audio to the caller in 640-byte frames. The remainder
+// of a response is padded with silence once the turn completes; other
+// messages are dropped.
+func (t *Vonage) Send(msg *messages.ServerMessage) error {
+	switch msg.Type {
+	case messages.TypeAudio:
+		payload, ok := msg.Payload.(messages.AudioResponsePayload)
+		if !ok {
+			return nil
+		}
+		// Decode Gemini's PCM audio (24kHz, 16-bit, little-endian)
+		pcmData, err := base64.StdEncoding.DecodeString(payload.Data)
+		if err != nil {
+			return fmt.Errorf("failed to decode Gemini audio: %w", err)
+		}
+		t.pending = append(t.pending, audio.PCM24kToPCM16k(pcmData)...)
+		return t.writeFrames()
+
+	case messages.TypeStatus:
+		payload, ok := msg.Payload.(messages.StatusPayload)
+		if !ok || payload.Status != "turn_complete" || len(t.pending) == 0 {
+			return nil
+		}
+		t.pending = append(t.pending, make([]byte, vonageFrameSize-len(t.pending))...)
+		return t.writeFrames()
+
+	default:
+		return nil
+	}
+}
+
+// writeFrames writes every full frame of pending audio
+func (t *Vonage) writeFrames() error {
+	_ = t.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	for len(t.pending) >= vonageFrameSize {
+		if err := t.conn.WriteMessage(websocket.BinaryMessage, t.pending[:vonageFrameSize]); err != nil {
+			return err
+		}
+		t.pending = t.pending[vonageFrameSize:]
+	}
+	// Keep the remainder in a fresh buffer rather than pinning the old one
+	t.pending = append([]byte(nil), t.pending...)
+	return nil
+}
+
+// Close sends a close frame, then closes the connection
+func (t *Vonage) Close() error {
+	return closeConn(t.conn)
+}
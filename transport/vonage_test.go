@@ -0,0 +1,133 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/room4-2/OpenConverse/audio"
+	"github.com/room4-2/OpenConverse/messages"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestVonageReplay(t *testing.T) {
+	tr, provider := connect(t, NewVonage)
+	replay(t, provider, "vonage_events.jsonl")
+
+	start := receive(t, tr)
+	if start.Type != EventStart || start.Caller != "447700900000" || start.StreamID != "aaaaaaaa-bbbb-cccc-dddd-0123456789ab" {
+		t.Fatalf("first event = %+v, want the start of the stream", start)
+	}
+	if dtmf := receive(t, tr); dtmf.Type != EventDTMF || dtmf.Digit != "#" {
+		t.Errorf("second event = %+v, want DTMF #", dtmf)
+	}
+
+	// Binary frames are the caller's 16kHz PCM, passed through as is
+	frame := bytes.Repeat([]byte{0x10, 0x20}, vonageFrameSize/2)
+	if err := provider.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+		t.Fatal(err)
+	}
+	if event := receive(t, tr); event.Type != EventAudio || !bytes.Equal(event.Audio, frame) {
+		t.Errorf("binary frame decoded as %+v", event.Type)
+	}
+}
+
+func TestVonageUnsupportedContentType(t *testing.T) {
+	tr, provider := connect(t, NewVonage)
+	connected := `{"event":"websocket:connected","content-type":"audio/l16;rate=8000"}`
+	if err := provider.WriteMessage(websocket.TextMessage, []byte(connected)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tr.Receive(); err == nil {
+		t.Fatal("Receive accepted an 8kHz stream")
+	}
+}
+
+func TestVonageMalformedFrames(t *testing.T) {
+	tr, provider := connect(t, NewVonage)
+	for _, frame := range []string{
+		`not json`,
+		`{"digit":"1"}`,
+		`{"event":"websocket:dtmf","digit":"E"}`,
+		`{"event":"websocket:notify"}`,
+	} {
+		if err := provider.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+			t.Fatal(err)
+		}
+		if event := receive(t, tr); event.Type != EventInvalid || event.Err == nil {
+			t.Errorf("%s: event = %+v, want EventInvalid", frame, event)
+		}
+	}
+}
+
+func TestVonageSendFraming(t *testing.T) {
+	tr, provider := connect(t, NewVonage)
+
+	// 1500 bytes at 24kHz are 1000 at 16kHz: one full frame and 360 bytes held back
+	msg := geminiAudio(1500)
+	if err := tr.Send(msg); err != nil {
+		t.Fatalf("Send audio: %v", err)
+	}
+	pcm, _ := base64.StdEncoding.DecodeString(msg.Payload.(messages.AudioResponsePayload).Data)
+	want := audio.PCM24kToPCM16k(pcm)
+	if len(want) != 1000 {
+		t.Fatalf("resampled to %d bytes, want 1000", len(want))
+	}
+
+	first := readFrame(t, provider)
+	if !bytes.Equal(first, want[:vonageFrameSize]) {
+		t.Error("first frame is not the start of the response")
+	}
+
+	// Other status messages don't flush the remainder
+	if err := tr.Send(messages.NewStatusMessage("s1", "connected", "")); err != nil {
+		t.Fatalf("Send status: %v", err)
+	}
+	_ = provider.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, _, err := provider.ReadMessage(); err == nil {
+		t.Fatal("a partial frame was sent before the turn completed")
+	}
+
+	// The end of the turn pads the remainder with silence to a full frame.
+	// The timed-out read above broke the provider's connection, so read from a fresh one.
+	tr, provider = connect(t, NewVonage)
+	if err := tr.Send(msg); err != nil {
+		t.Fatalf("Send audio: %v", err)
+	}
+	readFrame(t, provider)
+	if err := tr.Send(messages.NewTurnCompleteMessage("s1", nil)); err != nil {
+		t.Fatalf("Send turn complete: %v", err)
+	}
+	last := readFrame(t, provider)
+	if !bytes.Equal(last[:360], want[vonageFrameSize:]) {
+		t.Error("last frame doesn't start with the rest of the response")
+	}
+	if !bytes.Equal(last[360:], make([]byte, vonageFrameSize-360)) {
+		t.Error("last frame isn't padded with silence")
+	}
+
+	// Nothing is left to pad on the next turn
+	if err := tr.Send(messages.NewTurnCompleteMessage("s1", nil)); err != nil {
+		t.Fatalf("Send turn complete: %v", err)
+	}
+	_ = provider.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, _, err := provider.ReadMessage(); err == nil {
+		t.Error("an empty turn sent a frame")
+	}
+}
+
+// readFrame reads a binary audio frame sent to the provider
+func readFrame(t *testing.T, provider *websocket.Conn) []byte {
+	t.Helper()
+	_ = provider.SetReadDeadline(time.Now().Add(time.Second))
+	messageType, frame, err := provider.ReadMessage()
+	if err != nil {
+		t.Fatalf("provider read: %v", err)
+	}
+	if messageType != websocket.BinaryMessage || len(frame) != vonageFrameSize {
+		t.Fatalf("frame of type %d and %d bytes, want %d binary bytes", messageType, len(frame), vonageFrameSize)
+	}
+	return frame
+}
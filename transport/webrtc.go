@@ -0,0 +1,352 @@
+package transport
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/room4-2/OpenConverse/audio"
+	"github.com/room4-2/OpenConverse/messages"
+
+	"github.com/bytedance/sonic"
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	// opusFrameTime is the audio in each packet we send
+	opusFrameTime = 20 * time.Millisecond
+	// opusFrameTicks is opusFrameTime in the 48kHz RTP clock of Opus
+	opusFrameTicks = 960
+)
+
+// WebRTCConfig configures the WebRTC stack shared by all connections
+type WebRTCConfig struct {
+	PublicIP string // Advertised in ICE candidates instead of the local addresses (optional)
+	UDPPort  int    // One UDP port for all connections (0 uses a port per connection)
+}
+
+// NewWebRTCAPI builds the WebRTC stack: Opus audio only, with NACK and RTCP
+// reports, on the configured addresses
+func NewWebRTCAPI(config WebRTCConfig) (*webrtc.API, error) {
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType:    webrtc.MimeTypeOpus,
+			ClockRate:   48000,
+			Channels:    2,
+			SDPFmtpLine: "minptime=10;useinbandfec=1",
+		},
+		PayloadType: 111,
+	}, webrtc.RTPCodecTypeAudio); err != nil {
+		return nil, err
+	}
+
+	interceptors := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(mediaEngine, interceptors); err != nil {
+		return nil, err
+	}
+
+	settings := webrtc.SettingEngine{}
+	if config.PublicIP != "" {
+		settings.SetNAT1To1IPs([]string{config.PublicIP}, webrtc.ICECandidateTypeHost)
+	}
+	if config.UDPPort != 0 {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: config.UDPPort})
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen for WebRTC on UDP port %d: %w", config.UDPPort, err)
+		}
+		settings.SetICEUDPMux(webrtc.NewICEUDPMux(nil, conn))
+	}
+
+	return webrtc.NewAPI(
+		webrtc.WithMediaEngine(mediaEngine),
+		webrtc.WithInterceptorRegistry(interceptors),
+		webrtc.WithSettingEngine(settings),
+	), nil
+}
+
+// WebRTC is a browser's peer connection: Opus audio both ways, streamed to
+// Gemini as it arrives, and the JSON messages of WebSocket clients on the
+// data channel the browser opens
+type WebRTC struct {
+	pc     *webrtc.PeerConnection
+	track  *webrtc.TrackLocalStaticRTP
+	codec  *audio.OpusCodec
+	events chan Event
+
+	mu      sync.Mutex
+	channel *webrtc.DataChannel // The browser's data channel, once open
+	pending []byte              // Response audio (24kHz PCM) waiting for its packet slot
+	err     error               // Why the connection ended, nil for a normal close
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// NewWebRTC answers a browser's SDP offer. The answer carries every ICE
+// candidate, so the browser needn't trickle.
+func NewWebRTC(ctx context.Context, api *webrtc.API, offer string) (*WebRTC, string, error) {
+	codec, err := audio.NewOpusCodec()
+	if err != nil {
+		return nil, "", err
+	}
+
+	pc, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		_ = codec.Close()
+		return nil, "", err
+	}
+	t := &WebRTC{
+		pc:     pc,
+		codec:  codec,
+		events: make(chan Event, 64),
+		done:   make(chan struct{}),
+	}
+
+	answer, err := t.negotiate(ctx, offer)
+	if err != nil {
+		_ = pc.Close()
+		_ = codec.Close()
+		return nil, "", err
+	}
+	go t.sendLoop()
+	return t, answer, nil
+}
+
+// negotiate sets up the tracks and callbacks, then answers offer
+func (t *WebRTC) negotiate(ctx context.Context, offer string) (string, error) {
+	track, err := webrtc.NewTrackLocalStaticRTP(
+		webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}, "audio", "openconverse")
+	if err != nil {
+		return "", err
+	}
+	sender, err := t.pc.AddTrack(track)
+	if err != nil {
+		return "", err
+	}
+	t.track = track
+	// RTCP has to be read for the interceptors to work
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			if _, _, err := sender.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	t.pc.OnTrack(func(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		if remote.Kind() == webrtc.RTPCodecTypeAudio {
+			go t.readTrack(remote)
+		}
+	})
+	t.pc.OnDataChannel(t.openChannel)
+	t.pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			t.push(Event{Type: EventStart})
+		case webrtc.PeerConnectionStateFailed:
+			t.end(errors.New("WebRTC connection failed"))
+		case webrtc.PeerConnectionStateClosed:
+			t.end(nil)
+		}
+	})
+
+	if err := t.pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer}); err != nil {
+		return "", fmt.Errorf("invalid SDP offer: %w", err)
+	}
+	answer, err := t.pc.CreateAnswer(nil)
+	if err != nil {
+		return "", err
+	}
+	gathered := webrtc.GatheringCompletePromise(t.pc)
+	if err := t.pc.SetLocalDescription(answer); err != nil {
+		return "", err
+	}
+	select {
+	case <-gathered:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	return t.pc.LocalDescription().SDP, nil
+}
+
+// readTrack decodes the browser's audio for Gemini
+func (t *WebRTC) readTrack(remote *webrtc.TrackRemote) {
+	for {
+		packet, _, err := remote.ReadRTP()
+		if err != nil {
+			return
+		}
+		if len(packet.Payload) == 0 {
+			continue
+		}
+		pcm, err := t.codec.Decode(packet.Payload)
+		if err != nil {
+			t.push(Event{Type: EventInvalid, Err: fmt.Errorf("failed to decode Opus audio: %w", err)})
+			continue
+		}
+		t.push(Event{Type: EventAudio, Audio: pcm})
+	}
+}
+
+// openChannel takes the browser's data channel for JSON messages
+func (t *WebRTC) openChannel(channel *webrtc.DataChannel) {
+	channel.OnOpen(func() {
+		t.mu.Lock()
+		t.channel = channel
+		t.mu.Unlock()
+	})
+	channel.OnMessage(func(msg webrtc.DataChannelMessage) {
+		if !msg.IsString {
+			return
+		}
+		var clientMsg messages.ClientMessage
+		if err := sonic.Unmarshal(msg.Data, &clientMsg); err != nil {
+			t.push(invalid("Invalid message format"))
+			return
+		}
+		if event, ok := decodeClientMessage(&clientMsg); ok {
+			t.push(event)
+		}
+	})
+	// Closing the tab closes the channel long before ICE times out
+	channel.OnClose(func() { t.end(nil) })
+}
+
+// push queues an inbound event, unless the connection ended
+func (t *WebRTC) push(event Event) {
+	select {
+	case t.events <- event:
+	case <-t.done:
+	}
+}
+
+// end marks the connection over, err saying why when it failed
+func (t *WebRTC) end(err error) {
+	t.closeOnce.Do(func() {
+		t.mu.Lock()
+		t.err = err
+		t.mu.Unlock()
+		close(t.done)
+		_ = t.pc.Close()
+		_ = t.codec.Close()
+	})
+}
+
+func (t *WebRTC) Name() string    { return "webrtc" }
+func (t *WebRTC) Streaming() bool { return true }
+
+func (t *WebRTC) Receive() (Event, error) {
+	// Events queued before the end are still delivered
+	select {
+	case event := <-t.events:
+		return event, nil
+	default:
+	}
+	select {
+	case event := <-t.events:
+		return event, nil
+	case <-t.done:
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.err != nil {
+			return Event{}, t.err
+		}
+		return Event{}, io.EOF
+	}
+}
+
+// Send queues response audio for the audio track and writes other messages
+// to the data channel as JSON, dropping them until it's open
+func (t *WebRTC) Send(msg *messages.ServerMessage) error {
+	if msg.Type == messages.TypeAudio {
+		payload, ok := msg.Payload.(messages.AudioResponsePayload)
+		if !ok {
+			return nil
+		}
+		pcmData, err := base64.StdEncoding.DecodeString(payload.Data)
+		if err != nil {
+			return fmt.Errorf("failed to decode Gemini audio: %w", err)
+		}
+		t.mu.Lock()
+		t.pending = append(t.pending, pcmData...)
+		t.mu.Unlock()
+		return nil
+	}
+
+	t.mu.Lock()
+	channel := t.channel
+	t.mu.Unlock()
+	if channel == nil {
+		return nil
+	}
+	data, err := sonic.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return channel.SendText(string(data))
+}
+
+// sendLoop encodes and sends a packet of queued audio every 20ms. A partial
+// frame waits one interval for more audio, then is padded with silence.
+// Nothing is sent between responses, but the RTP timestamp keeps time so the
+// browser's jitter buffer doesn't mistake silences for network delay.
+func (t *WebRTC) sendLoop() {
+	ticker := time.NewTicker(opusFrameTime)
+	defer ticker.Stop()
+
+	frame := make([]byte, audio.OpusFrameBytes)
+	packet := &rtp.Packet{Header: rtp.Header{Version: 2}}
+	talking := false
+	waited := false
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+		}
+		packet.Timestamp += opusFrameTicks
+
+		t.mu.Lock()
+		n := min(len(t.pending), audio.OpusFrameBytes)
+		if n == 0 || (n < audio.OpusFrameBytes && !waited) {
+			waited = n > 0
+			talking = talking && n > 0
+			t.mu.Unlock()
+			continue
+		}
+		copy(frame, t.pending[:n])
+		clear(frame[n:])
+		t.pending = t.pending[n:]
+		if len(t.pending) == 0 {
+			t.pending = nil
+		}
+		t.mu.Unlock()
+		waited = false
+
+		payload, err := t.codec.Encode(frame)
+		if err != nil {
+			continue
+		}
+		// The marker bit starts a talkspurt; the track sets the SSRC and payload type
+		packet.Marker = !talking
+		talking = true
+		packet.SequenceNumber++
+		packet.Payload = payload
+		_ = t.track.WriteRTP(packet)
+	}
+}
+
+// Close closes the peer connection
+func (t *WebRTC) Close() error {
+	t.end(nil)
+	return nil
+}
@@ -0,0 +1,144 @@
+package transport
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/room4-2/OpenConverse/messages"
+
+	"github.com/bytedance/sonic"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	readLimit    = 512 * 1024 // Largest accepted client message
+	writeTimeout = 10 * time.Second
+)
+
+// WebSocket is the JSON protocol of browser and app clients. Audio is
+// buffered until the client sends an end_turn control message.
+type WebSocket struct {
+	conn    *websocket.Conn
+	started bool // EventStart returned; Receive goroutine only
+}
+
+// NewWebSocket wraps an upgraded client connection
+func NewWebSocket(conn *websocket.Conn) *WebSocket {
+	// Configure WebSocket for better performance
+	conn.SetReadLimit(readLimit)
+	conn.EnableWriteCompression(true)
+	_ = conn.SetCompressionLevel(6)
+
+	return &WebSocket{conn: conn}
+}
+
+func (t *WebSocket) Name() string    { return "websocket" }
+func (t *WebSocket) Streaming() bool { return false }
+
+func (t *WebSocket) Receive() (Event, error) {
+	// The stream starts with the connection
+	if !t.started {
+		t.started = true
+		return Event{Type: EventStart}, nil
+	}
+
+	for {
+		messageType, message, err := t.conn.ReadMessage()
+		if err != nil {
+			return Event{}, readError(err)
+		}
+
+		// Binary messages are raw PCM audio
+		if messageType == websocket.BinaryMessage {
+			return Event{Type: EventAudio, Audio: message}, nil
+		}
+
+		var clientMsg messages.ClientMessage
+		if err := sonic.Unmarshal(message, &clientMsg); err != nil {
+			return invalid("Invalid message format"), nil
+		}
+
+		event, ok := decodeClientMessage(&clientMsg)
+		if ok {
+			return event, nil
+		}
+	}
+}
+
+// decodeClientMessage decodes a JSON client message; ok is false for
+// messages that are silently ignored
+func decodeClientMessage(msg *messages.ClientMessage) (event Event, ok bool) {
+	switch msg.Type {
+	case "audio":
+		var payload messages.AudioPayload
+		if err := sonic.Unmarshal(msg.Payload, &payload); err != nil {
+			return invalid("Invalid audio payload"), true
+		}
+		audioBytes, err := base64.StdEncoding.DecodeString(payload.Data)
+		if err != nil {
+			return invalid("Invalid base64 audio data"), true
+		}
+		return Event{Type: EventAudio, Audio: audioBytes}, true
+
+	case "audio_binary":
+		// Handle binary audio (more efficient); malformed chunks are dropped
+		var payload messages.AudioPayload
+		if err := sonic.Unmarshal(msg.Payload, &payload); err != nil {
+			return Event{}, false
+		}
+		audioBytes, err := base64.StdEncoding.DecodeString(payload.Data)
+		if err != nil {
+			return Event{}, false
+		}
+		return Event{Type: EventAudio, Audio: audioBytes}, true
+
+	case "control":
+		var payload messages.ControlPayload
+		if err := sonic.Unmarshal(msg.Payload, &payload); err != nil {
+			return invalid("Invalid control payload"), true
+		}
+		switch payload.Action {
+		case "ping":
+			return Event{Type: EventPing}, true
+		case "end_turn":
+			return Event{Type: EventEndTurn}, true
+		default:
+			return invalid("Unknown control action: " + payload.Action), true
+		}
+
+	default:
+		return invalid("Unknown message type: " + msg.Type), true
+	}
+}
+
+func (t *WebSocket) Send(msg *messages.ServerMessage) error {
+	_ = t.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return t.conn.WriteJSON(msg)
+}
+
+// Close sends a close frame, then closes the connection
+func (t *WebSocket) Close() error {
+	return closeConn(t.conn)
+}
+
+// closeConn sends a normal close frame and closes conn. WriteControl may run
+// concurrently with a pending Send.
+func closeConn(conn *websocket.Conn) error {
+	_ = conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(writeTimeout))
+	return conn.Close()
+}
+
+// readError maps a normal closure of the connection to io.EOF
+func readError(err error) error {
+	if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+		return io.EOF
+	}
+	return err
+}
+
+func invalid(message string) Event {
+	return Event{Type: EventInvalid, Err: errors.New(message)}
+}
@@ -0,0 +1,118 @@
+package twilio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bytedance/sonic"
+)
+
+// DefaultAPIURL is the base URL of Twilio's REST API
+const DefaultAPIURL = "https://api.twilio.com"
+
+// RequestTimeout bounds a REST API request, including reading its response
+const RequestTimeout = 15 * time.Second
+
+// CallParams are the parameters of a new outbound call
+type CallParams struct {
+	To             string        // Number to call, E.164
+	From           string        // Caller ID: a number of the account, E.164
+	TwiML          string        // Instructions run once the call is answered
+	StatusCallback string        // URL Twilio posts the call's progress to (optional)
+	Timeout        time.Duration // How long to let the call ring (0 uses Twilio's 60s)
+}
+
+// Call is a call created through the REST API
+type Call struct {
+	SID    string `json:"sid"`
+	Status string `json:"status"`
+}
+
+// APIError is an error returned by the REST API
+type APIError struct {
+	Status  int    `json:"status"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("twilio API error %d (HTTP %d): %s", e.Code, e.Status, e.Message)
+}
+
+// Client calls Twilio's REST API for an account
+type Client struct {
+	accountSID string
+	authToken  string
+	baseURL    string // DefaultAPIURL, or a mock of it
+	httpClient *http.Client
+}
+
+// NewClient creates a client authenticating as accountSID with authToken.
+// baseURL is DefaultAPIURL unless pointing at a mock of the API. httpClient
+// sends the requests; nil uses a plain client with RequestTimeout.
+func NewClient(accountSID, authToken, baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: RequestTimeout}
+	}
+	return &Client{
+		accountSID: accountSID,
+		authToken:  authToken,
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: httpClient,
+	}
+}
+
+// CreateCall places an outbound call
+func (c *Client) CreateCall(ctx context.Context, params CallParams) (*Call, error) {
+	form := url.Values{}
+	form.Set("To", params.To)
+	form.Set("From", params.From)
+	form.Set("Twiml", params.TwiML)
+	if params.StatusCallback != "" {
+		form.Set("StatusCallback", params.StatusCallback)
+		form.Set("StatusCallbackMethod", http.MethodPost)
+		for _, event := range []string{"initiated", "ringing", "answered", "completed"} {
+			form.Add("StatusCallbackEvent", event)
+		}
+	}
+	if params.Timeout > 0 {
+		form.Set("Timeout", strconv.Itoa(int(params.Timeout.Seconds())))
+	}
+
+	endpoint := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Calls.json", c.baseURL, url.PathEscape(c.accountSID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.accountSID, c.authToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach Twilio: %w", err)
+	}
+	defer resp.Body.Close()
+
+	decoder := sonic.ConfigDefault.NewDecoder(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode >= 300 {
+		apiErr := &APIError{Status: resp.StatusCode}
+		if err := decoder.Decode(apiErr); err != nil || apiErr.Message == "" {
+			apiErr.Message = http.StatusText(resp.StatusCode)
+		}
+		apiErr.Status = resp.StatusCode
+		return nil, apiErr
+	}
+
+	var call Call
+	if err := decoder.Decode(&call); err != nil {
+		return nil, fmt.Errorf("failed to decode Twilio call: %w", err)
+	}
+	return &call, nil
+}
@@ -0,0 +1,190 @@
+package twilio
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/bytedance/sonic"
+)
+
+// Media Streams events. Twilio sends connected, start, media, mark, dtmf and
+// stop; the server sends media, mark and clear.
+const (
+	EventConnected = "connected"
+	EventStart     = "start"
+	EventMedia     = "media"
+	EventStop      = "stop"
+	EventMark      = "mark"
+	EventDTMF      = "dtmf"
+	EventClear     = "clear"
+)
+
+// Media Streams decoding errors
+var (
+	ErrMalformedMessage = errors.New("malformed Twilio media stream message")
+	ErrUnknownEvent     = errors.New("unknown Twilio media stream event")
+	// ErrUnsupportedFormat is returned by CheckFormat
+	ErrUnsupportedFormat = errors.New("unsupported Twilio media stream")
+)
+
+// Message is a Media Streams WebSocket message. Event says which of the
+// event fields is set.
+type Message struct {
+	Event          string `json:"event"`
+	SequenceNumber string `json:"sequenceNumber,omitempty"` // Order of inbound messages, from "1"
+	StreamSid      string `json:"streamSid,omitempty"`
+
+	Protocol string `json:"protocol,omitempty"` // connected: "Call"
+	Version  string `json:"version,omitempty"`  // connected: protocol version
+
+	Start *Start `json:"start,omitempty"`
+	Media *Media `json:"media,omitempty"`
+	Stop  *Stop  `json:"stop,omitempty"`
+	Mark  *Mark  `json:"mark,omitempty"`
+	DTMF  *DTMF  `json:"dtmf,omitempty"`
+}
+
+// Start describes the call and its stream
+type Start struct {
+	StreamSid        string            `json:"streamSid"`
+	AccountSid       string            `json:"accountSid"`
+	CallSid          string            `json:"callSid"`
+	Tracks           []string          `json:"tracks"`           // "inbound", "outbound"
+	CustomParameters map[string]string `json:"customParameters"` // <Parameter> entries of the TwiML
+	MediaFormat      MediaFormat       `json:"mediaFormat"`
+}
+
+// MediaFormat is the audio format of a stream
+type MediaFormat struct {
+	Encoding   string `json:"encoding"`   // "audio/x-mulaw"
+	SampleRate int    `json:"sampleRate"` // 8000
+	Channels   int    `json:"channels"`   // 1
+}
+
+// Media is a chunk of audio. Outbound chunks only have a payload.
+type Media struct {
+	Track     string `json:"track,omitempty"`     // "inbound" (the caller) or "outbound"
+	Chunk     string `json:"chunk,omitempty"`     // Order of the chunk in its track, from "1"
+	Timestamp string `json:"timestamp,omitempty"` // Milliseconds since the stream started
+	Payload   string `json:"payload"`             // Base64-encoded audio
+}
+
+// Stop reports that the stream ended
+type Stop struct {
+	AccountSid string `json:"accountSid"`
+	CallSid    string `json:"callSid"`
+}
+
+// Mark names a point in the outbound audio; Twilio echoes it once the audio
+// before it has played, or was cleared
+type Mark struct {
+	Name string `json:"name"`
+}
+
+// DTMF is a key pressed by the caller
+type DTMF struct {
+	Track string `json:"track"`
+	Digit string `json:"digit"`
+}
+
+// DecodeMessage decodes and validates an inbound message: the fields of its
+// event must be present and well-formed. Errors wrap ErrMalformedMessage or
+// ErrUnknownEvent.
+func DecodeMessage(data []byte) (*Message, error) {
+	var msg Message
+	if err := sonic.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedMessage, err)
+	}
+
+	switch msg.Event {
+	case EventConnected:
+
+	case EventStart:
+		if msg.Start == nil {
+			return nil, malformed("'start' event missing start data")
+		}
+		if msg.Start.StreamSid == "" {
+			return nil, malformed("'start' event missing streamSid")
+		}
+
+	case EventMedia:
+		if msg.Media == nil {
+			return nil, malformed("'media' event missing media data")
+		}
+		if msg.Media.Track != "" && msg.Media.Track != "inbound" && msg.Media.Track != "outbound" {
+			return nil, malformed("'media' event has unknown track " + strconv.Quote(msg.Media.Track))
+		}
+		if !optionalInt(msg.Media.Chunk) || !optionalInt(msg.Media.Timestamp) {
+			return nil, malformed("'media' event has a non-numeric chunk or timestamp")
+		}
+
+	case EventMark:
+		if msg.Mark == nil || msg.Mark.Name == "" {
+			return nil, malformed("'mark' event missing mark name")
+		}
+
+	case EventDTMF:
+		if msg.DTMF == nil || msg.DTMF.Digit == "" {
+			return nil, malformed("'dtmf' event missing digit")
+		}
+
+	case EventStop:
+
+	case "":
+		return nil, malformed("message missing 'event' field")
+
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownEvent, msg.Event)
+	}
+	return &msg, nil
+}
+
+// malformed returns an ErrMalformedMessage saying why
+func malformed(reason string) error {
+	return fmt.Errorf("%w: %s", ErrMalformedMessage, reason)
+}
+
+// optionalInt reports whether s is empty or a non-negative integer
+func optionalInt(s string) bool {
+	if s == "" {
+		return true
+	}
+	_, err := strconv.ParseUint(s, 10, 64)
+	return err == nil
+}
+
+// Audio returns the chunk's decoded audio
+func (m *Media) Audio() ([]byte, error) {
+	return base64.StdEncoding.DecodeString(m.Payload)
+}
+
+// CheckFormat checks that the stream carries the caller's audio as mono
+// 8kHz mu-law, the only format of bidirectional streams
+func (s *Start) CheckFormat() error {
+	f := s.MediaFormat
+	if f.Encoding != "audio/x-mulaw" || f.SampleRate != 8000 || f.Channels != 1 {
+		return fmt.Errorf("%w: format %s, %d Hz, %d channels", ErrUnsupportedFormat, f.Encoding, f.SampleRate, f.Channels)
+	}
+	if !slices.Contains(s.Tracks, "inbound") {
+		return fmt.Errorf("%w: no inbound track in %v", ErrUnsupportedFormat, s.Tracks)
+	}
+	return nil
+}
+
+// NewMediaMessage returns a message playing base64-encoded mu-law audio to the caller
+func NewMediaMessage(streamSid, payload string) *Message {
+	return &Message{Event: EventMedia, StreamSid: streamSid, Media: &Media{Payload: payload}}
+}
+
+// NewMarkMessage returns a message marking the current end of the audio sent
+func NewMarkMessage(streamSid, name string) *Message {
+	return &Message{Event: EventMark, StreamSid: streamSid, Mark: &Mark{Name: name}}
+}
+
+// NewClearMessage returns a message discarding the audio sent but not yet played
+func NewClearMessage(streamSid string) *Message {
+	return &Message{Event: EventClear, StreamSid: streamSid}
+}
@@ -0,0 +1,144 @@
+package twilio
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/bytedance/sonic"
+)
+
+// Frames recorded from a bidirectional Media Stream
+const (
+	connectedFrame = `{"event":"connected","protocol":"Call","version":"1.0.0"}`
+	startFrame     = `{"event":"start","sequenceNumber":"1","start":{"accountSid":"AC0123456789abcdef0123456789abcdef",` +
+		`"streamSid":"MZ18ad3ab5a668481ce02b83e7395059f0","callSid":"CA0123456789abcdef0123456789abcdef",` +
+		`"tracks":["inbound"],"mediaFormat":{"encoding":"audio/x-mulaw","sampleRate":8000,"channels":1},` +
+		`"customParameters":{"token":"t0k3n"}},"streamSid":"MZ18ad3ab5a668481ce02b83e7395059f0"}`
+	mediaFrame = `{"event":"media","sequenceNumber":"3","media":{"track":"inbound","chunk":"1","timestamp":"5",` +
+		`"payload":"/////w=="},"streamSid":"MZ18ad3ab5a668481ce02b83e7395059f0"}`
+	markFrame = `{"event":"mark","sequenceNumber":"4","streamSid":"MZ18ad3ab5a668481ce02b83e7395059f0",` +
+		`"mark":{"name":"turn-1"}}`
+	dtmfFrame = `{"event":"dtmf","streamSid":"MZ18ad3ab5a668481ce02b83e7395059f0","sequenceNumber":"5",` +
+		`"dtmf":{"track":"inbound_track","digit":"1"}}`
+	stopFrame = `{"event":"stop","sequenceNumber":"6","streamSid":"MZ18ad3ab5a668481ce02b83e7395059f0",` +
+		`"stop":{"accountSid":"AC0123456789abcdef0123456789abcdef","callSid":"CA0123456789abcdef0123456789abcdef"}}`
+)
+
+func TestDecodeMessage(t *testing.T) {
+	const streamSid = "MZ18ad3ab5a668481ce02b83e7395059f0"
+
+	msg, err := DecodeMessage([]byte(connectedFrame))
+	if err != nil || msg.Event != EventConnected || msg.Protocol != "Call" {
+		t.Errorf("connected = %+v, %v", msg, err)
+	}
+
+	msg, err = DecodeMessage([]byte(startFrame))
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if msg.Start.StreamSid != streamSid || msg.Start.CallSid != "CA0123456789abcdef0123456789abcdef" ||
+		msg.Start.CustomParameters["token"] != "t0k3n" {
+		t.Errorf("start = %+v", msg.Start)
+	}
+	if err := msg.Start.CheckFormat(); err != nil {
+		t.Errorf("CheckFormat: %v", err)
+	}
+
+	msg, err = DecodeMessage([]byte(mediaFrame))
+	if err != nil {
+		t.Fatalf("media: %v", err)
+	}
+	audio, err := msg.Media.Audio()
+	if err != nil || !bytes.Equal(audio, []byte{0xFF, 0xFF, 0xFF, 0xFF}) {
+		t.Errorf("media audio = %v, %v", audio, err)
+	}
+	if msg.Media.Track != "inbound" || msg.Media.Chunk != "1" || msg.StreamSid != streamSid {
+		t.Errorf("media = %+v", msg.Media)
+	}
+
+	msg, err = DecodeMessage([]byte(markFrame))
+	if err != nil || msg.Mark.Name != "turn-1" {
+		t.Errorf("mark = %+v, %v", msg, err)
+	}
+
+	msg, err = DecodeMessage([]byte(dtmfFrame))
+	if err != nil || msg.DTMF.Digit != "1" {
+		t.Errorf("dtmf = %+v, %v", msg, err)
+	}
+
+	msg, err = DecodeMessage([]byte(stopFrame))
+	if err != nil || msg.Event != EventStop || msg.Stop.CallSid != "CA0123456789abcdef0123456789abcdef" {
+		t.Errorf("stop = %+v, %v", msg, err)
+	}
+}
+
+func TestDecodeMessageMalformed(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame string
+		want  error
+	}{
+		{"not JSON", `{"event":`, ErrMalformedMessage},
+		{"missing event", `{"streamSid":"MZ1"}`, ErrMalformedMessage},
+		{"missing start", `{"event":"start","streamSid":"MZ1"}`, ErrMalformedMessage},
+		{"start without streamSid", `{"event":"start","start":{"callSid":"CA1"}}`, ErrMalformedMessage},
+		{"missing media", `{"event":"media","streamSid":"MZ1"}`, ErrMalformedMessage},
+		{"bad track", `{"event":"media","media":{"track":"both","chunk":"1","payload":""}}`, ErrMalformedMessage},
+		{"non-numeric chunk", `{"event":"media","media":{"track":"inbound","chunk":"one","payload":""}}`, ErrMalformedMessage},
+		{"non-numeric timestamp", `{"event":"media","media":{"track":"inbound","timestamp":"-5","payload":""}}`, ErrMalformedMessage},
+		{"mark without name", `{"event":"mark","mark":{}}`, ErrMalformedMessage},
+		{"dtmf without digit", `{"event":"dtmf","dtmf":{"track":"inbound_track"}}`, ErrMalformedMessage},
+		{"unknown event", `{"event":"transcription","streamSid":"MZ1"}`, ErrUnknownEvent},
+		{"outbound-only event", `{"event":"clear","streamSid":"MZ1"}`, ErrUnknownEvent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if msg, err := DecodeMessage([]byte(tt.frame)); !errors.Is(err, tt.want) {
+				t.Errorf("DecodeMessage = %+v, %v; want %v", msg, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		tracks []string
+		format MediaFormat
+	}{
+		{"linear PCM", []string{"inbound"}, MediaFormat{Encoding: "audio/l16", SampleRate: 8000, Channels: 1}},
+		{"16kHz", []string{"inbound"}, MediaFormat{Encoding: "audio/x-mulaw", SampleRate: 16000, Channels: 1}},
+		{"stereo", []string{"inbound"}, MediaFormat{Encoding: "audio/x-mulaw", SampleRate: 8000, Channels: 2}},
+		{"no inbound track", []string{"outbound"}, MediaFormat{Encoding: "audio/x-mulaw", SampleRate: 8000, Channels: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := &Start{StreamSid: "MZ1", Tracks: tt.tracks, MediaFormat: tt.format}
+			if err := start.CheckFormat(); !errors.Is(err, ErrUnsupportedFormat) {
+				t.Errorf("CheckFormat = %v, want ErrUnsupportedFormat", err)
+			}
+		})
+	}
+}
+
+// Outbound messages have the shape Twilio expects
+func TestOutboundMessages(t *testing.T) {
+	tests := []struct {
+		msg  *Message
+		want string
+	}{
+		{NewMediaMessage("MZ1", "/////w=="), `{"event":"media","streamSid":"MZ1","media":{"payload":"/////w=="}}`},
+		{NewMarkMessage("MZ1", "turn-1"), `{"event":"mark","streamSid":"MZ1","mark":{"name":"turn-1"}}`},
+		{NewClearMessage("MZ1"), `{"event":"clear","streamSid":"MZ1"}`},
+	}
+	for _, tt := range tests {
+		data, err := sonic.Marshal(tt.msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != tt.want {
+			t.Errorf("%s message = %s, want %s", tt.msg.Event, data, tt.want)
+		}
+	}
+}
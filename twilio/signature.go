@@ -0,0 +1,73 @@
+package twilio
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// SignatureHeader is the header carrying Twilio's request signature
+const SignatureHeader = "X-Twilio-Signature"
+
+// Signature computes the signature Twilio sends for a request to fullURL with
+// the given POST parameters (nil for GET requests, whose parameters are part
+// of the URL): base64(HMAC-SHA1(authToken, URL + sorted key/value pairs)).
+func Signature(authToken, fullURL string, params url.Values) string {
+	data := fullURL
+
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		values := append([]string(nil), params[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			data += key + value
+		}
+	}
+
+	mac := hmac.New(sha1.New, []byte(authToken))
+	mac.Write([]byte(data))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ValidSignature reports whether signature is Twilio's signature of the
+// request. Twilio signs some requests with the scheme's default port in the
+// URL and some without, so both forms are accepted, as Twilio's SDKs do.
+func ValidSignature(authToken, fullURL string, params url.Values, signature string) bool {
+	if signature == "" {
+		return false
+	}
+	for _, u := range signedURLs(fullURL) {
+		if hmac.Equal([]byte(Signature(authToken, u, params)), []byte(signature)) {
+			return true
+		}
+	}
+	return false
+}
+
+// signedURLs returns fullURL and, when its port is absent or the scheme's
+// default, the same URL with the port toggled
+func signedURLs(fullURL string) []string {
+	u, err := url.Parse(fullURL)
+	if err != nil {
+		return []string{fullURL}
+	}
+	defaultPort := map[string]string{"https": "443", "http": "80"}[u.Scheme]
+	var host string
+	switch u.Port() {
+	case "":
+		host = u.Host + ":" + defaultPort
+	case defaultPort:
+		host = strings.TrimSuffix(u.Host, ":"+defaultPort)
+	}
+	if defaultPort == "" || host == "" {
+		return []string{fullURL}
+	}
+	return []string{fullURL, strings.Replace(fullURL, "://"+u.Host, "://"+host, 1)}
+}
@@ -0,0 +1,58 @@
+package twilio
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestValidSignature(t *testing.T) {
+	// The example request of Twilio's webhook security documentation
+	const authToken = "12345"
+	params := url.Values{
+		"CallSid": {"CA1234567890ABCDE"},
+		"Caller":  {"+12349013030"},
+		"Digits":  {"1234"},
+		"From":    {"+12349013030"},
+		"To":      {"+18005551212"},
+	}
+	const docURL = "https://mycompany.com/myapp.php?foo=1&bar=2"
+
+	tests := []struct {
+		name      string
+		url       string
+		params    url.Values
+		signature string
+		want      bool
+	}{
+		{"documented example", docURL, params, "0/KCTR6DLpKmkAf8muzZqo1nDgQ=", true},
+		{"signed with the default port", docURL, params, "EpDEmp1PyjDYp77YxYU3GILBWzE=", true},
+		{"received with the default port", "https://mycompany.com:443/myapp.php?foo=1&bar=2", params, "0/KCTR6DLpKmkAf8muzZqo1nDgQ=", true},
+		{"other port", "https://mycompany.com:8443/myapp.php?foo=1&bar=2", params, "SffuNP6bC3hHFCUbsco6GyaPyas=", true},
+		{"other port signed without it", "https://mycompany.com:8443/myapp.php?foo=1&bar=2", params, "0/KCTR6DLpKmkAf8muzZqo1nDgQ=", false},
+		{"GET with a query string", "https://mycompany.com/myapp.php?foo=1&bar=2&CallSid=CA1234567890ABCDE", nil, "ER/9qNdfuNg4c0PcfQ4DjL5AXk8=", true},
+		{"repeated parameters", "https://mycompany.com/voice", url.Values{"Digits": {"2", "1"}, "To": {"+18005551212"}}, "YRR4qg+kmg3GC0xxmkoyEXrwG8w=", true},
+		{"tampered parameter", docURL, url.Values{
+			"CallSid": {"CA1234567890ABCDE"}, "Caller": {"+12349013030"}, "Digits": {"1234"},
+			"From": {"+15550100000"}, "To": {"+18005551212"},
+		}, "0/KCTR6DLpKmkAf8muzZqo1nDgQ=", false},
+		{"extra parameter", docURL, url.Values{
+			"CallSid": {"CA1234567890ABCDE"}, "Caller": {"+12349013030"}, "Digits": {"1234"},
+			"From": {"+12349013030"}, "To": {"+18005551212"}, "Extra": {"1"},
+		}, "0/KCTR6DLpKmkAf8muzZqo1nDgQ=", false},
+		{"tampered query", "https://mycompany.com/myapp.php?foo=2&bar=2", params, "0/KCTR6DLpKmkAf8muzZqo1nDgQ=", false},
+		{"other host", "https://evil.example/myapp.php?foo=1&bar=2", params, "0/KCTR6DLpKmkAf8muzZqo1nDgQ=", false},
+		{"http instead of https", "http://mycompany.com/myapp.php?foo=1&bar=2", params, "0/KCTR6DLpKmkAf8muzZqo1nDgQ=", false},
+		{"missing", docURL, params, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ValidSignature(authToken, tt.url, tt.params, tt.signature); got != tt.want {
+				t.Errorf("ValidSignature = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if ValidSignature("another token", docURL, params, "0/KCTR6DLpKmkAf8muzZqo1nDgQ=") {
+		t.Error("ValidSignature accepted a signature made with another auth token")
+	}
+}
@@ -0,0 +1,79 @@
+package vonage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestVerifyRequest(t *testing.T) {
+	secret := []byte("vonage-signature-secret")
+	now := time.Unix(1_760_000_000, 0)
+	body := []byte(`{"from":"447700900000","to":"447700900001","uuid":"aaaaaaaa-bbbb-cccc-dddd-0123456789ab","conversation_uuid":"CON-1"}`)
+	sum := sha256.Sum256(body)
+	bodyHash := hex.EncodeToString(sum[:])
+
+	sign := func(method jwt.SigningMethod, key any, issuedAt time.Time, payloadHash string) string {
+		token := jwt.NewWithClaims(method, claims{
+			PayloadHash:      payloadHash,
+			RegisteredClaims: jwt.RegisteredClaims{IssuedAt: jwt.NewNumericDate(issuedAt), ID: "jti-1"},
+		})
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "Bearer " + signed
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		body          []byte
+		wantErr       error // nil for accepted requests; jwt errors are only checked for non-nil
+		accept        bool
+	}{
+		{"signed POST", sign(jwt.SigningMethodHS256, secret, now, bodyHash), body, nil, true},
+		{"signed GET", sign(jwt.SigningMethodHS256, secret, now.Add(-time.Minute), ""), nil, nil, true},
+		{"missing", "", body, ErrMissingSignature, false},
+		{"not bearer", "Basic dXNlcjpwYXNz", body, ErrMissingSignature, false},
+		{"other secret", sign(jwt.SigningMethodHS256, []byte("other"), now, bodyHash), body, jwt.ErrTokenSignatureInvalid, false},
+		{"other algorithm", sign(jwt.SigningMethodHS512, secret, now, bodyHash), body, jwt.ErrTokenSignatureInvalid, false},
+		{"tampered body", sign(jwt.SigningMethodHS256, secret, now, bodyHash), []byte(`{"from":"15550100"}`), ErrPayloadMismatch, false},
+		{"stale", sign(jwt.SigningMethodHS256, secret, now.Add(-10*time.Minute), bodyHash), body, jwt.ErrTokenExpired, false},
+		{"issued in the future", sign(jwt.SigningMethodHS256, secret, now.Add(time.Hour), bodyHash), body, jwt.ErrTokenUsedBeforeIssued, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyRequest(secret, tt.authorization, tt.body, now)
+			if tt.accept {
+				if err != nil {
+					t.Fatalf("VerifyRequest rejected a valid request: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("VerifyRequest accepted the request")
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("VerifyRequest = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyRequestRejectsUnsignedToken(t *testing.T) {
+	token := jwt.NewWithClaims(jwt.SigningMethodNone, claims{
+		RegisteredClaims: jwt.RegisteredClaims{IssuedAt: jwt.NewNumericDate(time.Now())},
+	})
+	unsigned, err := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyRequest([]byte("secret"), "Bearer "+unsigned, nil, time.Now()); err == nil {
+		t.Error(`VerifyRequest accepted an "alg: none" token`)
+	}
+}